@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requestExpiry is how far the Timestamp of a request signed with
+// Signature Version 2 may be from the time it is received.
+const requestExpiry = 15 * time.Minute
+
+// SignatureError describes why VerifySignatureV2 rejected a request, with
+// the status code and error code AWS responds with.
+type SignatureError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (err *SignatureError) Error() string {
+	return fmt.Sprintf("%s (%s)", err.Message, err.Code)
+}
+
+func signatureError(statusCode int, code, format string, args ...interface{}) *SignatureError {
+	return &SignatureError{
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+// VerifySignatureV2 checks that the parsed form of req carries a valid
+// Signature Version 2 made with secret, and that the request has not
+// expired according to the wall clock. It is meant for test servers that
+// mimic AWS; looking up the secret of the AWSAccessKeyId is left to them.
+func VerifySignatureV2(req *http.Request, secret string) *SignatureError {
+	form := req.Form
+	if v := form.Get("SignatureVersion"); v != "2" {
+		return signatureError(400, "InvalidParameterValue", "Value (%s) for parameter SignatureVersion is invalid. Valid values are 2.", v)
+	}
+	var h func() hash.Hash
+	switch m := form.Get("SignatureMethod"); m {
+	case "HmacSHA256":
+		h = sha256.New
+	case "HmacSHA1":
+		h = sha1.New
+	default:
+		return signatureError(400, "InvalidParameterValue", "Value (%s) for parameter SignatureMethod is invalid.", m)
+	}
+	if !hmac.Equal([]byte(form.Get("Signature")), []byte(signatureV2(h, secret, req))) {
+		return signatureError(403, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided. Check your AWS Secret Access Key and signing method. Consult the service documentation for details.")
+	}
+	now := time.Now()
+	if expires := form.Get("Expires"); expires != "" {
+		t, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			return signatureError(400, "InvalidParameterValue", "Value (%s) for parameter Expires is invalid.", expires)
+		}
+		if now.After(t) {
+			return signatureError(400, "RequestExpired", "Request has expired. Expires date is %s", expires)
+		}
+		return nil
+	}
+	timestamp := form.Get("Timestamp")
+	if timestamp == "" {
+		return signatureError(400, "MissingParameter", "The request must contain the parameter Timestamp")
+	}
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return signatureError(400, "InvalidParameterValue", "Value (%s) for parameter Timestamp is invalid.", timestamp)
+	}
+	if d := now.Sub(t); d > requestExpiry || d < -requestExpiry {
+		return signatureError(400, "RequestExpired", "Request has expired. Timestamp date is %s", timestamp)
+	}
+	return nil
+}
+
+// signatureV2 computes the Signature Version 2 of the request.
+func signatureV2(h func() hash.Hash, secret string, req *http.Request) string {
+	var keys []string
+	for k := range req.Form {
+		if k != "Signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = Encode(k) + "=" + Encode(req.Form.Get(k))
+	}
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	payload := req.Method + "\n" + strings.ToLower(req.Host) + "\n" + path + "\n" + strings.Join(pairs, "&")
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/flaviamissi/go-elb/aws"
+	"github.com/flaviamissi/go-elb/ec2"
+	"github.com/flaviamissi/go-elb/ec2/ec2test"
+	"github.com/flaviamissi/go-elb/elb"
+	"github.com/flaviamissi/go-elb/elb/elbtest"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// fakeAWS holds the fake EC2 and ELB servers, and clients to talk to them.
+type fakeAWS struct {
+	ec2srv   *ec2test.Server
+	elbsrv   *elbtest.Server
+	ec2      *ec2.EC2
+	elb      *elb.ELB
+	ec2Proxy http.Handler
+	elbProxy http.Handler
+}
+
+func newFakeAWS() (*fakeAWS, error) {
+	ec2srv, err := ec2test.NewServer()
+	if err != nil {
+		return nil, err
+	}
+	elbsrv, err := elbtest.NewServer()
+	if err != nil {
+		ec2srv.Quit()
+		return nil, err
+	}
+	elbsrv.SetInstanceSource(ec2srv)
+	auth := aws.Auth{AccessKey: "fakeaws", SecretKey: "fakeaws"}
+	f := &fakeAWS{
+		ec2srv: ec2srv,
+		elbsrv: elbsrv,
+		ec2:    ec2.New(auth, aws.Region{EC2Endpoint: ec2srv.URL()}),
+		elb:    elb.New(auth, aws.Region{ELBEndpoint: elbsrv.URL()}),
+	}
+	if f.ec2Proxy, err = proxy(ec2srv.URL()); err != nil {
+		return nil, err
+	}
+	if f.elbProxy, err = proxy(elbsrv.URL()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func proxy(rawurl string) (http.Handler, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return httputil.NewSingleHostReverseProxy(u), nil
+}
+
+// Close shuts the fake servers down.
+func (f *fakeAWS) Close() {
+	f.elbsrv.Quit()
+	f.ec2srv.Quit()
+}
+
+// ServeHTTP routes requests made to the single address to the fake they
+// are meant for, by path or by Host.
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := strings.ToLower(req.Host)
+	switch {
+	case req.URL.Path == "/_admin/state":
+		f.serveState(w, req)
+	case hasPathPrefix(req.URL.Path, "/ec2"), strings.HasPrefix(host, "ec2."):
+		f.ec2Proxy.ServeHTTP(w, req)
+	case hasPathPrefix(req.URL.Path, "/elb"), strings.HasPrefix(host, "elasticloadbalancing."):
+		f.elbProxy.ServeHTTP(w, req)
+	default:
+		http.Error(w, "cannot tell whether the request is meant for EC2 or ELB", http.StatusNotFound)
+	}
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// state holds the state of the fakes, as returned by their APIs.
+type state struct {
+	Reservations   []ec2.Reservation              `json:"reservations"`
+	SecurityGroups []ec2.SecurityGroupInfo        `json:"securityGroups"`
+	LoadBalancers  []elb.LoadBalancerDescription  `json:"loadBalancers"`
+	InstanceHealth map[string][]elb.InstanceState `json:"instanceHealth"`
+}
+
+// State returns the current state of the fakes.
+func (f *fakeAWS) State() (*state, error) {
+	var s state
+	insts, err := f.ec2.Instances(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.Reservations = insts.Reservations
+	groups, err := f.ec2.SecurityGroups(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.SecurityGroups = groups.Groups
+	lbs, err := f.elb.DescribeLoadBalancers()
+	if err != nil {
+		return nil, err
+	}
+	s.LoadBalancers = lbs.LoadBalancerDescriptions
+	sort.Sort(loadBalancersByName(s.LoadBalancers))
+	s.InstanceHealth = make(map[string][]elb.InstanceState)
+	for _, lb := range s.LoadBalancers {
+		health, err := f.elb.DescribeInstanceHealth(lb.LoadBalancerName)
+		if err != nil {
+			return nil, err
+		}
+		s.InstanceHealth[lb.LoadBalancerName] = health.InstanceStates
+	}
+	return &s, nil
+}
+
+// StateJSON returns the current state of the fakes as indented JSON.
+func (f *fakeAWS) StateJSON() ([]byte, error) {
+	s, err := f.State()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(s, "", "\t")
+}
+
+func (f *fakeAWS) serveState(w http.ResponseWriter, req *http.Request) {
+	data, err := f.StateJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+type loadBalancersByName []elb.LoadBalancerDescription
+
+func (lbs loadBalancersByName) Len() int      { return len(lbs) }
+func (lbs loadBalancersByName) Swap(i, j int) { lbs[i], lbs[j] = lbs[j], lbs[i] }
+func (lbs loadBalancersByName) Less(i, j int) bool {
+	return lbs[i].LoadBalancerName < lbs[j].LoadBalancerName
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/flaviamissi/go-elb/aws"
+	"github.com/flaviamissi/go-elb/ec2"
+	"github.com/flaviamissi/go-elb/elb"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type S struct {
+	f *fakeAWS
+}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpTest(c *C) {
+	f, err := newFakeAWS()
+	c.Assert(err, IsNil)
+	s.f = f
+}
+
+func (s *S) TearDownTest(c *C) {
+	s.f.Close()
+}
+
+const yamlFixture = `
+ec2:
+  securityGroups:
+    - name: web
+      description: web servers
+      ingress:
+        - protocol: tcp
+          fromPort: 80
+          toPort: 80
+          sourceIps: [0.0.0.0/0]
+  instances:
+    - name: web1
+      imageId: ami-a1b2c3d4
+      instanceType: m1.small
+      securityGroups: [web]
+    - name: web2
+      imageId: ami-a1b2c3d4
+      instanceType: m1.small
+      availZone: us-east-1b
+      state: stopped
+elb:
+  loadBalancers:
+    - name: weblb
+      availZones: [us-east-1a]
+      listeners:
+        - protocol: http
+          port: 80
+          instanceProtocol: http
+          instancePort: 8080
+      healthCheck:
+        target: HTTP:8080/ping
+        interval: 30
+        timeout: 5
+        healthyThreshold: 2
+        unhealthyThreshold: 2
+      instances: [web1]
+`
+
+func writeFixture(c *C, name, content string) string {
+	path := filepath.Join(c.MkDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	c.Assert(err, IsNil)
+	return path
+}
+
+func (s *S) TestSeedFromYAML(c *C) {
+	fx, err := readFixture(writeFixture(c, "fixture.yaml", yamlFixture))
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Seed(fx), IsNil)
+	st, err := s.f.State()
+	c.Assert(err, IsNil)
+
+	states := make(map[string]string)
+	var web1 string
+	for _, r := range st.Reservations {
+		for _, inst := range r.Instances {
+			states[inst.AvailZone] = inst.State.Name
+			if len(r.SecurityGroups) == 1 && r.SecurityGroups[0].Name == "web" {
+				web1 = inst.InstanceId
+			}
+		}
+	}
+	c.Assert(states, DeepEquals, map[string]string{"us-east-1a": "running", "us-east-1b": "stopped"})
+	c.Assert(web1, Not(Equals), "")
+
+	var web *ec2.SecurityGroupInfo
+	for i, g := range st.SecurityGroups {
+		if g.Name == "web" {
+			web = &st.SecurityGroups[i]
+		}
+	}
+	c.Assert(web, NotNil)
+	c.Assert(web.Description, Equals, "web servers")
+	c.Assert(web.IPPerms, HasLen, 1)
+	c.Assert(web.IPPerms[0].SourceIPs, DeepEquals, []string{"0.0.0.0/0"})
+
+	c.Assert(st.LoadBalancers, HasLen, 1)
+	lb := st.LoadBalancers[0]
+	c.Assert(lb.LoadBalancerName, Equals, "weblb")
+	c.Assert(lb.HealthCheck.Target, Equals, "HTTP:8080/ping")
+	c.Assert(lb.Instances, DeepEquals, []elb.Instance{{InstanceId: web1}})
+	c.Assert(st.InstanceHealth["weblb"], HasLen, 1)
+	c.Assert(st.InstanceHealth["weblb"][0].InstanceId, Equals, web1)
+}
+
+func (s *S) TestSeedFromJSON(c *C) {
+	path := writeFixture(c, "fixture.json", `{
+		"ec2": {"instances": [{"name": "db", "imageId": "ami-a1b2c3d4"}]},
+		"elb": {"loadBalancers": [{
+			"name": "dblb",
+			"availZones": ["us-east-1a"],
+			"listeners": [{"protocol": "tcp", "port": 5432, "instanceProtocol": "tcp", "instancePort": 5432}],
+			"instances": ["db"]
+		}]}
+	}`)
+	fx, err := readFixture(path)
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Seed(fx), IsNil)
+	st, err := s.f.State()
+	c.Assert(err, IsNil)
+	c.Assert(st.LoadBalancers, HasLen, 1)
+	c.Assert(st.LoadBalancers[0].Instances, HasLen, 1)
+}
+
+func (s *S) TestSeedErrors(c *C) {
+	_, err := readFixture(writeFixture(c, "bad.json", "{"))
+	c.Assert(err, ErrorMatches, "cannot parse .*bad.json: .*")
+	err = s.f.Seed(&fixture{ELB: elbFixture{LoadBalancers: []lbFixture{{
+		Name:       "lb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []listenerFixture{{Protocol: "http", Port: 80, InstanceProtocol: "http", InstancePort: 80}},
+		Instances:  []string{"absent"},
+	}}}})
+	c.Assert(err, ErrorMatches, `cannot create load balancer "lb": no instance named "absent"`)
+	err = s.f.Seed(&fixture{EC2: ec2Fixture{Instances: []instanceFixture{{Name: "x", State: "exploded"}}}})
+	c.Assert(err, ErrorMatches, `invalid state "exploded" for instance "x"`)
+}
+
+func (s *S) TestRoutesByPath(c *C) {
+	srv := httptest.NewServer(s.f)
+	defer srv.Close()
+	auth := aws.Auth{AccessKey: "key", SecretKey: "secret"}
+	e := ec2.New(auth, aws.Region{EC2Endpoint: srv.URL + "/ec2"})
+	_, err := e.CreateSecurityGroup("viapath", "created through the single address")
+	c.Assert(err, IsNil)
+	l := elb.New(auth, aws.Region{ELBEndpoint: srv.URL + "/elb/"})
+	_, err = l.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "viapath",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 80, InstanceProtocol: "http", LoadBalancerPort: 80, Protocol: "http"}},
+	})
+	c.Assert(err, IsNil)
+	st, err := s.f.State()
+	c.Assert(err, IsNil)
+	c.Assert(st.LoadBalancers, HasLen, 1)
+	c.Assert(st.LoadBalancers[0].LoadBalancerName, Equals, "viapath")
+	c.Assert(st.SecurityGroups, HasLen, 2)
+}
+
+func (s *S) TestRoutesByHost(c *C) {
+	srv := httptest.NewServer(s.f)
+	defer srv.Close()
+	for _, t := range []struct {
+		host   string
+		action string
+		status int
+	}{
+		{"ec2.us-east-1.amazonaws.com", "DescribeInstances", http.StatusOK},
+		{"elasticloadbalancing.us-east-1.amazonaws.com", "DescribeLoadBalancers", http.StatusOK},
+		{"elasticloadbalancing.us-east-1.amazonaws.com", "DescribeInstances", http.StatusBadRequest},
+		{"s3.amazonaws.com", "ListBuckets", http.StatusNotFound},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+"/?Action="+t.action, nil)
+		c.Assert(err, IsNil)
+		req.Host = t.host
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, Equals, t.status, Commentf("%s %s", t.host, t.action))
+	}
+}
+
+func (s *S) TestAdminState(c *C) {
+	fx, err := readFixture(writeFixture(c, "fixture.yaml", yamlFixture))
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Seed(fx), IsNil)
+	srv := httptest.NewServer(s.f)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/_admin/state")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "application/json")
+	var st map[string]interface{}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&st), IsNil)
+	c.Assert(st["loadBalancers"], HasLen, 1)
+	c.Assert(st["instanceHealth"], NotNil)
+}
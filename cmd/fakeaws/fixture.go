@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2"
+	"github.com/flaviamissi/go-elb/ec2/ec2test"
+	"github.com/flaviamissi/go-elb/elb"
+	"io/ioutil"
+	"launchpad.net/goyaml"
+	"path/filepath"
+)
+
+// fixture holds the initial state of the fakes. In YAML it looks like
+//
+//	ec2:
+//	  securityGroups:
+//	    - name: web
+//	      description: web servers
+//	      ingress:
+//	        - protocol: tcp
+//	          fromPort: 80
+//	          toPort: 80
+//	          sourceIps: [0.0.0.0/0]
+//	  instances:
+//	    - name: web1
+//	      imageId: ami-a1b2c3d4
+//	      instanceType: m1.small
+//	      availZone: us-east-1a
+//	      securityGroups: [web]
+//	      backend: localhost:8080
+//	elb:
+//	  loadBalancers:
+//	    - name: weblb
+//	      availZones: [us-east-1a]
+//	      listeners:
+//	        - protocol: http
+//	          port: 80
+//	          instanceProtocol: http
+//	          instancePort: 8080
+//	      healthCheck:
+//	        target: HTTP:8080/ping
+//	        interval: 30
+//	        timeout: 5
+//	        healthyThreshold: 2
+//	        unhealthyThreshold: 2
+//	      instances: [web1]
+//
+// and JSON uses the same names.
+type fixture struct {
+	EC2 ec2Fixture `json:"ec2" yaml:"ec2"`
+	ELB elbFixture `json:"elb" yaml:"elb"`
+}
+
+type ec2Fixture struct {
+	SecurityGroups []groupFixture    `json:"securityGroups" yaml:"securityGroups"`
+	Instances      []instanceFixture `json:"instances" yaml:"instances"`
+}
+
+type groupFixture struct {
+	Name        string        `json:"name" yaml:"name"`
+	Description string        `json:"description" yaml:"description"`
+	Ingress     []permFixture `json:"ingress" yaml:"ingress"`
+}
+
+type permFixture struct {
+	Protocol     string   `json:"protocol" yaml:"protocol"`
+	FromPort     int      `json:"fromPort" yaml:"fromPort"`
+	ToPort       int      `json:"toPort" yaml:"toPort"`
+	SourceIPs    []string `json:"sourceIps" yaml:"sourceIps"`
+	SourceGroups []string `json:"sourceGroups" yaml:"sourceGroups"`
+}
+
+// instanceFixture describes an instance. Name is only used to refer to the
+// instance from load balancers, as instance ids are assigned by the fake.
+// State is either "running", the default, "pending" or "stopped". If Backend
+// is set, load balancers forward traffic and health checks to that address.
+type instanceFixture struct {
+	Name           string   `json:"name" yaml:"name"`
+	ImageId        string   `json:"imageId" yaml:"imageId"`
+	InstanceType   string   `json:"instanceType" yaml:"instanceType"`
+	AvailZone      string   `json:"availZone" yaml:"availZone"`
+	State          string   `json:"state" yaml:"state"`
+	SecurityGroups []string `json:"securityGroups" yaml:"securityGroups"`
+	Backend        string   `json:"backend" yaml:"backend"`
+}
+
+type elbFixture struct {
+	LoadBalancers []lbFixture `json:"loadBalancers" yaml:"loadBalancers"`
+}
+
+// lbFixture describes a load balancer. Instances holds instance fixture
+// names.
+type lbFixture struct {
+	Name           string              `json:"name" yaml:"name"`
+	Scheme         string              `json:"scheme" yaml:"scheme"`
+	AvailZones     []string            `json:"availZones" yaml:"availZones"`
+	Subnets        []string            `json:"subnets" yaml:"subnets"`
+	SecurityGroups []string            `json:"securityGroups" yaml:"securityGroups"`
+	Listeners      []listenerFixture   `json:"listeners" yaml:"listeners"`
+	HealthCheck    *healthCheckFixture `json:"healthCheck" yaml:"healthCheck"`
+	Instances      []string            `json:"instances" yaml:"instances"`
+}
+
+type listenerFixture struct {
+	Protocol         string `json:"protocol" yaml:"protocol"`
+	Port             int    `json:"port" yaml:"port"`
+	InstanceProtocol string `json:"instanceProtocol" yaml:"instanceProtocol"`
+	InstancePort     int    `json:"instancePort" yaml:"instancePort"`
+	SSLCertificateId string `json:"sslCertificateId" yaml:"sslCertificateId"`
+}
+
+type healthCheckFixture struct {
+	Target             string `json:"target" yaml:"target"`
+	Interval           int    `json:"interval" yaml:"interval"`
+	Timeout            int    `json:"timeout" yaml:"timeout"`
+	HealthyThreshold   int    `json:"healthyThreshold" yaml:"healthyThreshold"`
+	UnhealthyThreshold int    `json:"unhealthyThreshold" yaml:"unhealthyThreshold"`
+}
+
+var instanceStates = map[string]ec2.InstanceState{
+	"":        ec2test.Running,
+	"running": ec2test.Running,
+	"pending": ec2test.Pending,
+	"stopped": ec2test.Stopped,
+}
+
+// readFixture reads a fixture from a JSON file, or from a YAML file if its
+// extension is .yaml or .yml.
+func readFixture(path string) (*fixture, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fx fixture
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = goyaml.Unmarshal(data, &fx)
+	default:
+		err = json.Unmarshal(data, &fx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	return &fx, nil
+}
+
+// Seed creates the security groups, instances and load balancers described
+// by the fixture, in that order, through the fakes' APIs.
+func (f *fakeAWS) Seed(fx *fixture) error {
+	for _, g := range fx.EC2.SecurityGroups {
+		if _, err := f.ec2.CreateSecurityGroup(g.Name, g.Description); err != nil {
+			return fmt.Errorf("cannot create security group %q: %v", g.Name, err)
+		}
+	}
+	// Permissions may refer to any group, so they are set once all
+	// groups exist.
+	for _, g := range fx.EC2.SecurityGroups {
+		if len(g.Ingress) == 0 {
+			continue
+		}
+		var perms []ec2.IPPerm
+		for _, p := range g.Ingress {
+			perm := ec2.IPPerm{
+				Protocol:  p.Protocol,
+				FromPort:  p.FromPort,
+				ToPort:    p.ToPort,
+				SourceIPs: p.SourceIPs,
+			}
+			for _, name := range p.SourceGroups {
+				perm.SourceGroups = append(perm.SourceGroups, ec2.UserSecurityGroup{Name: name})
+			}
+			perms = append(perms, perm)
+		}
+		if _, err := f.ec2.AuthorizeSecurityGroup(ec2.SecurityGroup{Name: g.Name}, perms); err != nil {
+			return fmt.Errorf("cannot authorize ingress to security group %q: %v", g.Name, err)
+		}
+	}
+	ids := make(map[string]string)
+	defer f.ec2srv.SetInitialInstanceState(ec2test.Pending)
+	for _, inst := range fx.EC2.Instances {
+		state, ok := instanceStates[inst.State]
+		if !ok {
+			return fmt.Errorf("invalid state %q for instance %q", inst.State, inst.Name)
+		}
+		f.ec2srv.SetInitialInstanceState(state)
+		options := ec2.RunInstances{
+			ImageId:      inst.ImageId,
+			InstanceType: inst.InstanceType,
+			AvailZone:    inst.AvailZone,
+		}
+		for _, name := range inst.SecurityGroups {
+			options.SecurityGroups = append(options.SecurityGroups, ec2.SecurityGroup{Name: name})
+		}
+		resp, err := f.ec2.RunInstances(&options)
+		if err != nil {
+			return fmt.Errorf("cannot run instance %q: %v", inst.Name, err)
+		}
+		id := resp.Instances[0].InstanceId
+		if inst.Name != "" {
+			ids[inst.Name] = id
+		}
+		if inst.Backend != "" {
+			f.elbsrv.SetInstanceBackend(id, inst.Backend)
+		}
+	}
+	for _, lb := range fx.ELB.LoadBalancers {
+		if err := f.seedLoadBalancer(&lb, ids); err != nil {
+			return fmt.Errorf("cannot create load balancer %q: %v", lb.Name, err)
+		}
+	}
+	return nil
+}
+
+func (f *fakeAWS) seedLoadBalancer(lb *lbFixture, ids map[string]string) error {
+	options := elb.CreateLoadBalancer{
+		Name:           lb.Name,
+		Scheme:         lb.Scheme,
+		AvailZones:     lb.AvailZones,
+		Subnets:        lb.Subnets,
+		SecurityGroups: lb.SecurityGroups,
+	}
+	for _, l := range lb.Listeners {
+		options.Listeners = append(options.Listeners, elb.Listener{
+			Protocol:         l.Protocol,
+			LoadBalancerPort: l.Port,
+			InstanceProtocol: l.InstanceProtocol,
+			InstancePort:     l.InstancePort,
+			SSLCertificateId: l.SSLCertificateId,
+		})
+	}
+	if _, err := f.elb.CreateLoadBalancer(&options); err != nil {
+		return err
+	}
+	if hc := lb.HealthCheck; hc != nil {
+		_, err := f.elb.ConfigureHealthCheck(lb.Name, &elb.HealthCheck{
+			Target:             hc.Target,
+			Interval:           hc.Interval,
+			Timeout:            hc.Timeout,
+			HealthyThreshold:   hc.HealthyThreshold,
+			UnhealthyThreshold: hc.UnhealthyThreshold,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(lb.Instances) == 0 {
+		return nil
+	}
+	var instIds []string
+	for _, name := range lb.Instances {
+		id, ok := ids[name]
+		if !ok {
+			return fmt.Errorf("no instance named %q", name)
+		}
+		instIds = append(instIds, id)
+	}
+	_, err := f.elb.RegisterInstancesWithLoadBalancer(instIds, lb.Name)
+	return err
+}
@@ -0,0 +1,89 @@
+// The fakeaws command serves the ec2test and elbtest fake EC2 and ELB
+// providers, so that tools written in any language can be tested against
+// them.
+//
+// Usage:
+//
+//	fakeaws [flags]
+//
+// By default EC2 is served on localhost:8773 and ELB on localhost:8774, as
+// set by the -ec2 and -elb flags. If -addr is given, both are served on
+// that single address instead: requests whose path starts with /ec2 or
+// whose Host starts with "ec2." go to EC2, and requests whose path starts
+// with /elb or whose Host starts with "elasticloadbalancing." go to ELB.
+// The ELB fake takes its instances from the EC2 one.
+//
+// The -fixture flag names a JSON or YAML file, told apart by its
+// extension, holding the initial state of the fakes. See fixture.go for its
+// format.
+//
+// The current state of the fakes is served as JSON at /state on the -admin
+// address, and at /_admin/state on the -addr address. It is also written to
+// standard output when the process receives SIGUSR1.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+var (
+	ec2Addr     = flag.String("ec2", "localhost:8773", "address to serve EC2 on")
+	elbAddr     = flag.String("elb", "localhost:8774", "address to serve ELB on")
+	addr        = flag.String("addr", "", "single address to serve both EC2 and ELB on")
+	adminAddr   = flag.String("admin", "", "address to serve the admin endpoint on")
+	fixturePath = flag.String("fixture", "", "JSON or YAML file to seed the fakes from")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := newFakeAWS()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if *fixturePath != "" {
+		fx, err := readFixture(*fixturePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Seed(fx); err != nil {
+			log.Fatalf("cannot seed from %s: %v", *fixturePath, err)
+		}
+	}
+	notifyDump(f)
+	errc := make(chan error)
+	serve := func(addr string, h http.Handler) {
+		log.Printf("serving on %s", addr)
+		errc <- http.ListenAndServe(addr, h)
+	}
+	if *adminAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/state", f.serveState)
+		go serve(*adminAddr, mux)
+	}
+	if *addr != "" {
+		go serve(*addr, f)
+	} else {
+		go serve(*ec2Addr, f.ec2Proxy)
+		go serve(*elbAddr, f.elbProxy)
+	}
+	log.Fatal(<-errc)
+}
+
+// dump writes the state of the fakes to standard output.
+func dump(f *fakeAWS) {
+	data, err := f.StateJSON()
+	if err != nil {
+		log.Printf("cannot dump state: %v", err)
+		return
+	}
+	fmt.Printf("%s\n", data)
+}
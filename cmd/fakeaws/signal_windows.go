@@ -0,0 +1,5 @@
+package main
+
+// notifyDump does nothing, as there is no SIGUSR1 on Windows. Use the admin
+// endpoint instead.
+func notifyDump(f *fakeAWS) {}
@@ -231,6 +231,10 @@ type Instance struct {
 	ImageId            string        `xml:"imageId"`
 	PrivateDNSName     string        `xml:"privateDnsName"`
 	DNSName            string        `xml:"dnsName"`
+	PrivateIPAddress   string        `xml:"privateIpAddress"`
+	IPAddress          string        `xml:"ipAddress"`
+	VpcId              string        `xml:"vpcId"`
+	SubnetId           string        `xml:"subnetId"`
 	KeyName            string        `xml:"keyName"`
 	AMILaunchIndex     int           `xml:"amiLaunchIndex"`
 	Hypervisor         string        `xml:"hypervisor"`
@@ -577,6 +581,971 @@ func (ec2 *EC2) Snapshots(ids []string, filter *Filter) (resp *SnapshotsResp, er
 	return
 }
 
+// ----------------------------------------------------------------------------
+// Volume management functions and types.
+
+// The CreateVolume type encapsulates options for the respective request in EC2.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateVolume.html
+// for more details.
+type CreateVolume struct {
+	AvailZone  string
+	Size       int64 // in GiB, may be zero if SnapshotId is set
+	SnapshotId string
+	VolumeType string // "standard" if empty, or "io1"
+	IOPS       int64  // io1 volumes only
+}
+
+// Volume represents details about an EBS volume.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeVolumes.html
+// for more details.
+type Volume struct {
+	Id          string             `xml:"volumeId"`
+	Size        int64              `xml:"size"`
+	SnapshotId  string             `xml:"snapshotId"`
+	AvailZone   string             `xml:"availabilityZone"`
+	Status      string             `xml:"status"`
+	CreateTime  string             `xml:"createTime"`
+	VolumeType  string             `xml:"volumeType"`
+	IOPS        int64              `xml:"iops"`
+	Attachments []VolumeAttachment `xml:"attachmentSet>item"`
+	Tags        []Tag              `xml:"tagSet>item"`
+}
+
+// VolumeAttachment represents the attachment of an EBS volume to an instance.
+type VolumeAttachment struct {
+	VolumeId            string `xml:"volumeId"`
+	InstanceId          string `xml:"instanceId"`
+	Device              string `xml:"device"`
+	Status              string `xml:"status"`
+	AttachTime          string `xml:"attachTime"`
+	DeleteOnTermination bool   `xml:"deleteOnTermination"`
+}
+
+// Response to a CreateVolume request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateVolume.html
+// for more details.
+type CreateVolumeResp struct {
+	RequestId string `xml:"requestId"`
+	Volume
+}
+
+// CreateVolume creates an EBS volume, either empty or from a snapshot.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateVolume.html
+// for more details.
+func (ec2 *EC2) CreateVolume(options *CreateVolume) (resp *CreateVolumeResp, err error) {
+	params := makeParams("CreateVolume")
+	params["AvailabilityZone"] = options.AvailZone
+	if options.Size != 0 {
+		params["Size"] = strconv.FormatInt(options.Size, 10)
+	}
+	if options.SnapshotId != "" {
+		params["SnapshotId"] = options.SnapshotId
+	}
+	if options.VolumeType != "" {
+		params["VolumeType"] = options.VolumeType
+	}
+	if options.IOPS != 0 {
+		params["Iops"] = strconv.FormatInt(options.IOPS, 10)
+	}
+
+	resp = &CreateVolumeResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DeleteVolume deletes an EBS volume. The volume must not be attached to
+// an instance.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DeleteVolume.html
+// for more details.
+func (ec2 *EC2) DeleteVolume(id string) (resp *SimpleResp, err error) {
+	params := makeParams("DeleteVolume")
+	params["VolumeId"] = id
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to an AttachVolume or DetachVolume request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AttachVolume.html
+// for more details.
+type VolumeAttachmentResp struct {
+	RequestId string `xml:"requestId"`
+	VolumeAttachment
+}
+
+// AttachVolume attaches an EBS volume to an instance, exposing it with the
+// given device name, e.g. "/dev/sdf". The volume and the instance must be
+// in the same availability zone.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AttachVolume.html
+// for more details.
+func (ec2 *EC2) AttachVolume(volumeId, instanceId, device string) (resp *VolumeAttachmentResp, err error) {
+	params := makeParams("AttachVolume")
+	params["VolumeId"] = volumeId
+	params["InstanceId"] = instanceId
+	params["Device"] = device
+
+	resp = &VolumeAttachmentResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DetachVolume detaches an EBS volume from the instance it is attached to.
+// If force is true, the volume is detached even if the instance did not
+// release it, which may lose data.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DetachVolume.html
+// for more details.
+func (ec2 *EC2) DetachVolume(volumeId string, force bool) (resp *VolumeAttachmentResp, err error) {
+	params := makeParams("DetachVolume")
+	params["VolumeId"] = volumeId
+	if force {
+		params["Force"] = "true"
+	}
+
+	resp = &VolumeAttachmentResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to a DescribeVolumes request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeVolumes.html
+// for more details.
+type VolumesResp struct {
+	RequestId string   `xml:"requestId"`
+	Volumes   []Volume `xml:"volumeSet>item"`
+}
+
+// Volumes returns details about EBS volumes. The ids and filter
+// parameters, if provided, limit the volumes returned.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeVolumes.html
+// for more details.
+func (ec2 *EC2) Volumes(ids []string, filter *Filter) (resp *VolumesResp, err error) {
+	params := makeParams("DescribeVolumes")
+	addParamsList(params, "VolumeId", ids)
+	filter.addParams(params)
+
+	resp = &VolumesResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// ----------------------------------------------------------------------------
+// Key pair management functions and types.
+
+// KeyPair represents details about a key pair.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_KeyPairInfo.html
+// for more details.
+type KeyPair struct {
+	Name        string `xml:"keyName"`
+	Fingerprint string `xml:"keyFingerprint"`
+}
+
+// Response to a CreateKeyPair request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateKeyPair.html
+// for more details.
+type CreateKeyPairResp struct {
+	RequestId string `xml:"requestId"`
+	KeyPair
+
+	// Material holds the unencrypted PEM encoded RSA private key. EC2
+	// does not keep it, so it cannot be retrieved again.
+	Material string `xml:"keyMaterial"`
+}
+
+// CreateKeyPair creates a 2048-bit RSA key pair with the given name.
+// EC2 stores the public key and returns the private key.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateKeyPair.html
+// for more details.
+func (ec2 *EC2) CreateKeyPair(name string) (resp *CreateKeyPairResp, err error) {
+	params := makeParams("CreateKeyPair")
+	params["KeyName"] = name
+
+	resp = &CreateKeyPairResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to an ImportKeyPair request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_ImportKeyPair.html
+// for more details.
+type ImportKeyPairResp struct {
+	RequestId string `xml:"requestId"`
+	KeyPair
+}
+
+// ImportKeyPair imports the public key of an RSA key pair created with a
+// third-party tool, in the OpenSSH format of authorized_keys files, e.g.
+// "ssh-rsa AAAA... user@host".
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_ImportKeyPair.html
+// for more details.
+func (ec2 *EC2) ImportKeyPair(name string, publicKey []byte) (resp *ImportKeyPairResp, err error) {
+	params := makeParams("ImportKeyPair")
+	params["KeyName"] = name
+	params["PublicKeyMaterial"] = b64.EncodeToString(publicKey)
+
+	resp = &ImportKeyPairResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DeleteKeyPair deletes the key pair with the given name. Instances
+// started with the key pair are not affected.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DeleteKeyPair.html
+// for more details.
+func (ec2 *EC2) DeleteKeyPair(name string) (resp *SimpleResp, err error) {
+	params := makeParams("DeleteKeyPair")
+	params["KeyName"] = name
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to a DescribeKeyPairs request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeKeyPairs.html
+// for more details.
+type KeyPairsResp struct {
+	RequestId string    `xml:"requestId"`
+	KeyPairs  []KeyPair `xml:"keySet>item"`
+}
+
+// KeyPairs returns details about key pairs. The names and filter
+// parameters, if provided, limit the key pairs returned.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeKeyPairs.html
+// for more details.
+func (ec2 *EC2) KeyPairs(names []string, filter *Filter) (resp *KeyPairsResp, err error) {
+	params := makeParams("DescribeKeyPairs")
+	addParamsList(params, "KeyName", names)
+	filter.addParams(params)
+
+	resp = &KeyPairsResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// ----------------------------------------------------------------------------
+// Elastic IP address management functions and types.
+
+// Address represents details about an Elastic IP address.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_Address.html
+// for more details.
+type Address struct {
+	PublicIp           string `xml:"publicIp"`
+	Domain             string `xml:"domain"`
+	AllocationId       string `xml:"allocationId"`
+	InstanceId         string `xml:"instanceId"`
+	AssociationId      string `xml:"associationId"`
+	NetworkInterfaceId string `xml:"networkInterfaceId"`
+	PrivateIPAddress   string `xml:"privateIpAddress"`
+}
+
+// Response to an AllocateAddress request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AllocateAddress.html
+// for more details.
+type AllocateAddressResp struct {
+	RequestId    string `xml:"requestId"`
+	PublicIp     string `xml:"publicIp"`
+	Domain       string `xml:"domain"`
+	AllocationId string `xml:"allocationId"`
+}
+
+// AllocateAddress acquires an Elastic IP address. The domain is "vpc" for
+// an address to be used with instances in a VPC, and empty or "standard"
+// for one to be used with EC2-Classic instances.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AllocateAddress.html
+// for more details.
+func (ec2 *EC2) AllocateAddress(domain string) (resp *AllocateAddressResp, err error) {
+	params := makeParams("AllocateAddress")
+	if domain != "" {
+		params["Domain"] = domain
+	}
+
+	resp = &AllocateAddressResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// ReleaseAddress releases an Elastic IP address. EC2-Classic addresses are
+// identified by their public IP and VPC addresses by their allocation id;
+// the other parameter must be empty.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_ReleaseAddress.html
+// for more details.
+func (ec2 *EC2) ReleaseAddress(publicIp, allocationId string) (resp *SimpleResp, err error) {
+	params := makeParams("ReleaseAddress")
+	if publicIp != "" {
+		params["PublicIp"] = publicIp
+	}
+	if allocationId != "" {
+		params["AllocationId"] = allocationId
+	}
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// The AssociateAddress type encapsulates options for the respective
+// request in EC2. PublicIp identifies EC2-Classic addresses and
+// AllocationId VPC addresses.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AssociateAddress.html
+// for more details.
+type AssociateAddress struct {
+	InstanceId   string
+	PublicIp     string
+	AllocationId string
+
+	// AllowReassociation allows a VPC address already associated with
+	// another instance to be moved.
+	AllowReassociation bool
+}
+
+// Response to an AssociateAddress request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AssociateAddress.html
+// for more details.
+type AssociateAddressResp struct {
+	RequestId     string `xml:"requestId"`
+	AssociationId string `xml:"associationId"` // VPC addresses only
+}
+
+// AssociateAddress associates an Elastic IP address with an instance,
+// replacing the public address of the instance.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AssociateAddress.html
+// for more details.
+func (ec2 *EC2) AssociateAddress(options *AssociateAddress) (resp *AssociateAddressResp, err error) {
+	params := makeParams("AssociateAddress")
+	params["InstanceId"] = options.InstanceId
+	if options.PublicIp != "" {
+		params["PublicIp"] = options.PublicIp
+	}
+	if options.AllocationId != "" {
+		params["AllocationId"] = options.AllocationId
+	}
+	if options.AllowReassociation {
+		params["AllowReassociation"] = "true"
+	}
+
+	resp = &AssociateAddressResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DisassociateAddress disassociates an Elastic IP address from the
+// instance it is associated with. EC2-Classic addresses are identified by
+// their public IP and VPC addresses by their association id; the other
+// parameter must be empty.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DisassociateAddress.html
+// for more details.
+func (ec2 *EC2) DisassociateAddress(publicIp, associationId string) (resp *SimpleResp, err error) {
+	params := makeParams("DisassociateAddress")
+	if publicIp != "" {
+		params["PublicIp"] = publicIp
+	}
+	if associationId != "" {
+		params["AssociationId"] = associationId
+	}
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to a DescribeAddresses request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeAddresses.html
+// for more details.
+type AddressesResp struct {
+	RequestId string    `xml:"requestId"`
+	Addresses []Address `xml:"addressesSet>item"`
+}
+
+// Addresses returns details about Elastic IP addresses. The publicIps and
+// filter parameters, if provided, limit the addresses returned.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeAddresses.html
+// for more details.
+func (ec2 *EC2) Addresses(publicIps []string, filter *Filter) (resp *AddressesResp, err error) {
+	params := makeParams("DescribeAddresses")
+	addParamsList(params, "PublicIp", publicIps)
+	filter.addParams(params)
+
+	resp = &AddressesResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// ----------------------------------------------------------------------------
+// VPC management functions and types.
+
+// VPC represents details about a virtual private cloud.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_Vpc.html
+// for more details.
+type VPC struct {
+	Id              string `xml:"vpcId"`
+	State           string `xml:"state"`
+	CidrBlock       string `xml:"cidrBlock"`
+	DHCPOptionsId   string `xml:"dhcpOptionsId"`
+	InstanceTenancy string `xml:"instanceTenancy"`
+	IsDefault       bool   `xml:"isDefault"`
+	Tags            []Tag  `xml:"tagSet>item"`
+}
+
+// Response to a CreateVpc request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateVpc.html
+// for more details.
+type CreateVPCResp struct {
+	RequestId string `xml:"requestId"`
+	VPC       VPC    `xml:"vpc"`
+}
+
+// CreateVPC creates a VPC with the given CIDR block, e.g. "10.0.0.0/16".
+// The instance tenancy is "default" if empty, or "dedicated".
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateVpc.html
+// for more details.
+func (ec2 *EC2) CreateVPC(cidrBlock, instanceTenancy string) (resp *CreateVPCResp, err error) {
+	params := makeParams("CreateVpc")
+	params["CidrBlock"] = cidrBlock
+	if instanceTenancy != "" {
+		params["InstanceTenancy"] = instanceTenancy
+	}
+
+	resp = &CreateVPCResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DeleteVPC deletes a VPC. Its subnets, internet gateways and route tables
+// other than the main one must be deleted or detached first.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DeleteVpc.html
+// for more details.
+func (ec2 *EC2) DeleteVPC(id string) (resp *SimpleResp, err error) {
+	params := makeParams("DeleteVpc")
+	params["VpcId"] = id
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to a DescribeVpcs request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeVpcs.html
+// for more details.
+type VPCsResp struct {
+	RequestId string `xml:"requestId"`
+	VPCs      []VPC  `xml:"vpcSet>item"`
+}
+
+// VPCs returns details about VPCs. The ids and filter parameters, if
+// provided, limit the VPCs returned.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeVpcs.html
+// for more details.
+func (ec2 *EC2) VPCs(ids []string, filter *Filter) (resp *VPCsResp, err error) {
+	params := makeParams("DescribeVpcs")
+	addParamsList(params, "VpcId", ids)
+	filter.addParams(params)
+
+	resp = &VPCsResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Subnet represents details about a VPC subnet.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_Subnet.html
+// for more details.
+type Subnet struct {
+	Id                      string `xml:"subnetId"`
+	State                   string `xml:"state"`
+	VpcId                   string `xml:"vpcId"`
+	CidrBlock               string `xml:"cidrBlock"`
+	AvailableIPAddressCount int    `xml:"availableIpAddressCount"`
+	AvailZone               string `xml:"availabilityZone"`
+	DefaultForAZ            bool   `xml:"defaultForAz"`
+	MapPublicIPOnLaunch     bool   `xml:"mapPublicIpOnLaunch"`
+	Tags                    []Tag  `xml:"tagSet>item"`
+}
+
+// Response to a CreateSubnet request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateSubnet.html
+// for more details.
+type CreateSubnetResp struct {
+	RequestId string `xml:"requestId"`
+	Subnet    Subnet `xml:"subnet"`
+}
+
+// CreateSubnet creates a subnet in a VPC. The CIDR block must lie within
+// that of the VPC and must not overlap those of its other subnets. If
+// availZone is empty, EC2 picks one.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateSubnet.html
+// for more details.
+func (ec2 *EC2) CreateSubnet(vpcId, cidrBlock, availZone string) (resp *CreateSubnetResp, err error) {
+	params := makeParams("CreateSubnet")
+	params["VpcId"] = vpcId
+	params["CidrBlock"] = cidrBlock
+	if availZone != "" {
+		params["AvailabilityZone"] = availZone
+	}
+
+	resp = &CreateSubnetResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DeleteSubnet deletes a subnet. The instances running in it must be
+// terminated first.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DeleteSubnet.html
+// for more details.
+func (ec2 *EC2) DeleteSubnet(id string) (resp *SimpleResp, err error) {
+	params := makeParams("DeleteSubnet")
+	params["SubnetId"] = id
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to a DescribeSubnets request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeSubnets.html
+// for more details.
+type SubnetsResp struct {
+	RequestId string   `xml:"requestId"`
+	Subnets   []Subnet `xml:"subnetSet>item"`
+}
+
+// Subnets returns details about VPC subnets. The ids and filter
+// parameters, if provided, limit the subnets returned.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeSubnets.html
+// for more details.
+func (ec2 *EC2) Subnets(ids []string, filter *Filter) (resp *SubnetsResp, err error) {
+	params := makeParams("DescribeSubnets")
+	addParamsList(params, "SubnetId", ids)
+	filter.addParams(params)
+
+	resp = &SubnetsResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// InternetGateway represents details about an internet gateway.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_InternetGateway.html
+// for more details.
+type InternetGateway struct {
+	Id          string                      `xml:"internetGatewayId"`
+	Attachments []InternetGatewayAttachment `xml:"attachmentSet>item"`
+	Tags        []Tag                       `xml:"tagSet>item"`
+}
+
+// InternetGatewayAttachment represents the attachment of an internet
+// gateway to a VPC.
+type InternetGatewayAttachment struct {
+	VpcId string `xml:"vpcId"`
+	State string `xml:"state"`
+}
+
+// Response to a CreateInternetGateway request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateInternetGateway.html
+// for more details.
+type CreateInternetGatewayResp struct {
+	RequestId       string          `xml:"requestId"`
+	InternetGateway InternetGateway `xml:"internetGateway"`
+}
+
+// CreateInternetGateway creates an internet gateway, to be attached to a
+// VPC with AttachInternetGateway.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateInternetGateway.html
+// for more details.
+func (ec2 *EC2) CreateInternetGateway() (resp *CreateInternetGatewayResp, err error) {
+	params := makeParams("CreateInternetGateway")
+
+	resp = &CreateInternetGatewayResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DeleteInternetGateway deletes a detached internet gateway.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DeleteInternetGateway.html
+// for more details.
+func (ec2 *EC2) DeleteInternetGateway(id string) (resp *SimpleResp, err error) {
+	params := makeParams("DeleteInternetGateway")
+	params["InternetGatewayId"] = id
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// AttachInternetGateway attaches an internet gateway to a VPC. A VPC has
+// at most one internet gateway.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AttachInternetGateway.html
+// for more details.
+func (ec2 *EC2) AttachInternetGateway(id, vpcId string) (resp *SimpleResp, err error) {
+	params := makeParams("AttachInternetGateway")
+	params["InternetGatewayId"] = id
+	params["VpcId"] = vpcId
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DetachInternetGateway detaches an internet gateway from a VPC.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DetachInternetGateway.html
+// for more details.
+func (ec2 *EC2) DetachInternetGateway(id, vpcId string) (resp *SimpleResp, err error) {
+	params := makeParams("DetachInternetGateway")
+	params["InternetGatewayId"] = id
+	params["VpcId"] = vpcId
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to a DescribeInternetGateways request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeInternetGateways.html
+// for more details.
+type InternetGatewaysResp struct {
+	RequestId        string            `xml:"requestId"`
+	InternetGateways []InternetGateway `xml:"internetGatewaySet>item"`
+}
+
+// InternetGateways returns details about internet gateways. The ids and
+// filter parameters, if provided, limit the gateways returned.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeInternetGateways.html
+// for more details.
+func (ec2 *EC2) InternetGateways(ids []string, filter *Filter) (resp *InternetGatewaysResp, err error) {
+	params := makeParams("DescribeInternetGateways")
+	addParamsList(params, "InternetGatewayId", ids)
+	filter.addParams(params)
+
+	resp = &InternetGatewaysResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RouteTable represents details about a VPC route table.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_RouteTable.html
+// for more details.
+type RouteTable struct {
+	Id           string                  `xml:"routeTableId"`
+	VpcId        string                  `xml:"vpcId"`
+	Routes       []Route                 `xml:"routeSet>item"`
+	Associations []RouteTableAssociation `xml:"associationSet>item"`
+	Tags         []Tag                   `xml:"tagSet>item"`
+}
+
+// Route represents a route in a route table. Routes to a gateway or an
+// instance that went away are in the "blackhole" state.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_Route.html
+// for more details.
+type Route struct {
+	DestinationCidrBlock string `xml:"destinationCidrBlock"`
+	GatewayId            string `xml:"gatewayId"`
+	InstanceId           string `xml:"instanceId"`
+	State                string `xml:"state"`
+	Origin               string `xml:"origin"`
+}
+
+// RouteTableAssociation represents the association of a route table with
+// a subnet, or with its VPC as the main route table.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_RouteTableAssociation.html
+// for more details.
+type RouteTableAssociation struct {
+	Id           string `xml:"routeTableAssociationId"`
+	RouteTableId string `xml:"routeTableId"`
+	SubnetId     string `xml:"subnetId"`
+	Main         bool   `xml:"main"`
+}
+
+// Response to a CreateRouteTable request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateRouteTable.html
+// for more details.
+type CreateRouteTableResp struct {
+	RequestId  string     `xml:"requestId"`
+	RouteTable RouteTable `xml:"routeTable"`
+}
+
+// CreateRouteTable creates a route table for a VPC. It holds the local
+// route of the VPC.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateRouteTable.html
+// for more details.
+func (ec2 *EC2) CreateRouteTable(vpcId string) (resp *CreateRouteTableResp, err error) {
+	params := makeParams("CreateRouteTable")
+	params["VpcId"] = vpcId
+
+	resp = &CreateRouteTableResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DeleteRouteTable deletes a route table. The main route table of a VPC
+// and route tables associated with subnets cannot be deleted.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DeleteRouteTable.html
+// for more details.
+func (ec2 *EC2) DeleteRouteTable(id string) (resp *SimpleResp, err error) {
+	params := makeParams("DeleteRouteTable")
+	params["RouteTableId"] = id
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to a DescribeRouteTables request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeRouteTables.html
+// for more details.
+type RouteTablesResp struct {
+	RequestId   string       `xml:"requestId"`
+	RouteTables []RouteTable `xml:"routeTableSet>item"`
+}
+
+// RouteTables returns details about route tables. The ids and filter
+// parameters, if provided, limit the route tables returned.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeRouteTables.html
+// for more details.
+func (ec2 *EC2) RouteTables(ids []string, filter *Filter) (resp *RouteTablesResp, err error) {
+	params := makeParams("DescribeRouteTables")
+	addParamsList(params, "RouteTableId", ids)
+	filter.addParams(params)
+
+	resp = &RouteTablesResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Response to an AssociateRouteTable request.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AssociateRouteTable.html
+// for more details.
+type AssociateRouteTableResp struct {
+	RequestId     string `xml:"requestId"`
+	AssociationId string `xml:"associationId"`
+}
+
+// AssociateRouteTable associates a route table with a subnet of the same
+// VPC, in place of the main route table of the VPC.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AssociateRouteTable.html
+// for more details.
+func (ec2 *EC2) AssociateRouteTable(id, subnetId string) (resp *AssociateRouteTableResp, err error) {
+	params := makeParams("AssociateRouteTable")
+	params["RouteTableId"] = id
+	params["SubnetId"] = subnetId
+
+	resp = &AssociateRouteTableResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DisassociateRouteTable removes the association of a route table with a
+// subnet, which then uses the main route table of its VPC.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DisassociateRouteTable.html
+// for more details.
+func (ec2 *EC2) DisassociateRouteTable(associationId string) (resp *SimpleResp, err error) {
+	params := makeParams("DisassociateRouteTable")
+	params["AssociationId"] = associationId
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// The CreateRoute type encapsulates options for the respective request in
+// EC2. Exactly one of GatewayId and InstanceId must be set.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateRoute.html
+// for more details.
+type CreateRoute struct {
+	RouteTableId         string
+	DestinationCidrBlock string
+	GatewayId            string
+	InstanceId           string
+}
+
+// CreateRoute adds a route to a route table.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateRoute.html
+// for more details.
+func (ec2 *EC2) CreateRoute(options *CreateRoute) (resp *SimpleResp, err error) {
+	params := makeParams("CreateRoute")
+	params["RouteTableId"] = options.RouteTableId
+	params["DestinationCidrBlock"] = options.DestinationCidrBlock
+	if options.GatewayId != "" {
+		params["GatewayId"] = options.GatewayId
+	}
+	if options.InstanceId != "" {
+		params["InstanceId"] = options.InstanceId
+	}
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DeleteRoute removes the route with the given destination from a route
+// table.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DeleteRoute.html
+// for more details.
+func (ec2 *EC2) DeleteRoute(routeTableId, destinationCidrBlock string) (resp *SimpleResp, err error) {
+	params := makeParams("DeleteRoute")
+	params["RouteTableId"] = routeTableId
+	params["DestinationCidrBlock"] = destinationCidrBlock
+
+	resp = &SimpleResp{}
+	err = ec2.query(params, resp)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
 // ----------------------------------------------------------------------------
 // Security group management functions and types.
 
@@ -598,9 +1567,22 @@ type CreateSecurityGroupResp struct {
 //
 // See http://goo.gl/Eo7Yl for more details.
 func (ec2 *EC2) CreateSecurityGroup(name, description string) (resp *CreateSecurityGroupResp, err error) {
+	return ec2.CreateSecurityGroupVPC("", name, description)
+}
+
+// CreateSecurityGroupVPC creates a security group in the given VPC. If
+// vpcId is empty, the group is an EC2-Classic one, as created by
+// CreateSecurityGroup.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateSecurityGroup.html
+// for more details.
+func (ec2 *EC2) CreateSecurityGroupVPC(vpcId, name, description string) (resp *CreateSecurityGroupResp, err error) {
 	params := makeParams("CreateSecurityGroup")
 	params["GroupName"] = name
 	params["GroupDescription"] = description
+	if vpcId != "" {
+		params["VpcId"] = vpcId
+	}
 
 	resp = &CreateSecurityGroupResp{}
 	err = ec2.query(params, resp)
@@ -627,10 +1609,17 @@ type SecurityGroupInfo struct {
 	SecurityGroup
 	OwnerId     string   `xml:"ownerId"`
 	Description string   `xml:"groupDescription"`
+	VpcId       string   `xml:"vpcId"`
 	IPPerms     []IPPerm `xml:"ipPermissions>item"`
+
+	// IPPermsEgress holds the outbound rules of VPC security groups. Their
+	// SourceIPs and SourceGroups are the destinations of the traffic.
+	IPPermsEgress []IPPerm `xml:"ipPermissionsEgress>item"`
 }
 
-// IPPerm represents an allowance within an EC2 security group.
+// IPPerm represents an allowance within an EC2 security group. A Protocol
+// of "-1" stands for all protocols and ports, and is only allowed in VPC
+// security groups.
 //
 // See http://goo.gl/4oTxv for more details.
 type IPPerm struct {
@@ -738,6 +1727,26 @@ func (ec2 *EC2) RevokeSecurityGroup(group SecurityGroup, perms []IPPerm) (resp *
 	return ec2.authOrRevoke("RevokeSecurityGroupIngress", group, perms)
 }
 
+// AuthorizeSecurityGroupEgress allows instances within the given VPC
+// security group to send traffic matching the provided rules. The
+// SourceIPs and SourceGroups of perms are the destinations of the traffic,
+// and the group must be given by id.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_AuthorizeSecurityGroupEgress.html
+// for more details.
+func (ec2 *EC2) AuthorizeSecurityGroupEgress(group SecurityGroup, perms []IPPerm) (resp *SimpleResp, err error) {
+	return ec2.authOrRevoke("AuthorizeSecurityGroupEgress", group, perms)
+}
+
+// RevokeSecurityGroupEgress revokes outbound rules from a VPC security
+// group.
+//
+// See http://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_RevokeSecurityGroupEgress.html
+// for more details.
+func (ec2 *EC2) RevokeSecurityGroupEgress(group SecurityGroup, perms []IPPerm) (resp *SimpleResp, err error) {
+	return ec2.authOrRevoke("RevokeSecurityGroupEgress", group, perms)
+}
+
 func (ec2 *EC2) authOrRevoke(op string, group SecurityGroup, perms []IPPerm) (resp *SimpleResp, err error) {
 	params := makeParams(op)
 	if group.Id != "" {
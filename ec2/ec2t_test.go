@@ -1,6 +1,9 @@
 package ec2_test
 
 import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"github.com/flaviamissi/go-elb/aws"
 	"github.com/flaviamissi/go-elb/ec2"
@@ -8,6 +11,8 @@ import (
 	. "launchpad.net/gocheck"
 	"regexp"
 	"sort"
+	"strings"
+	"time"
 )
 
 // LocalServer represents a local ec2test fake server.
@@ -78,6 +83,1376 @@ func (s *LocalServerSuite) TestUserData(c *C) {
 	c.Assert(tinst.UserData, DeepEquals, data)
 }
 
+func (s *LocalServerSuite) TestAvailabilityZonePlacement(c *C) {
+	inst, err := s.ec2.RunInstances(&ec2.RunInstances{
+		ImageId:      imageId,
+		InstanceType: "t1.micro",
+		AvailZone:    "us-east-1c",
+	})
+	c.Assert(err, IsNil)
+	id := inst.Instances[0].InstanceId
+	defer s.ec2.TerminateInstances([]string{id})
+	c.Assert(inst.Instances[0].AvailZone, Equals, "us-east-1c")
+
+	filter := ec2.NewFilter()
+	filter.Add("availability-zone", "us-east-1c")
+	resp, err := s.ec2.Instances(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Reservations, HasLen, 1)
+	c.Assert(resp.Reservations[0].Instances[0].InstanceId, Equals, id)
+
+	desc, ok := s.srv.srv.DescribeInstance(id)
+	c.Assert(ok, Equals, true)
+	c.Assert(desc.AvailZone, Equals, "us-east-1c")
+	c.Assert(desc.State.Name, Equals, "pending")
+	_, ok = s.srv.srv.DescribeInstance("i-unknown")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *LocalServerSuite) TestSnapshotAndRestore(c *C) {
+	srv, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	e := ec2.New(s.srv.auth, aws.Region{EC2Endpoint: srv.URL()})
+
+	g, err := e.CreateSecurityGroup("snapgroup", "snapshot group")
+	c.Assert(err, IsNil)
+	_, err = e.AuthorizeSecurityGroup(g.SecurityGroup, []ec2.IPPerm{{
+		Protocol:     "tcp",
+		FromPort:     80,
+		ToPort:       80,
+		SourceIPs:    []string{"10.0.0.0/8"},
+		SourceGroups: []ec2.UserSecurityGroup{{Id: g.Id}},
+	}})
+	c.Assert(err, IsNil)
+	inst, err := e.RunInstances(&ec2.RunInstances{
+		ImageId:        imageId,
+		InstanceType:   "t1.micro",
+		AvailZone:      "us-east-1c",
+		UserData:       []byte("hello"),
+		SecurityGroups: []ec2.SecurityGroup{g.SecurityGroup},
+	})
+	c.Assert(err, IsNil)
+	id := inst.Instances[0].InstanceId
+	vol, err := e.CreateVolume(&ec2.CreateVolume{AvailZone: "us-east-1c", Size: 10})
+	c.Assert(err, IsNil)
+	addr, err := e.AllocateAddress("")
+	c.Assert(err, IsNil)
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: id, PublicIp: addr.PublicIp})
+	c.Assert(err, IsNil)
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	sub, err := e.CreateSubnet(vpc.VPC.Id, "10.0.1.0/24", "")
+	c.Assert(err, IsNil)
+	igw, err := e.CreateInternetGateway()
+	c.Assert(err, IsNil)
+	_, err = e.AttachInternetGateway(igw.InternetGateway.Id, vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	rt, err := e.CreateRouteTable(vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	_, err = e.AssociateRouteTable(rt.RouteTable.Id, sub.Subnet.Id)
+	c.Assert(err, IsNil)
+	_, err = e.CreateRoute(&ec2.CreateRoute{RouteTableId: rt.RouteTable.Id, DestinationCidrBlock: "0.0.0.0/0", GatewayId: igw.InternetGateway.Id})
+	c.Assert(err, IsNil)
+	vpcInst, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id})
+	c.Assert(err, IsNil)
+	tables, err := e.RouteTables(nil, nil)
+	c.Assert(err, IsNil)
+
+	data, err := srv.Snapshot().JSON()
+	c.Assert(err, IsNil)
+	snap, err := ec2test.ParseSnapshot(data)
+	c.Assert(err, IsNil)
+	c.Assert(snap, DeepEquals, srv.Snapshot())
+
+	restored, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	defer restored.Quit()
+	c.Assert(restored.Restore(snap), IsNil)
+	c.Assert(restored.Snapshot(), DeepEquals, snap)
+	c.Assert(restored.Instance(id).UserData, DeepEquals, []byte("hello"))
+	re := ec2.New(s.srv.auth, aws.Region{EC2Endpoint: restored.URL()})
+
+	resp, err := re.Instances([]string{id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Reservations, HasLen, 1)
+	c.Assert(resp.Reservations[0].ReservationId, Equals, inst.ReservationId)
+	c.Assert(resp.Reservations[0].SecurityGroups, DeepEquals, []ec2.SecurityGroup{g.SecurityGroup})
+	c.Assert(resp.Reservations[0].Instances[0].AvailZone, Equals, "us-east-1c")
+	c.Assert(resp.Reservations[0].Instances[0].IPAddress, Equals, addr.PublicIp)
+
+	groups, err := re.SecurityGroups([]ec2.SecurityGroup{g.SecurityGroup}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(groups.Groups, HasLen, 1)
+	c.Assert(groups.Groups[0].IPPerms, HasLen, 1)
+	c.Assert(groups.Groups[0].IPPerms[0].SourceIPs, DeepEquals, []string{"10.0.0.0/8"})
+	c.Assert(groups.Groups[0].IPPerms[0].SourceGroups[0].Id, Equals, g.Id)
+
+	vols, err := re.Volumes([]string{vol.Id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(vols.Volumes[0].Size, Equals, int64(10))
+	c.Assert(vols.Volumes[0].AvailZone, Equals, "us-east-1c")
+
+	restoredTables, err := re.RouteTables(nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(restoredTables.RouteTables, DeepEquals, tables.RouteTables)
+	resp, err = re.Instances([]string{vpcInst.Instances[0].InstanceId}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Reservations[0].Instances[0].SubnetId, Equals, sub.Subnet.Id)
+	c.Assert(resp.Reservations[0].Instances[0].VpcId, Equals, vpc.VPC.Id)
+
+	// New ids do not clash with restored ones.
+	inst2, err := re.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro"})
+	c.Assert(err, IsNil)
+	c.Assert(inst2.Instances[0].InstanceId, Not(Equals), id)
+	c.Assert(inst2.ReservationId, Not(Equals), inst.ReservationId)
+}
+
+func (s *LocalServerSuite) TestRestoreRejectsDanglingReferences(c *C) {
+	srv, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	before := srv.Snapshot()
+	err = srv.Restore(&ec2test.Snapshot{
+		Instances: []ec2test.InstanceSnapshot{{Id: "i-0", ReservationId: "r-9"}},
+	})
+	c.Assert(err, ErrorMatches, `instance "i-0" refers to unknown reservation "r-9"`)
+	err = srv.Restore(&ec2test.Snapshot{
+		SecurityGroups: []ec2test.SecurityGroupSnapshot{{
+			Id:          "sg-0",
+			Permissions: []ec2test.PermissionSnapshot{{Protocol: "tcp", SourceGroupId: "sg-9"}},
+		}},
+	})
+	c.Assert(err, ErrorMatches, `security group "sg-0" refers to unknown group "sg-9"`)
+	err = srv.Restore(&ec2test.Snapshot{
+		Volumes: []ec2test.VolumeSnapshot{{Id: "vol-0", Attachment: &ec2test.AttachmentSnapshot{InstanceId: "i-9"}}},
+	})
+	c.Assert(err, ErrorMatches, `volume "vol-0" refers to unknown instance "i-9"`)
+	c.Assert(srv.Snapshot(), DeepEquals, before)
+}
+
+func (s *LocalServerSuite) TestSignatureVerification(c *C) {
+	srv, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	auth := aws.Auth{"key", "secret"}
+	srv.SetCredentials(auth)
+	region := aws.Region{EC2Endpoint: srv.URL()}
+
+	e := ec2.New(auth, region)
+	_, err = e.Instances(nil, nil)
+	c.Assert(err, IsNil)
+	// Values that need encoding are signed the same way on both ends.
+	_, err = e.CreateSecurityGroup("signed", "a b+c/d~e=f&g*h")
+	c.Assert(err, IsNil)
+
+	_, err = ec2.New(aws.Auth{"key", "wrong"}, region).Instances(nil, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ec2.Error).StatusCode, Equals, 403)
+	c.Assert(err.(*ec2.Error).Code, Equals, "SignatureDoesNotMatch")
+
+	_, err = ec2.New(aws.Auth{"unknown", "secret"}, region).Instances(nil, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ec2.Error).StatusCode, Equals, 401)
+	c.Assert(err.(*ec2.Error).Code, Equals, "AuthFailure")
+
+	ec2.FakeTime(true)
+	defer ec2.FakeTime(false)
+	_, err = e.Instances(nil, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ec2.Error).Code, Equals, "RequestExpired")
+	ec2.FakeTime(false)
+
+	srv.SetCredentials()
+	_, err = ec2.New(aws.Auth{"unknown", "secret"}, region).Instances(nil, nil)
+	c.Assert(err, IsNil)
+}
+
+// newVirtualClockServer starts a server of its own with a virtual clock, so
+// that the shared server keeps running on the wall clock.
+func newVirtualClockServer(c *C) (*ec2test.Server, *ec2.EC2) {
+	srv, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	srv.SetVirtualClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	return srv, ec2.New(aws.Auth{"abc", "123"}, aws.Region{EC2Endpoint: srv.URL()})
+}
+
+func instanceStateName(c *C, srv *ec2test.Server, id string) string {
+	inst, ok := srv.DescribeInstance(id)
+	c.Assert(ok, Equals, true)
+	return inst.State.Name
+}
+
+func (s *LocalServerSuite) TestInstanceLifecycle(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	inst, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro"})
+	c.Assert(err, IsNil)
+	id := inst.Instances[0].InstanceId
+	c.Assert(inst.Instances[0].State, Equals, ec2test.Pending)
+
+	srv.Advance(ec2test.DefaultLifecycle.Pending - time.Second)
+	c.Assert(instanceStateName(c, srv, id), Equals, "pending")
+	srv.Advance(time.Second)
+	c.Assert(instanceStateName(c, srv, id), Equals, "running")
+
+	resp, err := e.TerminateInstances([]string{id})
+	c.Assert(err, IsNil)
+	c.Assert(resp.StateChanges[0].CurrentState, Equals, ec2test.ShuttingDown)
+	srv.Advance(ec2test.DefaultLifecycle.ShuttingDown)
+	insts, err := e.Instances([]string{id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(insts.Reservations[0].Instances[0].State, Equals, ec2test.Terminated)
+
+	// Terminated instances go away after the retention period.
+	srv.Advance(ec2test.DefaultLifecycle.Retention)
+	insts, err = e.Instances(nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(insts.Reservations, HasLen, 0)
+	_, err = e.TerminateInstances([]string{id})
+	c.Assert(err, ErrorMatches, `.*\(InvalidInstanceID.NotFound\)`)
+}
+
+func (s *LocalServerSuite) TestTerminateInstancesInRequestOrder(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	ids := srv.NewInstances(12, "t1.micro", imageId, ec2test.Running, nil)
+	order := append([]string{ids[11], ids[3]}, ids[:3]...)
+	order = append(order, ids[4:11]...)
+	resp, err := e.TerminateInstances(order)
+	c.Assert(err, IsNil)
+	var got []string
+	for _, d := range resp.StateChanges {
+		got = append(got, d.InstanceId)
+	}
+	c.Assert(got, DeepEquals, order)
+}
+
+func (s *LocalServerSuite) TestSetLifecycle(c *C) {
+	srv, _ := newVirtualClockServer(c)
+	defer srv.Quit()
+	srv.SetLifecycle(ec2test.Lifecycle{Pending: time.Minute, Stopping: 2 * time.Minute, Retention: time.Hour})
+	ids := srv.NewInstances(1, "t1.micro", imageId, ec2test.Pending, nil)
+	ids = append(ids, srv.NewInstances(1, "t1.micro", imageId, ec2test.Stopping, nil)...)
+	srv.Advance(time.Minute)
+	c.Assert(instanceStateName(c, srv, ids[0]), Equals, "running")
+	c.Assert(instanceStateName(c, srv, ids[1]), Equals, "stopping")
+	srv.Advance(time.Minute)
+	c.Assert(instanceStateName(c, srv, ids[1]), Equals, "stopped")
+}
+
+func (s *LocalServerSuite) TestStopAndStartInstances(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	id := srv.NewInstances(1, "m1.small", imageId, ec2test.Running, nil)[0]
+
+	stop, err := e.StopInstances(id)
+	c.Assert(err, IsNil)
+	c.Assert(stop.StateChanges, DeepEquals, []ec2.InstanceStateChange{{
+		InstanceId:    id,
+		PreviousState: ec2test.Running,
+		CurrentState:  ec2test.Stopping,
+	}})
+	_, err = e.StartInstances(id)
+	c.Assert(err, ErrorMatches, `.*\(IncorrectInstanceState\)`)
+	srv.Advance(ec2test.DefaultLifecycle.Stopping)
+	c.Assert(instanceStateName(c, srv, id), Equals, "stopped")
+	_, err = e.RebootInstances(id)
+	c.Assert(err, ErrorMatches, `.*\(IncorrectState\)`)
+
+	start, err := e.StartInstances(id)
+	c.Assert(err, IsNil)
+	c.Assert(start.StateChanges[0].PreviousState, Equals, ec2test.Stopped)
+	c.Assert(start.StateChanges[0].CurrentState, Equals, ec2test.Pending)
+	srv.Advance(ec2test.DefaultLifecycle.Pending)
+	c.Assert(instanceStateName(c, srv, id), Equals, "running")
+	_, err = e.RebootInstances(id)
+	c.Assert(err, IsNil)
+	c.Assert(instanceStateName(c, srv, id), Equals, "running")
+
+	_, err = e.TerminateInstances([]string{id})
+	c.Assert(err, IsNil)
+	_, err = e.StopInstances(id)
+	c.Assert(err, ErrorMatches, `.*\(IncorrectInstanceState\)`)
+	_, err = e.StopInstances("i-unknown")
+	c.Assert(err, ErrorMatches, `.*\(InvalidInstanceID.NotFound\)`)
+}
+
+func (s *LocalServerSuite) TestStopInstanceStoreInstance(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	srv.AddImage(ec2.Image{Id: "ami-store", RootDeviceType: "instance-store"})
+	id := srv.NewInstances(1, "m1.small", "ami-store", ec2test.Running, nil)[0]
+	ebsId := srv.NewInstances(1, "m1.small", imageId, ec2test.Running, nil)[0]
+
+	_, err := e.StopInstances(ebsId, id)
+	c.Assert(err, ErrorMatches, `The instance '`+id+`' does not have an 'ebs' root device type and cannot be stopped. \(UnsupportedOperation\)`)
+	// No instance was stopped.
+	c.Assert(instanceStateName(c, srv, ebsId), Equals, "running")
+	_, err = e.StartInstances(id)
+	c.Assert(err, ErrorMatches, `.*\(UnsupportedOperation\)`)
+
+	filter := ec2.NewFilter()
+	filter.Add("root-device-type", "instance-store")
+	resp, err := e.Instances(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Reservations, HasLen, 1)
+	c.Assert(resp.Reservations[0].Instances[0].InstanceId, Equals, id)
+}
+
+func (s *LocalServerSuite) TestCreateTags(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	ids := srv.NewInstances(3, "m1.small", imageId, ec2test.Running, nil)
+
+	_, err := e.CreateTags(ids[:2], []ec2.Tag{{"env", "prod"}, {"role", "web"}})
+	c.Assert(err, IsNil)
+	_, err = e.CreateTags(ids[1:], []ec2.Tag{{"role", "db"}})
+	c.Assert(err, IsNil)
+	_, err = e.CreateTags([]string{"i-unknown"}, []ec2.Tag{{"role", "db"}})
+	c.Assert(err, ErrorMatches, `.*\(InvalidInstanceID.NotFound\)`)
+	_, err = e.CreateTags(ids, []ec2.Tag{{"aws:role", "db"}})
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+
+	resp, err := e.Instances(ids[:1], nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Reservations[0].Instances[0].Tags, DeepEquals, []ec2.Tag{{"env", "prod"}, {"role", "web"}})
+
+	tests := []struct {
+		name, value string
+		ids         []string
+	}{
+		{"tag:role", "db", ids[1:]},
+		{"tag:env", "prod", ids[:2]},
+		{"tag:env", "dev", nil},
+		{"tag-key", "env", ids[:2]},
+		{"tag-value", "web", ids[:1]},
+	}
+	for _, t := range tests {
+		filter := ec2.NewFilter()
+		filter.Add(t.name, t.value)
+		resp, err := e.Instances(nil, filter)
+		c.Assert(err, IsNil)
+		var got []string
+		for _, r := range resp.Reservations {
+			for _, inst := range r.Instances {
+				got = append(got, inst.InstanceId)
+			}
+		}
+		sort.Strings(got)
+		c.Check(got, DeepEquals, t.ids, Commentf("%s=%s", t.name, t.value))
+	}
+}
+
+func (s *LocalServerSuite) TestDescribeImages(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	ubuntu := srv.AddImage(ec2.Image{
+		Name:               "ubuntu-12.04-amd64",
+		Architecture:       "x86_64",
+		VirtualizationType: "paravirtual",
+	})
+	store := srv.AddImage(ec2.Image{
+		Id:             "ami-store",
+		Name:           "ubuntu-12.04-i386-store",
+		Architecture:   "i386",
+		RootDeviceType: "instance-store",
+		Public:         true,
+	})
+	c.Assert(ubuntu, Equals, "ami-0")
+
+	resp, err := e.Images([]string{ubuntu}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Images, HasLen, 1)
+	c.Assert(resp.Images[0].Name, Equals, "ubuntu-12.04-amd64")
+	c.Assert(resp.Images[0].State, Equals, "available")
+	c.Assert(resp.Images[0].RootDeviceType, Equals, "ebs")
+
+	tests := []struct {
+		name, value string
+		ids         []string
+	}{
+		{"name", "ubuntu-12.04-*", []string{ubuntu, store}},
+		{"name", "*-store", []string{store}},
+		{"architecture", "x86_64", []string{ubuntu}},
+		{"root-device-type", "instance-store", []string{store}},
+		{"is-public", "false", []string{ubuntu}},
+		{"state", "pending", nil},
+	}
+	for _, t := range tests {
+		filter := ec2.NewFilter()
+		filter.Add(t.name, t.value)
+		resp, err := e.Images(nil, filter)
+		c.Assert(err, IsNil)
+		var got []string
+		for _, img := range resp.Images {
+			got = append(got, img.Id)
+		}
+		c.Check(got, DeepEquals, t.ids, Commentf("%s=%s", t.name, t.value))
+	}
+
+	_, err = e.Images([]string{"ami-unknown"}, nil)
+	c.Assert(err, ErrorMatches, `.*\(InvalidAMIID.NotFound\)`)
+	srv.RemoveImage(store)
+	resp, err = e.Images(nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Images, HasLen, 1)
+}
+
+func (s *LocalServerSuite) TestSnapshotProgress(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	volId := createVolume(c, e, 20)
+	resp, err := e.CreateSnapshot(volId, "backup")
+	c.Assert(err, IsNil)
+	c.Assert(resp.VolumeSize, Equals, "20")
+	c.Assert(resp.Status, Equals, "pending")
+	c.Assert(resp.Progress, Equals, "0%")
+	id := resp.Id
+
+	srv.Advance(ec2test.DefaultLifecycle.Snapshot / 4)
+	snaps, err := e.Snapshots([]string{id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(snaps.Snapshots[0].Status, Equals, "pending")
+	c.Assert(snaps.Snapshots[0].Progress, Equals, "25%")
+	c.Assert(snaps.Snapshots[0].VolumeId, Equals, volId)
+	c.Assert(snaps.Snapshots[0].Description, Equals, "backup")
+
+	filter := ec2.NewFilter()
+	filter.Add("status", "completed")
+	snaps, err = e.Snapshots(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(snaps.Snapshots, HasLen, 0)
+
+	srv.Advance(ec2test.DefaultLifecycle.Snapshot)
+	snaps, err = e.Snapshots(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(snaps.Snapshots, HasLen, 1)
+	c.Assert(snaps.Snapshots[0].Progress, Equals, "100%")
+}
+
+func (s *LocalServerSuite) TestSnapshotProgressAcrossSetVirtualClock(c *C) {
+	srv, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	e := ec2.New(aws.Auth{"abc", "123"}, aws.Region{EC2Endpoint: srv.URL()})
+	resp, err := e.CreateSnapshot(createVolume(c, e, 8), "backup")
+	c.Assert(err, IsNil)
+
+	srv.SetVirtualClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	snaps, err := e.Snapshots([]string{resp.Id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(snaps.Snapshots[0].Status, Equals, "pending")
+	c.Assert(snaps.Snapshots[0].Progress, Equals, "0%")
+	c.Assert(snaps.Snapshots[0].StartTime, Matches, "(2013-12-31T23:59|2014-01-01T00:00:00).*")
+
+	srv.Advance(ec2test.DefaultLifecycle.Snapshot)
+	snaps, err = e.Snapshots([]string{resp.Id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(snaps.Snapshots[0].Status, Equals, "completed")
+}
+
+func (s *LocalServerSuite) TestDeleteSnapshotInUse(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	resp, err := e.CreateSnapshot(createVolume(c, e, 8), "root")
+	c.Assert(err, IsNil)
+	img := srv.AddImage(ec2.Image{
+		BlockDevices: []ec2.BlockDeviceMapping{{DeviceName: "/dev/sda1", SnapshotId: resp.Id}},
+	})
+
+	_, err = e.DeleteSnapshots([]string{resp.Id})
+	c.Assert(err, ErrorMatches, `The snapshot `+resp.Id+` is currently in use by `+img+` \(InvalidSnapshot.InUse\)`)
+	filter := ec2.NewFilter()
+	filter.Add("block-device-mapping.snapshot-id", resp.Id)
+	images, err := e.Images(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(images.Images, HasLen, 1)
+
+	srv.RemoveImage(img)
+	_, err = e.DeleteSnapshots([]string{resp.Id})
+	c.Assert(err, IsNil)
+	_, err = e.Snapshots([]string{resp.Id}, nil)
+	c.Assert(err, ErrorMatches, `.*\(InvalidSnapshot.NotFound\)`)
+}
+
+func createVolume(c *C, e *ec2.EC2, size int64) string {
+	resp, err := e.CreateVolume(&ec2.CreateVolume{AvailZone: "us-east-1a", Size: size})
+	c.Assert(err, IsNil)
+	return resp.Id
+}
+
+func volumeStatus(c *C, e *ec2.EC2, id string) (status, attachStatus string) {
+	resp, err := e.Volumes([]string{id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Volumes, HasLen, 1)
+	v := resp.Volumes[0]
+	if len(v.Attachments) > 0 {
+		attachStatus = v.Attachments[0].Status
+	}
+	return v.Status, attachStatus
+}
+
+func (s *LocalServerSuite) TestVolumeLifecycleAcrossSetVirtualClock(c *C) {
+	srv, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	e := ec2.New(aws.Auth{"abc", "123"}, aws.Region{EC2Endpoint: srv.URL()})
+	id := createVolume(c, e, 8)
+
+	srv.SetVirtualClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	status, _ := volumeStatus(c, e, id)
+	c.Assert(status, Equals, "creating")
+	srv.Advance(ec2test.DefaultLifecycle.Volume)
+	status, _ = volumeStatus(c, e, id)
+	c.Assert(status, Equals, "available")
+}
+
+func (s *LocalServerSuite) TestVolumeLifecycle(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	instId := srv.NewInstances(1, "t1.micro", imageId, ec2test.Running, nil)[0]
+	resp, err := e.CreateVolume(&ec2.CreateVolume{AvailZone: "us-east-1a", Size: 100, VolumeType: "io1", IOPS: 1000})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Status, Equals, "creating")
+	c.Assert(resp.VolumeType, Equals, "io1")
+	c.Assert(resp.IOPS, Equals, int64(1000))
+	c.Assert(resp.CreateTime, Equals, "2014-01-01T00:00:00.000Z")
+	id := resp.Id
+
+	_, err = e.AttachVolume(id, instId, "/dev/sdf")
+	c.Assert(err, ErrorMatches, id+` is not 'available'. \(IncorrectState\)`)
+	srv.Advance(ec2test.DefaultLifecycle.Volume)
+	status, _ := volumeStatus(c, e, id)
+	c.Assert(status, Equals, "available")
+
+	att, err := e.AttachVolume(id, instId, "/dev/sdf")
+	c.Assert(err, IsNil)
+	c.Assert(att.Status, Equals, "attaching")
+	c.Assert(att.InstanceId, Equals, instId)
+	status, attachStatus := volumeStatus(c, e, id)
+	c.Assert(status, Equals, "in-use")
+	c.Assert(attachStatus, Equals, "attaching")
+	srv.Advance(ec2test.DefaultLifecycle.Attach)
+	_, attachStatus = volumeStatus(c, e, id)
+	c.Assert(attachStatus, Equals, "attached")
+
+	filter := ec2.NewFilter()
+	filter.Add("attachment.instance-id", instId)
+	vols, err := e.Volumes(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(vols.Volumes, HasLen, 1)
+	c.Assert(vols.Volumes[0].Attachments[0].Device, Equals, "/dev/sdf")
+
+	_, err = e.DeleteVolume(id)
+	c.Assert(err, ErrorMatches, `Volume `+id+` is currently attached to `+instId+` \(VolumeInUse\)`)
+
+	att, err = e.DetachVolume(id, false)
+	c.Assert(err, IsNil)
+	c.Assert(att.Status, Equals, "detaching")
+	srv.Advance(ec2test.DefaultLifecycle.Attach)
+	status, attachStatus = volumeStatus(c, e, id)
+	c.Assert(status, Equals, "available")
+	c.Assert(attachStatus, Equals, "")
+	_, err = e.DetachVolume(id, false)
+	c.Assert(err, ErrorMatches, `.*\(IncorrectState\)`)
+
+	_, err = e.DeleteVolume(id)
+	c.Assert(err, IsNil)
+	_, err = e.Volumes([]string{id}, nil)
+	c.Assert(err, ErrorMatches, `The volume '`+id+`' does not exist. \(InvalidVolume.NotFound\)`)
+}
+
+func (s *LocalServerSuite) TestCreateVolumeFromSnapshot(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	snap, err := e.CreateSnapshot(createVolume(c, e, 20), "")
+	c.Assert(err, IsNil)
+
+	resp, err := e.CreateVolume(&ec2.CreateVolume{AvailZone: "us-east-1b", SnapshotId: snap.Id})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Size, Equals, int64(20))
+	c.Assert(resp.SnapshotId, Equals, snap.Id)
+	c.Assert(resp.VolumeType, Equals, "standard")
+
+	tests := []struct {
+		options ec2.CreateVolume
+		err     string
+	}{
+		{ec2.CreateVolume{AvailZone: "us-east-1a", SnapshotId: snap.Id, Size: 10}, `.*smaller than snapshot.*\(InvalidParameterValue\)`},
+		{ec2.CreateVolume{AvailZone: "us-east-1a", SnapshotId: "snap-unknown"}, `.*\(InvalidSnapshot.NotFound\)`},
+		{ec2.CreateVolume{AvailZone: "us-east-1a"}, `.*\(MissingParameter\)`},
+		{ec2.CreateVolume{Size: 10}, `.*\(MissingParameter\)`},
+		{ec2.CreateVolume{AvailZone: "us-east-1a", Size: 2048}, `.*\(InvalidParameterValue\)`},
+		{ec2.CreateVolume{AvailZone: "us-east-1a", Size: 10, IOPS: 100}, `.*\(InvalidParameterCombination\)`},
+		{ec2.CreateVolume{AvailZone: "us-east-1a", Size: 10, VolumeType: "io1"}, `.*\(MissingParameter\)`},
+		{ec2.CreateVolume{AvailZone: "us-east-1a", Size: 10, VolumeType: "io1", IOPS: 1000}, `.*\(InvalidParameterValue\)`},
+		{ec2.CreateVolume{AvailZone: "us-east-1a", Size: 10, VolumeType: "sc1"}, `.*\(InvalidParameterValue\)`},
+	}
+	for i, t := range tests {
+		_, err := e.CreateVolume(&t.options)
+		c.Check(err, ErrorMatches, t.err, Commentf("test %d", i))
+	}
+}
+
+func (s *LocalServerSuite) TestAttachVolumeErrors(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	ids := srv.NewInstances(2, "t1.micro", imageId, ec2test.Running, nil)
+	pending := srv.NewInstances(1, "t1.micro", imageId, ec2test.Pending, nil)[0]
+	vol1 := createVolume(c, e, 10)
+	vol2 := createVolume(c, e, 10)
+	other, err := e.CreateVolume(&ec2.CreateVolume{AvailZone: "us-east-1b", Size: 10})
+	c.Assert(err, IsNil)
+	srv.Advance(ec2test.DefaultLifecycle.Volume)
+
+	_, err = e.AttachVolume(other.Id, ids[0], "/dev/sdf")
+	c.Assert(err, ErrorMatches, `.*\(InvalidVolume.ZoneMismatch\)`)
+	_, err = e.AttachVolume(vol1, pending, "/dev/sdf")
+	c.Assert(err, ErrorMatches, `Instance '`+pending+`' is not 'running'. \(IncorrectState\)`)
+	_, err = e.AttachVolume(vol1, "i-unknown", "/dev/sdf")
+	c.Assert(err, ErrorMatches, `.*\(InvalidInstanceID.NotFound\)`)
+	_, err = e.AttachVolume("vol-unknown", ids[0], "/dev/sdf")
+	c.Assert(err, ErrorMatches, `.*\(InvalidVolume.NotFound\)`)
+
+	_, err = e.AttachVolume(vol1, ids[0], "/dev/sdf")
+	c.Assert(err, IsNil)
+	_, err = e.AttachVolume(vol1, ids[1], "/dev/sdf")
+	c.Assert(err, ErrorMatches, `.*\(VolumeInUse\)`)
+	_, err = e.AttachVolume(vol2, ids[0], "/dev/sdf")
+	c.Assert(err, ErrorMatches, `Attachment point /dev/sdf is already in use \(InvalidParameterValue\)`)
+	_, err = e.AttachVolume(vol2, ids[1], "/dev/sdf")
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestVolumeDetachedOnTermination(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	instId := srv.NewInstances(1, "t1.micro", imageId, ec2test.Running, nil)[0]
+	id := createVolume(c, e, 10)
+	srv.Advance(ec2test.DefaultLifecycle.Volume)
+	_, err := e.AttachVolume(id, instId, "/dev/sdf")
+	c.Assert(err, IsNil)
+
+	_, err = e.TerminateInstances([]string{instId})
+	c.Assert(err, IsNil)
+	status, _ := volumeStatus(c, e, id)
+	c.Assert(status, Equals, "in-use")
+	srv.Advance(ec2test.DefaultLifecycle.ShuttingDown)
+	status, _ = volumeStatus(c, e, id)
+	c.Assert(status, Equals, "available")
+}
+
+func (s *LocalServerSuite) TestCreateKeyPair(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	resp, err := e.CreateKeyPair("mykey")
+	c.Assert(err, IsNil)
+	c.Assert(resp.Name, Equals, "mykey")
+
+	block, _ := pem.Decode([]byte(resp.Material))
+	c.Assert(block, NotNil)
+	c.Assert(block.Type, Equals, "RSA PRIVATE KEY")
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	c.Assert(err, IsNil)
+	c.Assert(key.N.BitLen(), Equals, 2048)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	c.Assert(err, IsNil)
+	digest := sha1.Sum(der)
+	c.Assert(resp.Fingerprint, Equals, strings.Replace(fmt.Sprintf("% x", digest), " ", ":", -1))
+
+	_, err = e.CreateKeyPair("mykey")
+	c.Assert(err, ErrorMatches, `The keypair 'mykey' already exists. \(InvalidKeyPair.Duplicate\)`)
+
+	keys, err := e.KeyPairs(nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(keys.KeyPairs, DeepEquals, []ec2.KeyPair{resp.KeyPair})
+
+	_, err = e.DeleteKeyPair("mykey")
+	c.Assert(err, IsNil)
+	_, err = e.DeleteKeyPair("mykey")
+	c.Assert(err, IsNil)
+	_, err = e.KeyPairs([]string{"mykey"}, nil)
+	c.Assert(err, ErrorMatches, `The key pair 'mykey' does not exist \(InvalidKeyPair.NotFound\)`)
+}
+
+// testPublicKey was generated with ssh-keygen, and its fingerprint with
+// openssl pkey -pubin -outform DER | openssl md5 -c.
+const (
+	testPublicKey            = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQCx4qnRPoyjQ+aLwtaNEAS0+N12QztbKEoHs97PYIxiUsvr6Zpg1cCksSJ+0gdr4f+RnSeuSYUvZ4p8GuBS90k+9QkCG7twBHSimyse1/lcIUytcWuQBol1t+2ufy0EzSI65YNF9fZkb1hRaXqX9x/itpGaSq1Y4HWF4+UbWS6kYw== user@host"
+	testPublicKeyFingerprint = "2d:a2:15:9e:c8:0f:fa:d0:41:bc:c4:ec:79:67:f9:78"
+)
+
+func (s *LocalServerSuite) TestImportKeyPair(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	resp, err := e.ImportKeyPair("imported", []byte(testPublicKey+"\n"))
+	c.Assert(err, IsNil)
+	c.Assert(resp.Name, Equals, "imported")
+	c.Assert(resp.Fingerprint, Equals, testPublicKeyFingerprint)
+
+	_, err = e.ImportKeyPair("imported", []byte(testPublicKey))
+	c.Assert(err, ErrorMatches, `.*\(InvalidKeyPair.Duplicate\)`)
+	_, err = e.ImportKeyPair("bad", []byte("ssh-rsa AAAAB3NzaC1yc2E= user@host"))
+	c.Assert(err, ErrorMatches, `Key is not in valid OpenSSH public key format \(InvalidKey.Format\)`)
+	_, err = e.ImportKeyPair("bad", []byte("ssh-dss "+testPublicKey[len("ssh-rsa "):]))
+	c.Assert(err, ErrorMatches, `.*\(InvalidKey.Format\)`)
+
+	filter := ec2.NewFilter()
+	filter.Add("fingerprint", testPublicKeyFingerprint)
+	keys, err := e.KeyPairs(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(keys.KeyPairs, DeepEquals, []ec2.KeyPair{resp.KeyPair})
+	filter = ec2.NewFilter()
+	filter.Add("key-name", "imp*")
+	keys, err = e.KeyPairs(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(keys.KeyPairs, HasLen, 1)
+}
+
+func (s *LocalServerSuite) TestRunInstancesKeyName(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	_, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", KeyName: "mykey"})
+	c.Assert(err, ErrorMatches, `The key pair 'mykey' does not exist \(InvalidKeyPair.NotFound\)`)
+	c.Assert(srv.Snapshot().Instances, HasLen, 0)
+
+	_, err = e.ImportKeyPair("mykey", []byte(testPublicKey))
+	c.Assert(err, IsNil)
+	inst, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", KeyName: "mykey"})
+	c.Assert(err, IsNil)
+	c.Assert(inst.Instances[0].KeyName, Equals, "mykey")
+	srv.NewInstances(1, "t1.micro", imageId, ec2test.Running, nil)
+
+	filter := ec2.NewFilter()
+	filter.Add("key-name", "mykey")
+	resp, err := e.Instances(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Reservations, HasLen, 1)
+	c.Assert(resp.Reservations[0].Instances[0].InstanceId, Equals, inst.Instances[0].InstanceId)
+}
+
+func (s *LocalServerSuite) TestElasticIPAddresses(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	ids := srv.NewInstances(2, "t1.micro", imageId, ec2test.Running, nil)
+	addr1, err := e.AllocateAddress("")
+	c.Assert(err, IsNil)
+	c.Assert(addr1.PublicIp, Equals, "203.0.113.1")
+	c.Assert(addr1.Domain, Equals, "standard")
+	c.Assert(addr1.AllocationId, Equals, "")
+	addr2, err := e.AllocateAddress("standard")
+	c.Assert(err, IsNil)
+	c.Assert(addr2.PublicIp, Equals, "203.0.113.2")
+
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[0], PublicIp: addr1.PublicIp})
+	c.Assert(err, IsNil)
+	inst, ok := srv.DescribeInstance(ids[0])
+	c.Assert(ok, Equals, true)
+	c.Assert(inst.IPAddress, Equals, "203.0.113.1")
+	c.Assert(inst.DNSName, Equals, "ec2-203-0-113-1.compute-1.amazonaws.com")
+
+	filter := ec2.NewFilter()
+	filter.Add("instance-id", ids[0])
+	addrs, err := e.Addresses(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(addrs.Addresses, DeepEquals, []ec2.Address{{PublicIp: "203.0.113.1", Domain: "standard", InstanceId: ids[0]}})
+	filter = ec2.NewFilter()
+	filter.Add("ip-address", addr1.PublicIp)
+	insts, err := e.Instances(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(insts.Reservations, HasLen, 1)
+
+	// EC2-Classic addresses move between instances, and an instance has
+	// a single address.
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[1], PublicIp: addr1.PublicIp})
+	c.Assert(err, IsNil)
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[1], PublicIp: addr2.PublicIp})
+	c.Assert(err, IsNil)
+	inst, _ = srv.DescribeInstance(ids[0])
+	c.Assert(inst.IPAddress, Equals, "")
+	c.Assert(inst.DNSName, Equals, ids[0]+".example.com")
+	inst, _ = srv.DescribeInstance(ids[1])
+	c.Assert(inst.IPAddress, Equals, addr2.PublicIp)
+	addrs, err = e.Addresses([]string{addr1.PublicIp}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(addrs.Addresses[0].InstanceId, Equals, "")
+
+	// Addresses of terminated instances are disassociated.
+	_, err = e.TerminateInstances([]string{ids[1]})
+	c.Assert(err, IsNil)
+	srv.Advance(ec2test.DefaultLifecycle.ShuttingDown)
+	addrs, err = e.Addresses([]string{addr2.PublicIp}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(addrs.Addresses[0].InstanceId, Equals, "")
+
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[0], PublicIp: addr2.PublicIp})
+	c.Assert(err, IsNil)
+	_, err = e.ReleaseAddress(addr2.PublicIp, "")
+	c.Assert(err, IsNil)
+	inst, _ = srv.DescribeInstance(ids[0])
+	c.Assert(inst.IPAddress, Equals, "")
+	_, err = e.DisassociateAddress(addr2.PublicIp, "")
+	c.Assert(err, ErrorMatches, `Address '203.0.113.2' not found. \(InvalidAddress.NotFound\)`)
+
+	// Released addresses go back to the pool.
+	addr3, err := e.AllocateAddress("")
+	c.Assert(err, IsNil)
+	c.Assert(addr3.PublicIp, Equals, addr2.PublicIp)
+}
+
+func (s *LocalServerSuite) TestVPCAddresses(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	sub, err := e.CreateSubnet(vpc.VPC.Id, "10.0.0.0/24", "us-east-1a")
+	c.Assert(err, IsNil)
+	run, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id, MinCount: 2, MaxCount: 2})
+	c.Assert(err, IsNil)
+	ids := []string{run.Instances[0].InstanceId, run.Instances[1].InstanceId}
+	classic := srv.NewInstances(1, "t1.micro", imageId, ec2test.Running, nil)[0]
+	addr, err := e.AllocateAddress("vpc")
+	c.Assert(err, IsNil)
+	c.Assert(addr.Domain, Equals, "vpc")
+	c.Assert(addr.AllocationId, Equals, "eipalloc-0")
+
+	// Domains must match the instance.
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: classic, AllocationId: addr.AllocationId})
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterCombination\)`)
+	standard, err := e.AllocateAddress("standard")
+	c.Assert(err, IsNil)
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[0], PublicIp: standard.PublicIp})
+	c.Assert(err, ErrorMatches, `You must specify an allocation id when mapping an address to a VPC instance \(InvalidParameterValue\)`)
+	_, err = e.ReleaseAddress(standard.PublicIp, "")
+	c.Assert(err, IsNil)
+
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[0], PublicIp: addr.PublicIp})
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+	assoc, err := e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[0], AllocationId: addr.AllocationId})
+	c.Assert(err, IsNil)
+	c.Assert(assoc.AssociationId, Equals, "eipassoc-0")
+
+	_, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[1], AllocationId: addr.AllocationId})
+	c.Assert(err, ErrorMatches, `resource eipalloc-0 is already associated with associate-id eipassoc-0 \(Resource.AlreadyAssociated\)`)
+	assoc, err = e.AssociateAddress(&ec2.AssociateAddress{InstanceId: ids[1], AllocationId: addr.AllocationId, AllowReassociation: true})
+	c.Assert(err, IsNil)
+	c.Assert(assoc.AssociationId, Equals, "eipassoc-1")
+	inst, _ := srv.DescribeInstance(ids[1])
+	c.Assert(inst.IPAddress, Equals, addr.PublicIp)
+
+	_, err = e.ReleaseAddress("", addr.AllocationId)
+	c.Assert(err, ErrorMatches, `Address 203.0.113.1 is in use. \(InvalidIPAddress.InUse\)`)
+	_, err = e.DisassociateAddress(addr.PublicIp, "")
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+	_, err = e.DisassociateAddress("", "eipassoc-0")
+	c.Assert(err, ErrorMatches, `.*\(InvalidAssociationID.NotFound\)`)
+	_, err = e.DisassociateAddress("", assoc.AssociationId)
+	c.Assert(err, IsNil)
+	inst, _ = srv.DescribeInstance(ids[1])
+	c.Assert(inst.IPAddress, Equals, "")
+
+	filter := ec2.NewFilter()
+	filter.Add("domain", "vpc")
+	addrs, err := e.Addresses(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(addrs.Addresses, DeepEquals, []ec2.Address{{PublicIp: addr.PublicIp, Domain: "vpc", AllocationId: addr.AllocationId}})
+	_, err = e.ReleaseAddress("", addr.AllocationId)
+	c.Assert(err, IsNil)
+	_, err = e.ReleaseAddress("", addr.AllocationId)
+	c.Assert(err, ErrorMatches, `.*\(InvalidAllocationID.NotFound\)`)
+}
+
+func (s *LocalServerSuite) TestVPCsAndSubnets(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	_, err := e.CreateVPC("10.0.0.1/16", "")
+	c.Assert(err, ErrorMatches, `Value \(10.0.0.1/16\) for parameter cidrBlock is invalid. This is not a valid CIDR block. \(InvalidParameterValue\)`)
+	_, err = e.CreateVPC("10.0.0.0/8", "")
+	c.Assert(err, ErrorMatches, `The CIDR '10.0.0.0/8' is invalid. \(InvalidVpc.Range\)`)
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	c.Assert(vpc.VPC, DeepEquals, ec2.VPC{
+		Id:              "vpc-0",
+		State:           "available",
+		CidrBlock:       "10.0.0.0/16",
+		InstanceTenancy: "default",
+	})
+
+	_, err = e.CreateSubnet("vpc-9", "10.0.0.0/24", "")
+	c.Assert(err, ErrorMatches, `.*\(InvalidVpcID.NotFound\)`)
+	_, err = e.CreateSubnet(vpc.VPC.Id, "10.1.0.0/24", "")
+	c.Assert(err, ErrorMatches, `The CIDR '10.1.0.0/24' is invalid. \(InvalidSubnet.Range\)`)
+	_, err = e.CreateSubnet(vpc.VPC.Id, "10.0.0.0/29", "")
+	c.Assert(err, ErrorMatches, `.*\(InvalidSubnet.Range\)`)
+	sub, err := e.CreateSubnet(vpc.VPC.Id, "10.0.0.0/24", "us-east-1c")
+	c.Assert(err, IsNil)
+	c.Assert(sub.Subnet, DeepEquals, ec2.Subnet{
+		Id:                      "subnet-0",
+		State:                   "available",
+		VpcId:                   vpc.VPC.Id,
+		CidrBlock:               "10.0.0.0/24",
+		AvailableIPAddressCount: 251,
+		AvailZone:               "us-east-1c",
+	})
+	_, err = e.CreateSubnet(vpc.VPC.Id, "10.0.0.128/25", "")
+	c.Assert(err, ErrorMatches, `The CIDR '10.0.0.128/25' conflicts with another subnet \(InvalidSubnet.Conflict\)`)
+	_, err = e.CreateSubnet(vpc.VPC.Id, "10.0.0.0/23", "")
+	c.Assert(err, ErrorMatches, `.*\(InvalidSubnet.Conflict\)`)
+	_, err = e.CreateSubnet(vpc.VPC.Id, "10.0.1.0/24", "")
+	c.Assert(err, IsNil)
+
+	// Subnets of other VPCs may overlap.
+	other, err := e.CreateVPC("10.0.0.0/16", "dedicated")
+	c.Assert(err, IsNil)
+	_, err = e.CreateSubnet(other.VPC.Id, "10.0.0.0/24", "")
+	c.Assert(err, IsNil)
+
+	filter := ec2.NewFilter()
+	filter.Add("vpc-id", vpc.VPC.Id)
+	subs, err := e.Subnets(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(subs.Subnets, HasLen, 2)
+	c.Assert(subs.Subnets[0].Id, Equals, "subnet-0")
+	c.Assert(subs.Subnets[1].Id, Equals, "subnet-1")
+	filter = ec2.NewFilter()
+	filter.Add("availability-zone", "us-east-1c")
+	subs, err = e.Subnets(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(subs.Subnets, HasLen, 1)
+
+	filter = ec2.NewFilter()
+	filter.Add("cidr", "10.0.0.0/16")
+	vpcs, err := e.VPCs(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(vpcs.VPCs, HasLen, 2)
+	c.Assert(vpcs.VPCs[1].InstanceTenancy, Equals, "dedicated")
+	_, err = e.VPCs([]string{"vpc-9"}, nil)
+	c.Assert(err, ErrorMatches, `.*\(InvalidVpcID.NotFound\)`)
+}
+
+func (s *LocalServerSuite) TestRunInstancesInSubnet(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	sub, err := e.CreateSubnet(vpc.VPC.Id, "10.0.0.0/28", "us-east-1b")
+	c.Assert(err, IsNil)
+
+	_, err = e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: "subnet-9"})
+	c.Assert(err, ErrorMatches, `.*\(InvalidSubnetID.NotFound\)`)
+	_, err = e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id, AvailZone: "us-east-1a"})
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+	_, err = e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id, MinCount: 12, MaxCount: 12})
+	c.Assert(err, ErrorMatches, `.*\(InsufficientFreeAddressesInSubnet\)`)
+	resp, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id})
+	c.Assert(err, IsNil)
+	inst := resp.Instances[0]
+	c.Assert(inst.VpcId, Equals, vpc.VPC.Id)
+	c.Assert(inst.SubnetId, Equals, sub.Subnet.Id)
+	c.Assert(inst.AvailZone, Equals, "us-east-1b")
+
+	subs, err := e.Subnets([]string{sub.Subnet.Id}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(subs.Subnets[0].AvailableIPAddressCount, Equals, 10)
+
+	filter := ec2.NewFilter()
+	filter.Add("subnet-id", sub.Subnet.Id)
+	insts, err := e.Instances(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(insts.Reservations, HasLen, 1)
+	c.Assert(insts.Reservations[0].Instances[0].InstanceId, Equals, inst.InstanceId)
+
+	_, err = e.DeleteSubnet(sub.Subnet.Id)
+	c.Assert(err, ErrorMatches, `The subnet 'subnet-0' has dependencies and cannot be deleted. \(DependencyViolation\)`)
+	_, err = e.TerminateInstances([]string{inst.InstanceId})
+	c.Assert(err, IsNil)
+	srv.Advance(time.Minute)
+	_, err = e.DeleteSubnet(sub.Subnet.Id)
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestInternetGateways(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	other, err := e.CreateVPC("10.1.0.0/16", "")
+	c.Assert(err, IsNil)
+	igw, err := e.CreateInternetGateway()
+	c.Assert(err, IsNil)
+	c.Assert(igw.InternetGateway, DeepEquals, ec2.InternetGateway{Id: "igw-0"})
+	id := igw.InternetGateway.Id
+
+	_, err = e.DetachInternetGateway(id, vpc.VPC.Id)
+	c.Assert(err, ErrorMatches, `.*\(Gateway.NotAttached\)`)
+	_, err = e.AttachInternetGateway(id, vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	_, err = e.AttachInternetGateway(id, other.VPC.Id)
+	c.Assert(err, ErrorMatches, `resource igw-0 is already attached to network vpc-0 \(Resource.AlreadyAssociated\)`)
+	igw2, err := e.CreateInternetGateway()
+	c.Assert(err, IsNil)
+	_, err = e.AttachInternetGateway(igw2.InternetGateway.Id, vpc.VPC.Id)
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+
+	filter := ec2.NewFilter()
+	filter.Add("attachment.vpc-id", vpc.VPC.Id)
+	igws, err := e.InternetGateways(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(igws.InternetGateways, DeepEquals, []ec2.InternetGateway{{
+		Id:          id,
+		Attachments: []ec2.InternetGatewayAttachment{{VpcId: vpc.VPC.Id, State: "available"}},
+	}})
+
+	_, err = e.DeleteInternetGateway(id)
+	c.Assert(err, ErrorMatches, `.*\(DependencyViolation\)`)
+	_, err = e.DeleteVPC(vpc.VPC.Id)
+	c.Assert(err, ErrorMatches, `The vpc 'vpc-0' has dependencies and cannot be deleted. \(DependencyViolation\)`)
+	_, err = e.DetachInternetGateway(id, other.VPC.Id)
+	c.Assert(err, ErrorMatches, `.*\(Gateway.NotAttached\)`)
+	_, err = e.DetachInternetGateway(id, vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	_, err = e.DeleteInternetGateway(id)
+	c.Assert(err, IsNil)
+	_, err = e.InternetGateways([]string{id}, nil)
+	c.Assert(err, ErrorMatches, `.*\(InvalidInternetGatewayID.NotFound\)`)
+}
+
+func (s *LocalServerSuite) TestRouteTables(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	sub, err := e.CreateSubnet(vpc.VPC.Id, "10.0.0.0/24", "")
+	c.Assert(err, IsNil)
+	igw, err := e.CreateInternetGateway()
+	c.Assert(err, IsNil)
+	gwId := igw.InternetGateway.Id
+
+	// Every VPC has a main route table with a local route.
+	filter := ec2.NewFilter()
+	filter.Add("association.main", "true")
+	tables, err := e.RouteTables(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(tables.RouteTables, DeepEquals, []ec2.RouteTable{{
+		Id:    "rtb-0",
+		VpcId: vpc.VPC.Id,
+		Routes: []ec2.Route{{
+			DestinationCidrBlock: "10.0.0.0/16",
+			GatewayId:            "local",
+			State:                "active",
+			Origin:               "CreateRouteTable",
+		}},
+		Associations: []ec2.RouteTableAssociation{{
+			Id:           "rtbassoc-0",
+			RouteTableId: "rtb-0",
+			Main:         true,
+		}},
+	}})
+	_, err = e.DisassociateRouteTable("rtbassoc-0")
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+	_, err = e.DeleteRouteTable("rtb-0")
+	c.Assert(err, ErrorMatches, `.*\(DependencyViolation\)`)
+
+	rt, err := e.CreateRouteTable(vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	rtId := rt.RouteTable.Id
+	c.Assert(rtId, Equals, "rtb-1")
+	c.Assert(rt.RouteTable.Associations, HasLen, 0)
+	assoc, err := e.AssociateRouteTable(rtId, sub.Subnet.Id)
+	c.Assert(err, IsNil)
+	c.Assert(assoc.AssociationId, Equals, "rtbassoc-1")
+	_, err = e.AssociateRouteTable("rtb-0", sub.Subnet.Id)
+	c.Assert(err, ErrorMatches, `.*\(Resource.AlreadyAssociated\)`)
+
+	route := &ec2.CreateRoute{RouteTableId: rtId, DestinationCidrBlock: "0.0.0.0/0", GatewayId: gwId}
+	_, err = e.CreateRoute(route)
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+	_, err = e.CreateRoute(&ec2.CreateRoute{RouteTableId: rtId, DestinationCidrBlock: "0.0.0.0/0", GatewayId: "igw-9"})
+	c.Assert(err, ErrorMatches, `.*\(InvalidGatewayID.NotFound\)`)
+	_, err = e.CreateRoute(&ec2.CreateRoute{RouteTableId: rtId, DestinationCidrBlock: "0.0.0.0/0"})
+	c.Assert(err, ErrorMatches, `.*\(MissingParameter\)`)
+	_, err = e.AttachInternetGateway(gwId, vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	_, err = e.CreateRoute(route)
+	c.Assert(err, IsNil)
+	_, err = e.CreateRoute(route)
+	c.Assert(err, ErrorMatches, `The route identified by 0.0.0.0/0 already exists. \(RouteAlreadyExists\)`)
+
+	// Routes to a detached gateway are blackholes.
+	filter = ec2.NewFilter()
+	filter.Add("route.state", "blackhole")
+	tables, err = e.RouteTables(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(tables.RouteTables, HasLen, 0)
+	_, err = e.DetachInternetGateway(gwId, vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	tables, err = e.RouteTables(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(tables.RouteTables, HasLen, 1)
+	c.Assert(tables.RouteTables[0].Routes[1], DeepEquals, ec2.Route{
+		DestinationCidrBlock: "0.0.0.0/0",
+		GatewayId:            gwId,
+		State:                "blackhole",
+		Origin:               "CreateRoute",
+	})
+	c.Assert(tables.RouteTables[0].Associations, DeepEquals, []ec2.RouteTableAssociation{{
+		Id:           "rtbassoc-1",
+		RouteTableId: rtId,
+		SubnetId:     sub.Subnet.Id,
+	}})
+
+	// So are routes to terminated instances.
+	inst, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id})
+	c.Assert(err, IsNil)
+	instId := inst.Instances[0].InstanceId
+	_, err = e.CreateRoute(&ec2.CreateRoute{RouteTableId: rtId, DestinationCidrBlock: "192.168.0.0/16", InstanceId: instId})
+	c.Assert(err, IsNil)
+	filter = ec2.NewFilter()
+	filter.Add("route.instance-id", instId)
+	tables, err = e.RouteTables(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(tables.RouteTables[0].Routes[2].State, Equals, "active")
+	_, err = e.TerminateInstances([]string{instId})
+	c.Assert(err, IsNil)
+	srv.Advance(time.Minute)
+	tables, err = e.RouteTables(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(tables.RouteTables[0].Routes[2].State, Equals, "blackhole")
+
+	_, err = e.DeleteRoute(rtId, "10.0.0.0/16")
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+	_, err = e.DeleteRoute(rtId, "0.0.0.0/0")
+	c.Assert(err, IsNil)
+	_, err = e.DeleteRoute(rtId, "0.0.0.0/0")
+	c.Assert(err, ErrorMatches, `.*\(InvalidRoute.NotFound\)`)
+
+	_, err = e.DeleteRouteTable(rtId)
+	c.Assert(err, ErrorMatches, `.*\(DependencyViolation\)`)
+	_, err = e.DeleteVPC(vpc.VPC.Id)
+	c.Assert(err, ErrorMatches, `.*\(DependencyViolation\)`)
+	_, err = e.DisassociateRouteTable(assoc.AssociationId)
+	c.Assert(err, IsNil)
+	_, err = e.DeleteRouteTable(rtId)
+	c.Assert(err, IsNil)
+	_, err = e.DeleteSubnet(sub.Subnet.Id)
+	c.Assert(err, IsNil)
+	_, err = e.DeleteVPC(vpc.VPC.Id)
+	c.Assert(err, IsNil)
+	tables, err = e.RouteTables(nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(tables.RouteTables, HasLen, 0)
+}
+
+func (s *LocalServerSuite) TestVPCSecurityGroups(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	vpcId := vpc.VPC.Id
+
+	// Every VPC has a default group that allows traffic from its members
+	// and to anywhere.
+	filter := ec2.NewFilter()
+	filter.Add("vpc-id", vpcId)
+	groups, err := e.SecurityGroups(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(groups.Groups, HasLen, 1)
+	def := groups.Groups[0]
+	c.Assert(def.Name, Equals, "default")
+	c.Assert(def.VpcId, Equals, vpcId)
+	c.Assert(def.IPPerms, DeepEquals, []ec2.IPPerm{{
+		Protocol:     "-1",
+		SourceGroups: []ec2.UserSecurityGroup{{Id: def.Id, Name: "default", OwnerId: "9876"}},
+	}})
+	c.Assert(def.IPPermsEgress, DeepEquals, []ec2.IPPerm{{Protocol: "-1", SourceIPs: []string{"0.0.0.0/0"}}})
+	_, err = e.DeleteSecurityGroup(def.SecurityGroup)
+	c.Assert(err, ErrorMatches, `.*\(CannotDelete\)`)
+
+	// Names are unique within each VPC only, and VPC groups can only be
+	// referred to by id.
+	_, err = e.CreateSecurityGroupVPC(vpcId, "default", "another default")
+	c.Assert(err, ErrorMatches, `.*\(InvalidGroup.Duplicate\)`)
+	web, err := e.CreateSecurityGroupVPC(vpcId, "web", "web servers")
+	c.Assert(err, IsNil)
+	classic, err := e.CreateSecurityGroup("web", "classic web servers")
+	c.Assert(err, IsNil)
+	c.Assert(classic.Id, Not(Equals), web.Id)
+	groups, err = e.SecurityGroups(ec2.SecurityGroupNames("web"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(groups.Groups, HasLen, 1)
+	c.Assert(groups.Groups[0].Id, Equals, classic.Id)
+	c.Assert(groups.Groups[0].VpcId, Equals, "")
+	c.Assert(groups.Groups[0].IPPermsEgress, HasLen, 0)
+
+	webGroup := ec2.SecurityGroup{Id: web.Id}
+	_, err = e.RevokeSecurityGroupEgress(webGroup, []ec2.IPPerm{{Protocol: "-1", SourceIPs: []string{"0.0.0.0/0"}}})
+	c.Assert(err, IsNil)
+	perm := ec2.IPPerm{
+		Protocol:     "tcp",
+		FromPort:     5432,
+		ToPort:       5432,
+		SourceGroups: []ec2.UserSecurityGroup{{Id: def.Id}},
+	}
+	_, err = e.AuthorizeSecurityGroupEgress(webGroup, []ec2.IPPerm{perm})
+	c.Assert(err, IsNil)
+	_, err = e.AuthorizeSecurityGroupEgress(webGroup, []ec2.IPPerm{perm})
+	c.Assert(err, ErrorMatches, `.*\(InvalidPermission.Duplicate\)`)
+	_, err = e.AuthorizeSecurityGroupEgress(classic.SecurityGroup, []ec2.IPPerm{perm})
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterValue\)`)
+	_, err = e.AuthorizeSecurityGroup(classic.SecurityGroup, []ec2.IPPerm{{Protocol: "-1", SourceIPs: []string{"10.0.0.0/8"}}})
+	c.Assert(err, ErrorMatches, `.*\(InvalidPermission.Malformed\)`)
+	_, err = e.AuthorizeSecurityGroup(classic.SecurityGroup, []ec2.IPPerm{perm})
+	c.Assert(err, ErrorMatches, `.*\(InvalidGroup.NotFound\)`)
+
+	// Ports are ignored for the "-1" protocol.
+	all := ec2.IPPerm{Protocol: "-1", FromPort: 22, ToPort: 80, SourceIPs: []string{"10.0.0.0/16"}}
+	_, err = e.AuthorizeSecurityGroup(webGroup, []ec2.IPPerm{all})
+	c.Assert(err, IsNil)
+	groups, err = e.SecurityGroups([]ec2.SecurityGroup{webGroup}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(groups.Groups[0].IPPerms, DeepEquals, []ec2.IPPerm{{Protocol: "-1", SourceIPs: []string{"10.0.0.0/16"}}})
+	c.Assert(groups.Groups[0].IPPermsEgress, DeepEquals, []ec2.IPPerm{{
+		Protocol:     "tcp",
+		FromPort:     5432,
+		ToPort:       5432,
+		SourceGroups: []ec2.UserSecurityGroup{{Id: def.Id, Name: "default", OwnerId: "9876"}},
+	}})
+
+	filter = ec2.NewFilter()
+	filter.Add("egress.ip-permission.to-port", "5432")
+	groups, err = e.SecurityGroups(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(groups.Groups, HasLen, 1)
+	c.Assert(groups.Groups[0].Id, Equals, web.Id)
+	filter = ec2.NewFilter()
+	filter.Add("ip-permission.to-port", "5432")
+	groups, err = e.SecurityGroups(nil, filter)
+	c.Assert(err, IsNil)
+	c.Assert(groups.Groups, HasLen, 0)
+
+	// Instances in a subnet use groups of its VPC, its default group if
+	// none is given.
+	sub, err := e.CreateSubnet(vpcId, "10.0.0.0/24", "")
+	c.Assert(err, IsNil)
+	_, err = e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id, SecurityGroups: []ec2.SecurityGroup{classic.SecurityGroup}})
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameter\)`)
+	_, err = e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SecurityGroups: []ec2.SecurityGroup{webGroup}})
+	c.Assert(err, ErrorMatches, `.*\(InvalidParameterCombination\)`)
+	inst, err := e.RunInstances(&ec2.RunInstances{ImageId: imageId, InstanceType: "t1.micro", SubnetId: sub.Subnet.Id})
+	c.Assert(err, IsNil)
+	insts, err := e.Instances([]string{inst.Instances[0].InstanceId}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(insts.Reservations[0].SecurityGroups, DeepEquals, []ec2.SecurityGroup{def.SecurityGroup})
+	_, err = e.TerminateInstances([]string{inst.Instances[0].InstanceId})
+	c.Assert(err, IsNil)
+	srv.Advance(time.Minute)
+	_, err = e.DeleteSubnet(sub.Subnet.Id)
+	c.Assert(err, IsNil)
+
+	// The default group goes away with the VPC, once the others are
+	// deleted.
+	_, err = e.DeleteVPC(vpcId)
+	c.Assert(err, ErrorMatches, `.*\(DependencyViolation\)`)
+	_, err = e.DeleteSecurityGroup(webGroup)
+	c.Assert(err, IsNil)
+	_, err = e.DeleteVPC(vpcId)
+	c.Assert(err, IsNil)
+	_, err = e.SecurityGroups([]ec2.SecurityGroup{def.SecurityGroup}, nil)
+	c.Assert(err, ErrorMatches, `.*\(InvalidGroup.NotFound\)`)
+}
+
+func (s *LocalServerSuite) TestSyncSecurityGroup(c *C) {
+	srv, e := newVirtualClockServer(c)
+	defer srv.Quit()
+	web, err := e.CreateSecurityGroup("web", "web servers")
+	c.Assert(err, IsNil)
+	db, err := e.CreateSecurityGroup("db", "database servers")
+	c.Assert(err, IsNil)
+	_, err = e.AuthorizeSecurityGroup(db.SecurityGroup, []ec2.IPPerm{{
+		Protocol:  "tcp",
+		FromPort:  22,
+		ToPort:    22,
+		SourceIPs: []string{"0.0.0.0/0"},
+	}, {
+		Protocol:     "tcp",
+		FromPort:     5432,
+		ToPort:       5432,
+		SourceGroups: []ec2.UserSecurityGroup{{Id: web.Id}},
+	}})
+	c.Assert(err, IsNil)
+
+	desired := []ec2.IPPerm{{
+		Protocol:  "tcp",
+		FromPort:  22,
+		ToPort:    22,
+		SourceIPs: []string{"10.0.0.1/8"},
+	}, {
+		Protocol:     "tcp",
+		FromPort:     5432,
+		ToPort:       5432,
+		SourceGroups: []ec2.UserSecurityGroup{{Name: "web"}, {Name: "db"}},
+	}}
+	authorized, revoked, err := e.SyncSecurityGroup(ec2.SecurityGroup{Name: "db"}, desired)
+	c.Assert(err, IsNil)
+	c.Assert(authorized, DeepEquals, []ec2.IPPerm{{
+		Protocol:  "tcp",
+		FromPort:  22,
+		ToPort:    22,
+		SourceIPs: []string{"10.0.0.0/8"},
+	}, {
+		Protocol:     "tcp",
+		FromPort:     5432,
+		ToPort:       5432,
+		SourceGroups: []ec2.UserSecurityGroup{{Name: "db"}},
+	}})
+	c.Assert(revoked, DeepEquals, []ec2.IPPerm{{
+		Protocol:  "tcp",
+		FromPort:  22,
+		ToPort:    22,
+		SourceIPs: []string{"0.0.0.0/0"},
+	}})
+
+	// The group now grants exactly the desired permissions, so syncing
+	// again changes nothing.
+	groups, err := e.SecurityGroups([]ec2.SecurityGroup{db.SecurityGroup}, nil)
+	c.Assert(err, IsNil)
+	authorize, revoke, err := ec2.DiffIPPerms(groups.Groups[0].IPPerms, desired)
+	c.Assert(err, IsNil)
+	c.Assert(authorize, IsNil)
+	c.Assert(revoke, IsNil)
+	authorized, revoked, err = e.SyncSecurityGroup(db.SecurityGroup, desired)
+	c.Assert(err, IsNil)
+	c.Assert(authorized, IsNil)
+	c.Assert(revoked, IsNil)
+
+	_, _, err = e.SyncSecurityGroup(db.SecurityGroup, []ec2.IPPerm{{Protocol: "tcp", SourceIPs: []string{"bad"}}})
+	c.Assert(err, ErrorMatches, `invalid CIDR block "bad/32"`)
+	_, _, err = e.SyncSecurityGroup(ec2.SecurityGroup{Name: "unknown"}, nil)
+	c.Assert(err, ErrorMatches, `.*\(InvalidGroup.NotFound\)`)
+
+	// Outbound permissions of VPC groups are synced in the same way.
+	vpc, err := e.CreateVPC("10.0.0.0/16", "")
+	c.Assert(err, IsNil)
+	app, err := e.CreateSecurityGroupVPC(vpc.VPC.Id, "app", "application servers")
+	c.Assert(err, IsNil)
+	appGroup := ec2.SecurityGroup{Id: app.Id}
+	egress := []ec2.IPPerm{{
+		Protocol:  "tcp",
+		FromPort:  443,
+		ToPort:    443,
+		SourceIPs: []string{"0.0.0.0/0"},
+	}}
+	authorized, revoked, err = e.SyncSecurityGroupEgress(appGroup, egress)
+	c.Assert(err, IsNil)
+	c.Assert(authorized, DeepEquals, egress)
+	c.Assert(revoked, DeepEquals, []ec2.IPPerm{{Protocol: "-1", SourceIPs: []string{"0.0.0.0/0"}}})
+	groups, err = e.SecurityGroups([]ec2.SecurityGroup{appGroup}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(groups.Groups[0].IPPermsEgress, DeepEquals, egress)
+	c.Assert(groups.Groups[0].IPPerms, HasLen, 0)
+}
+
 // AmazonServerSuite runs the ec2test server tests against a live EC2 server.
 // It will only be activated if the -all flag is specified.
 type AmazonServerSuite struct {
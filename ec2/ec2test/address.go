@@ -0,0 +1,275 @@
+package ec2test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2"
+	"net/http"
+	"strings"
+)
+
+// addressPool is the network Elastic IP addresses are allocated from. It
+// is reserved for documentation by RFC 5737, so it is never routable.
+const addressPool = "203.0.113.%d"
+
+// address holds a simulated Elastic IP address.
+type address struct {
+	publicIp      string
+	domain        string // "standard" or "vpc"
+	allocationId  string // vpc only
+	instance      *Instance
+	associationId string // vpc and associated only
+}
+
+func (srv *Server) allocateAddress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	domain := req.Form.Get("Domain")
+	switch domain {
+	case "":
+		domain = "standard"
+	case "standard", "vpc":
+	default:
+		fatalf(400, "InvalidParameterValue", "Invalid value '%s' for domain.", domain)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	addr := &address{domain: domain}
+	for i := 1; i < 255 && addr.publicIp == ""; i++ {
+		if ip := fmt.Sprintf(addressPool, i); srv.addresses[ip] == nil {
+			addr.publicIp = ip
+		}
+	}
+	if addr.publicIp == "" {
+		fatalf(400, "AddressLimitExceeded", "The maximum number of addresses has been reached.")
+	}
+	if domain == "vpc" {
+		addr.allocationId = fmt.Sprintf("eipalloc-%d", srv.allocationId.next())
+	}
+	srv.addresses[addr.publicIp] = addr
+	return &ec2.AllocateAddressResp{
+		RequestId:    reqId,
+		PublicIp:     addr.publicIp,
+		Domain:       addr.domain,
+		AllocationId: addr.allocationId,
+	}
+}
+
+// formAddress returns the address identified by the PublicIp parameter
+// for EC2-Classic addresses or by the AllocationId parameter for VPC
+// addresses.
+func (srv *Server) formAddress(req *http.Request) *address {
+	publicIp := req.Form.Get("PublicIp")
+	allocationId := req.Form.Get("AllocationId")
+	switch {
+	case publicIp != "" && allocationId != "":
+		fatalf(400, "InvalidParameterCombination", "The parameters PublicIp and AllocationId cannot be used together")
+	case allocationId != "":
+		for _, addr := range srv.addresses {
+			if addr.allocationId == allocationId {
+				return addr
+			}
+		}
+		fatalf(400, "InvalidAllocationID.NotFound", "The allocation ID '%s' does not exist", allocationId)
+	case publicIp != "":
+		addr := srv.addresses[publicIp]
+		if addr == nil {
+			fatalf(400, "InvalidAddress.NotFound", "Address '%s' not found.", publicIp)
+		}
+		if addr.domain == "vpc" {
+			fatalf(400, "InvalidParameterValue", "You must specify an allocation id when using a VPC elastic IP address")
+		}
+		return addr
+	}
+	fatalf(400, "MissingParameter", "Either public IP or allocation id must be specified")
+	panic("not reached")
+}
+
+// releaseAddress releases an address. EC2-Classic addresses are
+// disassociated first, while associated VPC addresses cannot be
+// released.
+func (srv *Server) releaseAddress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	addr := srv.formAddress(req)
+	if addr.instance != nil {
+		if addr.domain == "vpc" {
+			fatalf(400, "InvalidIPAddress.InUse", "Address %s is in use.", addr.publicIp)
+		}
+		addr.disassociate()
+	}
+	delete(srv.addresses, addr.publicIp)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "ReleaseAddressResponse"},
+		RequestId: reqId,
+	}
+}
+
+// associateAddress associates an address with an instance, replacing the
+// address the instance had. EC2-Classic addresses are moved from the
+// instance they were associated with, while VPC addresses are only moved
+// if AllowReassociation is set. VPC addresses only go to instances in a
+// subnet, and EC2-Classic addresses only to the others.
+func (srv *Server) associateAddress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	instId := req.Form.Get("InstanceId")
+	if instId == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter instanceId")
+	}
+	inst := srv.instances[instId]
+	if inst == nil {
+		fatalf(400, "InvalidInstanceID.NotFound", "no such instance id %q", instId)
+	}
+	addr := srv.formAddress(req)
+	if inst.state != Pending && inst.state != Running && inst.state != Stopped {
+		fatalf(400, "IncorrectInstanceState", "The instance '%s' is not in a valid state for this operation.", inst.id)
+	}
+	if addr.domain == "vpc" && inst.subnet == nil {
+		fatalf(400, "InvalidParameterCombination", "The allocation ID '%s' is for a VPC address and cannot be associated with the EC2-Classic instance '%s'", addr.allocationId, inst.id)
+	}
+	if addr.domain != "vpc" && inst.subnet != nil {
+		fatalf(400, "InvalidParameterValue", "You must specify an allocation id when mapping an address to a VPC instance")
+	}
+	if addr.instance != nil && addr.instance != inst && addr.domain == "vpc" && req.Form.Get("AllowReassociation") != "true" {
+		fatalf(400, "Resource.AlreadyAssociated", "resource %s is already associated with associate-id %s", addr.allocationId, addr.associationId)
+	}
+	if addr.instance != inst {
+		if addr.instance != nil {
+			addr.disassociate()
+		}
+		if other := srv.addresses[inst.publicIp]; other != nil {
+			other.disassociate()
+		}
+		addr.instance = inst
+		inst.publicIp = addr.publicIp
+		if addr.domain == "vpc" {
+			addr.associationId = fmt.Sprintf("eipassoc-%d", srv.associationId.next())
+		}
+	}
+	return &ec2.AssociateAddressResp{
+		RequestId:     reqId,
+		AssociationId: addr.associationId,
+	}
+}
+
+// disassociateAddress disassociates an address from its instance. As in
+// EC2, disassociating an EC2-Classic address that is not associated
+// succeeds.
+func (srv *Server) disassociateAddress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var addr *address
+	if id := req.Form.Get("AssociationId"); id != "" {
+		for _, a := range srv.addresses {
+			if a.associationId == id {
+				addr = a
+			}
+		}
+		if addr == nil {
+			fatalf(400, "InvalidAssociationID.NotFound", "The association ID '%s' does not exist", id)
+		}
+	} else {
+		publicIp := req.Form.Get("PublicIp")
+		if publicIp == "" {
+			fatalf(400, "MissingParameter", "Either public IP or association id must be specified")
+		}
+		addr = srv.addresses[publicIp]
+		if addr == nil {
+			fatalf(400, "InvalidAddress.NotFound", "Address '%s' not found.", publicIp)
+		}
+		if addr.domain == "vpc" {
+			fatalf(400, "InvalidParameterValue", "You must specify an association id when unmapping an address from a VPC instance")
+		}
+	}
+	if addr.instance != nil {
+		addr.disassociate()
+	}
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DisassociateAddressResponse"},
+		RequestId: reqId,
+	}
+}
+
+// disassociate removes the association of the address with its instance.
+func (addr *address) disassociate() {
+	addr.instance.publicIp = ""
+	addr.instance = nil
+	addr.associationId = ""
+}
+
+// updateAddresses disassociates the addresses of terminated instances.
+func (srv *Server) updateAddresses() {
+	for _, addr := range srv.addresses {
+		if addr.instance != nil && addr.instance.state == Terminated {
+			addr.disassociate()
+		}
+	}
+}
+
+func (srv *Server) describeAddresses(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ips := make(map[string]bool)
+	for name, vals := range req.Form {
+		if !strings.HasPrefix(name, "PublicIp.") {
+			continue
+		}
+		if srv.addresses[vals[0]] == nil {
+			fatalf(400, "InvalidAddress.NotFound", "Address '%s' not found.", vals[0])
+		}
+		ips[vals[0]] = true
+	}
+	f := newFilter(req.Form)
+	var resp ec2.AddressesResp
+	resp.RequestId = reqId
+	for _, ip := range srv.publicIps() {
+		if len(ips) > 0 && !ips[ip] {
+			continue
+		}
+		addr := srv.addresses[ip]
+		ok, err := f.ok(addr)
+		if ok {
+			resp.Addresses = append(resp.Addresses, addr.ec2Address())
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe addresses: %v", err)
+		}
+	}
+	return &resp
+}
+
+// publicIps returns the public IPs of the addresses, in order.
+func (srv *Server) publicIps() []string {
+	var ips []string
+	for ip := range srv.addresses {
+		ips = append(ips, ip)
+	}
+	return sortedIds(ips)
+}
+
+func (addr *address) ec2Address() ec2.Address {
+	a := ec2.Address{
+		PublicIp:      addr.publicIp,
+		Domain:        addr.domain,
+		AllocationId:  addr.allocationId,
+		AssociationId: addr.associationId,
+	}
+	if addr.instance != nil {
+		a.InstanceId = addr.instance.id
+	}
+	return a
+}
+
+func (addr *address) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "allocation-id":
+		return addr.allocationId == value, nil
+	case "association-id":
+		return addr.associationId == value, nil
+	case "domain":
+		return addr.domain == value, nil
+	case "instance-id":
+		return addr.instance != nil && addr.instance.id == value, nil
+	case "public-ip":
+		return addr.publicIp == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
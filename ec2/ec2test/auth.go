@@ -0,0 +1,50 @@
+package ec2test
+
+import (
+	"github.com/flaviamissi/go-elb/aws"
+	"net/http"
+)
+
+// SetCredentials makes the server authenticate every request against the
+// given credentials, verifying its Signature Version 2. Requests signed
+// with an unknown access key, with a wrong signature or with an expired
+// timestamp are rejected with the errors EC2 returns. Timestamps are
+// checked against the wall clock.
+//
+// Calling SetCredentials without arguments turns authentication off, which
+// is the default.
+func (srv *Server) SetCredentials(auths ...aws.Auth) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(auths) == 0 {
+		srv.secrets = nil
+		return
+	}
+	srv.secrets = make(map[string]string)
+	for _, auth := range auths {
+		srv.secrets[auth.AccessKey] = auth.SecretKey
+	}
+}
+
+// authenticate verifies the signature of the request, if the server was
+// given credentials.
+func (srv *Server) authenticate(req *http.Request) {
+	srv.mu.Lock()
+	secrets := srv.secrets
+	srv.mu.Unlock()
+	if secrets == nil {
+		return
+	}
+	form := req.Form
+	key := form.Get("AWSAccessKeyId")
+	if key == "" || form.Get("Signature") == "" {
+		fatalf(401, "MissingAuthenticationToken", "Request is missing Authentication Token")
+	}
+	secret, ok := secrets[key]
+	if !ok {
+		fatalf(401, "AuthFailure", "AWS was not able to validate the provided access credentials")
+	}
+	if err := aws.VerifySignatureV2(req, secret); err != nil {
+		fatalf(err.StatusCode, err.Code, "%s", err.Message)
+	}
+}
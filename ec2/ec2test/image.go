@@ -0,0 +1,314 @@
+package ec2test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// image holds a simulated AMI.
+type image struct {
+	ec2.Image
+}
+
+// ebsSnapshot holds a simulated EBS snapshot. Its progress is derived from
+// the time it was started.
+type ebsSnapshot struct {
+	id          string
+	volumeId    string
+	volumeSize  int
+	description string
+	startTime   time.Time
+}
+
+// AddImage makes an image known to the server, so that it is returned by
+// DescribeImages. Instances take their root device type from their image,
+// and instances of unknown images are EBS-backed.
+//
+// Missing fields are filled in with those of an available EBS-backed
+// machine image owned by the account, and a missing id is allocated. It
+// returns the image id.
+func (srv *Server) AddImage(img ec2.Image) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for img.Id == "" {
+		id := fmt.Sprintf("ami-%d", srv.imageId.next())
+		if srv.images[id] == nil {
+			img.Id = id
+		}
+	}
+	if img.Type == "" {
+		img.Type = "machine"
+	}
+	if img.State == "" {
+		img.State = "available"
+	}
+	if img.OwnerId == "" {
+		img.OwnerId = ownerId
+	}
+	if img.RootDeviceType == "" {
+		img.RootDeviceType = "ebs"
+	}
+	srv.images[img.Id] = &image{img}
+	return img.Id
+}
+
+// RemoveImage removes an image from the server. It does nothing if there
+// is no such image.
+func (srv *Server) RemoveImage(id string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.images, id)
+}
+
+func (srv *Server) describeImages(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ids := make(map[*image]bool)
+	for name, vals := range req.Form {
+		if !strings.HasPrefix(name, "ImageId.") {
+			continue
+		}
+		img := srv.images[vals[0]]
+		if img == nil {
+			fatalf(400, "InvalidAMIID.NotFound", "The image id '[%s]' does not exist", vals[0])
+		}
+		ids[img] = true
+	}
+	f := newFilter(req.Form)
+	var resp ec2.ImagesResp
+	resp.RequestId = reqId
+	for _, id := range srv.imageIds() {
+		img := srv.images[id]
+		if len(ids) > 0 && !ids[img] {
+			continue
+		}
+		ok, err := f.ok(img)
+		if ok {
+			resp.Images = append(resp.Images, img.Image)
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe images: %v", err)
+		}
+	}
+	return &resp
+}
+
+func (img *image) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "architecture":
+		return img.Architecture == value, nil
+	case "block-device-mapping.device-name":
+		return img.hasBlockDevice(func(bd ec2.BlockDeviceMapping) bool { return bd.DeviceName == value }), nil
+	case "block-device-mapping.snapshot-id":
+		return img.hasBlockDevice(func(bd ec2.BlockDeviceMapping) bool { return bd.SnapshotId == value }), nil
+	case "block-device-mapping.volume-type":
+		return img.hasBlockDevice(func(bd ec2.BlockDeviceMapping) bool { return bd.VolumeType == value }), nil
+	case "description":
+		return wildcardMatch(value, img.Description), nil
+	case "hypervisor":
+		return img.Hypervisor == value, nil
+	case "image-id":
+		return img.Id == value, nil
+	case "image-type":
+		return img.Type == value, nil
+	case "is-public":
+		public, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, err
+		}
+		return img.Public == public, nil
+	case "kernel-id":
+		return img.KernelId == value, nil
+	case "name":
+		return wildcardMatch(value, img.Name), nil
+	case "owner-alias":
+		return img.OwnerAlias == value, nil
+	case "owner-id":
+		return img.OwnerId == value, nil
+	case "platform":
+		return img.Platform == value, nil
+	case "ramdisk-id":
+		return img.RamdiskId == value, nil
+	case "root-device-name":
+		return img.RootDeviceName == value, nil
+	case "root-device-type":
+		return img.RootDeviceType == value, nil
+	case "state":
+		return img.State == value, nil
+	case "virtualization-type":
+		return img.VirtualizationType == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
+
+func (img *image) hasBlockDevice(test func(bd ec2.BlockDeviceMapping) bool) bool {
+	for _, bd := range img.BlockDevices {
+		if test(bd) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageIds returns the ids of the images, in order.
+func (srv *Server) imageIds() []string {
+	var ids []string
+	for id := range srv.images {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
+
+// snapshotIds returns the ids of the snapshots, in order.
+func (srv *Server) snapshotIds() []string {
+	var ids []string
+	for id := range srv.snapshots {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
+
+// wildcardMatch reports whether s matches the pattern, in which * matches
+// any sequence of characters and ? matches any single character.
+func wildcardMatch(pattern, s string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.MustCompile("^" + expr + "$").MatchString(s)
+}
+
+func (srv *Server) createSnapshot(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	vol := srv.volume(req.Form.Get("VolumeId"))
+	snap := &ebsSnapshot{
+		id:          fmt.Sprintf("snap-%d", srv.snapshotId.next()),
+		volumeId:    vol.id,
+		volumeSize:  vol.size,
+		description: req.Form.Get("Description"),
+		startTime:   srv.now(),
+	}
+	srv.snapshots[snap.id] = snap
+	return &ec2.CreateSnapshotResp{
+		RequestId: reqId,
+		Snapshot:  srv.ec2Snapshot(snap),
+	}
+}
+
+// deleteSnapshot deletes the given snapshots. Snapshots used by an image
+// cannot be deleted.
+func (srv *Server) deleteSnapshot(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var snaps []*ebsSnapshot
+	for name, vals := range req.Form {
+		if name != "SnapshotId" && !strings.HasPrefix(name, "SnapshotId.") {
+			continue
+		}
+		snap := srv.snapshots[vals[0]]
+		if snap == nil {
+			fatalf(400, "InvalidSnapshot.NotFound", "The snapshot '%s' does not exist.", vals[0])
+		}
+		for _, id := range srv.imageIds() {
+			if srv.images[id].hasBlockDevice(func(bd ec2.BlockDeviceMapping) bool { return bd.SnapshotId == snap.id }) {
+				fatalf(400, "InvalidSnapshot.InUse", "The snapshot %s is currently in use by %s", snap.id, id)
+			}
+		}
+		snaps = append(snaps, snap)
+	}
+	if len(snaps) == 0 {
+		fatalf(400, "MissingParameter", "The request must contain the parameter snapshotId")
+	}
+	for _, snap := range snaps {
+		delete(srv.snapshots, snap.id)
+	}
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DeleteSnapshotResponse"},
+		RequestId: reqId,
+	}
+}
+
+func (srv *Server) describeSnapshots(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ids := make(map[*ebsSnapshot]bool)
+	for name, vals := range req.Form {
+		if !strings.HasPrefix(name, "SnapshotId.") {
+			continue
+		}
+		snap := srv.snapshots[vals[0]]
+		if snap == nil {
+			fatalf(400, "InvalidSnapshot.NotFound", "The snapshot '%s' does not exist.", vals[0])
+		}
+		ids[snap] = true
+	}
+	f := newFilter(req.Form)
+	var resp ec2.SnapshotsResp
+	resp.RequestId = reqId
+	for _, id := range srv.snapshotIds() {
+		snap := srv.snapshots[id]
+		if len(ids) > 0 && !ids[snap] {
+			continue
+		}
+		s := snapshotInfo(srv.ec2Snapshot(snap))
+		ok, err := f.ok(&s)
+		if ok {
+			resp.Snapshots = append(resp.Snapshots, ec2.Snapshot(s))
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe snapshots: %v", err)
+		}
+	}
+	return &resp
+}
+
+// ec2Snapshot returns the snapshot as described by EC2. Snapshots stay
+// pending, progressing steadily, for the Snapshot duration of the server
+// lifecycle, and are completed from then on.
+func (srv *Server) ec2Snapshot(snap *ebsSnapshot) ec2.Snapshot {
+	s := ec2.Snapshot{
+		Id:          snap.id,
+		VolumeId:    snap.volumeId,
+		VolumeSize:  strconv.Itoa(snap.volumeSize),
+		Status:      "completed",
+		StartTime:   snap.startTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Description: snap.description,
+		Progress:    "100%",
+		OwnerId:     ownerId,
+	}
+	if elapsed := srv.now().Sub(snap.startTime); elapsed < srv.lifecycle.Snapshot {
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		s.Status = "pending"
+		s.Progress = fmt.Sprintf("%d%%", elapsed*100/srv.lifecycle.Snapshot)
+	}
+	return s
+}
+
+// snapshotInfo is a snapshot as described by EC2 that can be filtered.
+type snapshotInfo ec2.Snapshot
+
+func (s *snapshotInfo) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "description":
+		return wildcardMatch(value, s.Description), nil
+	case "owner-id":
+		return s.OwnerId == value, nil
+	case "progress":
+		return s.Progress == value, nil
+	case "snapshot-id":
+		return s.Id == value, nil
+	case "status":
+		return s.Status == value, nil
+	case "volume-id":
+		return s.VolumeId == value, nil
+	case "volume-size":
+		return s.VolumeSize == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
@@ -0,0 +1,213 @@
+package ec2test
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// keyBits is the size of the RSA keys created by CreateKeyPair.
+const keyBits = 2048
+
+// keyPairName returns the KeyName parameter of the request.
+func keyPairName(req *http.Request) string {
+	name := req.Form.Get("KeyName")
+	if name == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter KeyName")
+	}
+	return name
+}
+
+// createKeyPair generates an RSA key pair. As in EC2, its fingerprint is
+// the SHA-1 digest of the DER encoded PKCS#8 private key.
+func (srv *Server) createKeyPair(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	name := keyPairName(req)
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		fatalf(500, "InternalError", "cannot generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		fatalf(500, "InternalError", "cannot marshal key: %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if _, ok := srv.keyPairs[name]; ok {
+		fatalf(400, "InvalidKeyPair.Duplicate", "The keypair '%s' already exists.", name)
+	}
+	digest := sha1.Sum(der)
+	kp := ec2.KeyPair{
+		Name:        name,
+		Fingerprint: fingerprint(digest[:]),
+	}
+	srv.keyPairs[name] = kp
+	return &ec2.CreateKeyPairResp{
+		RequestId: reqId,
+		KeyPair:   kp,
+		Material: strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}))),
+	}
+}
+
+// importKeyPair imports an OpenSSH RSA public key. As in EC2, its
+// fingerprint is the MD5 digest of the DER encoded public key, so it
+// differs from that of the same key created by CreateKeyPair.
+func (srv *Server) importKeyPair(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	name := keyPairName(req)
+	material := req.Form.Get("PublicKeyMaterial")
+	if material == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter PublicKeyMaterial")
+	}
+	data, err := b64.DecodeString(material)
+	if err != nil {
+		fatalf(400, "InvalidParameterValue", "Value for parameter PublicKeyMaterial is invalid. Length exceeds maximum of 2048 or is not base64 encoded.")
+	}
+	pub, err := parseAuthorizedKey(data)
+	if err != nil {
+		fatalf(400, "InvalidKey.Format", "Key is not in valid OpenSSH public key format")
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		fatalf(500, "InternalError", "cannot marshal key: %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if _, ok := srv.keyPairs[name]; ok {
+		fatalf(400, "InvalidKeyPair.Duplicate", "The keypair '%s' already exists.", name)
+	}
+	digest := md5.Sum(der)
+	kp := ec2.KeyPair{
+		Name:        name,
+		Fingerprint: fingerprint(digest[:]),
+	}
+	srv.keyPairs[name] = kp
+	return &ec2.ImportKeyPairResp{
+		RequestId: reqId,
+		KeyPair:   kp,
+	}
+}
+
+// deleteKeyPair deletes a key pair. As in EC2, deleting a key pair that
+// does not exist succeeds.
+func (srv *Server) deleteKeyPair(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	name := keyPairName(req)
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.keyPairs, name)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DeleteKeyPairResponse"},
+		RequestId: reqId,
+	}
+}
+
+func (srv *Server) describeKeyPairs(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	names := make(map[string]bool)
+	for attr, vals := range req.Form {
+		if !strings.HasPrefix(attr, "KeyName.") {
+			continue
+		}
+		if _, ok := srv.keyPairs[vals[0]]; !ok {
+			fatalf(400, "InvalidKeyPair.NotFound", "The key pair '%s' does not exist", vals[0])
+		}
+		names[vals[0]] = true
+	}
+	f := newFilter(req.Form)
+	var resp ec2.KeyPairsResp
+	resp.RequestId = reqId
+	for _, name := range srv.keyPairNames() {
+		if len(names) > 0 && !names[name] {
+			continue
+		}
+		kp := keyPairInfo(srv.keyPairs[name])
+		ok, err := f.ok(&kp)
+		if ok {
+			resp.KeyPairs = append(resp.KeyPairs, ec2.KeyPair(kp))
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe key pairs: %v", err)
+		}
+	}
+	return &resp
+}
+
+// keyPairNames returns the names of the key pairs, in order.
+func (srv *Server) keyPairNames() []string {
+	var names []string
+	for name := range srv.keyPairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keyPairInfo is a key pair that can be filtered.
+type keyPairInfo ec2.KeyPair
+
+func (kp *keyPairInfo) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "fingerprint":
+		return kp.Fingerprint == value, nil
+	case "key-name":
+		return wildcardMatch(value, kp.Name), nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
+
+// fingerprint formats a digest as colon separated hex bytes.
+func fingerprint(digest []byte) string {
+	parts := make([]string, len(digest))
+	for i, c := range digest {
+		parts[i] = fmt.Sprintf("%02x", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+// parseAuthorizedKey parses an RSA public key in the OpenSSH
+// authorized_keys format, e.g. "ssh-rsa AAAA... user@host".
+func parseAuthorizedKey(data []byte) (*rsa.PublicKey, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 || fields[0] != "ssh-rsa" {
+		return nil, errors.New("not an ssh-rsa key")
+	}
+	blob, err := b64.DecodeString(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	var parts [3][]byte
+	for i := range parts {
+		if len(blob) < 4 {
+			return nil, errors.New("short key")
+		}
+		n := binary.BigEndian.Uint32(blob)
+		if uint32(len(blob)-4) < n {
+			return nil, errors.New("short key")
+		}
+		parts[i], blob = blob[4:4+n], blob[4+n:]
+	}
+	if len(blob) != 0 || string(parts[0]) != "ssh-rsa" {
+		return nil, errors.New("malformed key")
+	}
+	e := new(big.Int).SetBytes(parts[1])
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("bad exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(parts[2]),
+		E: int(e.Int64()),
+	}, nil
+}
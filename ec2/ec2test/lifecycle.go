@@ -0,0 +1,148 @@
+package ec2test
+
+import (
+	"github.com/flaviamissi/go-elb/ec2"
+	"time"
+)
+
+// Lifecycle holds how long instances, snapshots and volumes take to move
+// from one state to the next. See Server.SetLifecycle.
+type Lifecycle struct {
+	Pending      time.Duration // pending to running
+	Stopping     time.Duration // stopping to stopped
+	ShuttingDown time.Duration // shutting-down to terminated
+	Snapshot     time.Duration // snapshot pending to completed
+	Volume       time.Duration // volume creating to available
+	Attach       time.Duration // volume attaching to attached, or detaching to detached
+
+	// Retention is how long terminated instances are still reported
+	// before they are removed.
+	Retention time.Duration
+}
+
+// DefaultLifecycle is the lifecycle of the instances of a new server.
+var DefaultLifecycle = Lifecycle{
+	Pending:      30 * time.Second,
+	Stopping:     30 * time.Second,
+	ShuttingDown: 30 * time.Second,
+	Snapshot:     time.Minute,
+	Volume:       10 * time.Second,
+	Attach:       10 * time.Second,
+	Retention:    time.Hour,
+}
+
+// SetLifecycle sets how long instances, snapshots and volumes take to move
+// between states. Those that were already due to move do so at once.
+func (srv *Server) SetLifecycle(l Lifecycle) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.lifecycle = l
+	srv.update()
+}
+
+// SetVirtualClock stops the server from using the wall clock and sets its
+// time to now. From then on time only moves when Advance is called, which
+// makes instance state changes deterministic. Instances count the time
+// spent in their current state from now. Snapshots, volumes and volume
+// attachments keep how far along they are, their timestamps being moved
+// to the new clock.
+func (srv *Server) SetVirtualClock(now time.Time) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.update()
+	shift := now.Sub(srv.now())
+	srv.virtual = true
+	srv.virtualNow = now
+	for _, inst := range srv.instances {
+		inst.stateChanged = now
+	}
+	for _, snap := range srv.snapshots {
+		snap.startTime = snap.startTime.Add(shift)
+	}
+	for _, vol := range srv.volumes {
+		vol.createTime = vol.createTime.Add(shift)
+		if a := vol.attachment; a != nil {
+			a.attachTime = a.attachTime.Add(shift)
+			if !a.detachTime.IsZero() {
+				a.detachTime = a.detachTime.Add(shift)
+			}
+		}
+	}
+}
+
+// Advance moves the virtual clock forward by d, moving the instances
+// through the states they are due to reach on the way.
+//
+// It panics if SetVirtualClock was not called.
+func (srv *Server) Advance(d time.Duration) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !srv.virtual {
+		panic("ec2test: Advance called without a virtual clock")
+	}
+	srv.virtualNow = srv.virtualNow.Add(d)
+	srv.update()
+}
+
+// now returns the current time of the server, which is the wall clock time
+// unless SetVirtualClock was called.
+func (srv *Server) now() time.Time {
+	if srv.virtual {
+		return srv.virtualNow
+	}
+	return time.Now()
+}
+
+// next returns the state an instance in the given state moves to on its
+// own, and after how long.
+func (l *Lifecycle) next(state ec2.InstanceState) (next ec2.InstanceState, after time.Duration, ok bool) {
+	switch state {
+	case Pending:
+		return Running, l.Pending, true
+	case Stopping:
+		return Stopped, l.Stopping, true
+	case ShuttingDown:
+		return Terminated, l.ShuttingDown, true
+	}
+	return ec2.InstanceState{}, 0, false
+}
+
+// setState moves the instance to the given state at the current time.
+func (srv *Server) setState(inst *Instance, state ec2.InstanceState) {
+	inst.state = state
+	inst.stateChanged = srv.now()
+}
+
+// update brings the instances, and the volumes and addresses associated
+// with them, up to date with the current time.
+func (srv *Server) update() {
+	srv.updateInstances()
+	srv.updateVolumes()
+	srv.updateAddresses()
+}
+
+// updateInstances moves every instance to the state it is due to be in
+// now, and removes the terminated instances past their retention period,
+// along with their reservation once it is empty.
+func (srv *Server) updateInstances() {
+	now := srv.now()
+	for id, inst := range srv.instances {
+		for {
+			next, after, ok := srv.lifecycle.next(inst.state)
+			if !ok || now.Before(inst.stateChanged.Add(after)) {
+				break
+			}
+			inst.state = next
+			inst.stateChanged = inst.stateChanged.Add(after)
+		}
+		if inst.state != Terminated || now.Before(inst.stateChanged.Add(srv.lifecycle.Retention)) {
+			continue
+		}
+		delete(srv.instances, id)
+		r := inst.reservation
+		delete(r.instances, id)
+		if len(r.instances) == 0 {
+			delete(srv.reservations, r.id)
+		}
+	}
+}
@@ -14,9 +14,11 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var b64 = base64.StdEncoding
@@ -25,6 +27,9 @@ var b64 = base64.StdEncoding
 type Action struct {
 	RequestId string
 
+	// Time holds the server time at which the request was received.
+	Time time.Time
+
 	// Request holds the requested action as a url.Values instance
 	Request url.Values
 
@@ -36,10 +41,6 @@ type Action struct {
 	Err *ec2.Error
 }
 
-// TODO possible other things:
-// - some virtual time stamp interface, so a client
-// can ask for all actions after a certain virtual time.
-
 // Server implements an EC2 simulator for use in testing.
 type Server struct {
 	url      string
@@ -47,14 +48,37 @@ type Server struct {
 	mu       sync.Mutex
 	reqs     []*Action
 
-	instances            map[string]*Instance      // id -> instance
-	reservations         map[string]*reservation   // id -> reservation
-	groups               map[string]*securityGroup // id -> group
+	instances            map[string]*Instance        // id -> instance
+	reservations         map[string]*reservation     // id -> reservation
+	groups               map[string]*securityGroup   // id -> group
+	images               map[string]*image           // id -> image
+	snapshots            map[string]*ebsSnapshot     // id -> snapshot
+	volumes              map[string]*volume          // id -> volume
+	keyPairs             map[string]ec2.KeyPair      // name -> key pair
+	addresses            map[string]*address         // public ip -> address
+	vpcs                 map[string]*vpc             // id -> VPC
+	subnets              map[string]*subnet          // id -> subnet
+	internetGateways     map[string]*internetGateway // id -> internet gateway
+	routeTables          map[string]*routeTable      // id -> route table
 	maxId                counter
 	reqId                counter
 	reservationId        counter
 	groupId              counter
+	imageId              counter
+	snapshotId           counter
+	volumeId             counter
+	allocationId         counter
+	associationId        counter
+	vpcId                counter
+	subnetId             counter
+	internetGatewayId    counter
+	routeTableId         counter
+	routeTableAssocId    counter
 	initialInstanceState ec2.InstanceState
+	lifecycle            Lifecycle
+	virtual              bool
+	virtualNow           time.Time
+	secrets              map[string]string // access key -> secret key
 }
 
 // reservation holds a simulated ec2 reservation.
@@ -68,20 +92,30 @@ type reservation struct {
 type Instance struct {
 	// UserData holds the data that was passed to the RunInstances request
 	// when the instance was started.
-	UserData    []byte
-	id          string
-	imageId     string
-	reservation *reservation
-	instType    string
-	state       ec2.InstanceState
+	UserData     []byte
+	id           string
+	imageId      string
+	reservation  *reservation
+	instType     string
+	availZone    string
+	state        ec2.InstanceState
+	stateChanged time.Time
+	rootDevice   string // "ebs" or "instance-store"
+	keyName      string
+	subnet       *subnet           // nil outside of a VPC
+	publicIp     string            // of the associated Elastic IP address
+	tags         map[string]string // key -> value
 }
 
 // permKey represents permission for a given security
 // group or IP address (but not both) to access a given range of
-// ports. Equality of permKeys is used in the implementation of
-// permission sets, relying on the uniqueness of securityGroup
-// instances.
+// ports, or for instances of a VPC security group to send traffic to
+// them if egress is set. Equality of permKeys is used in the
+// implementation of permission sets, relying on the uniqueness of
+// securityGroup instances. The ports of the "-1" protocol, which stands
+// for all traffic, are always zero.
 type permKey struct {
+	egress   bool
 	protocol string
 	fromPort int
 	toPort   int
@@ -97,6 +131,7 @@ type securityGroup struct {
 	id          string
 	name        string
 	description string
+	vpc         *vpc // nil for EC2-Classic groups
 
 	perms map[permKey]bool
 }
@@ -109,6 +144,15 @@ func (g *securityGroup) ec2SecurityGroup() ec2.SecurityGroup {
 }
 
 func (g *securityGroup) matchAttr(attr, value string) (ok bool, err error) {
+	// Filters on permissions apply to inbound ones unless prefixed with
+	// "egress.".
+	egress := strings.HasPrefix(attr, "egress.ip-permission.")
+	if egress {
+		attr = attr[len("egress."):]
+	}
+	hasPerm := func(test func(k permKey) bool) bool {
+		return g.hasPerm(func(k permKey) bool { return k.egress == egress && test(k) })
+	}
 	switch attr {
 	case "description":
 		return g.description == value, nil
@@ -117,9 +161,13 @@ func (g *securityGroup) matchAttr(attr, value string) (ok bool, err error) {
 	case "group-name":
 		return g.name == value, nil
 	case "ip-permission.cidr":
-		return g.hasPerm(func(k permKey) bool { return k.ipAddr == value }), nil
+		return hasPerm(func(k permKey) bool { return k.ipAddr == value }), nil
+	case "ip-permission.group-id":
+		return hasPerm(func(k permKey) bool {
+			return k.group != nil && k.group.id == value
+		}), nil
 	case "ip-permission.group-name":
-		return g.hasPerm(func(k permKey) bool {
+		return hasPerm(func(k permKey) bool {
 			return k.group != nil && k.group.name == value
 		}), nil
 	case "ip-permission.from-port":
@@ -127,17 +175,19 @@ func (g *securityGroup) matchAttr(attr, value string) (ok bool, err error) {
 		if err != nil {
 			return false, err
 		}
-		return g.hasPerm(func(k permKey) bool { return k.fromPort == port }), nil
+		return hasPerm(func(k permKey) bool { return k.fromPort == port }), nil
 	case "ip-permission.to-port":
 		port, err := strconv.Atoi(value)
 		if err != nil {
 			return false, err
 		}
-		return g.hasPerm(func(k permKey) bool { return k.toPort == port }), nil
+		return hasPerm(func(k permKey) bool { return k.toPort == port }), nil
 	case "ip-permission.protocol":
-		return g.hasPerm(func(k permKey) bool { return k.protocol == value }), nil
+		return hasPerm(func(k permKey) bool { return k.protocol == value }), nil
 	case "owner-id":
 		return value == ownerId, nil
+	case "vpc-id":
+		return g.vpc != nil && g.vpc.id == value, nil
 	}
 	return false, fmt.Errorf("unknown attribute %q", attr)
 }
@@ -151,15 +201,18 @@ func (g *securityGroup) hasPerm(test func(k permKey) bool) bool {
 	return false
 }
 
-// ec2Perms returns the list of EC2 permissions granted
-// to g. It groups permissions by port range and protocol.
-func (g *securityGroup) ec2Perms() (perms []ec2.IPPerm) {
+// ec2Perms returns the list of inbound or outbound EC2 permissions
+// granted to g. It groups permissions by port range and protocol.
+func (g *securityGroup) ec2Perms(egress bool) (perms []ec2.IPPerm) {
 	// The grouping is held in result. We use permKey for convenience,
 	// (ensuring that the group and ipAddr of each key is zero). For
 	// each protocol/port range combination, we build up the permission
 	// set in the associated value.
 	result := make(map[permKey]*ec2.IPPerm)
 	for k := range g.perms {
+		if k.egress != egress {
+			continue
+		}
 		groupKey := k
 		groupKey.group = nil
 		groupKey.ipAddr = ""
@@ -198,18 +251,64 @@ var actions = map[string]func(*Server, http.ResponseWriter, *http.Request, strin
 	"DescribeSecurityGroups":        (*Server).describeSecurityGroups,
 	"DeleteSecurityGroup":           (*Server).deleteSecurityGroup,
 	"AuthorizeSecurityGroupIngress": (*Server).authorizeSecurityGroupIngress,
+	"AuthorizeSecurityGroupEgress":  (*Server).authorizeSecurityGroupEgress,
+	"RevokeSecurityGroupEgress":     (*Server).revokeSecurityGroupEgress,
 	"RevokeSecurityGroupIngress":    (*Server).revokeSecurityGroupIngress,
+	"StartInstances":                (*Server).startInstances,
+	"StopInstances":                 (*Server).stopInstances,
+	"RebootInstances":               (*Server).rebootInstances,
+	"CreateTags":                    (*Server).createTags,
+	"DescribeImages":                (*Server).describeImages,
+	"CreateSnapshot":                (*Server).createSnapshot,
+	"DeleteSnapshot":                (*Server).deleteSnapshot,
+	"DescribeSnapshots":             (*Server).describeSnapshots,
+	"CreateVolume":                  (*Server).createVolume,
+	"DeleteVolume":                  (*Server).deleteVolume,
+	"AttachVolume":                  (*Server).attachVolume,
+	"DetachVolume":                  (*Server).detachVolume,
+	"DescribeVolumes":               (*Server).describeVolumes,
+	"CreateKeyPair":                 (*Server).createKeyPair,
+	"ImportKeyPair":                 (*Server).importKeyPair,
+	"DeleteKeyPair":                 (*Server).deleteKeyPair,
+	"DescribeKeyPairs":              (*Server).describeKeyPairs,
+	"AllocateAddress":               (*Server).allocateAddress,
+	"ReleaseAddress":                (*Server).releaseAddress,
+	"AssociateAddress":              (*Server).associateAddress,
+	"DisassociateAddress":           (*Server).disassociateAddress,
+	"DescribeAddresses":             (*Server).describeAddresses,
+	"CreateVpc":                     (*Server).createVpc,
+	"DeleteVpc":                     (*Server).deleteVpc,
+	"DescribeVpcs":                  (*Server).describeVpcs,
+	"CreateSubnet":                  (*Server).createSubnet,
+	"DeleteSubnet":                  (*Server).deleteSubnet,
+	"DescribeSubnets":               (*Server).describeSubnets,
+	"CreateInternetGateway":         (*Server).createInternetGateway,
+	"DeleteInternetGateway":         (*Server).deleteInternetGateway,
+	"AttachInternetGateway":         (*Server).attachInternetGateway,
+	"DetachInternetGateway":         (*Server).detachInternetGateway,
+	"DescribeInternetGateways":      (*Server).describeInternetGateways,
+	"CreateRouteTable":              (*Server).createRouteTable,
+	"DeleteRouteTable":              (*Server).deleteRouteTable,
+	"DescribeRouteTables":           (*Server).describeRouteTables,
+	"AssociateRouteTable":           (*Server).associateRouteTable,
+	"DisassociateRouteTable":        (*Server).disassociateRouteTable,
+	"CreateRoute":                   (*Server).createRoute,
+	"DeleteRoute":                   (*Server).deleteRoute,
 }
 
 const ownerId = "9876"
 
+// defaultAvailZone is the availability zone of instances started without
+// an explicit placement.
+const defaultAvailZone = "us-east-1a"
+
 // newAction allocates a new action and adds it to the
 // recorded list of server actions.
 func (srv *Server) newAction() *Action {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	a := new(Action)
+	a := &Action{Time: srv.now()}
 	srv.reqs = append(srv.reqs, a)
 	return a
 }
@@ -220,15 +319,21 @@ func NewServer() (*Server, error) {
 		instances:            make(map[string]*Instance),
 		groups:               make(map[string]*securityGroup),
 		reservations:         make(map[string]*reservation),
+		images:               make(map[string]*image),
+		snapshots:            make(map[string]*ebsSnapshot),
+		volumes:              make(map[string]*volume),
+		keyPairs:             make(map[string]ec2.KeyPair),
+		addresses:            make(map[string]*address),
+		vpcs:                 make(map[string]*vpc),
+		subnets:              make(map[string]*subnet),
+		internetGateways:     make(map[string]*internetGateway),
+		routeTables:          make(map[string]*routeTable),
 		initialInstanceState: Pending,
+		lifecycle:            DefaultLifecycle,
 	}
 
 	// Add default security group.
-	g := &securityGroup{
-		name:        "default",
-		description: "default group",
-		id:          fmt.Sprintf("sg-%d", srv.groupId.next()),
-	}
+	g := srv.newSecurityGroup(nil, "default", "default group")
 	g.perms = map[permKey]bool{
 		permKey{
 			protocol: "icmp",
@@ -249,7 +354,6 @@ func NewServer() (*Server, error) {
 			group:    g,
 		}: true,
 	}
-	srv.groups[g.id] = g
 
 	l, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
@@ -309,6 +413,10 @@ func (srv *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 	}()
 
+	srv.authenticate(req)
+	srv.mu.Lock()
+	srv.update()
+	srv.mu.Unlock()
 	f := actions[req.Form.Get("Action")]
 	if f == nil {
 		fatalf(400, "InvalidParameterValue", "Unrecognized Action")
@@ -326,9 +434,23 @@ func (srv *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
 func (srv *Server) Instance(id string) *Instance {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
+	srv.update()
 	return srv.instances[id]
 }
 
+// DescribeInstance returns the instance with the given id as it would be
+// returned by DescribeInstances, and whether the instance exists.
+func (srv *Server) DescribeInstance(id string) (ec2.Instance, bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.update()
+	inst := srv.instances[id]
+	if inst == nil {
+		return ec2.Instance{}, false
+	}
+	return inst.ec2instance(), true
+}
+
 // writeError writes an appropriate error response.
 // TODO how should we deal with errors when the
 // error itself is potentially generated by backend-agnostic
@@ -382,12 +504,7 @@ func (srv *Server) formToGroups(form url.Values) []*securityGroup {
 				fatalf(400, "InvalidGroup.NotFound", "unknown group id %q", values[0])
 			}
 		case strings.HasPrefix(name, "SecurityGroup."):
-			var found *securityGroup
-			for _, g := range srv.groups {
-				if g.name == values[0] {
-					found = g
-				}
-			}
+			found := srv.group(ec2.SecurityGroup{Name: values[0]})
 			if found == nil {
 				fatalf(400, "InvalidGroup.NotFound", "unknown group name %q", values[0])
 			}
@@ -418,14 +535,11 @@ func (srv *Server) runInstances(w http.ResponseWriter, req *http.Request, reqId
 
 	// TODO attributes still to consider:
 	//	ImageId: 			accept anything, we can verify later
-	//	KeyName          		?
 	//	InstanceType    	?
 	//	KernelId              	?
 	//	RamdiskId             	?
-	//	AvailZone             	?
 	//	GroupName             	tag
 	//	Monitoring            	ignore?
-	//	SubnetId           		?
 	//	DisableAPITermination bool
 	//	ShutdownBehavior      string
 	//	PrivateIPAddress      string
@@ -436,8 +550,39 @@ func (srv *Server) runInstances(w http.ResponseWriter, req *http.Request, reqId
 	// make sure that form fields are correct before creating the reservation.
 	instType := req.Form.Get("InstanceType")
 	imageId := req.Form.Get("ImageId")
+	availZone := req.Form.Get("Placement.AvailabilityZone")
+	keyName := req.Form.Get("KeyName")
+	if _, ok := srv.keyPairs[keyName]; keyName != "" && !ok {
+		fatalf(400, "InvalidKeyPair.NotFound", "The key pair '%s' does not exist", keyName)
+	}
+	var sub *subnet
+	if id := req.Form.Get("SubnetId"); id != "" {
+		sub = srv.subnet(id)
+		if availZone != "" && availZone != sub.availZone {
+			fatalf(400, "InvalidParameterValue", "The specified availability zone %s does not match the availability zone of subnet %s", availZone, sub.id)
+		}
+		availZone = sub.availZone
+		if srv.availableAddresses(sub) < max {
+			fatalf(400, "InsufficientFreeAddressesInSubnet", "There are not enough free addresses in subnet '%s' to satisfy the requested number of instances.", sub.id)
+		}
+	}
 
-	r := srv.newReservation(srv.formToGroups(req.Form))
+	groups := srv.formToGroups(req.Form)
+	for _, g := range groups {
+		switch {
+		case sub == nil && g.vpc != nil:
+			fatalf(400, "InvalidParameterCombination", "The security group '%s' belongs to a VPC and cannot be used without a subnet", g.id)
+		case sub != nil && g.vpc != sub.vpc:
+			fatalf(400, "InvalidParameter", "Security group %s and subnet %s belong to different networks.", g.id, sub.id)
+		}
+	}
+	if sub != nil && len(groups) == 0 {
+		if g := srv.defaultGroup(sub.vpc); g != nil {
+			groups = append(groups, g)
+		}
+	}
+
+	r := srv.newReservation(groups)
 
 	var resp ec2.RunInstancesResp
 	resp.RequestId = reqId
@@ -447,17 +592,25 @@ func (srv *Server) runInstances(w http.ResponseWriter, req *http.Request, reqId
 	for i := 0; i < max; i++ {
 		inst := srv.newInstance(r, instType, imageId, srv.initialInstanceState)
 		inst.UserData = userData
+		inst.keyName = keyName
+		inst.subnet = sub
+		if availZone != "" {
+			inst.availZone = availZone
+		}
 		resp.Instances = append(resp.Instances, inst.ec2instance())
 	}
 	return &resp
 }
 
+// group returns the group with the given id, or the EC2-Classic group
+// with the given name, or nil if there is no such group. As in EC2, VPC
+// groups can only be referred to by id.
 func (srv *Server) group(group ec2.SecurityGroup) *securityGroup {
 	if group.Id != "" {
 		return srv.groups[group.Id]
 	}
 	for _, g := range srv.groups {
-		if g.name == group.Name {
+		if g.vpc == nil && g.name == group.Name {
 			return g
 		}
 	}
@@ -491,11 +644,17 @@ func (srv *Server) NewInstances(n int, instType string, imageId string, state ec
 
 func (srv *Server) newInstance(r *reservation, instType string, imageId string, state ec2.InstanceState) *Instance {
 	inst := &Instance{
-		id:          fmt.Sprintf("i-%d", srv.maxId.next()),
-		instType:    instType,
-		imageId:     imageId,
-		state:       state,
-		reservation: r,
+		id:           fmt.Sprintf("i-%d", srv.maxId.next()),
+		instType:     instType,
+		imageId:      imageId,
+		availZone:    defaultAvailZone,
+		state:        state,
+		stateChanged: srv.now(),
+		rootDevice:   "ebs",
+		reservation:  r,
+	}
+	if img := srv.images[imageId]; img != nil && img.RootDeviceType != "" {
+		inst.rootDevice = img.RootDeviceType
 	}
 	srv.instances[inst.id] = inst
 	r.instances[inst.id] = inst
@@ -518,45 +677,195 @@ func (srv *Server) terminateInstances(w http.ResponseWriter, req *http.Request,
 	defer srv.mu.Unlock()
 	var resp ec2.TerminateInstancesResp
 	resp.RequestId = reqId
+	for _, inst := range srv.formInstances(req.Form, "InstanceId.") {
+		resp.StateChanges = append(resp.StateChanges, srv.terminate(inst))
+	}
+	return &resp
+}
+
+// formInstances returns the instances whose ids are held by the form
+// fields with the given prefix, e.g. "InstanceId.", in index order.
+func (srv *Server) formInstances(form url.Values, prefix string) []*Instance {
 	var insts []*Instance
-	for attr, vals := range req.Form {
-		if strings.HasPrefix(attr, "InstanceId.") {
-			id := vals[0]
-			inst := srv.instances[id]
-			if inst == nil {
-				fatalf(400, "InvalidInstanceID.NotFound", "no such instance id %q", id)
-			}
-			insts = append(insts, inst)
+	for i := 1; ; i++ {
+		id := form.Get(fmt.Sprintf("%s%d", prefix, i))
+		if id == "" {
+			break
+		}
+		inst := srv.instances[id]
+		if inst == nil {
+			fatalf(400, "InvalidInstanceID.NotFound", "no such instance id %q", id)
 		}
+		insts = append(insts, inst)
 	}
+	return insts
+}
+
+func (srv *Server) startInstances(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	insts := srv.formInstances(req.Form, "InstanceId.")
 	for _, inst := range insts {
-		resp.StateChanges = append(resp.StateChanges, inst.terminate())
+		if inst.rootDevice != "ebs" {
+			fatalf(400, "UnsupportedOperation", "The instance '%s' does not have an 'ebs' root device type and cannot be started.", inst.id)
+		}
+		switch inst.state {
+		case Pending, Running, Stopped:
+		default:
+			fatalf(400, "IncorrectInstanceState", "The instance '%s' is not in a state from which it can be started.", inst.id)
+		}
+	}
+	var resp ec2.StartInstanceResp
+	resp.RequestId = reqId
+	for _, inst := range insts {
+		d := ec2.InstanceStateChange{InstanceId: inst.id, PreviousState: inst.state}
+		if inst.state == Stopped {
+			srv.setState(inst, Pending)
+		}
+		d.CurrentState = inst.state
+		resp.StateChanges = append(resp.StateChanges, d)
 	}
 	return &resp
 }
 
-func (inst *Instance) terminate() (d ec2.InstanceStateChange) {
+func (srv *Server) stopInstances(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	insts := srv.formInstances(req.Form, "InstanceId.")
+	for _, inst := range insts {
+		if inst.rootDevice != "ebs" {
+			fatalf(400, "UnsupportedOperation", "The instance '%s' does not have an 'ebs' root device type and cannot be stopped.", inst.id)
+		}
+		switch inst.state {
+		case Running, Stopping, Stopped:
+		default:
+			fatalf(400, "IncorrectInstanceState", "The instance '%s' is not in a state from which it can be stopped.", inst.id)
+		}
+	}
+	var resp ec2.StopInstanceResp
+	resp.RequestId = reqId
+	for _, inst := range insts {
+		d := ec2.InstanceStateChange{InstanceId: inst.id, PreviousState: inst.state}
+		if inst.state == Running {
+			srv.setState(inst, Stopping)
+		}
+		d.CurrentState = inst.state
+		resp.StateChanges = append(resp.StateChanges, d)
+	}
+	return &resp
+}
+
+// rebootInstances checks that the instances can be rebooted. Rebooting
+// leaves them running, and terminated instances are ignored.
+func (srv *Server) rebootInstances(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, inst := range srv.formInstances(req.Form, "InstanceId.") {
+		switch inst.state {
+		case Running, ShuttingDown, Terminated:
+		default:
+			fatalf(400, "IncorrectState", "The instance '%s' is not in a state from which it can be rebooted.", inst.id)
+		}
+	}
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "RebootInstancesResponse"},
+		RequestId: reqId,
+	}
+}
+
+// createTags adds or overwrites tags of instances.
+func (srv *Server) createTags(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	insts := srv.formInstances(req.Form, "ResourceId.")
+	if len(insts) == 0 {
+		fatalf(400, "MissingParameter", "The request must contain the parameter resourceIdSet")
+	}
+	tags := make(map[string]string)
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("Tag.%d.", i)
+		key, ok := req.Form[prefix+"Key"]
+		if !ok {
+			break
+		}
+		if key[0] == "" {
+			fatalf(400, "InvalidParameterValue", "Tag key must not be empty")
+		}
+		if strings.HasPrefix(key[0], "aws:") {
+			fatalf(400, "InvalidParameterValue", "Tag keys starting with 'aws:' are reserved for internal use")
+		}
+		tags[key[0]] = req.Form.Get(prefix + "Value")
+	}
+	if len(tags) == 0 {
+		fatalf(400, "MissingParameter", "The request must contain the parameter tagSet")
+	}
+	for _, inst := range insts {
+		if inst.tags == nil {
+			inst.tags = make(map[string]string)
+		}
+		for k, v := range tags {
+			inst.tags[k] = v
+		}
+	}
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "CreateTagsResponse"},
+		RequestId: reqId,
+	}
+}
+
+func (srv *Server) terminate(inst *Instance) (d ec2.InstanceStateChange) {
 	d.PreviousState = inst.state
-	inst.state = ShuttingDown
+	if inst.state != ShuttingDown && inst.state != Terminated {
+		srv.setState(inst, ShuttingDown)
+	}
 	d.CurrentState = inst.state
 	d.InstanceId = inst.id
 	return d
 }
 
 func (inst *Instance) ec2instance() ec2.Instance {
-	return ec2.Instance{
+	i := ec2.Instance{
 		InstanceId:   inst.id,
 		InstanceType: inst.instType,
 		ImageId:      inst.imageId,
 		DNSName:      fmt.Sprintf("%s.example.com", inst.id),
+		KeyName:      inst.keyName,
+		AvailZone:    inst.availZone,
+		State:        inst.state,
+		Tags:         inst.ec2Tags(),
 		// TODO the rest
 	}
+	if inst.publicIp != "" {
+		i.IPAddress = inst.publicIp
+		i.DNSName = fmt.Sprintf("ec2-%s.compute-1.amazonaws.com", strings.Replace(inst.publicIp, ".", "-", -1))
+	}
+	if inst.subnet != nil {
+		i.VpcId = inst.subnet.vpc.id
+		i.SubnetId = inst.subnet.id
+	}
+	return i
+}
+
+// ec2Tags returns the tags of the instance sorted by key.
+func (inst *Instance) ec2Tags() []ec2.Tag {
+	var tags []ec2.Tag
+	for k, v := range inst.tags {
+		tags = append(tags, ec2.Tag{Key: k, Value: v})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return tags
 }
 
 func (inst *Instance) matchAttr(attr, value string) (ok bool, err error) {
+	if strings.HasPrefix(attr, "tag:") {
+		v, ok := inst.tags[attr[len("tag:"):]]
+		return ok && v == value, nil
+	}
 	switch attr {
 	case "architecture":
 		return value == "i386", nil
+	case "availability-zone":
+		return value == inst.availZone, nil
 	case "instance-id":
 		return inst.id == value, nil
 	case "group-id":
@@ -583,6 +892,26 @@ func (inst *Instance) matchAttr(attr, value string) (ok bool, err error) {
 		return code&0xff == inst.state.Code, nil
 	case "instance-state-name":
 		return value == inst.state.Name, nil
+	case "ip-address":
+		return value == inst.publicIp, nil
+	case "key-name":
+		return value == inst.keyName, nil
+	case "root-device-type":
+		return value == inst.rootDevice, nil
+	case "subnet-id":
+		return inst.subnet != nil && inst.subnet.id == value, nil
+	case "tag-key":
+		_, ok := inst.tags[value]
+		return ok, nil
+	case "tag-value":
+		for _, v := range inst.tags {
+			if v == value {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "vpc-id":
+		return inst.subnet != nil && inst.subnet.vpc.id == value, nil
 	}
 	return false, fmt.Errorf("unknown attribute %q", attr)
 }
@@ -591,10 +920,14 @@ var (
 	Pending      = ec2.InstanceState{0, "pending"}
 	Running      = ec2.InstanceState{16, "running"}
 	ShuttingDown = ec2.InstanceState{32, "shutting-down"}
-	Terminated   = ec2.InstanceState{16, "terminated"}
-	Stopped      = ec2.InstanceState{16, "stopped"}
+	Terminated   = ec2.InstanceState{48, "terminated"}
+	Stopping     = ec2.InstanceState{64, "stopping"}
+	Stopped      = ec2.InstanceState{80, "stopped"}
 )
 
+// createSecurityGroup creates an EC2-Classic security group, or a VPC one
+// if the VpcId parameter is set. Group names are unique within EC2-Classic
+// and within each VPC.
 func (srv *Server) createSecurityGroup(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
 	name := req.Form.Get("GroupName")
 	if name == "" {
@@ -602,16 +935,16 @@ func (srv *Server) createSecurityGroup(w http.ResponseWriter, req *http.Request,
 	}
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
-	if srv.group(ec2.SecurityGroup{Name: name}) != nil {
-		fatalf(400, "InvalidGroup.Duplicate", "group %q already exists", name)
+	var v *vpc
+	if id := req.Form.Get("VpcId"); id != "" {
+		v = srv.vpc(id)
 	}
-	g := &securityGroup{
-		name:        name,
-		description: req.Form.Get("GroupDescription"),
-		id:          fmt.Sprintf("sg-%d", srv.groupId.next()),
-		perms:       make(map[permKey]bool),
+	for _, g := range srv.groups {
+		if g.vpc == v && g.name == name {
+			fatalf(400, "InvalidGroup.Duplicate", "group %q already exists", name)
+		}
 	}
-	srv.groups[g.id] = g
+	g := srv.newSecurityGroup(v, name, req.Form.Get("GroupDescription"))
 	// we define a local type for this because ec2.CreateSecurityGroupResp
 	// contains SecurityGroup, but the response to this request
 	// should not contain the security group name.
@@ -628,6 +961,34 @@ func (srv *Server) createSecurityGroup(w http.ResponseWriter, req *http.Request,
 	return r
 }
 
+// newSecurityGroup creates a security group. As in EC2, VPC groups start
+// out allowing all outbound traffic.
+func (srv *Server) newSecurityGroup(v *vpc, name, description string) *securityGroup {
+	g := &securityGroup{
+		name:        name,
+		description: description,
+		vpc:         v,
+		id:          fmt.Sprintf("sg-%d", srv.groupId.next()),
+		perms:       make(map[permKey]bool),
+	}
+	if v != nil {
+		g.perms[permKey{egress: true, protocol: "-1", ipAddr: "0.0.0.0/0"}] = true
+	}
+	srv.groups[g.id] = g
+	return g
+}
+
+// defaultGroup returns the default security group of the VPC, or nil if
+// it has none.
+func (srv *Server) defaultGroup(v *vpc) *securityGroup {
+	for _, g := range srv.groups {
+		if g.vpc == v && g.name == "default" {
+			return g
+		}
+	}
+	return nil
+}
+
 func (srv *Server) notImplemented(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
 	fatalf(500, "InternalError", "not implemented")
 	panic("not reached")
@@ -715,12 +1076,17 @@ func (srv *Server) describeSecurityGroups(w http.ResponseWriter, req *http.Reque
 	for _, group := range groups {
 		ok, err := f.ok(group)
 		if ok {
-			resp.Groups = append(resp.Groups, ec2.SecurityGroupInfo{
+			info := ec2.SecurityGroupInfo{
 				OwnerId:       ownerId,
 				SecurityGroup: group.ec2SecurityGroup(),
 				Description:   group.description,
-				IPPerms:       group.ec2Perms(),
-			})
+				IPPerms:       group.ec2Perms(false),
+				IPPermsEgress: group.ec2Perms(true),
+			}
+			if group.vpc != nil {
+				info.VpcId = group.vpc.id
+			}
+			resp.Groups = append(resp.Groups, info)
 		} else if err != nil {
 			fatalf(400, "InvalidParameterValue", "describe security groups: %v", err)
 		}
@@ -731,32 +1097,50 @@ func (srv *Server) describeSecurityGroups(w http.ResponseWriter, req *http.Reque
 func (srv *Server) authorizeSecurityGroupIngress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
-	g := srv.group(ec2.SecurityGroup{
-		Name: req.Form.Get("GroupName"),
-		Id:   req.Form.Get("GroupId"),
-	})
-	if g == nil {
-		fatalf(400, "InvalidGroup.NotFound", "group not found")
+	g := srv.formGroup(req)
+	srv.authorize(g, srv.parsePerms(req, g, false))
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "AuthorizeSecurityGroupIngressResponse"},
+		RequestId: reqId,
 	}
-	perms := srv.parsePerms(req)
+}
 
-	for _, p := range perms {
-		if g.perms[p] {
-			fatalf(400, "InvalidPermission.Duplicate", "Permission has already been authorized on the specified group")
-		}
-	}
-	for _, p := range perms {
-		g.perms[p] = true
+func (srv *Server) revokeSecurityGroupIngress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	g := srv.formGroup(req)
+	srv.revoke(g, srv.parsePerms(req, g, false))
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "RevokeSecurityGroupIngressResponse"},
+		RequestId: reqId,
 	}
+}
+
+func (srv *Server) authorizeSecurityGroupEgress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	g := srv.formEgressGroup(req)
+	srv.authorize(g, srv.parsePerms(req, g, true))
 	return &ec2.SimpleResp{
-		XMLName:   xml.Name{"", "AuthorizeSecurityGroupIngressResponse"},
+		XMLName:   xml.Name{"", "AuthorizeSecurityGroupEgressResponse"},
 		RequestId: reqId,
 	}
 }
 
-func (srv *Server) revokeSecurityGroupIngress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+func (srv *Server) revokeSecurityGroupEgress(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
+	g := srv.formEgressGroup(req)
+	srv.revoke(g, srv.parsePerms(req, g, true))
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "RevokeSecurityGroupEgressResponse"},
+		RequestId: reqId,
+	}
+}
+
+// formGroup returns the group identified by the GroupId or GroupName
+// parameter.
+func (srv *Server) formGroup(req *http.Request) *securityGroup {
 	g := srv.group(ec2.SecurityGroup{
 		Name: req.Form.Get("GroupName"),
 		Id:   req.Form.Get("GroupId"),
@@ -764,16 +1148,44 @@ func (srv *Server) revokeSecurityGroupIngress(w http.ResponseWriter, req *http.R
 	if g == nil {
 		fatalf(400, "InvalidGroup.NotFound", "group not found")
 	}
-	perms := srv.parsePerms(req)
+	return g
+}
+
+// formEgressGroup returns the VPC group identified by the GroupId
+// parameter. As in EC2, only VPC groups have outbound rules.
+func (srv *Server) formEgressGroup(req *http.Request) *securityGroup {
+	id := req.Form.Get("GroupId")
+	if id == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter groupId")
+	}
+	g := srv.groups[id]
+	if g == nil {
+		fatalf(400, "InvalidGroup.NotFound", "The security group '%s' does not exist", id)
+	}
+	if g.vpc == nil {
+		fatalf(400, "InvalidParameterValue", "Outbound rules are only supported by VPC security groups")
+	}
+	return g
+}
 
-	// Note EC2 does not give an error if asked to revoke an authorization
-	// that does not exist.
+// authorize adds permissions to a group. It fails without changing the
+// group if any of them has already been authorized.
+func (srv *Server) authorize(g *securityGroup, perms []permKey) {
 	for _, p := range perms {
-		delete(g.perms, p)
+		if g.perms[p] {
+			fatalf(400, "InvalidPermission.Duplicate", "Permission has already been authorized on the specified group")
+		}
 	}
-	return &ec2.SimpleResp{
-		XMLName:   xml.Name{"", "RevokeSecurityGroupIngressResponse"},
-		RequestId: reqId,
+	for _, p := range perms {
+		g.perms[p] = true
+	}
+}
+
+// revoke removes permissions from a group. Note EC2 does not give an
+// error if asked to revoke an authorization that does not exist.
+func (srv *Server) revoke(g *securityGroup, perms []permKey) {
+	for _, p := range perms {
+		delete(g.perms, p)
 	}
 }
 
@@ -782,8 +1194,9 @@ var ipPat = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+/[0-9]+$`)
 var ownerIdPat = regexp.MustCompile(`^[0-9]+$`)
 
 // parsePerms returns a slice of permKey values extracted
-// from the permission fields in req.
-func (srv *Server) parsePerms(req *http.Request) []permKey {
+// from the permission fields in req, which apply to the target group and
+// are outbound ones if egress is set.
+func (srv *Server) parsePerms(req *http.Request, target *securityGroup, egress bool) []permKey {
 	// perms maps an index found in the form to its associated
 	// IPPerm. For instance, the form value with key
 	// "IpPermissions.3.FromPort" will be stored in perms[3].FromPort
@@ -822,6 +1235,11 @@ func (srv *Server) parsePerms(req *http.Request) []permKey {
 			switch val {
 			case "tcp", "udp", "icmp":
 				ec2p.Protocol = val
+			case "-1":
+				if target.vpc == nil {
+					fatalf(400, "InvalidPermission.Malformed", "Protocol -1 is only supported by VPC security groups")
+				}
+				ec2p.Protocol = val
 			default:
 				// check it's a well formed number
 				atoi(val)
@@ -887,10 +1305,14 @@ func (srv *Server) parsePerms(req *http.Request) []permKey {
 	// looking up security groups from srv as we do so.
 	var result []permKey
 	for _, p := range perms {
+		if p.Protocol == "-1" {
+			p.FromPort, p.ToPort = 0, 0
+		}
 		if p.FromPort > p.ToPort {
 			fatalf(400, "InvalidParameterValue", "invalid port range")
 		}
 		k := permKey{
+			egress:   egress,
 			protocol: p.Protocol,
 			fromPort: p.FromPort,
 			toPort:   p.ToPort,
@@ -907,7 +1329,7 @@ func (srv *Server) parsePerms(req *http.Request) []permKey {
 				ec2g.Name = g.Name
 			}
 			k.group = srv.group(ec2g)
-			if k.group == nil {
+			if k.group == nil || k.group.vpc != target.vpc {
 				fatalf(400, "InvalidGroup.NotFound", "group %v not found", g)
 			}
 			result = append(result, k)
@@ -921,15 +1343,14 @@ func (srv *Server) parsePerms(req *http.Request) []permKey {
 	return result
 }
 
+// deleteSecurityGroup deletes a security group. The default group of a
+// VPC is deleted along with the VPC only.
 func (srv *Server) deleteSecurityGroup(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
-	g := srv.group(ec2.SecurityGroup{
-		Name: req.Form.Get("GroupName"),
-		Id:   req.Form.Get("GroupId"),
-	})
-	if g == nil {
-		fatalf(400, "InvalidGroup.NotFound", "group not found")
+	g := srv.formGroup(req)
+	if g.vpc != nil && g.name == "default" {
+		fatalf(400, "CannotDelete", "the specified group: \"%s\" name: \"default\" cannot be deleted by a user", g.id)
 	}
 	for _, r := range srv.reservations {
 		for _, h := range r.groups {
@@ -984,6 +1405,20 @@ func atoi(s string) int {
 	return i
 }
 
+// idSlice orders ids with idLess.
+type idSlice []string
+
+func (ids idSlice) Len() int           { return len(ids) }
+func (ids idSlice) Less(i, j int) bool { return idLess(ids[i], ids[j]) }
+func (ids idSlice) Swap(i, j int)      { ids[i], ids[j] = ids[j], ids[i] }
+
+// sortedIds sorts ids, typically the keys of a map, with idLess and
+// returns them.
+func sortedIds(ids []string) []string {
+	sort.Sort(idSlice(ids))
+	return ids
+}
+
 func fatalf(statusCode int, code string, f string, a ...interface{}) {
 	panic(&ec2.Error{
 		StatusCode: statusCode,
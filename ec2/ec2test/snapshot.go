@@ -0,0 +1,802 @@
+package ec2test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot holds the state of a Server: its instances, reservations,
+// security groups, images, EBS snapshots, volumes, key pairs, Elastic
+// IP addresses and VPCs with their subnets, internet gateways and route
+// tables. Snapshots can be serialized as JSON, so that fixtures can be checked in and restored with
+// Server.Restore.
+type Snapshot struct {
+	Instances      []InstanceSnapshot      `json:"instances"`
+	Reservations   []ReservationSnapshot   `json:"reservations"`
+	SecurityGroups []SecurityGroupSnapshot `json:"securityGroups"`
+	Images         []ec2.Image             `json:"images,omitempty"`
+	EBSSnapshots   []EBSSnapshot           `json:"ebsSnapshots,omitempty"`
+	Volumes        []VolumeSnapshot        `json:"volumes,omitempty"`
+	KeyPairs       []ec2.KeyPair           `json:"keyPairs,omitempty"`
+	Addresses      []AddressSnapshot       `json:"addresses,omitempty"`
+	VPCs           []VPCSnapshot           `json:"vpcs,omitempty"`
+	Subnets        []SubnetSnapshot        `json:"subnets,omitempty"`
+	Gateways       []GatewaySnapshot       `json:"internetGateways,omitempty"`
+	RouteTables    []RouteTableSnapshot    `json:"routeTables,omitempty"`
+
+	// InitialInstanceState is the state new instances are started in.
+	InitialInstanceState ec2.InstanceState `json:"initialInstanceState"`
+
+	// NextInstanceId and the like are the numbers the server uses for the
+	// next ids it allocates. Restore never reuses an id present in the
+	// snapshot, so they may be left zero.
+	NextInstanceId    int `json:"nextInstanceId,omitempty"`
+	NextReservationId int `json:"nextReservationId,omitempty"`
+	NextGroupId       int `json:"nextGroupId,omitempty"`
+	NextImageId       int `json:"nextImageId,omitempty"`
+	NextSnapshotId    int `json:"nextSnapshotId,omitempty"`
+	NextVolumeId      int `json:"nextVolumeId,omitempty"`
+	NextAllocationId  int `json:"nextAllocationId,omitempty"`
+	NextAssociationId int `json:"nextAssociationId,omitempty"`
+	NextVPCId         int `json:"nextVpcId,omitempty"`
+	NextSubnetId      int `json:"nextSubnetId,omitempty"`
+	NextGatewayId     int `json:"nextInternetGatewayId,omitempty"`
+	NextRouteTableId  int `json:"nextRouteTableId,omitempty"`
+
+	// NextRouteTableAssociationId is the number used for the next route
+	// table association id.
+	NextRouteTableAssociationId int `json:"nextRouteTableAssociationId,omitempty"`
+}
+
+// InstanceSnapshot holds the state of an instance.
+type InstanceSnapshot struct {
+	Id            string            `json:"id"`
+	ReservationId string            `json:"reservationId"`
+	ImageId       string            `json:"imageId"`
+	InstanceType  string            `json:"instanceType"`
+	AvailZone     string            `json:"availZone"`
+	State         ec2.InstanceState `json:"state"`
+	UserData      []byte            `json:"userData,omitempty"`
+	KeyName       string            `json:"keyName,omitempty"`
+	SubnetId      string            `json:"subnetId,omitempty"`
+
+	// RootDeviceType is "ebs", the default, or "instance-store".
+	RootDeviceType string            `json:"rootDeviceType,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+
+	// StateChanged is the server time at which the instance entered its
+	// state. If zero, it is the time of the restore.
+	StateChanged time.Time `json:"stateChanged,omitempty"`
+}
+
+// EBSSnapshot holds the state of an EBS snapshot.
+type EBSSnapshot struct {
+	Id          string    `json:"id"`
+	VolumeId    string    `json:"volumeId"`
+	VolumeSize  int       `json:"volumeSize"`
+	Description string    `json:"description,omitempty"`
+	StartTime   time.Time `json:"startTime"`
+}
+
+// VolumeSnapshot holds the state of an EBS volume.
+type VolumeSnapshot struct {
+	Id         string    `json:"id"`
+	Size       int       `json:"size"`
+	SnapshotId string    `json:"snapshotId,omitempty"`
+	AvailZone  string    `json:"availZone"`
+	VolumeType string    `json:"volumeType"`
+	IOPS       int       `json:"iops,omitempty"`
+	CreateTime time.Time `json:"createTime"`
+
+	// Attachment holds the attachment of the volume, if any.
+	Attachment *AttachmentSnapshot `json:"attachment,omitempty"`
+}
+
+// AttachmentSnapshot holds the attachment of a volume to an instance.
+type AttachmentSnapshot struct {
+	InstanceId string    `json:"instanceId"`
+	Device     string    `json:"device"`
+	AttachTime time.Time `json:"attachTime"`
+
+	// DetachTime is the time the volume started detaching, or zero.
+	DetachTime time.Time `json:"detachTime,omitempty"`
+}
+
+// AddressSnapshot holds the state of an Elastic IP address.
+type AddressSnapshot struct {
+	PublicIp      string `json:"publicIp"`
+	Domain        string `json:"domain"`
+	AllocationId  string `json:"allocationId,omitempty"`
+	InstanceId    string `json:"instanceId,omitempty"`
+	AssociationId string `json:"associationId,omitempty"`
+}
+
+// VPCSnapshot holds the state of a VPC.
+type VPCSnapshot struct {
+	Id              string `json:"id"`
+	CidrBlock       string `json:"cidrBlock"`
+	InstanceTenancy string `json:"instanceTenancy"`
+}
+
+// SubnetSnapshot holds the state of a VPC subnet.
+type SubnetSnapshot struct {
+	Id        string `json:"id"`
+	VpcId     string `json:"vpcId"`
+	CidrBlock string `json:"cidrBlock"`
+	AvailZone string `json:"availZone"`
+}
+
+// GatewaySnapshot holds the state of an internet gateway.
+type GatewaySnapshot struct {
+	Id string `json:"id"`
+
+	// VpcId holds the VPC the gateway is attached to, if any.
+	VpcId string `json:"vpcId,omitempty"`
+}
+
+// RouteTableSnapshot holds the state of a route table. Every VPC has
+// exactly one main route table, which has a main association id.
+type RouteTableSnapshot struct {
+	Id                string                          `json:"id"`
+	VpcId             string                          `json:"vpcId"`
+	MainAssociationId string                          `json:"mainAssociationId,omitempty"`
+	Routes            []RouteSnapshot                 `json:"routes,omitempty"`
+	Associations      []RouteTableAssociationSnapshot `json:"associations,omitempty"`
+}
+
+// RouteSnapshot holds a route of a route table. Its target need not
+// exist, in which case the route is a blackhole.
+type RouteSnapshot struct {
+	DestinationCidrBlock string `json:"destinationCidrBlock"`
+	GatewayId            string `json:"gatewayId,omitempty"`
+	InstanceId           string `json:"instanceId,omitempty"`
+	Origin               string `json:"origin"`
+}
+
+// RouteTableAssociationSnapshot holds the association of a route table
+// with a subnet.
+type RouteTableAssociationSnapshot struct {
+	Id       string `json:"id"`
+	SubnetId string `json:"subnetId"`
+}
+
+// ReservationSnapshot holds the state of a reservation.
+type ReservationSnapshot struct {
+	Id       string   `json:"id"`
+	GroupIds []string `json:"groupIds,omitempty"`
+}
+
+// SecurityGroupSnapshot holds the state of a security group.
+type SecurityGroupSnapshot struct {
+	Id          string               `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	VpcId       string               `json:"vpcId,omitempty"`
+	Permissions []PermissionSnapshot `json:"permissions,omitempty"`
+}
+
+// PermissionSnapshot holds a permission granted by a security group to
+// either another group or an IP address range. Outbound permissions of
+// VPC groups have Egress set, and their source is the destination of the
+// traffic.
+type PermissionSnapshot struct {
+	Egress        bool   `json:"egress,omitempty"`
+	Protocol      string `json:"protocol"`
+	FromPort      int    `json:"fromPort"`
+	ToPort        int    `json:"toPort"`
+	SourceGroupId string `json:"sourceGroupId,omitempty"`
+	SourceIP      string `json:"sourceIP,omitempty"`
+}
+
+// ParseSnapshot parses a snapshot serialized as JSON.
+func ParseSnapshot(data []byte) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("cannot parse ec2test snapshot: %v", err)
+	}
+	return &snap, nil
+}
+
+// JSON returns the snapshot serialized as indented JSON.
+func (snap *Snapshot) JSON() ([]byte, error) {
+	return json.MarshalIndent(snap, "", "\t")
+}
+
+// Snapshot returns the current state of the server. The recorded actions
+// are not part of it. Entries are sorted by id, so that snapshots of the
+// same state are equal.
+func (srv *Server) Snapshot() *Snapshot {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.update()
+
+	snap := &Snapshot{
+		InitialInstanceState: srv.initialInstanceState,
+		NextInstanceId:       int(srv.maxId),
+		NextReservationId:    int(srv.reservationId),
+		NextGroupId:          int(srv.groupId),
+		NextImageId:          int(srv.imageId),
+		NextSnapshotId:       int(srv.snapshotId),
+		NextVolumeId:         int(srv.volumeId),
+		NextAllocationId:     int(srv.allocationId),
+		NextAssociationId:    int(srv.associationId),
+		NextVPCId:            int(srv.vpcId),
+		NextSubnetId:         int(srv.subnetId),
+		NextGatewayId:        int(srv.internetGatewayId),
+		NextRouteTableId:     int(srv.routeTableId),
+
+		NextRouteTableAssociationId: int(srv.routeTableAssocId),
+	}
+	for _, id := range srv.imageIds() {
+		img := srv.images[id].Image
+		img.ProductCodes = append([]string(nil), img.ProductCodes...)
+		img.BlockDevices = append([]ec2.BlockDeviceMapping(nil), img.BlockDevices...)
+		snap.Images = append(snap.Images, img)
+	}
+	for _, id := range srv.snapshotIds() {
+		s := srv.snapshots[id]
+		snap.EBSSnapshots = append(snap.EBSSnapshots, EBSSnapshot{
+			Id:          s.id,
+			VolumeId:    s.volumeId,
+			VolumeSize:  s.volumeSize,
+			Description: s.description,
+			StartTime:   s.startTime.UTC().Round(0),
+		})
+	}
+	for _, id := range srv.volumeIds() {
+		vol := srv.volumes[id]
+		vs := VolumeSnapshot{
+			Id:         vol.id,
+			Size:       vol.size,
+			SnapshotId: vol.snapshotId,
+			AvailZone:  vol.availZone,
+			VolumeType: vol.volumeType,
+			IOPS:       vol.iops,
+			CreateTime: vol.createTime.UTC().Round(0),
+		}
+		if a := vol.attachment; a != nil {
+			vs.Attachment = &AttachmentSnapshot{
+				InstanceId: a.instance.id,
+				Device:     a.device,
+				AttachTime: a.attachTime.UTC().Round(0),
+			}
+			if !a.detachTime.IsZero() {
+				vs.Attachment.DetachTime = a.detachTime.UTC().Round(0)
+			}
+		}
+		snap.Volumes = append(snap.Volumes, vs)
+	}
+	for _, name := range srv.keyPairNames() {
+		snap.KeyPairs = append(snap.KeyPairs, srv.keyPairs[name])
+	}
+	for _, ip := range srv.publicIps() {
+		addr := srv.addresses[ip]
+		as := AddressSnapshot{
+			PublicIp:      addr.publicIp,
+			Domain:        addr.domain,
+			AllocationId:  addr.allocationId,
+			AssociationId: addr.associationId,
+		}
+		if addr.instance != nil {
+			as.InstanceId = addr.instance.id
+		}
+		snap.Addresses = append(snap.Addresses, as)
+	}
+	for _, id := range srv.vpcIds() {
+		v := srv.vpcs[id]
+		snap.VPCs = append(snap.VPCs, VPCSnapshot{
+			Id:              v.id,
+			CidrBlock:       v.cidr.String(),
+			InstanceTenancy: v.tenancy,
+		})
+	}
+	for _, id := range srv.subnetIds() {
+		s := srv.subnets[id]
+		snap.Subnets = append(snap.Subnets, SubnetSnapshot{
+			Id:        s.id,
+			VpcId:     s.vpc.id,
+			CidrBlock: s.cidr.String(),
+			AvailZone: s.availZone,
+		})
+	}
+	for _, id := range srv.internetGatewayIds() {
+		igw := srv.internetGateways[id]
+		gs := GatewaySnapshot{Id: igw.id}
+		if igw.vpc != nil {
+			gs.VpcId = igw.vpc.id
+		}
+		snap.Gateways = append(snap.Gateways, gs)
+	}
+	for _, id := range srv.routeTableIds() {
+		rt := srv.routeTables[id]
+		rts := RouteTableSnapshot{
+			Id:                rt.id,
+			VpcId:             rt.vpc.id,
+			MainAssociationId: rt.mainAssocId,
+		}
+		for _, r := range rt.routes {
+			rts.Routes = append(rts.Routes, RouteSnapshot{
+				DestinationCidrBlock: r.dest.String(),
+				GatewayId:            r.gatewayId,
+				InstanceId:           r.instanceId,
+				Origin:               r.origin,
+			})
+		}
+		for _, id := range rt.associationIds() {
+			rts.Associations = append(rts.Associations, RouteTableAssociationSnapshot{
+				Id:       id,
+				SubnetId: rt.associations[id].id,
+			})
+		}
+		snap.RouteTables = append(snap.RouteTables, rts)
+	}
+	for _, inst := range srv.instances {
+		is := InstanceSnapshot{
+			Id:            inst.id,
+			ReservationId: inst.reservation.id,
+			ImageId:       inst.imageId,
+			InstanceType:  inst.instType,
+			AvailZone:     inst.availZone,
+			State:         inst.state,
+			KeyName:       inst.keyName,
+			StateChanged:  inst.stateChanged.UTC().Round(0),
+		}
+		if inst.UserData != nil {
+			is.UserData = append([]byte(nil), inst.UserData...)
+		}
+		if inst.rootDevice != "ebs" {
+			is.RootDeviceType = inst.rootDevice
+		}
+		if inst.subnet != nil {
+			is.SubnetId = inst.subnet.id
+		}
+		for k, v := range inst.tags {
+			if is.Tags == nil {
+				is.Tags = make(map[string]string)
+			}
+			is.Tags[k] = v
+		}
+		snap.Instances = append(snap.Instances, is)
+	}
+	sort.Slice(snap.Instances, func(i, j int) bool {
+		return idLess(snap.Instances[i].Id, snap.Instances[j].Id)
+	})
+	for _, r := range srv.reservations {
+		rs := ReservationSnapshot{Id: r.id}
+		for _, g := range r.groups {
+			rs.GroupIds = append(rs.GroupIds, g.id)
+		}
+		snap.Reservations = append(snap.Reservations, rs)
+	}
+	sort.Slice(snap.Reservations, func(i, j int) bool {
+		return idLess(snap.Reservations[i].Id, snap.Reservations[j].Id)
+	})
+	for _, g := range srv.groups {
+		gs := SecurityGroupSnapshot{
+			Id:          g.id,
+			Name:        g.name,
+			Description: g.description,
+		}
+		if g.vpc != nil {
+			gs.VpcId = g.vpc.id
+		}
+		for k := range g.perms {
+			ps := PermissionSnapshot{
+				Egress:   k.egress,
+				Protocol: k.protocol,
+				FromPort: k.fromPort,
+				ToPort:   k.toPort,
+				SourceIP: k.ipAddr,
+			}
+			if k.group != nil {
+				ps.SourceGroupId = k.group.id
+			}
+			gs.Permissions = append(gs.Permissions, ps)
+		}
+		sort.Slice(gs.Permissions, func(i, j int) bool {
+			return permLess(gs.Permissions[i], gs.Permissions[j])
+		})
+		snap.SecurityGroups = append(snap.SecurityGroups, gs)
+	}
+	sort.Slice(snap.SecurityGroups, func(i, j int) bool {
+		return idLess(snap.SecurityGroups[i].Id, snap.SecurityGroups[j].Id)
+	})
+	return snap
+}
+
+// Restore replaces the state of the server with the given snapshot,
+// including the default security group. It returns an error, leaving the
+// server untouched, if the snapshot refers to groups, reservations,
+// instances, VPCs or subnets it does not hold or holds an id more than
+// once.
+func (srv *Server) Restore(snap *Snapshot) error {
+	vpcs := make(map[string]*vpc)
+	for _, vs := range snap.VPCs {
+		if vpcs[vs.Id] != nil {
+			return fmt.Errorf("duplicate VPC id %q", vs.Id)
+		}
+		cidr, err := parseCIDR(vs.CidrBlock)
+		if err != nil {
+			return fmt.Errorf("VPC %q: %v", vs.Id, err)
+		}
+		vpcs[vs.Id] = &vpc{
+			id:      vs.Id,
+			cidr:    cidr,
+			tenancy: vs.InstanceTenancy,
+		}
+	}
+	subnets := make(map[string]*subnet)
+	for _, ss := range snap.Subnets {
+		if subnets[ss.Id] != nil {
+			return fmt.Errorf("duplicate subnet id %q", ss.Id)
+		}
+		v := vpcs[ss.VpcId]
+		if v == nil {
+			return fmt.Errorf("subnet %q refers to unknown VPC %q", ss.Id, ss.VpcId)
+		}
+		cidr, err := parseCIDR(ss.CidrBlock)
+		if err != nil {
+			return fmt.Errorf("subnet %q: %v", ss.Id, err)
+		}
+		subnets[ss.Id] = &subnet{
+			id:        ss.Id,
+			vpc:       v,
+			cidr:      cidr,
+			availZone: ss.AvailZone,
+		}
+	}
+	gateways := make(map[string]*internetGateway)
+	for _, gs := range snap.Gateways {
+		if gateways[gs.Id] != nil {
+			return fmt.Errorf("duplicate internet gateway id %q", gs.Id)
+		}
+		igw := &internetGateway{id: gs.Id}
+		if gs.VpcId != "" {
+			if igw.vpc = vpcs[gs.VpcId]; igw.vpc == nil {
+				return fmt.Errorf("internet gateway %q refers to unknown VPC %q", gs.Id, gs.VpcId)
+			}
+		}
+		gateways[gs.Id] = igw
+	}
+	routeTables := make(map[string]*routeTable)
+	mainTables := make(map[*vpc]bool)
+	for _, rts := range snap.RouteTables {
+		if routeTables[rts.Id] != nil {
+			return fmt.Errorf("duplicate route table id %q", rts.Id)
+		}
+		rt := &routeTable{
+			id:           rts.Id,
+			vpc:          vpcs[rts.VpcId],
+			mainAssocId:  rts.MainAssociationId,
+			associations: make(map[string]*subnet),
+		}
+		if rt.vpc == nil {
+			return fmt.Errorf("route table %q refers to unknown VPC %q", rts.Id, rts.VpcId)
+		}
+		if rt.mainAssocId != "" {
+			if mainTables[rt.vpc] {
+				return fmt.Errorf("VPC %q has more than one main route table", rts.VpcId)
+			}
+			mainTables[rt.vpc] = true
+		}
+		for _, r := range rts.Routes {
+			dest, err := parseCIDR(r.DestinationCidrBlock)
+			if err != nil {
+				return fmt.Errorf("route table %q: %v", rts.Id, err)
+			}
+			rt.routes = append(rt.routes, &route{
+				dest:       dest,
+				gatewayId:  r.GatewayId,
+				instanceId: r.InstanceId,
+				origin:     r.Origin,
+			})
+		}
+		for _, as := range rts.Associations {
+			s := subnets[as.SubnetId]
+			if s == nil {
+				return fmt.Errorf("route table %q refers to unknown subnet %q", rts.Id, as.SubnetId)
+			}
+			rt.associations[as.Id] = s
+		}
+		routeTables[rts.Id] = rt
+	}
+	for id, v := range vpcs {
+		if !mainTables[v] {
+			return fmt.Errorf("VPC %q has no main route table", id)
+		}
+	}
+	groups := make(map[string]*securityGroup)
+	for _, gs := range snap.SecurityGroups {
+		if groups[gs.Id] != nil {
+			return fmt.Errorf("duplicate security group id %q", gs.Id)
+		}
+		g := &securityGroup{
+			id:          gs.Id,
+			name:        gs.Name,
+			description: gs.Description,
+			perms:       make(map[permKey]bool),
+		}
+		if gs.VpcId != "" {
+			if g.vpc = vpcs[gs.VpcId]; g.vpc == nil {
+				return fmt.Errorf("security group %q refers to unknown VPC %q", gs.Id, gs.VpcId)
+			}
+		}
+		groups[gs.Id] = g
+	}
+	for _, gs := range snap.SecurityGroups {
+		g := groups[gs.Id]
+		for _, ps := range gs.Permissions {
+			k := permKey{
+				egress:   ps.Egress,
+				protocol: ps.Protocol,
+				fromPort: ps.FromPort,
+				toPort:   ps.ToPort,
+				ipAddr:   ps.SourceIP,
+			}
+			if ps.SourceGroupId != "" {
+				if k.group = groups[ps.SourceGroupId]; k.group == nil {
+					return fmt.Errorf("security group %q refers to unknown group %q", gs.Id, ps.SourceGroupId)
+				}
+			}
+			g.perms[k] = true
+		}
+	}
+	reservations := make(map[string]*reservation)
+	for _, rs := range snap.Reservations {
+		if reservations[rs.Id] != nil {
+			return fmt.Errorf("duplicate reservation id %q", rs.Id)
+		}
+		r := &reservation{
+			id:        rs.Id,
+			instances: make(map[string]*Instance),
+		}
+		for _, id := range rs.GroupIds {
+			g := groups[id]
+			if g == nil {
+				return fmt.Errorf("reservation %q refers to unknown group %q", rs.Id, id)
+			}
+			r.groups = append(r.groups, g)
+		}
+		reservations[rs.Id] = r
+	}
+	instances := make(map[string]*Instance)
+	for _, is := range snap.Instances {
+		if instances[is.Id] != nil {
+			return fmt.Errorf("duplicate instance id %q", is.Id)
+		}
+		r := reservations[is.ReservationId]
+		if r == nil {
+			return fmt.Errorf("instance %q refers to unknown reservation %q", is.Id, is.ReservationId)
+		}
+		inst := &Instance{
+			id:           is.Id,
+			imageId:      is.ImageId,
+			instType:     is.InstanceType,
+			availZone:    is.AvailZone,
+			state:        is.State,
+			stateChanged: is.StateChanged,
+			keyName:      is.KeyName,
+			rootDevice:   is.RootDeviceType,
+			reservation:  r,
+		}
+		if inst.rootDevice == "" {
+			inst.rootDevice = "ebs"
+		}
+		if is.SubnetId != "" {
+			if inst.subnet = subnets[is.SubnetId]; inst.subnet == nil {
+				return fmt.Errorf("instance %q refers to unknown subnet %q", is.Id, is.SubnetId)
+			}
+		}
+		if is.UserData != nil {
+			inst.UserData = append([]byte(nil), is.UserData...)
+		}
+		for k, v := range is.Tags {
+			if inst.tags == nil {
+				inst.tags = make(map[string]string)
+			}
+			inst.tags[k] = v
+		}
+		instances[is.Id] = inst
+		r.instances[is.Id] = inst
+	}
+	images := make(map[string]*image)
+	for _, img := range snap.Images {
+		if images[img.Id] != nil {
+			return fmt.Errorf("duplicate image id %q", img.Id)
+		}
+		img.ProductCodes = append([]string(nil), img.ProductCodes...)
+		img.BlockDevices = append([]ec2.BlockDeviceMapping(nil), img.BlockDevices...)
+		images[img.Id] = &image{img}
+	}
+	snapshots := make(map[string]*ebsSnapshot)
+	for _, s := range snap.EBSSnapshots {
+		if snapshots[s.Id] != nil {
+			return fmt.Errorf("duplicate EBS snapshot id %q", s.Id)
+		}
+		snapshots[s.Id] = &ebsSnapshot{
+			id:          s.Id,
+			volumeId:    s.VolumeId,
+			volumeSize:  s.VolumeSize,
+			description: s.Description,
+			startTime:   s.StartTime,
+		}
+	}
+	volumes := make(map[string]*volume)
+	for _, vs := range snap.Volumes {
+		if volumes[vs.Id] != nil {
+			return fmt.Errorf("duplicate volume id %q", vs.Id)
+		}
+		vol := &volume{
+			id:         vs.Id,
+			size:       vs.Size,
+			snapshotId: vs.SnapshotId,
+			availZone:  vs.AvailZone,
+			volumeType: vs.VolumeType,
+			iops:       vs.IOPS,
+			createTime: vs.CreateTime,
+		}
+		if as := vs.Attachment; as != nil {
+			inst := instances[as.InstanceId]
+			if inst == nil {
+				return fmt.Errorf("volume %q refers to unknown instance %q", vs.Id, as.InstanceId)
+			}
+			vol.attachment = &attachment{
+				instance:   inst,
+				device:     as.Device,
+				attachTime: as.AttachTime,
+				detachTime: as.DetachTime,
+			}
+		}
+		volumes[vs.Id] = vol
+	}
+	keyPairs := make(map[string]ec2.KeyPair)
+	for _, kp := range snap.KeyPairs {
+		if _, ok := keyPairs[kp.Name]; ok {
+			return fmt.Errorf("duplicate key pair name %q", kp.Name)
+		}
+		keyPairs[kp.Name] = kp
+	}
+	addresses := make(map[string]*address)
+	for _, as := range snap.Addresses {
+		if addresses[as.PublicIp] != nil {
+			return fmt.Errorf("duplicate address %q", as.PublicIp)
+		}
+		addr := &address{
+			publicIp:      as.PublicIp,
+			domain:        as.Domain,
+			allocationId:  as.AllocationId,
+			associationId: as.AssociationId,
+		}
+		if as.InstanceId != "" {
+			if addr.instance = instances[as.InstanceId]; addr.instance == nil {
+				return fmt.Errorf("address %q refers to unknown instance %q", as.PublicIp, as.InstanceId)
+			}
+			if addr.instance.publicIp != "" {
+				return fmt.Errorf("instance %q has more than one address", as.InstanceId)
+			}
+			addr.instance.publicIp = addr.publicIp
+		}
+		addresses[as.PublicIp] = addr
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.instances = instances
+	srv.reservations = reservations
+	srv.groups = groups
+	srv.images = images
+	srv.snapshots = snapshots
+	srv.volumes = volumes
+	srv.keyPairs = keyPairs
+	srv.addresses = addresses
+	srv.vpcs = vpcs
+	srv.subnets = subnets
+	srv.internetGateways = gateways
+	srv.routeTables = routeTables
+	srv.initialInstanceState = snap.InitialInstanceState
+	srv.maxId = counter(snap.NextInstanceId)
+	srv.reservationId = counter(snap.NextReservationId)
+	srv.groupId = counter(snap.NextGroupId)
+	srv.imageId = counter(snap.NextImageId)
+	srv.snapshotId = counter(snap.NextSnapshotId)
+	srv.volumeId = counter(snap.NextVolumeId)
+	srv.allocationId = counter(snap.NextAllocationId)
+	srv.associationId = counter(snap.NextAssociationId)
+	srv.vpcId = counter(snap.NextVPCId)
+	srv.subnetId = counter(snap.NextSubnetId)
+	srv.internetGatewayId = counter(snap.NextGatewayId)
+	srv.routeTableId = counter(snap.NextRouteTableId)
+	srv.routeTableAssocId = counter(snap.NextRouteTableAssociationId)
+	for id, inst := range instances {
+		srv.maxId.skip(id, "i-")
+		if inst.stateChanged.IsZero() {
+			inst.stateChanged = srv.now()
+		}
+	}
+	for id := range reservations {
+		srv.reservationId.skip(id, "r-")
+	}
+	for id := range groups {
+		srv.groupId.skip(id, "sg-")
+	}
+	for id := range images {
+		srv.imageId.skip(id, "ami-")
+	}
+	for id, s := range snapshots {
+		srv.snapshotId.skip(id, "snap-")
+		if s.startTime.IsZero() {
+			s.startTime = srv.now()
+		}
+	}
+	for _, addr := range addresses {
+		srv.allocationId.skip(addr.allocationId, "eipalloc-")
+		srv.associationId.skip(addr.associationId, "eipassoc-")
+	}
+	for id := range vpcs {
+		srv.vpcId.skip(id, "vpc-")
+	}
+	for id := range subnets {
+		srv.subnetId.skip(id, "subnet-")
+	}
+	for id := range gateways {
+		srv.internetGatewayId.skip(id, "igw-")
+	}
+	for id, rt := range routeTables {
+		srv.routeTableId.skip(id, "rtb-")
+		srv.routeTableAssocId.skip(rt.mainAssocId, "rtbassoc-")
+		for id := range rt.associations {
+			srv.routeTableAssocId.skip(id, "rtbassoc-")
+		}
+	}
+	for id, vol := range volumes {
+		srv.volumeId.skip(id, "vol-")
+		if vol.createTime.IsZero() {
+			vol.createTime = srv.now()
+		}
+		if a := vol.attachment; a != nil && a.attachTime.IsZero() {
+			a.attachTime = srv.now()
+		}
+	}
+	return nil
+}
+
+// skip makes sure that the counter never allocates the given id, made of
+// prefix followed by a number.
+func (c *counter) skip(id, prefix string) {
+	if !strings.HasPrefix(id, prefix) {
+		return
+	}
+	n, err := strconv.Atoi(id[len(prefix):])
+	if err == nil && n >= int(*c) {
+		*c = counter(n + 1)
+	}
+}
+
+// idLess orders ids such as "i-2" and "i-10" numerically.
+func idLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func permLess(a, b PermissionSnapshot) bool {
+	switch {
+	case a.Egress != b.Egress:
+		return !a.Egress
+	case a.Protocol != b.Protocol:
+		return a.Protocol < b.Protocol
+	case a.FromPort != b.FromPort:
+		return a.FromPort < b.FromPort
+	case a.ToPort != b.ToPort:
+		return a.ToPort < b.ToPort
+	case a.SourceGroupId != b.SourceGroupId:
+		return idLess(a.SourceGroupId, b.SourceGroupId)
+	}
+	return a.SourceIP < b.SourceIP
+}
@@ -0,0 +1,348 @@
+package ec2test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// volume holds a simulated EBS volume. Its status is derived from the
+// time it was created and from its attachment.
+type volume struct {
+	id         string
+	size       int
+	snapshotId string
+	availZone  string
+	volumeType string
+	iops       int
+	createTime time.Time
+	attachment *attachment
+}
+
+// attachment holds the attachment of a volume to an instance.
+type attachment struct {
+	instance   *Instance
+	device     string
+	attachTime time.Time
+	detachTime time.Time // zero unless detaching
+}
+
+// volumeStatus returns the status of the volume: "creating" for the
+// Volume duration of the server lifecycle, then "in-use" while it is
+// attached and "available" otherwise.
+func (srv *Server) volumeStatus(vol *volume) string {
+	switch {
+	case srv.now().Before(vol.createTime.Add(srv.lifecycle.Volume)):
+		return "creating"
+	case vol.attachment != nil:
+		return "in-use"
+	}
+	return "available"
+}
+
+// attachmentStatus returns the status of the attachment, which moves from
+// "attaching" to "attached" after the Attach duration of the server
+// lifecycle and is "detaching" once detached until it goes away.
+func (srv *Server) attachmentStatus(a *attachment) string {
+	switch {
+	case !a.detachTime.IsZero():
+		return "detaching"
+	case srv.now().Before(a.attachTime.Add(srv.lifecycle.Attach)):
+		return "attaching"
+	}
+	return "attached"
+}
+
+// updateVolumes removes the attachments that are done detaching and those
+// of terminated instances.
+func (srv *Server) updateVolumes() {
+	now := srv.now()
+	for _, vol := range srv.volumes {
+		a := vol.attachment
+		if a == nil {
+			continue
+		}
+		if a.instance.state == Terminated || !a.detachTime.IsZero() && !now.Before(a.detachTime.Add(srv.lifecycle.Attach)) {
+			vol.attachment = nil
+		}
+	}
+}
+
+func (srv *Server) createVolume(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	vol := &volume{
+		availZone:  req.Form.Get("AvailabilityZone"),
+		snapshotId: req.Form.Get("SnapshotId"),
+		volumeType: req.Form.Get("VolumeType"),
+		createTime: srv.now(),
+	}
+	if vol.availZone == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter availabilityZone")
+	}
+	if s := req.Form.Get("Size"); s != "" {
+		vol.size = atoi(s)
+	}
+	if s := req.Form.Get("Iops"); s != "" {
+		vol.iops = atoi(s)
+	}
+	if vol.snapshotId != "" {
+		snap := srv.snapshots[vol.snapshotId]
+		if snap == nil {
+			fatalf(400, "InvalidSnapshot.NotFound", "The snapshot '%s' does not exist.", vol.snapshotId)
+		}
+		if vol.size == 0 {
+			vol.size = snap.volumeSize
+		} else if vol.size < snap.volumeSize {
+			fatalf(400, "InvalidParameterValue", "Volume of size %dGiB is smaller than snapshot '%s', expect size >= %dGiB", vol.size, snap.id, snap.volumeSize)
+		}
+	} else if vol.size == 0 {
+		fatalf(400, "MissingParameter", "The request must contain the parameter size or snapshotId")
+	}
+	if vol.volumeType == "" {
+		vol.volumeType = "standard"
+	}
+	minSize := 1
+	switch vol.volumeType {
+	case "standard", "gp2":
+		if vol.iops != 0 {
+			fatalf(400, "InvalidParameterCombination", "The parameter iops is not supported for %s volumes.", vol.volumeType)
+		}
+	case "io1":
+		minSize = 4
+		if vol.iops == 0 {
+			fatalf(400, "MissingParameter", "The request must contain the parameter iops")
+		}
+	default:
+		fatalf(400, "InvalidParameterValue", "Value (%s) for parameter volumeType is invalid. Valid values are standard, io1, gp2.", vol.volumeType)
+	}
+	if vol.size < minSize || vol.size > 1024 {
+		fatalf(400, "InvalidParameterValue", "Volume of %dGiB is outside the range of %d to 1024 GiB for %s volumes.", vol.size, minSize, vol.volumeType)
+	}
+	if vol.iops != 0 && (vol.iops < 100 || vol.iops > 30*vol.size) {
+		fatalf(400, "InvalidParameterValue", "Iops of %d is outside the range of 100 to %d for a volume of %dGiB.", vol.iops, 30*vol.size, vol.size)
+	}
+	vol.id = fmt.Sprintf("vol-%d", srv.volumeId.next())
+	srv.volumes[vol.id] = vol
+	return &ec2.CreateVolumeResp{
+		RequestId: reqId,
+		Volume:    srv.ec2Volume(vol),
+	}
+}
+
+// volume returns the volume with the given id.
+func (srv *Server) volume(id string) *volume {
+	if id == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter volumeId")
+	}
+	vol := srv.volumes[id]
+	if vol == nil {
+		fatalf(400, "InvalidVolume.NotFound", "The volume '%s' does not exist.", id)
+	}
+	return vol
+}
+
+// deleteVolume deletes a volume at once. Attached volumes cannot be
+// deleted.
+func (srv *Server) deleteVolume(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	vol := srv.volume(req.Form.Get("VolumeId"))
+	if vol.attachment != nil {
+		fatalf(400, "VolumeInUse", "Volume %s is currently attached to %s", vol.id, vol.attachment.instance.id)
+	}
+	delete(srv.volumes, vol.id)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DeleteVolumeResponse"},
+		RequestId: reqId,
+	}
+}
+
+// attachVolume attaches an available volume to a running or stopped
+// instance in the same availability zone.
+func (srv *Server) attachVolume(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	vol := srv.volume(req.Form.Get("VolumeId"))
+	instId := req.Form.Get("InstanceId")
+	if instId == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter instanceId")
+	}
+	inst := srv.instances[instId]
+	if inst == nil {
+		fatalf(400, "InvalidInstanceID.NotFound", "no such instance id %q", instId)
+	}
+	device := req.Form.Get("Device")
+	if device == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter device")
+	}
+	if !strings.HasPrefix(device, "/dev/") {
+		fatalf(400, "InvalidParameterValue", "Value (%s) for parameter device is invalid. %s is not a valid EBS device name.", device, device)
+	}
+	switch status := srv.volumeStatus(vol); status {
+	case "available":
+	case "in-use":
+		fatalf(400, "VolumeInUse", "%s is already attached to an instance", vol.id)
+	default:
+		fatalf(400, "IncorrectState", "%s is not 'available'.", vol.id)
+	}
+	if inst.state != Running && inst.state != Stopped {
+		fatalf(400, "IncorrectState", "Instance '%s' is not 'running'.", inst.id)
+	}
+	if vol.availZone != inst.availZone {
+		fatalf(400, "InvalidVolume.ZoneMismatch", "The volume '%s' is not in the same availability zone as instance '%s'", vol.id, inst.id)
+	}
+	for _, other := range srv.volumes {
+		if a := other.attachment; a != nil && a.instance == inst && a.device == device {
+			fatalf(400, "InvalidParameterValue", "Attachment point %s is already in use", device)
+		}
+	}
+	vol.attachment = &attachment{
+		instance:   inst,
+		device:     device,
+		attachTime: srv.now(),
+	}
+	return &ec2.VolumeAttachmentResp{
+		RequestId:        reqId,
+		VolumeAttachment: srv.ec2Attachment(vol),
+	}
+}
+
+// detachVolume starts detaching a volume. The InstanceId and Device
+// parameters, if given, must match the attachment.
+func (srv *Server) detachVolume(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	vol := srv.volume(req.Form.Get("VolumeId"))
+	a := vol.attachment
+	if a == nil {
+		fatalf(400, "IncorrectState", "Volume '%s' is in the '%s' state.", vol.id, srv.volumeStatus(vol))
+	}
+	if id := req.Form.Get("InstanceId"); id != "" && id != a.instance.id {
+		fatalf(400, "InvalidAttachment.NotFound", "Volume '%s' is not attached to instance '%s'", vol.id, id)
+	}
+	if device := req.Form.Get("Device"); device != "" && device != a.device {
+		fatalf(400, "InvalidAttachment.NotFound", "Volume '%s' is not attached at '%s'", vol.id, device)
+	}
+	if a.detachTime.IsZero() {
+		a.detachTime = srv.now()
+	}
+	return &ec2.VolumeAttachmentResp{
+		RequestId:        reqId,
+		VolumeAttachment: srv.ec2Attachment(vol),
+	}
+}
+
+func (srv *Server) describeVolumes(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ids := make(map[*volume]bool)
+	for name, vals := range req.Form {
+		if !strings.HasPrefix(name, "VolumeId.") {
+			continue
+		}
+		ids[srv.volume(vals[0])] = true
+	}
+	f := newFilter(req.Form)
+	var resp ec2.VolumesResp
+	resp.RequestId = reqId
+	for _, id := range srv.volumeIds() {
+		vol := srv.volumes[id]
+		if len(ids) > 0 && !ids[vol] {
+			continue
+		}
+		v := volumeInfo(srv.ec2Volume(vol))
+		ok, err := f.ok(&v)
+		if ok {
+			resp.Volumes = append(resp.Volumes, ec2.Volume(v))
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe volumes: %v", err)
+		}
+	}
+	return &resp
+}
+
+// volumeIds returns the ids of the volumes, in order.
+func (srv *Server) volumeIds() []string {
+	var ids []string
+	for id := range srv.volumes {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
+
+// ec2Volume returns the volume as described by EC2.
+func (srv *Server) ec2Volume(vol *volume) ec2.Volume {
+	v := ec2.Volume{
+		Id:         vol.id,
+		Size:       int64(vol.size),
+		SnapshotId: vol.snapshotId,
+		AvailZone:  vol.availZone,
+		Status:     srv.volumeStatus(vol),
+		CreateTime: vol.createTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+		VolumeType: vol.volumeType,
+		IOPS:       int64(vol.iops),
+	}
+	if vol.attachment != nil {
+		v.Attachments = []ec2.VolumeAttachment{srv.ec2Attachment(vol)}
+	}
+	return v
+}
+
+// ec2Attachment returns the attachment of the volume as described by EC2.
+func (srv *Server) ec2Attachment(vol *volume) ec2.VolumeAttachment {
+	a := vol.attachment
+	return ec2.VolumeAttachment{
+		VolumeId:   vol.id,
+		InstanceId: a.instance.id,
+		Device:     a.device,
+		Status:     srv.attachmentStatus(a),
+		AttachTime: a.attachTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+}
+
+// volumeInfo is a volume as described by EC2 that can be filtered.
+type volumeInfo ec2.Volume
+
+func (v *volumeInfo) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "attachment.device":
+		return v.hasAttachment(func(a ec2.VolumeAttachment) bool { return a.Device == value }), nil
+	case "attachment.instance-id":
+		return v.hasAttachment(func(a ec2.VolumeAttachment) bool { return a.InstanceId == value }), nil
+	case "attachment.status":
+		return v.hasAttachment(func(a ec2.VolumeAttachment) bool { return a.Status == value }), nil
+	case "availability-zone":
+		return v.AvailZone == value, nil
+	case "create-time":
+		return v.CreateTime == value, nil
+	case "size":
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return v.Size == size, nil
+	case "snapshot-id":
+		return v.SnapshotId == value, nil
+	case "status":
+		return v.Status == value, nil
+	case "volume-id":
+		return v.Id == value, nil
+	case "volume-type":
+		return v.VolumeType == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
+
+func (v *volumeInfo) hasAttachment(test func(a ec2.VolumeAttachment) bool) bool {
+	for _, a := range v.Attachments {
+		if test(a) {
+			return true
+		}
+	}
+	return false
+}
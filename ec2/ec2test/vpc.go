@@ -0,0 +1,869 @@
+package ec2test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// vpc holds a simulated VPC. Its main route table and default security
+// group are created and deleted along with it.
+type vpc struct {
+	id      string
+	cidr    *net.IPNet
+	tenancy string
+}
+
+// subnet holds a simulated VPC subnet.
+type subnet struct {
+	id        string
+	vpc       *vpc
+	cidr      *net.IPNet
+	availZone string
+}
+
+// internetGateway holds a simulated internet gateway.
+type internetGateway struct {
+	id  string
+	vpc *vpc // nil unless attached
+}
+
+// routeTable holds a simulated route table.
+type routeTable struct {
+	id           string
+	vpc          *vpc
+	mainAssocId  string // association with the VPC, if the table is its main one
+	routes       []*route
+	associations map[string]*subnet // association id -> subnet
+}
+
+// route holds a route of a route table. Its target is referred to by id,
+// so that routes to gateways and instances that went away are kept as
+// blackholes.
+type route struct {
+	dest       *net.IPNet
+	gatewayId  string // "local" for the route of the VPC itself
+	instanceId string
+	origin     string // "CreateRouteTable" or "CreateRoute"
+}
+
+// reservedAddresses is the number of addresses EC2 reserves in every
+// subnet.
+const reservedAddresses = 5
+
+// parseCIDR parses an IPv4 CIDR block, which must not have bits set past
+// its prefix.
+func parseCIDR(s string) (*net.IPNet, error) {
+	ip, cidr, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil || !ip.Equal(cidr.IP) {
+		return nil, fmt.Errorf("invalid CIDR block %q", s)
+	}
+	return cidr, nil
+}
+
+// formCIDR returns the CIDR block held by the given form field, e.g.
+// "CidrBlock".
+func formCIDR(req *http.Request, name string) *net.IPNet {
+	s := req.Form.Get(name)
+	param := strings.ToLower(name[:1]) + name[1:]
+	if s == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter %s", param)
+	}
+	cidr, err := parseCIDR(s)
+	if err != nil {
+		fatalf(400, "InvalidParameterValue", "Value (%s) for parameter %s is invalid. This is not a valid CIDR block.", s, param)
+	}
+	return cidr
+}
+
+// prefixLen returns the prefix length of the CIDR block.
+func prefixLen(cidr *net.IPNet) int {
+	ones, _ := cidr.Mask.Size()
+	return ones
+}
+
+// cidrContains reports whether the outer CIDR block holds all of the inner
+// one.
+func cidrContains(outer, inner *net.IPNet) bool {
+	return prefixLen(inner) >= prefixLen(outer) && outer.Contains(inner.IP)
+}
+
+// cidrOverlap reports whether two CIDR blocks have addresses in common.
+func cidrOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func (srv *Server) createVpc(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	v := &vpc{
+		cidr:    formCIDR(req, "CidrBlock"),
+		tenancy: req.Form.Get("InstanceTenancy"),
+	}
+	if n := prefixLen(v.cidr); n < 16 || n > 28 {
+		fatalf(400, "InvalidVpc.Range", "The CIDR '%s' is invalid.", v.cidr)
+	}
+	switch v.tenancy {
+	case "":
+		v.tenancy = "default"
+	case "default", "dedicated":
+	default:
+		fatalf(400, "InvalidParameterValue", "Value (%s) for parameter instanceTenancy is invalid.", v.tenancy)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	v.id = fmt.Sprintf("vpc-%d", srv.vpcId.next())
+	srv.vpcs[v.id] = v
+	rt := srv.newRouteTable(v)
+	rt.mainAssocId = fmt.Sprintf("rtbassoc-%d", srv.routeTableAssocId.next())
+	g := srv.newSecurityGroup(v, "default", "default VPC security group")
+	g.perms[permKey{protocol: "-1", group: g}] = true
+	return &ec2.CreateVPCResp{
+		RequestId: reqId,
+		VPC:       v.ec2VPC(),
+	}
+}
+
+// vpc returns the VPC with the given id.
+func (srv *Server) vpc(id string) *vpc {
+	if id == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter vpcId")
+	}
+	v := srv.vpcs[id]
+	if v == nil {
+		fatalf(400, "InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", id)
+	}
+	return v
+}
+
+// deleteVpc deletes a VPC, its main route table and its default security
+// group. VPCs with subnets, attached internet gateways, other route tables
+// or other security groups cannot be deleted.
+func (srv *Server) deleteVpc(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	v := srv.vpc(req.Form.Get("VpcId"))
+	var main *routeTable
+	for _, rt := range srv.routeTables {
+		if rt.vpc != v {
+			continue
+		}
+		if rt.mainAssocId == "" {
+			fatalf(400, "DependencyViolation", "The vpc '%s' has dependencies and cannot be deleted.", v.id)
+		}
+		main = rt
+	}
+	for _, s := range srv.subnets {
+		if s.vpc == v {
+			fatalf(400, "DependencyViolation", "The vpc '%s' has dependencies and cannot be deleted.", v.id)
+		}
+	}
+	for _, igw := range srv.internetGateways {
+		if igw.vpc == v {
+			fatalf(400, "DependencyViolation", "The vpc '%s' has dependencies and cannot be deleted.", v.id)
+		}
+	}
+	def := srv.defaultGroup(v)
+	for _, g := range srv.groups {
+		if g.vpc == v && g != def {
+			fatalf(400, "DependencyViolation", "The vpc '%s' has dependencies and cannot be deleted.", v.id)
+		}
+	}
+	if def != nil {
+		delete(srv.groups, def.id)
+	}
+	delete(srv.routeTables, main.id)
+	delete(srv.vpcs, v.id)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DeleteVpcResponse"},
+		RequestId: reqId,
+	}
+}
+
+func (srv *Server) describeVpcs(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ids := make(map[*vpc]bool)
+	for name, vals := range req.Form {
+		if strings.HasPrefix(name, "VpcId.") {
+			ids[srv.vpc(vals[0])] = true
+		}
+	}
+	f := newFilter(req.Form)
+	var resp ec2.VPCsResp
+	resp.RequestId = reqId
+	for _, id := range srv.vpcIds() {
+		v := srv.vpcs[id]
+		if len(ids) > 0 && !ids[v] {
+			continue
+		}
+		info := vpcInfo(v.ec2VPC())
+		ok, err := f.ok(&info)
+		if ok {
+			resp.VPCs = append(resp.VPCs, ec2.VPC(info))
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe VPCs: %v", err)
+		}
+	}
+	return &resp
+}
+
+func (v *vpc) ec2VPC() ec2.VPC {
+	return ec2.VPC{
+		Id:              v.id,
+		State:           "available",
+		CidrBlock:       v.cidr.String(),
+		InstanceTenancy: v.tenancy,
+	}
+}
+
+// vpcInfo is a VPC as described by EC2 that can be filtered.
+type vpcInfo ec2.VPC
+
+func (v *vpcInfo) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "cidr":
+		return v.CidrBlock == value, nil
+	case "isDefault":
+		isDefault, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, err
+		}
+		return v.IsDefault == isDefault, nil
+	case "state":
+		return v.State == value, nil
+	case "vpc-id":
+		return v.Id == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
+
+// createSubnet creates a subnet, checking that its CIDR block lies within
+// that of the VPC and does not overlap those of the other subnets.
+func (srv *Server) createSubnet(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	v := srv.vpc(req.Form.Get("VpcId"))
+	s := &subnet{
+		vpc:       v,
+		cidr:      formCIDR(req, "CidrBlock"),
+		availZone: req.Form.Get("AvailabilityZone"),
+	}
+	if n := prefixLen(s.cidr); n < 16 || n > 28 || !cidrContains(v.cidr, s.cidr) {
+		fatalf(400, "InvalidSubnet.Range", "The CIDR '%s' is invalid.", s.cidr)
+	}
+	for _, other := range srv.subnets {
+		if other.vpc == v && cidrOverlap(other.cidr, s.cidr) {
+			fatalf(400, "InvalidSubnet.Conflict", "The CIDR '%s' conflicts with another subnet", s.cidr)
+		}
+	}
+	if s.availZone == "" {
+		s.availZone = defaultAvailZone
+	}
+	s.id = fmt.Sprintf("subnet-%d", srv.subnetId.next())
+	srv.subnets[s.id] = s
+	return &ec2.CreateSubnetResp{
+		RequestId: reqId,
+		Subnet:    srv.ec2Subnet(s),
+	}
+}
+
+// subnet returns the subnet with the given id.
+func (srv *Server) subnet(id string) *subnet {
+	if id == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter subnetId")
+	}
+	s := srv.subnets[id]
+	if s == nil {
+		fatalf(400, "InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", id)
+	}
+	return s
+}
+
+// deleteSubnet deletes a subnet and its route table association. Subnets
+// with instances that are not terminated cannot be deleted.
+func (srv *Server) deleteSubnet(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	s := srv.subnet(req.Form.Get("SubnetId"))
+	if srv.subnetInstances(s) > 0 {
+		fatalf(400, "DependencyViolation", "The subnet '%s' has dependencies and cannot be deleted.", s.id)
+	}
+	for _, rt := range srv.routeTables {
+		for id, as := range rt.associations {
+			if as == s {
+				delete(rt.associations, id)
+			}
+		}
+	}
+	delete(srv.subnets, s.id)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DeleteSubnetResponse"},
+		RequestId: reqId,
+	}
+}
+
+// subnetInstances returns the number of instances in the subnet that are
+// not terminated.
+func (srv *Server) subnetInstances(s *subnet) int {
+	n := 0
+	for _, inst := range srv.instances {
+		if inst.subnet == s && inst.state != Terminated {
+			n++
+		}
+	}
+	return n
+}
+
+// availableAddresses returns the number of addresses of the subnet that
+// are not reserved by EC2 or used by an instance.
+func (srv *Server) availableAddresses(s *subnet) int {
+	ones, bits := s.cidr.Mask.Size()
+	return 1<<uint(bits-ones) - reservedAddresses - srv.subnetInstances(s)
+}
+
+func (srv *Server) describeSubnets(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ids := make(map[*subnet]bool)
+	for name, vals := range req.Form {
+		if strings.HasPrefix(name, "SubnetId.") {
+			ids[srv.subnet(vals[0])] = true
+		}
+	}
+	f := newFilter(req.Form)
+	var resp ec2.SubnetsResp
+	resp.RequestId = reqId
+	for _, id := range srv.subnetIds() {
+		s := srv.subnets[id]
+		if len(ids) > 0 && !ids[s] {
+			continue
+		}
+		info := subnetInfo(srv.ec2Subnet(s))
+		ok, err := f.ok(&info)
+		if ok {
+			resp.Subnets = append(resp.Subnets, ec2.Subnet(info))
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe subnets: %v", err)
+		}
+	}
+	return &resp
+}
+
+func (srv *Server) ec2Subnet(s *subnet) ec2.Subnet {
+	return ec2.Subnet{
+		Id:                      s.id,
+		State:                   "available",
+		VpcId:                   s.vpc.id,
+		CidrBlock:               s.cidr.String(),
+		AvailableIPAddressCount: srv.availableAddresses(s),
+		AvailZone:               s.availZone,
+	}
+}
+
+// subnetInfo is a subnet as described by EC2 that can be filtered.
+type subnetInfo ec2.Subnet
+
+func (s *subnetInfo) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "availability-zone":
+		return s.AvailZone == value, nil
+	case "available-ip-address-count":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return false, err
+		}
+		return s.AvailableIPAddressCount == n, nil
+	case "cidr", "cidrBlock", "cidr-block":
+		return s.CidrBlock == value, nil
+	case "defaultForAz", "default-for-az":
+		def, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, err
+		}
+		return s.DefaultForAZ == def, nil
+	case "state":
+		return s.State == value, nil
+	case "subnet-id":
+		return s.Id == value, nil
+	case "vpc-id":
+		return s.VpcId == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
+
+func (srv *Server) createInternetGateway(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	igw := &internetGateway{
+		id: fmt.Sprintf("igw-%d", srv.internetGatewayId.next()),
+	}
+	srv.internetGateways[igw.id] = igw
+	return &ec2.CreateInternetGatewayResp{
+		RequestId:       reqId,
+		InternetGateway: igw.ec2InternetGateway(),
+	}
+}
+
+// internetGateway returns the internet gateway with the given id.
+func (srv *Server) internetGateway(id string) *internetGateway {
+	if id == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter internetGatewayId")
+	}
+	igw := srv.internetGateways[id]
+	if igw == nil {
+		fatalf(400, "InvalidInternetGatewayID.NotFound", "The internetGateway ID '%s' does not exist", id)
+	}
+	return igw
+}
+
+// deleteInternetGateway deletes a detached internet gateway. Routes to it
+// become blackholes.
+func (srv *Server) deleteInternetGateway(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	igw := srv.internetGateway(req.Form.Get("InternetGatewayId"))
+	if igw.vpc != nil {
+		fatalf(400, "DependencyViolation", "The internetGateway '%s' has dependencies and cannot be deleted.", igw.id)
+	}
+	delete(srv.internetGateways, igw.id)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DeleteInternetGatewayResponse"},
+		RequestId: reqId,
+	}
+}
+
+func (srv *Server) attachInternetGateway(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	igw := srv.internetGateway(req.Form.Get("InternetGatewayId"))
+	v := srv.vpc(req.Form.Get("VpcId"))
+	if igw.vpc != nil {
+		fatalf(400, "Resource.AlreadyAssociated", "resource %s is already attached to network %s", igw.id, igw.vpc.id)
+	}
+	for _, other := range srv.internetGateways {
+		if other.vpc == v {
+			fatalf(400, "InvalidParameterValue", "Network %s already has an internet gateway attached", v.id)
+		}
+	}
+	igw.vpc = v
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "AttachInternetGatewayResponse"},
+		RequestId: reqId,
+	}
+}
+
+// detachInternetGateway detaches an internet gateway from its VPC. Routes
+// to it become blackholes.
+func (srv *Server) detachInternetGateway(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	igw := srv.internetGateway(req.Form.Get("InternetGatewayId"))
+	v := srv.vpc(req.Form.Get("VpcId"))
+	if igw.vpc != v {
+		fatalf(400, "Gateway.NotAttached", "resource %s is not attached to network %s", igw.id, v.id)
+	}
+	igw.vpc = nil
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DetachInternetGatewayResponse"},
+		RequestId: reqId,
+	}
+}
+
+func (srv *Server) describeInternetGateways(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ids := make(map[*internetGateway]bool)
+	for name, vals := range req.Form {
+		if strings.HasPrefix(name, "InternetGatewayId.") {
+			ids[srv.internetGateway(vals[0])] = true
+		}
+	}
+	f := newFilter(req.Form)
+	var resp ec2.InternetGatewaysResp
+	resp.RequestId = reqId
+	for _, id := range srv.internetGatewayIds() {
+		igw := srv.internetGateways[id]
+		if len(ids) > 0 && !ids[igw] {
+			continue
+		}
+		info := internetGatewayInfo(igw.ec2InternetGateway())
+		ok, err := f.ok(&info)
+		if ok {
+			resp.InternetGateways = append(resp.InternetGateways, ec2.InternetGateway(info))
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe internet gateways: %v", err)
+		}
+	}
+	return &resp
+}
+
+func (igw *internetGateway) ec2InternetGateway() ec2.InternetGateway {
+	g := ec2.InternetGateway{Id: igw.id}
+	if igw.vpc != nil {
+		g.Attachments = []ec2.InternetGatewayAttachment{{VpcId: igw.vpc.id, State: "available"}}
+	}
+	return g
+}
+
+// internetGatewayInfo is an internet gateway as described by EC2 that can
+// be filtered.
+type internetGatewayInfo ec2.InternetGateway
+
+func (g *internetGatewayInfo) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "attachment.state":
+		return len(g.Attachments) > 0 && g.Attachments[0].State == value, nil
+	case "attachment.vpc-id":
+		return len(g.Attachments) > 0 && g.Attachments[0].VpcId == value, nil
+	case "internet-gateway-id":
+		return g.Id == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
+
+// newRouteTable creates a route table holding the local route of the VPC.
+func (srv *Server) newRouteTable(v *vpc) *routeTable {
+	rt := &routeTable{
+		id:  fmt.Sprintf("rtb-%d", srv.routeTableId.next()),
+		vpc: v,
+		routes: []*route{{
+			dest:      v.cidr,
+			gatewayId: "local",
+			origin:    "CreateRouteTable",
+		}},
+		associations: make(map[string]*subnet),
+	}
+	srv.routeTables[rt.id] = rt
+	return rt
+}
+
+func (srv *Server) createRouteTable(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	rt := srv.newRouteTable(srv.vpc(req.Form.Get("VpcId")))
+	return &ec2.CreateRouteTableResp{
+		RequestId:  reqId,
+		RouteTable: srv.ec2RouteTable(rt),
+	}
+}
+
+// routeTable returns the route table with the given id.
+func (srv *Server) routeTable(id string) *routeTable {
+	if id == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter routeTableId")
+	}
+	rt := srv.routeTables[id]
+	if rt == nil {
+		fatalf(400, "InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", id)
+	}
+	return rt
+}
+
+// deleteRouteTable deletes a route table. The main route table of a VPC
+// and route tables associated with subnets cannot be deleted.
+func (srv *Server) deleteRouteTable(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	rt := srv.routeTable(req.Form.Get("RouteTableId"))
+	if rt.mainAssocId != "" || len(rt.associations) > 0 {
+		fatalf(400, "DependencyViolation", "The routeTable '%s' has dependencies and cannot be deleted.", rt.id)
+	}
+	delete(srv.routeTables, rt.id)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "DeleteRouteTableResponse"},
+		RequestId: reqId,
+	}
+}
+
+func (srv *Server) associateRouteTable(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	rt := srv.routeTable(req.Form.Get("RouteTableId"))
+	s := srv.subnet(req.Form.Get("SubnetId"))
+	if s.vpc != rt.vpc {
+		fatalf(400, "InvalidParameterValue", "Route table %s and subnet %s belong to different networks", rt.id, s.id)
+	}
+	for _, other := range srv.routeTables {
+		for _, as := range other.associations {
+			if as == s {
+				fatalf(400, "Resource.AlreadyAssociated", "the specified association for route table %s conflicts with an existing association", rt.id)
+			}
+		}
+	}
+	id := fmt.Sprintf("rtbassoc-%d", srv.routeTableAssocId.next())
+	rt.associations[id] = s
+	return &ec2.AssociateRouteTableResp{
+		RequestId:     reqId,
+		AssociationId: id,
+	}
+}
+
+func (srv *Server) disassociateRouteTable(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	id := req.Form.Get("AssociationId")
+	if id == "" {
+		fatalf(400, "MissingParameter", "The request must contain the parameter associationId")
+	}
+	for _, rt := range srv.routeTables {
+		if rt.mainAssocId == id {
+			fatalf(400, "InvalidParameterValue", "cannot disassociate the main route table association %s", id)
+		}
+		if rt.associations[id] != nil {
+			delete(rt.associations, id)
+			return &ec2.SimpleResp{
+				XMLName:   xml.Name{"", "DisassociateRouteTableResponse"},
+				RequestId: reqId,
+			}
+		}
+	}
+	fatalf(400, "InvalidAssociationID.NotFound", "The association ID '%s' does not exist", id)
+	panic("not reached")
+}
+
+// createRoute adds a route to an internet gateway attached to the VPC of
+// the route table, or to an instance in that VPC.
+func (srv *Server) createRoute(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	rt := srv.routeTable(req.Form.Get("RouteTableId"))
+	r := &route{
+		dest:       formCIDR(req, "DestinationCidrBlock"),
+		gatewayId:  req.Form.Get("GatewayId"),
+		instanceId: req.Form.Get("InstanceId"),
+		origin:     "CreateRoute",
+	}
+	switch {
+	case r.gatewayId != "" && r.instanceId != "":
+		fatalf(400, "InvalidParameterCombination", "The parameters gatewayId and instanceId cannot be used together")
+	case r.gatewayId != "":
+		igw := srv.internetGateways[r.gatewayId]
+		if igw == nil {
+			fatalf(400, "InvalidGatewayID.NotFound", "The gateway ID '%s' does not exist", r.gatewayId)
+		}
+		if igw.vpc != rt.vpc {
+			fatalf(400, "InvalidParameterValue", "route table %s and network gateway %s belong to different networks", rt.id, igw.id)
+		}
+	case r.instanceId != "":
+		inst := srv.instances[r.instanceId]
+		if inst == nil {
+			fatalf(400, "InvalidInstanceID.NotFound", "no such instance id %q", r.instanceId)
+		}
+		if inst.subnet == nil || inst.subnet.vpc != rt.vpc {
+			fatalf(400, "InvalidParameterValue", "route table %s and instance %s belong to different networks", rt.id, inst.id)
+		}
+	default:
+		fatalf(400, "MissingParameter", "The request must contain either a gateway id or an instance id")
+	}
+	for _, other := range rt.routes {
+		if other.dest.String() == r.dest.String() {
+			fatalf(400, "RouteAlreadyExists", "The route identified by %s already exists.", r.dest)
+		}
+	}
+	rt.routes = append(rt.routes, r)
+	return &ec2.SimpleResp{
+		XMLName:   xml.Name{"", "CreateRouteResponse"},
+		RequestId: reqId,
+	}
+}
+
+// deleteRoute removes a route other than the local one.
+func (srv *Server) deleteRoute(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	rt := srv.routeTable(req.Form.Get("RouteTableId"))
+	dest := req.Form.Get("DestinationCidrBlock")
+	for i, r := range rt.routes {
+		if r.dest.String() != dest {
+			continue
+		}
+		if r.gatewayId == "local" {
+			fatalf(400, "InvalidParameterValue", "cannot remove local route %s in route table %s", dest, rt.id)
+		}
+		rt.routes = append(rt.routes[:i], rt.routes[i+1:]...)
+		return &ec2.SimpleResp{
+			XMLName:   xml.Name{"", "DeleteRouteResponse"},
+			RequestId: reqId,
+		}
+	}
+	fatalf(400, "InvalidRoute.NotFound", "no route with destination-cidr-block %s in route table %s", dest, rt.id)
+	panic("not reached")
+}
+
+func (srv *Server) describeRouteTables(w http.ResponseWriter, req *http.Request, reqId string) interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ids := make(map[*routeTable]bool)
+	for name, vals := range req.Form {
+		if strings.HasPrefix(name, "RouteTableId.") {
+			ids[srv.routeTable(vals[0])] = true
+		}
+	}
+	f := newFilter(req.Form)
+	var resp ec2.RouteTablesResp
+	resp.RequestId = reqId
+	for _, id := range srv.routeTableIds() {
+		rt := srv.routeTables[id]
+		if len(ids) > 0 && !ids[rt] {
+			continue
+		}
+		info := routeTableInfo(srv.ec2RouteTable(rt))
+		ok, err := f.ok(&info)
+		if ok {
+			resp.RouteTables = append(resp.RouteTables, ec2.RouteTable(info))
+		} else if err != nil {
+			fatalf(400, "InvalidParameterValue", "describe route tables: %v", err)
+		}
+	}
+	return &resp
+}
+
+// ec2RouteTable returns the route table as described by EC2. Routes to
+// internet gateways that are not attached to the VPC and to instances
+// that are gone are blackholes.
+func (srv *Server) ec2RouteTable(rt *routeTable) ec2.RouteTable {
+	t := ec2.RouteTable{
+		Id:    rt.id,
+		VpcId: rt.vpc.id,
+	}
+	for _, r := range rt.routes {
+		state := "active"
+		switch {
+		case r.gatewayId == "local":
+		case r.gatewayId != "":
+			if igw := srv.internetGateways[r.gatewayId]; igw == nil || igw.vpc != rt.vpc {
+				state = "blackhole"
+			}
+		default:
+			if inst := srv.instances[r.instanceId]; inst == nil || inst.state == Terminated {
+				state = "blackhole"
+			}
+		}
+		t.Routes = append(t.Routes, ec2.Route{
+			DestinationCidrBlock: r.dest.String(),
+			GatewayId:            r.gatewayId,
+			InstanceId:           r.instanceId,
+			State:                state,
+			Origin:               r.origin,
+		})
+	}
+	if rt.mainAssocId != "" {
+		t.Associations = append(t.Associations, ec2.RouteTableAssociation{
+			Id:           rt.mainAssocId,
+			RouteTableId: rt.id,
+			Main:         true,
+		})
+	}
+	for _, id := range rt.associationIds() {
+		t.Associations = append(t.Associations, ec2.RouteTableAssociation{
+			Id:           id,
+			RouteTableId: rt.id,
+			SubnetId:     rt.associations[id].id,
+		})
+	}
+	return t
+}
+
+// routeTableInfo is a route table as described by EC2 that can be
+// filtered.
+type routeTableInfo ec2.RouteTable
+
+func (t *routeTableInfo) matchAttr(attr, value string) (ok bool, err error) {
+	switch attr {
+	case "association.main":
+		main, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, err
+		}
+		return t.hasAssociation(func(a ec2.RouteTableAssociation) bool { return a.Main == main }), nil
+	case "association.route-table-association-id":
+		return t.hasAssociation(func(a ec2.RouteTableAssociation) bool { return a.Id == value }), nil
+	case "association.subnet-id":
+		return t.hasAssociation(func(a ec2.RouteTableAssociation) bool { return a.SubnetId == value }), nil
+	case "route-table-id":
+		return t.Id == value, nil
+	case "route.destination-cidr-block":
+		return t.hasRoute(func(r ec2.Route) bool { return r.DestinationCidrBlock == value }), nil
+	case "route.gateway-id":
+		return t.hasRoute(func(r ec2.Route) bool { return r.GatewayId == value }), nil
+	case "route.instance-id":
+		return t.hasRoute(func(r ec2.Route) bool { return r.InstanceId == value }), nil
+	case "route.origin":
+		return t.hasRoute(func(r ec2.Route) bool { return r.Origin == value }), nil
+	case "route.state":
+		return t.hasRoute(func(r ec2.Route) bool { return r.State == value }), nil
+	case "vpc-id":
+		return t.VpcId == value, nil
+	}
+	return false, fmt.Errorf("unknown attribute %q", attr)
+}
+
+func (t *routeTableInfo) hasAssociation(test func(a ec2.RouteTableAssociation) bool) bool {
+	for _, a := range t.Associations {
+		if test(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *routeTableInfo) hasRoute(test func(r ec2.Route) bool) bool {
+	for _, r := range t.Routes {
+		if test(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// vpcIds returns the ids of the VPCs, in order.
+func (srv *Server) vpcIds() []string {
+	var ids []string
+	for id := range srv.vpcs {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
+
+// subnetIds returns the ids of the subnets, in order.
+func (srv *Server) subnetIds() []string {
+	var ids []string
+	for id := range srv.subnets {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
+
+// internetGatewayIds returns the ids of the internet gateways, in order.
+func (srv *Server) internetGatewayIds() []string {
+	var ids []string
+	for id := range srv.internetGateways {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
+
+// routeTableIds returns the ids of the route tables, in order.
+func (srv *Server) routeTableIds() []string {
+	var ids []string
+	for id := range srv.routeTables {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
+
+// associationIds returns the ids of the subnet associations of the route
+// table, in order.
+func (rt *routeTable) associationIds() []string {
+	var ids []string
+	for id := range rt.associations {
+		ids = append(ids, id)
+	}
+	return sortedIds(ids)
+}
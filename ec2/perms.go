@@ -0,0 +1,224 @@
+package ec2
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// Security group permission syncing.
+
+// ipRule is a single rule of an IPPerm: a protocol and port range for
+// either one source IP range or one source group.
+type ipRule struct {
+	protocol string
+	fromPort int
+	toPort   int
+	sourceIP string
+
+	// group identifies the source group, by id if known and by owner
+	// and name otherwise.
+	group string
+}
+
+// ipRules holds a set of rules, mapped to their source group, if any.
+type ipRules map[ipRule]UserSecurityGroup
+
+// protocolNames maps the protocol numbers EC2 knows by name to their name.
+var protocolNames = map[string]string{
+	"1":  "icmp",
+	"6":  "tcp",
+	"17": "udp",
+}
+
+// normalizeCIDR returns the CIDR block s in canonical form. A bare IP
+// address stands for itself only.
+func normalizeCIDR(s string) (string, error) {
+	if !strings.Contains(s, "/") {
+		if strings.Contains(s, ":") {
+			s += "/128"
+		} else {
+			s += "/32"
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR block %q", s)
+	}
+	return ipnet.String(), nil
+}
+
+// add adds the rules of perms to rs. Source groups given by name only take
+// the id of a group of the same name in known, if any.
+func (rs ipRules) add(perms []IPPerm, known []IPPerm) error {
+	for _, p := range perms {
+		r := ipRule{
+			protocol: strings.ToLower(p.Protocol),
+			fromPort: p.FromPort,
+			toPort:   p.ToPort,
+		}
+		if name, ok := protocolNames[r.protocol]; ok {
+			r.protocol = name
+		}
+		if r.protocol == "-1" {
+			r.fromPort, r.toPort = 0, 0
+		}
+		for _, ip := range p.SourceIPs {
+			cidr, err := normalizeCIDR(ip)
+			if err != nil {
+				return err
+			}
+			r := r
+			r.sourceIP = cidr
+			rs[r] = UserSecurityGroup{}
+		}
+		for _, g := range p.SourceGroups {
+			if g.Id == "" {
+				g.Id = groupId(g, known)
+			}
+			r := r
+			if g.Id != "" {
+				r.group = "id:" + g.Id
+			} else {
+				r.group = "name:" + g.OwnerId + "/" + g.Name
+			}
+			rs[r] = g
+		}
+	}
+	return nil
+}
+
+// groupId returns the id of the source group in perms with the name and,
+// if set, owner of g, or "" if there is none.
+func groupId(g UserSecurityGroup, perms []IPPerm) string {
+	for _, p := range perms {
+		for _, h := range p.SourceGroups {
+			if h.Name == g.Name && (g.OwnerId == "" || h.OwnerId == g.OwnerId) {
+				return h.Id
+			}
+		}
+	}
+	return ""
+}
+
+// without returns the rules of rs that are not in other, grouped into
+// permissions by protocol and port range, in a stable order.
+func (rs ipRules) without(other ipRules) []IPPerm {
+	type portRange struct {
+		protocol string
+		fromPort int
+		toPort   int
+	}
+	byRange := make(map[portRange]*IPPerm)
+	var ranges []portRange
+	for r, g := range rs {
+		if _, ok := other[r]; ok {
+			continue
+		}
+		k := portRange{r.protocol, r.fromPort, r.toPort}
+		p := byRange[k]
+		if p == nil {
+			p = &IPPerm{Protocol: r.protocol, FromPort: r.fromPort, ToPort: r.toPort}
+			byRange[k] = p
+			ranges = append(ranges, k)
+		}
+		if r.group != "" {
+			p.SourceGroups = append(p.SourceGroups, g)
+		} else {
+			p.SourceIPs = append(p.SourceIPs, r.sourceIP)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		a, b := ranges[i], ranges[j]
+		switch {
+		case a.protocol != b.protocol:
+			return a.protocol < b.protocol
+		case a.fromPort != b.fromPort:
+			return a.fromPort < b.fromPort
+		}
+		return a.toPort < b.toPort
+	})
+	var perms []IPPerm
+	for _, k := range ranges {
+		p := byRange[k]
+		sort.Strings(p.SourceIPs)
+		sort.Slice(p.SourceGroups, func(i, j int) bool {
+			a, b := p.SourceGroups[i], p.SourceGroups[j]
+			if a.Id != b.Id {
+				return a.Id < b.Id
+			}
+			return a.Name < b.Name
+		})
+		perms = append(perms, *p)
+	}
+	return perms
+}
+
+// DiffIPPerms returns the permissions that must be authorized and revoked
+// for a security group granting the current permissions to grant the
+// desired ones instead. Both lists are split into rules for a single
+// source, so the way permissions are grouped does not matter. Protocol
+// names are lowercased, protocol numbers are replaced by the names EC2
+// uses, the ports of the "-1" protocol are ignored and CIDR blocks are put
+// in canonical form. Source groups in desired given by name only match
+// groups of the same name in current.
+func DiffIPPerms(current, desired []IPPerm) (authorize, revoke []IPPerm, err error) {
+	have := make(ipRules)
+	if err := have.add(current, nil); err != nil {
+		return nil, nil, err
+	}
+	want := make(ipRules)
+	if err := want.add(desired, current); err != nil {
+		return nil, nil, err
+	}
+	return want.without(have), have.without(want), nil
+}
+
+// SyncSecurityGroup makes the inbound permissions of group exactly perms,
+// authorizing and revoking as few as possible as computed by DiffIPPerms.
+// New permissions are authorized before stale ones are revoked, so that
+// rules kept across the change never lapse. It returns the permissions
+// that were authorized and revoked.
+func (ec2 *EC2) SyncSecurityGroup(group SecurityGroup, perms []IPPerm) (authorized, revoked []IPPerm, err error) {
+	return ec2.syncSecurityGroup(group, perms, false)
+}
+
+// SyncSecurityGroupEgress is like SyncSecurityGroup, but for the outbound
+// permissions of a VPC security group.
+func (ec2 *EC2) SyncSecurityGroupEgress(group SecurityGroup, perms []IPPerm) (authorized, revoked []IPPerm, err error) {
+	return ec2.syncSecurityGroup(group, perms, true)
+}
+
+func (ec2 *EC2) syncSecurityGroup(group SecurityGroup, perms []IPPerm, egress bool) (authorized, revoked []IPPerm, err error) {
+	resp, err := ec2.SecurityGroups([]SecurityGroup{group}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Groups) != 1 {
+		return nil, nil, fmt.Errorf("expected one security group, got %d", len(resp.Groups))
+	}
+	info := resp.Groups[0]
+	current := info.IPPerms
+	authorize, revoke := ec2.AuthorizeSecurityGroup, ec2.RevokeSecurityGroup
+	if egress {
+		current = info.IPPermsEgress
+		authorize, revoke = ec2.AuthorizeSecurityGroupEgress, ec2.RevokeSecurityGroupEgress
+	}
+	authorized, revoked, err = DiffIPPerms(current, perms)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(authorized) > 0 {
+		if _, err := authorize(info.SecurityGroup, authorized); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(revoked) > 0 {
+		if _, err := revoke(info.SecurityGroup, revoked); err != nil {
+			return authorized, nil, err
+		}
+	}
+	return authorized, revoked, nil
+}
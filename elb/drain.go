@@ -0,0 +1,38 @@
+package elb
+
+import (
+	"time"
+)
+
+// DeregisterInstancesAndDrain deregisters instances from a Load Balancer and,
+// when connection draining is enabled for it, waits until the instances are
+// no longer reported by DescribeInstanceHealth or until the draining timeout
+// passes, whichever happens first. After it returns, the instances may be
+// terminated without cutting off in-flight requests.
+func (elb *ELB) DeregisterInstancesAndDrain(lbName string, instanceIds []string) error {
+	attrs, err := elb.DescribeLoadBalancerAttributes(lbName)
+	if err != nil {
+		return err
+	}
+	if _, err := elb.DeregisterInstancesFromLoadBalancer(instanceIds, lbName); err != nil {
+		return err
+	}
+	draining := attrs.LoadBalancerAttributes.ConnectionDraining
+	if !draining.Enabled {
+		return nil
+	}
+	timeout := time.Duration(draining.Timeout) * time.Second
+	_, err = poll(timeout, func() (bool, error) {
+		resp, err := elb.DescribeInstanceHealth(lbName)
+		if err != nil {
+			return false, err
+		}
+		for _, s := range resp.InstanceStates {
+			if contains(instanceIds, s.InstanceId) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	return err
+}
@@ -14,10 +14,14 @@ import (
 type ELB struct {
 	aws.Auth
 	aws.Region
+
+	// SkipValidation disables the client-side validation of requests
+	// before they are sent, leaving it entirely to AWS.
+	SkipValidation bool
 }
 
 func New(auth aws.Auth, region aws.Region) *ELB {
-	return &ELB{auth, region}
+	return &ELB{Auth: auth, Region: region}
 }
 
 // The CreateLoadBalancer type encapsulates options for the respective request in AWS.
@@ -59,6 +63,11 @@ type SimpleResp struct {
 //
 // See http://goo.gl/4QFKi for more details.
 func (elb *ELB) CreateLoadBalancer(options *CreateLoadBalancer) (resp *CreateLoadBalancerResp, err error) {
+	if !elb.SkipValidation {
+		if err := options.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	params := makeCreateParams(options)
 	resp = new(CreateLoadBalancerResp)
 	if err := elb.query(params, resp); err != nil {
@@ -233,8 +242,8 @@ func (elb *ELB) DescribeInstanceHealth(lbName string, instanceIds ...string) (*D
 		"Action":           "DescribeInstanceHealth",
 		"LoadBalancerName": lbName,
 	}
-	for _, iId := range instanceIds {
-		key := fmt.Sprintf("Instances.member.1.InstanceId")
+	for i, iId := range instanceIds {
+		key := fmt.Sprintf("Instances.member.%d.InstanceId", i+1)
 		params[key] = iId
 	}
 	resp := new(DescribeInstanceHealthResp)
@@ -252,6 +261,11 @@ type HealthCheckResp struct {
 //
 // See http://goo.gl/2HE6a for more information
 func (elb *ELB) ConfigureHealthCheck(lbName string, healthCheck *HealthCheck) (*HealthCheckResp, error) {
+	if !elb.SkipValidation {
+		if err := healthCheck.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	params := map[string]string{
 		"Action":                         "ConfigureHealthCheck",
 		"LoadBalancerName":               lbName,
@@ -268,6 +282,241 @@ func (elb *ELB) ConfigureHealthCheck(lbName string, healthCheck *HealthCheck) (*
 	return resp, nil
 }
 
+// LoadBalancerAttributes holds the attributes of a Load Balancer.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_LoadBalancerAttributes.html
+// for more information.
+type LoadBalancerAttributes struct {
+	ConnectionDraining ConnectionDraining `xml:"ConnectionDraining"`
+}
+
+// ConnectionDraining describes whether a Load Balancer keeps existing
+// connections open to deregistered or unhealthy instances, and for how many
+// seconds.
+type ConnectionDraining struct {
+	Enabled bool `xml:"Enabled"`
+	Timeout int  `xml:"Timeout"`
+}
+
+type DescribeLoadBalancerAttributesResp struct {
+	LoadBalancerAttributes LoadBalancerAttributes `xml:"DescribeLoadBalancerAttributesResult>LoadBalancerAttributes"`
+}
+
+// Describe the attributes of a Load Balancer.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_DescribeLoadBalancerAttributes.html
+// for more information.
+func (elb *ELB) DescribeLoadBalancerAttributes(lbName string) (*DescribeLoadBalancerAttributesResp, error) {
+	params := map[string]string{
+		"Action":           "DescribeLoadBalancerAttributes",
+		"LoadBalancerName": lbName,
+	}
+	resp := new(DescribeLoadBalancerAttributesResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+type ModifyLoadBalancerAttributesResp struct {
+	LoadBalancerName       string                 `xml:"ModifyLoadBalancerAttributesResult>LoadBalancerName"`
+	LoadBalancerAttributes LoadBalancerAttributes `xml:"ModifyLoadBalancerAttributesResult>LoadBalancerAttributes"`
+}
+
+// Modify the attributes of a Load Balancer. A zero connection draining
+// timeout leaves the current one unchanged.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_ModifyLoadBalancerAttributes.html
+// for more information.
+func (elb *ELB) ModifyLoadBalancerAttributes(lbName string, attrs *LoadBalancerAttributes) (*ModifyLoadBalancerAttributesResp, error) {
+	params := map[string]string{
+		"Action":           "ModifyLoadBalancerAttributes",
+		"LoadBalancerName": lbName,
+		"LoadBalancerAttributes.ConnectionDraining.Enabled": strconv.FormatBool(attrs.ConnectionDraining.Enabled),
+	}
+	if attrs.ConnectionDraining.Timeout != 0 {
+		params["LoadBalancerAttributes.ConnectionDraining.Timeout"] = strconv.Itoa(attrs.ConnectionDraining.Timeout)
+	}
+	resp := new(ModifyLoadBalancerAttributesResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Creates listeners in a Load Balancer.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_CreateLoadBalancerListeners.html
+// for more information.
+func (elb *ELB) CreateLoadBalancerListeners(lbName string, listeners ...Listener) (*SimpleResp, error) {
+	if !elb.SkipValidation {
+		for i := range listeners {
+			if err := listeners[i].Validate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	params := map[string]string{
+		"Action":           "CreateLoadBalancerListeners",
+		"LoadBalancerName": lbName,
+	}
+	addListenersParams(params, listeners)
+	return elb.simpleQuery(params)
+}
+
+// Deletes the listeners of a Load Balancer that use the given ports.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_DeleteLoadBalancerListeners.html
+// for more information.
+func (elb *ELB) DeleteLoadBalancerListeners(lbName string, ports ...int) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "DeleteLoadBalancerListeners",
+		"LoadBalancerName": lbName,
+	}
+	for i, port := range ports {
+		params[fmt.Sprintf("LoadBalancerPorts.member.%d", i+1)] = strconv.Itoa(port)
+	}
+	return elb.simpleQuery(params)
+}
+
+// Adds availability zones to a Load Balancer.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_EnableAvailabilityZonesForLoadBalancer.html
+// for more information.
+func (elb *ELB) EnableAvailabilityZonesForLoadBalancer(lbName string, zones ...string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "EnableAvailabilityZonesForLoadBalancer",
+		"LoadBalancerName": lbName,
+	}
+	addMembersParams(params, "AvailabilityZones", zones)
+	return elb.simpleQuery(params)
+}
+
+// Removes availability zones from a Load Balancer.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_DisableAvailabilityZonesForLoadBalancer.html
+// for more information.
+func (elb *ELB) DisableAvailabilityZonesForLoadBalancer(lbName string, zones ...string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "DisableAvailabilityZonesForLoadBalancer",
+		"LoadBalancerName": lbName,
+	}
+	addMembersParams(params, "AvailabilityZones", zones)
+	return elb.simpleQuery(params)
+}
+
+// Adds subnets to a Load Balancer in a VPC.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_AttachLoadBalancerToSubnets.html
+// for more information.
+func (elb *ELB) AttachLoadBalancerToSubnets(lbName string, subnets ...string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "AttachLoadBalancerToSubnets",
+		"LoadBalancerName": lbName,
+	}
+	addMembersParams(params, "Subnets", subnets)
+	return elb.simpleQuery(params)
+}
+
+// Removes subnets from a Load Balancer in a VPC.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_DetachLoadBalancerFromSubnets.html
+// for more information.
+func (elb *ELB) DetachLoadBalancerFromSubnets(lbName string, subnets ...string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "DetachLoadBalancerFromSubnets",
+		"LoadBalancerName": lbName,
+	}
+	addMembersParams(params, "Subnets", subnets)
+	return elb.simpleQuery(params)
+}
+
+// Replaces the security groups of a Load Balancer in a VPC.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_ApplySecurityGroupsToLoadBalancer.html
+// for more information.
+func (elb *ELB) ApplySecurityGroupsToLoadBalancer(lbName string, groups ...string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "ApplySecurityGroupsToLoadBalancer",
+		"LoadBalancerName": lbName,
+	}
+	addMembersParams(params, "SecurityGroups", groups)
+	return elb.simpleQuery(params)
+}
+
+// Creates a stickiness policy that follows the lifetime of an application
+// generated cookie.
+//
+// See http://goo.gl/clXGV for more information.
+func (elb *ELB) CreateAppCookieStickinessPolicy(lbName, policyName, cookieName string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "CreateAppCookieStickinessPolicy",
+		"LoadBalancerName": lbName,
+		"PolicyName":       policyName,
+		"CookieName":       cookieName,
+	}
+	return elb.simpleQuery(params)
+}
+
+// Creates a stickiness policy based on a cookie generated by the Load
+// Balancer. An expiration period of zero makes the cookie last for the
+// browser session.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_CreateLBCookieStickinessPolicy.html
+// for more information.
+func (elb *ELB) CreateLBCookieStickinessPolicy(lbName, policyName string, expirationPeriod int) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "CreateLBCookieStickinessPolicy",
+		"LoadBalancerName": lbName,
+		"PolicyName":       policyName,
+	}
+	if expirationPeriod > 0 {
+		params["CookieExpirationPeriod"] = strconv.Itoa(expirationPeriod)
+	}
+	return elb.simpleQuery(params)
+}
+
+// Replaces the policies of the listener that uses the given port. Calling it
+// with no policy names removes all policies from the listener.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_SetLoadBalancerPoliciesOfListener.html
+// for more information.
+func (elb *ELB) SetLoadBalancerPoliciesOfListener(lbName string, port int, policyNames ...string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "SetLoadBalancerPoliciesOfListener",
+		"LoadBalancerName": lbName,
+		"LoadBalancerPort": strconv.Itoa(port),
+	}
+	if len(policyNames) == 0 {
+		// an empty list is how the API is told to remove every policy
+		params["PolicyNames"] = ""
+	}
+	addMembersParams(params, "PolicyNames", policyNames)
+	return elb.simpleQuery(params)
+}
+
+// Deletes a policy from a Load Balancer. The policy must not be in use by
+// any listener.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_DeleteLoadBalancerPolicy.html
+// for more information.
+func (elb *ELB) DeleteLoadBalancerPolicy(lbName, policyName string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":           "DeleteLoadBalancerPolicy",
+		"LoadBalancerName": lbName,
+		"PolicyName":       policyName,
+	}
+	return elb.simpleQuery(params)
+}
+
+func (elb *ELB) simpleQuery(params map[string]string) (*SimpleResp, error) {
+	resp := new(SimpleResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
 func (elb *ELB) query(params map[string]string, resp interface{}) error {
 	params["Version"] = "2012-06-01"
 	params["Timestamp"] = time.Now().In(time.UTC).Format(time.RFC3339)
@@ -352,17 +601,30 @@ func makeCreateParams(createLB *CreateLoadBalancer) map[string]string {
 		key := fmt.Sprintf("Subnets.member.%d", i+1)
 		params[key] = s
 	}
-	for i, l := range createLB.Listeners {
+	addListenersParams(params, createLB.Listeners)
+	for i, az := range createLB.AvailZones {
+		key := fmt.Sprintf("AvailabilityZones.member.%d", i+1)
+		params[key] = az
+	}
+	return params
+}
+
+func addListenersParams(params map[string]string, listeners []Listener) {
+	for i, l := range listeners {
 		key := "Listeners.member.%d.%s"
 		index := i + 1
 		params[fmt.Sprintf(key, index, "InstancePort")] = strconv.Itoa(l.InstancePort)
 		params[fmt.Sprintf(key, index, "InstanceProtocol")] = l.InstanceProtocol
 		params[fmt.Sprintf(key, index, "Protocol")] = l.Protocol
 		params[fmt.Sprintf(key, index, "LoadBalancerPort")] = strconv.Itoa(l.LoadBalancerPort)
+		if l.SSLCertificateId != "" {
+			params[fmt.Sprintf(key, index, "SSLCertificateId")] = l.SSLCertificateId
+		}
 	}
-	for i, az := range createLB.AvailZones {
-		key := fmt.Sprintf("AvailabilityZones.member.%d", i+1)
-		params[key] = az
+}
+
+func addMembersParams(params map[string]string, label string, values []string) {
+	for i, v := range values {
+		params[fmt.Sprintf("%s.member.%d", label, i+1)] = v
 	}
-	return params
 }
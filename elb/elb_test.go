@@ -89,6 +89,8 @@ func (s *S) TestCreateLoadBalancerWithSubnetsAndMoreListeners(c *C) {
 
 func (s *S) TestCreateLoadBalancerWithWrongParamsCombination(c *C) {
 	testServer.PrepareResponse(400, nil, CreateLoadBalancerBadRequest)
+	s.elb.SkipValidation = true
+	defer func() { s.elb.SkipValidation = false }()
 	createLB := &elb.CreateLoadBalancer{
 		Name:       "testlb",
 		AvailZones: []string{"us-east-1a", "us-east-1b"},
@@ -111,6 +113,28 @@ func (s *S) TestCreateLoadBalancerWithWrongParamsCombination(c *C) {
 	c.Assert(e.Code, Equals, "ValidationError")
 }
 
+func (s *S) TestCreateLoadBalancerIsValidatedBeforeSending(c *C) {
+	createLB := &elb.CreateLoadBalancer{
+		Name:       "testlb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners: []elb.Listener{
+			{
+				InstancePort:     80,
+				InstanceProtocol: "tcp",
+				Protocol:         "http",
+				LoadBalancerPort: 80,
+			},
+		},
+	}
+	resp, err := s.elb.CreateLoadBalancer(createLB)
+	c.Assert(resp, IsNil)
+	e, ok := err.(*elb.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Code, Equals, "ValidationError")
+	c.Assert(e.StatusCode, Equals, 0)
+	c.Assert(e.Message, Equals, "Listener protocol HTTP and instance protocol TCP are not compatible")
+}
+
 func (s *S) TestDeleteLoadBalancer(c *C) {
 	testServer.PrepareResponse(200, nil, DeleteLoadBalancer)
 	resp, err := s.elb.DeleteLoadBalancer("testlb")
@@ -265,6 +289,16 @@ func (s *S) TestDescribeInstanceHealth(c *C) {
 	c.Assert(resp.InstanceStates[0].ReasonCode, Equals, "ELB")
 }
 
+func (s *S) TestDescribeInstanceHealthWithMultipleInstances(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeInstanceHealth)
+	_, err := s.elb.DescribeInstanceHealth("testlb", "i-b44db8ca", "i-461ecf38")
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DescribeInstanceHealth")
+	c.Assert(values.Get("Instances.member.1.InstanceId"), Equals, "i-b44db8ca")
+	c.Assert(values.Get("Instances.member.2.InstanceId"), Equals, "i-461ecf38")
+}
+
 func (s *S) TestDescribeInstanceHealthBadRequest(c *C) {
 	testServer.PrepareResponse(400, nil, DescribeInstanceHealthBadRequest)
 	resp, err := s.elb.DescribeInstanceHealth("testlb", "i-foooo")
@@ -316,3 +350,158 @@ func (s *S) TestConfigureHealthCheckBadRequest(c *C) {
 	c.Assert(err, NotNil)
 	c.Assert(err, ErrorMatches, ".*foolb.*(LoadBalancerNotFound).*")
 }
+
+func (s *S) TestConfigureHealthCheckIsValidatedBeforeSending(c *C) {
+	hc := elb.HealthCheck{
+		HealthyThreshold:   10,
+		Interval:           5,
+		Target:             "HTTP:80/",
+		Timeout:            5,
+		UnhealthyThreshold: 2,
+	}
+	resp, err := s.elb.ConfigureHealthCheck("testlb", &hc)
+	c.Assert(resp, IsNil)
+	c.Assert(err, ErrorMatches, `HealthCheck Interval must be greater than the Timeout. \(ValidationError\)`)
+}
+
+func (s *S) TestSkipValidation(c *C) {
+	testServer.PrepareResponse(200, nil, ConfigureHealthCheck)
+	s.elb.SkipValidation = true
+	defer func() { s.elb.SkipValidation = false }()
+	hc := elb.HealthCheck{Target: "HTTP:80"}
+	_, err := s.elb.ConfigureHealthCheck("testlb", &hc)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("HealthCheck.Target"), Equals, "HTTP:80")
+}
+
+func (s *S) TestDescribeLoadBalancerAttributes(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeLoadBalancerAttributes)
+	resp, err := s.elb.DescribeLoadBalancerAttributes("testlb")
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Version"), Equals, "2012-06-01")
+	c.Assert(values.Get("Signature"), Not(Equals), "")
+	c.Assert(values.Get("Timestamp"), Not(Equals), "")
+	c.Assert(values.Get("Action"), Equals, "DescribeLoadBalancerAttributes")
+	c.Assert(values.Get("LoadBalancerName"), Equals, "testlb")
+	c.Assert(resp.LoadBalancerAttributes.ConnectionDraining, DeepEquals, elb.ConnectionDraining{Enabled: true, Timeout: 60})
+}
+
+func (s *S) TestModifyLoadBalancerAttributes(c *C) {
+	testServer.PrepareResponse(200, nil, ModifyLoadBalancerAttributes)
+	attrs := elb.LoadBalancerAttributes{
+		ConnectionDraining: elb.ConnectionDraining{Enabled: true, Timeout: 60},
+	}
+	resp, err := s.elb.ModifyLoadBalancerAttributes("testlb", &attrs)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "ModifyLoadBalancerAttributes")
+	c.Assert(values.Get("LoadBalancerName"), Equals, "testlb")
+	c.Assert(values.Get("LoadBalancerAttributes.ConnectionDraining.Enabled"), Equals, "true")
+	c.Assert(values.Get("LoadBalancerAttributes.ConnectionDraining.Timeout"), Equals, "60")
+	c.Assert(resp.LoadBalancerName, Equals, "testlb")
+	c.Assert(resp.LoadBalancerAttributes, DeepEquals, attrs)
+}
+
+func (s *S) TestModifyLoadBalancerAttributesDisableDraining(c *C) {
+	testServer.PrepareResponse(200, nil, ModifyLoadBalancerAttributes)
+	_, err := s.elb.ModifyLoadBalancerAttributes("testlb", &elb.LoadBalancerAttributes{})
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("LoadBalancerAttributes.ConnectionDraining.Enabled"), Equals, "false")
+	_, ok := values["LoadBalancerAttributes.ConnectionDraining.Timeout"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *S) TestCreateLoadBalancerListeners(c *C) {
+	testServer.PrepareResponse(200, nil, CreateLoadBalancerListeners)
+	listener := elb.Listener{
+		InstancePort:     80,
+		InstanceProtocol: "http",
+		LoadBalancerPort: 443,
+		Protocol:         "https",
+		SSLCertificateId: "arn:aws:iam::123456789012:server-certificate/cert",
+	}
+	resp, err := s.elb.CreateLoadBalancerListeners("testlb", listener)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "CreateLoadBalancerListeners")
+	c.Assert(values.Get("LoadBalancerName"), Equals, "testlb")
+	c.Assert(values.Get("Listeners.member.1.InstancePort"), Equals, "80")
+	c.Assert(values.Get("Listeners.member.1.InstanceProtocol"), Equals, "http")
+	c.Assert(values.Get("Listeners.member.1.LoadBalancerPort"), Equals, "443")
+	c.Assert(values.Get("Listeners.member.1.Protocol"), Equals, "https")
+	c.Assert(values.Get("Listeners.member.1.SSLCertificateId"), Equals, "arn:aws:iam::123456789012:server-certificate/cert")
+	c.Assert(resp.RequestId, Equals, "1549581b-12b7-11e3-895e-1334aEXAMPLE")
+}
+
+func (s *S) TestDeleteLoadBalancerListeners(c *C) {
+	testServer.PrepareResponse(200, nil, DeleteLoadBalancerListeners)
+	_, err := s.elb.DeleteLoadBalancerListeners("testlb", 80, 443)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DeleteLoadBalancerListeners")
+	c.Assert(values.Get("LoadBalancerName"), Equals, "testlb")
+	c.Assert(values.Get("LoadBalancerPorts.member.1"), Equals, "80")
+	c.Assert(values.Get("LoadBalancerPorts.member.2"), Equals, "443")
+}
+
+func (s *S) TestEnableAvailabilityZonesForLoadBalancer(c *C) {
+	testServer.PrepareResponse(200, nil, EnableAvailabilityZonesForLoadBalancer)
+	_, err := s.elb.EnableAvailabilityZonesForLoadBalancer("testlb", "us-east-1a", "us-east-1b")
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "EnableAvailabilityZonesForLoadBalancer")
+	c.Assert(values.Get("LoadBalancerName"), Equals, "testlb")
+	c.Assert(values.Get("AvailabilityZones.member.1"), Equals, "us-east-1a")
+	c.Assert(values.Get("AvailabilityZones.member.2"), Equals, "us-east-1b")
+}
+
+func (s *S) TestAttachLoadBalancerToSubnets(c *C) {
+	testServer.PrepareResponse(200, nil, AttachLoadBalancerToSubnets)
+	_, err := s.elb.AttachLoadBalancerToSubnets("testlb", "subnet-3561b05d", "subnet-119f0078")
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "AttachLoadBalancerToSubnets")
+	c.Assert(values.Get("Subnets.member.1"), Equals, "subnet-3561b05d")
+	c.Assert(values.Get("Subnets.member.2"), Equals, "subnet-119f0078")
+}
+
+func (s *S) TestApplySecurityGroupsToLoadBalancer(c *C) {
+	testServer.PrepareResponse(200, nil, ApplySecurityGroupsToLoadBalancer)
+	_, err := s.elb.ApplySecurityGroupsToLoadBalancer("testlb", "sg-123456789")
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "ApplySecurityGroupsToLoadBalancer")
+	c.Assert(values.Get("SecurityGroups.member.1"), Equals, "sg-123456789")
+}
+
+func (s *S) TestCreateLBCookieStickinessPolicy(c *C) {
+	testServer.PrepareResponse(200, nil, CreateLBCookieStickinessPolicy)
+	_, err := s.elb.CreateLBCookieStickinessPolicy("testlb", "sticky", 60)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "CreateLBCookieStickinessPolicy")
+	c.Assert(values.Get("LoadBalancerName"), Equals, "testlb")
+	c.Assert(values.Get("PolicyName"), Equals, "sticky")
+	c.Assert(values.Get("CookieExpirationPeriod"), Equals, "60")
+}
+
+func (s *S) TestSetLoadBalancerPoliciesOfListener(c *C) {
+	testServer.PrepareResponse(200, nil, SetLoadBalancerPoliciesOfListener)
+	_, err := s.elb.SetLoadBalancerPoliciesOfListener("testlb", 80, "sticky")
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "SetLoadBalancerPoliciesOfListener")
+	c.Assert(values.Get("LoadBalancerPort"), Equals, "80")
+	c.Assert(values.Get("PolicyNames.member.1"), Equals, "sticky")
+}
+
+func (s *S) TestSetLoadBalancerPoliciesOfListenerWithoutPolicies(c *C) {
+	testServer.PrepareResponse(200, nil, SetLoadBalancerPoliciesOfListener)
+	_, err := s.elb.SetLoadBalancerPoliciesOfListener("testlb", 80)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values["PolicyNames"], DeepEquals, []string{""})
+}
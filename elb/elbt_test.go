@@ -1,10 +1,20 @@
 package elb_test
 
 import (
+	"fmt"
 	"github.com/flaviamissi/go-elb/aws"
+	"github.com/flaviamissi/go-elb/ec2"
+	"github.com/flaviamissi/go-elb/ec2/ec2test"
 	"github.com/flaviamissi/go-elb/elb"
 	"github.com/flaviamissi/go-elb/elb/elbtest"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"time"
 )
 
 // LocalServer represents a local elbtest fake server.
@@ -234,6 +244,107 @@ func (s *LocalServerSuite) TestDescribeInstanceHealthChangingIt(c *C) {
 	c.Assert(resp.InstanceStates[0].ReasonCode, Equals, "Instance")
 }
 
+func (s *LocalServerSuite) TestDescribeInstanceHealthWithMultipleInstances(c *C) {
+	srv := s.srv.srv
+	instId1 := srv.NewInstance()
+	defer srv.RemoveInstance(instId1)
+	instId2 := srv.NewInstance()
+	defer srv.RemoveInstance(instId2)
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	_, err := s.clientTests.elb.RegisterInstancesWithLoadBalancer([]string{instId1, instId2}, "somelb")
+	c.Assert(err, IsNil)
+	srv.ChangeInstanceState("somelb", elb.InstanceState{InstanceId: instId2, State: "InService", ReasonCode: "N/A", Description: "N/A"})
+	resp, err := s.clientTests.elb.DescribeInstanceHealth("somelb", instId1, instId2)
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 2)
+	c.Assert(resp.InstanceStates[0].InstanceId, Equals, instId1)
+	c.Assert(resp.InstanceStates[0].State, Equals, "OutOfService")
+	c.Assert(resp.InstanceStates[1].InstanceId, Equals, instId2)
+	c.Assert(resp.InstanceStates[1].State, Equals, "InService")
+	resp, err = s.clientTests.elb.DescribeInstanceHealth("somelb", instId2)
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 1)
+	c.Assert(resp.InstanceStates[0].InstanceId, Equals, instId2)
+}
+
+func (s *LocalServerSuite) TestWaitForInstancesInService(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	instId1 := srv.NewInstance()
+	defer srv.RemoveInstance(instId1)
+	instId2 := srv.NewInstance()
+	defer srv.RemoveInstance(instId2)
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	srv.RegisterInstance(instId1, "somelb")
+	srv.RegisterInstance(instId2, "somelb")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		for _, id := range []string{instId1, instId2} {
+			srv.ChangeInstanceState("somelb", elb.InstanceState{InstanceId: id, State: "InService", ReasonCode: "N/A", Description: "N/A"})
+		}
+	}()
+	err := s.clientTests.elb.WaitForInstancesInService("somelb", []string{instId1, instId2}, 5*time.Second)
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestWaitForInstancesInServiceTimeout(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	instId1 := srv.NewInstance()
+	defer srv.RemoveInstance(instId1)
+	instId2 := srv.NewInstance()
+	defer srv.RemoveInstance(instId2)
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	srv.RegisterInstance(instId1, "somelb")
+	srv.RegisterInstance(instId2, "somelb")
+	srv.ChangeInstanceState("somelb", elb.InstanceState{InstanceId: instId1, State: "InService", ReasonCode: "N/A", Description: "N/A"})
+	state := elb.InstanceState{
+		Description: "Instance has failed at least the UnhealthyThreshold number of health checks consecutively.",
+		InstanceId:  instId2,
+		State:       "OutOfService",
+		ReasonCode:  "Instance",
+	}
+	srv.ChangeInstanceState("somelb", state)
+	err := s.clientTests.elb.WaitForInstancesInService("somelb", nil, 30*time.Millisecond)
+	c.Assert(err, NotNil)
+	e, ok := err.(*elb.WaitTimeoutError)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.LoadBalancerName, Equals, "somelb")
+	c.Assert(e.State, Equals, "InService")
+	c.Assert(e.InstanceStates, DeepEquals, []elb.InstanceState{state})
+	c.Assert(err, ErrorMatches, `timed out after 30ms waiting for instances of load balancer "somelb" to be InService; `+instId2+` is OutOfService \(Instance: .*\)`)
+}
+
+func (s *LocalServerSuite) TestWaitForInstancesOutOfService(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	srv.RegisterInstance(instId, "somelb")
+	srv.ChangeInstanceState("somelb", elb.InstanceState{InstanceId: instId, State: "InService", ReasonCode: "N/A", Description: "N/A"})
+	err := s.clientTests.elb.WaitForInstancesOutOfService("somelb", []string{instId}, 20*time.Millisecond)
+	c.Assert(err, FitsTypeOf, &elb.WaitTimeoutError{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		srv.ChangeInstanceState("somelb", elb.InstanceState{InstanceId: instId, State: "OutOfService", ReasonCode: "Instance", Description: "Instance is in stopped state."})
+	}()
+	err = s.clientTests.elb.WaitForInstancesOutOfService("somelb", []string{instId}, 5*time.Second)
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestWaitForInstancesInServiceWithAbsentLoadBalancer(c *C) {
+	err := s.clientTests.elb.WaitForInstancesInService("absentlb", nil, time.Second)
+	c.Assert(err, ErrorMatches, `^There is no ACTIVE Load Balancer named 'absentlb' \(LoadBalancerNotFound\)$`)
+}
+
 func (s *LocalServerSuite) TestConfigureHealthCheck(c *C) {
 	s.clientTests.TestConfigureHealthCheck(c)
 }
@@ -241,3 +352,1319 @@ func (s *LocalServerSuite) TestConfigureHealthCheck(c *C) {
 func (s *LocalServerSuite) TestConfigureHealthCheckBadRequest(c *C) {
 	s.clientTests.TestConfigureHealthCheckBadRequest(c)
 }
+
+func (s *LocalServerSuite) TestConfigureHealthCheckWithTCPTarget(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	hc := elb.HealthCheck{
+		HealthyThreshold:   10,
+		Interval:           30,
+		Target:             "tcp:8080",
+		Timeout:            5,
+		UnhealthyThreshold: 2,
+	}
+	resp, err := s.clientTests.elb.ConfigureHealthCheck("somelb", &hc)
+	c.Assert(err, IsNil)
+	c.Assert(resp.HealthCheck.Target, Equals, "TCP:8080")
+}
+
+func (s *LocalServerSuite) TestConfigureHealthCheckRejectsTCPTargetWithPath(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	e := s.clientTests.elb
+	e.SkipValidation = true
+	defer func() { e.SkipValidation = false }()
+	hc := elb.HealthCheck{
+		HealthyThreshold:   10,
+		Interval:           30,
+		Target:             "TCP:80/",
+		Timeout:            5,
+		UnhealthyThreshold: 2,
+	}
+	resp, err := e.ConfigureHealthCheck("somelb", &hc)
+	c.Assert(resp, IsNil)
+	c.Assert(err, ErrorMatches, `HealthCheck TCP Target must specify a port only, e.g. TCP:80 \(ValidationError\)`)
+	c.Assert(err.(*elb.Error).StatusCode, Equals, 400)
+}
+
+func (s *LocalServerSuite) setInService(lbName string, instIds ...string) {
+	for _, id := range instIds {
+		s.srv.srv.ChangeInstanceState(lbName, elb.InstanceState{InstanceId: id, State: "InService", ReasonCode: "N/A", Description: "N/A"})
+	}
+}
+
+func (s *LocalServerSuite) registeredInstances(c *C, lbName string) []string {
+	resp, err := s.clientTests.elb.DescribeLoadBalancers(lbName)
+	c.Assert(err, IsNil)
+	var ids []string
+	for _, inst := range resp.LoadBalancerDescriptions[0].Instances {
+		ids = append(ids, inst.InstanceId)
+	}
+	return ids
+}
+
+func (s *LocalServerSuite) TestReplaceInstances(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	var oldIds, newIds []string
+	for i := 0; i < 3; i++ {
+		oldIds = append(oldIds, srv.NewInstance())
+		newIds = append(newIds, srv.NewInstance())
+	}
+	for _, id := range oldIds {
+		srv.RegisterInstance(id, "somelb")
+	}
+	s.setInService("somelb", oldIds...)
+	var events []elb.ReplaceEvent
+	options := elb.ReplaceOptions{
+		BatchSize:         2,
+		MinHealthyPercent: 100,
+		HealthTimeout:     time.Second,
+		Progress: func(e elb.ReplaceEvent) {
+			events = append(events, e)
+			if e.Action == "register" {
+				s.setInService("somelb", e.InstanceIds...)
+			}
+		},
+	}
+	err := s.clientTests.elb.ReplaceInstances("somelb", oldIds, newIds, &options)
+	c.Assert(err, IsNil)
+	c.Assert(s.registeredInstances(c, "somelb"), DeepEquals, newIds)
+	c.Assert(events, DeepEquals, []elb.ReplaceEvent{
+		{Action: "register", InstanceIds: newIds[0:2], Batch: 1, Batches: 2},
+		{Action: "in-service", InstanceIds: newIds[0:2], Batch: 1, Batches: 2},
+		{Action: "deregister", InstanceIds: oldIds[0:2], Batch: 1, Batches: 2},
+		{Action: "register", InstanceIds: newIds[2:3], Batch: 2, Batches: 2},
+		{Action: "in-service", InstanceIds: newIds[2:3], Batch: 2, Batches: 2},
+		{Action: "deregister", InstanceIds: oldIds[2:3], Batch: 2, Batches: 2},
+	})
+}
+
+func (s *LocalServerSuite) TestReplaceInstancesRollsBackWhenNewInstancesAreUnhealthy(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	var oldIds, newIds []string
+	for i := 0; i < 2; i++ {
+		oldIds = append(oldIds, srv.NewInstance())
+		newIds = append(newIds, srv.NewInstance())
+	}
+	for _, id := range oldIds {
+		srv.RegisterInstance(id, "somelb")
+	}
+	s.setInService("somelb", oldIds...)
+	var actions []string
+	options := elb.ReplaceOptions{
+		HealthTimeout: 20 * time.Millisecond,
+		Rollback:      true,
+		Progress: func(e elb.ReplaceEvent) {
+			actions = append(actions, e.Action)
+			// Only the first new instance ever becomes healthy.
+			if e.Action == "register" && e.InstanceIds[0] == newIds[0] {
+				s.setInService("somelb", e.InstanceIds...)
+			}
+			if e.Action == "rollback-register" {
+				s.setInService("somelb", e.InstanceIds...)
+			}
+		},
+	}
+	err := s.clientTests.elb.ReplaceInstances("somelb", oldIds, newIds, &options)
+	c.Assert(err, FitsTypeOf, &elb.ReplaceError{})
+	rerr := err.(*elb.ReplaceError)
+	c.Assert(rerr.RolledBack, Equals, true)
+	c.Assert(rerr.RollbackErr, IsNil)
+	c.Assert(rerr.Err, FitsTypeOf, &elb.WaitTimeoutError{})
+	c.Assert(err, ErrorMatches, "cannot replace instances: timed out .*; rolled back")
+	c.Assert(actions, DeepEquals, []string{"register", "in-service", "deregister", "register", "rollback-register", "rollback-deregister"})
+	c.Assert(s.registeredInstances(c, "somelb"), DeepEquals, []string{oldIds[1], oldIds[0]})
+}
+
+func (s *LocalServerSuite) TestReplaceInstancesKeepsMinimumHealthy(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	oldIds := []string{srv.NewInstance(), srv.NewInstance()}
+	newIds := []string{srv.NewInstance()}
+	for _, id := range oldIds {
+		srv.RegisterInstance(id, "somelb")
+	}
+	s.setInService("somelb", oldIds...)
+	options := elb.ReplaceOptions{
+		MinHealthyPercent: 100,
+		HealthTimeout:     time.Second,
+		Progress: func(e elb.ReplaceEvent) {
+			if e.Action == "register" {
+				s.setInService("somelb", e.InstanceIds...)
+			}
+		},
+	}
+	err := s.clientTests.elb.ReplaceInstances("somelb", oldIds, newIds, &options)
+	c.Assert(err, ErrorMatches, `cannot replace instances: deregistering \[`+oldIds[1]+`\] would leave 1 healthy instances, at least 2 are required`)
+	c.Assert(s.registeredInstances(c, "somelb"), DeepEquals, []string{oldIds[1], newIds[0]})
+}
+
+func (s *LocalServerSuite) enableDraining(c *C, lbName string, timeout int) {
+	attrs := elb.LoadBalancerAttributes{
+		ConnectionDraining: elb.ConnectionDraining{Enabled: true, Timeout: timeout},
+	}
+	_, err := s.clientTests.elb.ModifyLoadBalancerAttributes(lbName, &attrs)
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestLoadBalancerAttributes(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	resp, err := s.clientTests.elb.DescribeLoadBalancerAttributes("somelb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.LoadBalancerAttributes.ConnectionDraining, DeepEquals, elb.ConnectionDraining{Enabled: false, Timeout: 300})
+	s.enableDraining(c, "somelb", 60)
+	resp, err = s.clientTests.elb.DescribeLoadBalancerAttributes("somelb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.LoadBalancerAttributes.ConnectionDraining, DeepEquals, elb.ConnectionDraining{Enabled: true, Timeout: 60})
+	attrs := elb.LoadBalancerAttributes{
+		ConnectionDraining: elb.ConnectionDraining{Enabled: true, Timeout: -1},
+	}
+	_, err = s.clientTests.elb.ModifyLoadBalancerAttributes("somelb", &attrs)
+	c.Assert(err, ErrorMatches, `ConnectionDraining timeout must be between 1 and 3600 seconds. \(ValidationError\)`)
+
+	// Draining can be disabled without giving a timeout, which keeps the
+	// current one.
+	_, err = s.clientTests.elb.ModifyLoadBalancerAttributes("somelb", &elb.LoadBalancerAttributes{})
+	c.Assert(err, IsNil)
+	resp, err = s.clientTests.elb.DescribeLoadBalancerAttributes("somelb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.LoadBalancerAttributes.ConnectionDraining, DeepEquals, elb.ConnectionDraining{Enabled: false, Timeout: 60})
+	_, err = s.clientTests.elb.DescribeLoadBalancerAttributes("absentlb")
+	c.Assert(err, ErrorMatches, `^There is no ACTIVE Load Balancer named 'absentlb' \(LoadBalancerNotFound\)$`)
+}
+
+func (s *LocalServerSuite) TestDeregisterInstancesAndDrainWithoutDraining(c *C) {
+	srv := s.srv.srv
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	srv.RegisterInstance(instId, "somelb")
+	err := s.clientTests.elb.DeregisterInstancesAndDrain("somelb", []string{instId})
+	c.Assert(err, IsNil)
+	resp, err := s.clientTests.elb.DescribeInstanceHealth("somelb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 0)
+}
+
+func (s *LocalServerSuite) TestDeregisterInstancesAndDrain(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	instId1 := srv.NewInstance()
+	defer srv.RemoveInstance(instId1)
+	instId2 := srv.NewInstance()
+	defer srv.RemoveInstance(instId2)
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	srv.RegisterInstance(instId1, "somelb")
+	srv.RegisterInstance(instId2, "somelb")
+	s.setInService("somelb", instId1, instId2)
+	s.enableDraining(c, "somelb", 300)
+	done := make(chan error)
+	go func() {
+		done <- s.clientTests.elb.DeregisterInstancesAndDrain("somelb", []string{instId1})
+	}()
+	select {
+	case err := <-done:
+		c.Fatalf("deregistration returned before draining finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Assert(s.registeredInstances(c, "somelb"), DeepEquals, []string{instId2})
+	resp, err := s.clientTests.elb.DescribeInstanceHealth("somelb", instId1)
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, DeepEquals, []elb.InstanceState{{
+		Description: "Instance deregistration currently in progress.",
+		InstanceId:  instId1,
+		ReasonCode:  "ELB",
+		State:       "OutOfService",
+	}})
+	srv.FinishDraining("somelb", instId1)
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatalf("deregistration did not return after draining finished")
+	}
+	resp, err = s.clientTests.elb.DescribeInstanceHealth("somelb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 1)
+	c.Assert(resp.InstanceStates[0].InstanceId, Equals, instId2)
+}
+
+func (s *LocalServerSuite) TestDeregisterInstancesAndDrainTimeout(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	srv.RegisterInstance(instId, "somelb")
+	s.enableDraining(c, "somelb", 1)
+	start := time.Now()
+	err := s.clientTests.elb.DeregisterInstancesAndDrain("somelb", []string{instId})
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(start) >= time.Second, Equals, true)
+	resp, err := s.clientTests.elb.DescribeInstanceHealth("somelb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 0)
+}
+
+func (s *LocalServerSuite) TestPlanAndApplyCreatesLoadBalancer(c *C) {
+	srv := s.srv.srv
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	defer srv.RemoveLoadBalancer("planlb")
+	spec := elb.LoadBalancerSpec{
+		Name: "planlb",
+		Listeners: []elb.ListenerDescription{{
+			Listener: elb.Listener{
+				InstancePort:     8080,
+				InstanceProtocol: "http",
+				LoadBalancerPort: 80,
+				Protocol:         "http",
+			},
+			PolicyNames: []string{"sticky"},
+		}},
+		HealthCheck: &elb.HealthCheck{
+			HealthyThreshold:   10,
+			Interval:           30,
+			Target:             "HTTP:8080/ping",
+			Timeout:            5,
+			UnhealthyThreshold: 2,
+		},
+		AvailZones: []string{"us-east-1a"},
+		LBCookieStickinessPolicies: []elb.LBCookieStickinessPolicies{
+			{PolicyName: "sticky", CookieExpirationPeriod: 60},
+		},
+		Instances: []string{instId},
+	}
+	plan, err := s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	expected := `load balancer "planlb":
+  CreateLoadBalancer listeners=HTTP:80->HTTP:8080 zones=us-east-1a
+  CreateLBCookieStickinessPolicy sticky expiration=60
+  SetLoadBalancerPoliciesOfListener port=80 policies=sticky
+  ConfigureHealthCheck target=HTTP:8080/ping interval=30 timeout=5 healthy=10 unhealthy=2
+  RegisterInstancesWithLoadBalancer ` + instId + "\n"
+	c.Assert(plan.String(), Equals, expected)
+	err = s.clientTests.elb.Apply(plan)
+	c.Assert(err, IsNil)
+	resp, err := s.clientTests.elb.DescribeLoadBalancers("planlb")
+	c.Assert(err, IsNil)
+	lb := resp.LoadBalancerDescriptions[0]
+	c.Assert(lb.AvailZones, DeepEquals, []string{"us-east-1a"})
+	c.Assert(lb.HealthCheck, DeepEquals, *spec.HealthCheck)
+	c.Assert(lb.ListenerDescriptions, DeepEquals, []elb.ListenerDescription{{
+		Listener: elb.Listener{
+			InstancePort:     8080,
+			InstanceProtocol: "HTTP",
+			LoadBalancerPort: 80,
+			Protocol:         "HTTP",
+		},
+		PolicyNames: []string{"sticky"},
+	}})
+	c.Assert(lb.Policies.LBCookieStickinessPolicies, DeepEquals, spec.LBCookieStickinessPolicies)
+	c.Assert(lb.Instances, DeepEquals, []elb.Instance{{InstanceId: instId}})
+	plan, err = s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 0)
+	c.Assert(plan.String(), Equals, "load balancer \"planlb\" is up to date\n")
+}
+
+func (s *LocalServerSuite) TestPlanAndApplyUpdatesLoadBalancer(c *C) {
+	srv := s.srv.srv
+	defer srv.RemoveLoadBalancer("planlb")
+	e := s.clientTests.elb
+	_, err := e.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "planlb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners: []elb.Listener{
+			{InstancePort: 80, InstanceProtocol: "http", LoadBalancerPort: 80, Protocol: "http"},
+			{InstancePort: 8443, InstanceProtocol: "tcp", LoadBalancerPort: 8443, Protocol: "tcp"},
+		},
+	})
+	c.Assert(err, IsNil)
+	_, err = e.CreateAppCookieStickinessPolicy("planlb", "old", "session")
+	c.Assert(err, IsNil)
+	_, err = e.SetLoadBalancerPoliciesOfListener("planlb", 80, "old")
+	c.Assert(err, IsNil)
+	spec := elb.LoadBalancerSpec{
+		Name: "planlb",
+		Listeners: []elb.ListenerDescription{
+			{
+				Listener:    elb.Listener{InstancePort: 8080, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"},
+				PolicyNames: []string{"sticky"},
+			},
+			{
+				Listener: elb.Listener{InstancePort: 443, InstanceProtocol: "TCP", LoadBalancerPort: 443, Protocol: "TCP"},
+			},
+		},
+		AvailZones: []string{"us-east-1b"},
+		LBCookieStickinessPolicies: []elb.LBCookieStickinessPolicies{
+			{PolicyName: "sticky", CookieExpirationPeriod: 60},
+		},
+	}
+	plan, err := e.Plan(&spec)
+	c.Assert(err, IsNil)
+	expected := `load balancer "planlb":
+  EnableAvailabilityZonesForLoadBalancer us-east-1b
+  CreateLBCookieStickinessPolicy sticky expiration=60
+  DeleteLoadBalancerListeners 80 8443
+  CreateLoadBalancerListeners HTTP:80->HTTP:8080,TCP:443->TCP:443
+  SetLoadBalancerPoliciesOfListener port=80 policies=sticky
+  DeleteLoadBalancerPolicy old
+  DisableAvailabilityZonesForLoadBalancer us-east-1a
+`
+	c.Assert(plan.String(), Equals, expected)
+	err = e.Apply(plan)
+	c.Assert(err, IsNil)
+	resp, err := e.DescribeLoadBalancers("planlb")
+	c.Assert(err, IsNil)
+	lb := resp.LoadBalancerDescriptions[0]
+	c.Assert(lb.AvailZones, DeepEquals, []string{"us-east-1b"})
+	c.Assert(lb.ListenerDescriptions, DeepEquals, spec.Listeners)
+	c.Assert(lb.Policies.AppCookieStickinessPolicies, HasLen, 0)
+	c.Assert(lb.Policies.LBCookieStickinessPolicies, DeepEquals, spec.LBCookieStickinessPolicies)
+	plan, err = e.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 0)
+}
+
+func (s *LocalServerSuite) TestPlanLeavesUnmanagedSettingsAlone(c *C) {
+	srv := s.srv.srv
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	defer srv.RemoveLoadBalancer("planlb")
+	listener := elb.Listener{InstancePort: 80, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"}
+	_, err := s.clientTests.elb.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "planlb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{listener},
+	})
+	c.Assert(err, IsNil)
+	srv.RegisterInstance(instId, "planlb")
+	spec := elb.LoadBalancerSpec{
+		Name:      "planlb",
+		Listeners: []elb.ListenerDescription{{Listener: listener}},
+	}
+	plan, err := s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 0)
+	spec.Instances = []string{}
+	plan, err = s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(plan.String(), Equals, "load balancer \"planlb\":\n  DeregisterInstancesFromLoadBalancer "+instId+"\n")
+	spec.Instances = nil
+	spec.AvailZones = []string{}
+	plan, err = s.clientTests.elb.Plan(&spec)
+	c.Assert(plan, IsNil)
+	c.Assert(err, ErrorMatches, `cannot remove all availability zones of load balancer "planlb"`)
+}
+
+func (s *LocalServerSuite) TestPlanHealthCheckTargetCase(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("planlb")
+	defer srv.RemoveLoadBalancer("planlb")
+	spec := elb.LoadBalancerSpec{
+		Name: "planlb",
+		HealthCheck: &elb.HealthCheck{
+			HealthyThreshold:   10,
+			Interval:           30,
+			Target:             "http:80/ping",
+			Timeout:            5,
+			UnhealthyThreshold: 2,
+		},
+	}
+	plan, err := s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 1)
+	c.Assert(s.clientTests.elb.Apply(plan), IsNil)
+	plan, err = s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 0)
+}
+
+func (s *LocalServerSuite) TestPlanRejectsSchemeChange(c *C) {
+	defer s.srv.srv.RemoveLoadBalancer("planlb")
+	_, err := s.clientTests.elb.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "planlb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 80, InstanceProtocol: "http", LoadBalancerPort: 80, Protocol: "http"}},
+	})
+	c.Assert(err, IsNil)
+	plan, err := s.clientTests.elb.Plan(&elb.LoadBalancerSpec{Name: "planlb", Scheme: "internal"})
+	c.Assert(plan, IsNil)
+	c.Assert(err, ErrorMatches, `cannot change scheme of load balancer "planlb" from internet-facing to internal`)
+}
+
+func (s *LocalServerSuite) TestPlanRejectsPolicyChange(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("planlb")
+	defer srv.RemoveLoadBalancer("planlb")
+	_, err := s.clientTests.elb.CreateLBCookieStickinessPolicy("planlb", "sticky", 60)
+	c.Assert(err, IsNil)
+	spec := elb.LoadBalancerSpec{
+		Name: "planlb",
+		LBCookieStickinessPolicies: []elb.LBCookieStickinessPolicies{
+			{PolicyName: "sticky", CookieExpirationPeriod: 120},
+		},
+	}
+	plan, err := s.clientTests.elb.Plan(&spec)
+	c.Assert(plan, IsNil)
+	c.Assert(err, ErrorMatches, `policy "sticky" of load balancer "planlb" cannot be modified`)
+}
+
+func (s *LocalServerSuite) TestApplyStopsAtFirstFailure(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("planlb")
+	defer srv.RemoveLoadBalancer("planlb")
+	spec := elb.LoadBalancerSpec{
+		Name:       "planlb",
+		AvailZones: []string{"us-east-1a"},
+		Instances:  []string{"i-unknown"},
+	}
+	plan, err := s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 2)
+	err = s.clientTests.elb.Apply(plan)
+	aerr, ok := err.(*elb.ApplyError)
+	c.Assert(ok, Equals, true)
+	c.Assert(aerr.Change, Equals, plan.Changes[1])
+	c.Assert(aerr.Err, ErrorMatches, ".*InvalidInstance.*")
+	c.Assert(err, ErrorMatches, "cannot apply RegisterInstancesWithLoadBalancer i-unknown: .*")
+}
+
+func (s *LocalServerSuite) TestInjectFaultFailsNextCalls(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("testlb")
+	defer srv.RemoveLoadBalancer("testlb")
+	defer srv.ClearFaults()
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{
+		Count: 2,
+		Err:   &elb.Error{StatusCode: 400, Code: "Throttling", Message: "Rate exceeded"},
+	})
+	for i := 0; i < 2; i++ {
+		_, err := s.clientTests.elb.DescribeLoadBalancers("testlb")
+		c.Assert(err, DeepEquals, &elb.Error{StatusCode: 400, Code: "Throttling", Message: "Rate exceeded"})
+	}
+	_, err := s.clientTests.elb.DescribeLoadBalancers("testlb")
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestInjectFaultDefaultsToInternalFailure(c *C) {
+	srv := s.srv.srv
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{})
+	for i := 0; i < 3; i++ {
+		_, err := s.clientTests.elb.DescribeLoadBalancers()
+		c.Assert(err, NotNil)
+		e, ok := err.(*elb.Error)
+		c.Assert(ok, Equals, true)
+		c.Assert(e.StatusCode, Equals, 500)
+		c.Assert(e.Code, Equals, "InternalFailure")
+	}
+	srv.ClearFaults()
+	_, err := s.clientTests.elb.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestInjectFaultMatchesParams(c *C) {
+	srv := s.srv.srv
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	srv.NewLoadBalancer("goodlb")
+	defer srv.RemoveLoadBalancer("goodlb")
+	srv.NewLoadBalancer("badlb")
+	defer srv.RemoveLoadBalancer("badlb")
+	defer srv.ClearFaults()
+	srv.InjectFault("RegisterInstancesWithLoadBalancer", elbtest.Fault{
+		Params: map[string]string{"LoadBalancerName": "badlb"},
+		Err:    &elb.Error{Code: "LoadBalancerNotFound", Message: "There is no ACTIVE Load Balancer named 'badlb'"},
+	})
+	_, err := s.clientTests.elb.RegisterInstancesWithLoadBalancer([]string{instId}, "goodlb")
+	c.Assert(err, IsNil)
+	_, err = s.clientTests.elb.RegisterInstancesWithLoadBalancer([]string{instId}, "badlb")
+	c.Assert(err, ErrorMatches, `There is no ACTIVE Load Balancer named 'badlb' \(LoadBalancerNotFound\)`)
+	c.Assert(err.(*elb.Error).StatusCode, Equals, 400)
+}
+
+func (s *LocalServerSuite) TestInjectFaultWithProbability(c *C) {
+	srv := s.srv.srv
+	defer srv.ClearFaults()
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{Probability: 0.5})
+	var failed int
+	for i := 0; i < 100; i++ {
+		if _, err := s.clientTests.elb.DescribeLoadBalancers(); err != nil {
+			failed++
+		}
+	}
+	c.Assert(failed > 0, Equals, true)
+	c.Assert(failed < 100, Equals, true)
+}
+
+func (s *LocalServerSuite) TestInjectFaultSparedByChanceTriesNextFault(c *C) {
+	srv := s.srv.srv
+	defer srv.ClearFaults()
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{Probability: 1e-12})
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{
+		Err: &elb.Error{StatusCode: 400, Code: "Throttling", Message: "Rate exceeded"},
+	})
+	_, err := s.clientTests.elb.DescribeLoadBalancers()
+	c.Assert(err, ErrorMatches, `Rate exceeded \(Throttling\)`)
+}
+
+func (s *LocalServerSuite) TestInjectFaultNotConsumedByRejectedRequests(c *C) {
+	srv, err := elbtest.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	auth := aws.Auth{"key", "secret"}
+	srv.SetCredentials(auth)
+	region := aws.Region{ELBEndpoint: srv.URL()}
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{
+		Count: 1,
+		Err:   &elb.Error{StatusCode: 400, Code: "Throttling", Message: "Rate exceeded"},
+	})
+	_, err = elb.New(aws.Auth{"key", "wrong"}, region).DescribeLoadBalancers()
+	c.Assert(err, ErrorMatches, `.*\(SignatureDoesNotMatch\)`)
+	client := elb.New(auth, region)
+	_, err = client.DescribeLoadBalancers()
+	c.Assert(err, ErrorMatches, `Rate exceeded \(Throttling\)`)
+	_, err = client.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+}
+
+func (s *LocalServerSuite) TestInjectFaultAddsLatency(c *C) {
+	srv := s.srv.srv
+	defer srv.ClearFaults()
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{Count: 1, Latency: 50 * time.Millisecond})
+	start := time.Now()
+	_, err := s.clientTests.elb.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(start) >= 50*time.Millisecond, Equals, true)
+	start = time.Now()
+	_, err = s.clientTests.elb.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(start) < 50*time.Millisecond, Equals, true)
+}
+
+func (s *LocalServerSuite) TestReplaceInstancesRollsBackWhenDeregistrationFails(c *C) {
+	elb.FastWait(true)
+	defer elb.FastWait(false)
+	srv := s.srv.srv
+	srv.NewLoadBalancer("somelb")
+	defer srv.RemoveLoadBalancer("somelb")
+	oldId := srv.NewInstance()
+	newId := srv.NewInstance()
+	srv.RegisterInstance(oldId, "somelb")
+	s.setInService("somelb", oldId)
+	defer srv.ClearFaults()
+	srv.InjectFault("DeregisterInstancesFromLoadBalancer", elbtest.Fault{
+		Params: map[string]string{"Instances.member.1.InstanceId": oldId},
+		Err:    &elb.Error{StatusCode: 503, Code: "ServiceUnavailable", Message: "Service is unavailable"},
+	})
+	var actions []string
+	options := elb.ReplaceOptions{
+		HealthTimeout: time.Second,
+		Rollback:      true,
+		Progress: func(e elb.ReplaceEvent) {
+			actions = append(actions, e.Action)
+			if e.Action == "register" {
+				s.setInService("somelb", e.InstanceIds...)
+			}
+		},
+	}
+	err := s.clientTests.elb.ReplaceInstances("somelb", []string{oldId}, []string{newId}, &options)
+	c.Assert(err, ErrorMatches, `cannot replace instances: Service is unavailable \(ServiceUnavailable\); rolled back`)
+	c.Assert(actions, DeepEquals, []string{"register", "in-service", "rollback-deregister"})
+	c.Assert(s.registeredInstances(c, "somelb"), DeepEquals, []string{oldId})
+}
+
+func (s *LocalServerSuite) TestActionsRecordsRequests(c *C) {
+	srv := s.srv.srv
+	srv.ClearActions()
+	defer srv.RemoveLoadBalancer("testlb")
+	_, err := s.clientTests.elb.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "testlb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 80, InstanceProtocol: "http", LoadBalancerPort: 80, Protocol: "http"}},
+	})
+	c.Assert(err, IsNil)
+	_, err = s.clientTests.elb.DescribeLoadBalancers("absentlb")
+	c.Assert(err, NotNil)
+	actions := srv.Actions()
+	c.Assert(actions, HasLen, 2)
+	c.Assert(actions[0].Request.Get("Action"), Equals, "CreateLoadBalancer")
+	c.Assert(actions[0].Request.Get("LoadBalancerName"), Equals, "testlb")
+	c.Assert(actions[0].Response, FitsTypeOf, elb.CreateLoadBalancerResp{})
+	c.Assert(actions[0].Err, IsNil)
+	c.Assert(actions[1].Request.Get("Action"), Equals, "DescribeLoadBalancers")
+	c.Assert(actions[1].Response, IsNil)
+	c.Assert(actions[1].Err, NotNil)
+	c.Assert(actions[1].Err.Code, Equals, "LoadBalancerNotFound")
+	c.Assert(actions[0].RequestId, Not(Equals), actions[1].RequestId)
+}
+
+func (s *LocalServerSuite) TestActionsSinceAndFilter(c *C) {
+	srv := s.srv.srv
+	srv.NewLoadBalancer("planlb")
+	defer srv.RemoveLoadBalancer("planlb")
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	spec := elb.LoadBalancerSpec{
+		Name:       "planlb",
+		AvailZones: []string{"us-east-1a", "us-east-1b"},
+		Instances:  []string{instId},
+	}
+	n := len(srv.Actions())
+	plan, err := s.clientTests.elb.Plan(&spec)
+	c.Assert(err, IsNil)
+	c.Assert(s.clientTests.elb.Apply(plan), IsNil)
+	var names []string
+	for _, a := range srv.ActionsSince(n) {
+		names = append(names, a.Request.Get("Action"))
+	}
+	c.Assert(names, DeepEquals, []string{
+		"DescribeLoadBalancers",
+		"EnableAvailabilityZonesForLoadBalancer",
+		"RegisterInstancesWithLoadBalancer",
+	})
+	registers := srv.ActionsSince(n, "RegisterInstancesWithLoadBalancer", "DeregisterInstancesFromLoadBalancer")
+	c.Assert(registers, HasLen, 1)
+	c.Assert(registers[0].Request.Get("Instances.member.1.InstanceId"), Equals, instId)
+	c.Assert(len(srv.Actions("RegisterInstancesWithLoadBalancer")) >= 1, Equals, true)
+	c.Assert(srv.ActionsSince(n+100), HasLen, 0)
+	c.Assert(srv.ActionsSince(-1), DeepEquals, srv.Actions())
+	srv.ClearActions()
+	c.Assert(srv.Actions(), HasLen, 0)
+}
+
+func (s *LocalServerSuite) TestActionsRecordsInjectedFaults(c *C) {
+	srv := s.srv.srv
+	defer srv.ClearFaults()
+	srv.InjectFault("DescribeLoadBalancers", elbtest.Fault{Count: 1})
+	n := len(srv.Actions())
+	_, err := s.clientTests.elb.DescribeLoadBalancers()
+	c.Assert(err, NotNil)
+	_, err = s.clientTests.elb.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+	actions := srv.ActionsSince(n)
+	c.Assert(actions, HasLen, 2)
+	c.Assert(actions[0].Err.Code, Equals, "InternalFailure")
+	c.Assert(actions[1].Err, IsNil)
+	c.Assert(actions[1].Response, NotNil)
+}
+
+// startBackends creates n instances and serves HTTP for each of them,
+// replying with the instance id. It returns the instance ids and a function
+// that stops the backends and removes the instances.
+func (s *LocalServerSuite) startBackends(n int, handler func(instId string, w http.ResponseWriter, req *http.Request)) ([]string, func()) {
+	srv := s.srv.srv
+	var ids []string
+	var backends []*httptest.Server
+	for i := 0; i < n; i++ {
+		instId := srv.NewInstance()
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if handler != nil {
+				handler(instId, w, req)
+			}
+			fmt.Fprint(w, instId)
+		}))
+		srv.SetInstanceBackend(instId, backend.Listener.Addr().String())
+		ids = append(ids, instId)
+		backends = append(backends, backend)
+	}
+	return ids, func() {
+		for i, backend := range backends {
+			backend.Close()
+			srv.RemoveInstance(ids[i])
+		}
+	}
+}
+
+func (s *LocalServerSuite) createForwardingLoadBalancer(c *C, lbName string, instIds ...string) string {
+	_, err := s.clientTests.elb.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       lbName,
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 8080, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"}},
+	})
+	c.Assert(err, IsNil)
+	if len(instIds) > 0 {
+		_, err = s.clientTests.elb.RegisterInstancesWithLoadBalancer(instIds, lbName)
+		c.Assert(err, IsNil)
+		s.setInService(lbName, instIds...)
+	}
+	addr := s.srv.srv.ListenerAddr(lbName, 80)
+	c.Assert(addr, Not(Equals), "")
+	return "http://" + addr + "/"
+}
+
+func get(c *C, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	return resp.StatusCode, string(body)
+}
+
+func (s *LocalServerSuite) TestListenerForwardsRoundRobinToInServiceInstances(c *C) {
+	ids, stop := s.startBackends(3, nil)
+	defer stop()
+	url := s.createForwardingLoadBalancer(c, "fwdlb", ids...)
+	defer s.clientTests.elb.DeleteLoadBalancer("fwdlb")
+	var got []string
+	for i := 0; i < 6; i++ {
+		code, body := get(c, http.DefaultClient, url)
+		c.Assert(code, Equals, http.StatusOK)
+		got = append(got, body)
+	}
+	c.Assert(got[0:3], DeepEquals, got[3:6])
+	seen := map[string]bool{got[0]: true, got[1]: true, got[2]: true}
+	c.Assert(seen, DeepEquals, map[string]bool{ids[0]: true, ids[1]: true, ids[2]: true})
+
+	s.srv.srv.ChangeInstanceState("fwdlb", elb.InstanceState{InstanceId: ids[0], State: "OutOfService"})
+	s.srv.srv.ChangeInstanceState("fwdlb", elb.InstanceState{InstanceId: ids[1], State: "OutOfService"})
+	for i := 0; i < 3; i++ {
+		_, body := get(c, http.DefaultClient, url)
+		c.Assert(body, Equals, ids[2])
+	}
+	_, err := s.clientTests.elb.DeregisterInstancesFromLoadBalancer([]string{ids[2]}, "fwdlb")
+	c.Assert(err, IsNil)
+	code, _ := get(c, http.DefaultClient, url)
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *LocalServerSuite) TestListenerWithLBCookieStickiness(c *C) {
+	ids, stop := s.startBackends(2, nil)
+	defer stop()
+	url := s.createForwardingLoadBalancer(c, "fwdlb", ids...)
+	defer s.clientTests.elb.DeleteLoadBalancer("fwdlb")
+	_, err := s.clientTests.elb.CreateLBCookieStickinessPolicy("fwdlb", "sticky", 60)
+	c.Assert(err, IsNil)
+	_, err = s.clientTests.elb.SetLoadBalancerPoliciesOfListener("fwdlb", 80, "sticky")
+	c.Assert(err, IsNil)
+	jar, err := cookiejar.New(nil)
+	c.Assert(err, IsNil)
+	client := &http.Client{Jar: jar}
+	_, first := get(c, client, url)
+	for i := 0; i < 4; i++ {
+		_, body := get(c, client, url)
+		c.Assert(body, Equals, first)
+	}
+	// The binding is dropped when the instance goes out of service.
+	s.srv.srv.ChangeInstanceState("fwdlb", elb.InstanceState{InstanceId: first, State: "OutOfService"})
+	_, body := get(c, client, url)
+	c.Assert(body, Not(Equals), first)
+}
+
+func (s *LocalServerSuite) TestListenerWithAppCookieStickiness(c *C) {
+	ids, stop := s.startBackends(2, func(instId string, w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "SESSION", Value: instId, Path: "/"})
+		}
+	})
+	defer stop()
+	url := s.createForwardingLoadBalancer(c, "fwdlb", ids...)
+	defer s.clientTests.elb.DeleteLoadBalancer("fwdlb")
+	_, err := s.clientTests.elb.CreateAppCookieStickinessPolicy("fwdlb", "sticky", "SESSION")
+	c.Assert(err, IsNil)
+	_, err = s.clientTests.elb.SetLoadBalancerPoliciesOfListener("fwdlb", 80, "sticky")
+	c.Assert(err, IsNil)
+	jar, err := cookiejar.New(nil)
+	c.Assert(err, IsNil)
+	client := &http.Client{Jar: jar}
+	// Without an application session requests are balanced.
+	_, first := get(c, client, url)
+	_, second := get(c, client, url)
+	c.Assert(first, Not(Equals), second)
+	_, owner := get(c, client, url+"login")
+	for i := 0; i < 4; i++ {
+		_, body := get(c, client, url)
+		c.Assert(body, Equals, owner)
+	}
+}
+
+func (s *LocalServerSuite) TestTCPListenerForwardsConnections(c *C) {
+	srv := s.srv.srv
+	instId := srv.NewInstance()
+	defer srv.RemoveInstance(instId)
+	backend, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := ioutil.ReadAll(conn)
+			fmt.Fprintf(conn, "%s: %s", instId, data)
+			conn.Close()
+		}
+	}()
+	srv.SetInstanceBackend(instId, backend.Addr().String())
+	_, err = s.clientTests.elb.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "tcplb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 2222, InstanceProtocol: "TCP", LoadBalancerPort: 22, Protocol: "TCP"}},
+	})
+	c.Assert(err, IsNil)
+	defer s.clientTests.elb.DeleteLoadBalancer("tcplb")
+	_, err = s.clientTests.elb.RegisterInstancesWithLoadBalancer([]string{instId}, "tcplb")
+	c.Assert(err, IsNil)
+	s.setInService("tcplb", instId)
+	conn, err := net.Dial("tcp", srv.ListenerAddr("tcplb", 22))
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	fmt.Fprint(conn, "hello")
+	conn.(*net.TCPConn).CloseWrite()
+	reply, err := ioutil.ReadAll(conn)
+	c.Assert(err, IsNil)
+	c.Assert(string(reply), Equals, instId+": hello")
+}
+
+func (s *LocalServerSuite) TestListenerIsClosedWhenDeleted(c *C) {
+	srv := s.srv.srv
+	s.createForwardingLoadBalancer(c, "fwdlb")
+	defer s.clientTests.elb.DeleteLoadBalancer("fwdlb")
+	_, err := s.clientTests.elb.CreateLoadBalancerListeners("fwdlb", elb.Listener{InstancePort: 8443, InstanceProtocol: "HTTP", LoadBalancerPort: 8080, Protocol: "HTTP"})
+	c.Assert(err, IsNil)
+	addr := srv.ListenerAddr("fwdlb", 8080)
+	c.Assert(addr, Not(Equals), "")
+	_, err = s.clientTests.elb.DeleteLoadBalancerListeners("fwdlb", 8080)
+	c.Assert(err, IsNil)
+	c.Assert(srv.ListenerAddr("fwdlb", 8080), Equals, "")
+	_, err = net.Dial("tcp", addr)
+	c.Assert(err, NotNil)
+	addr = srv.ListenerAddr("fwdlb", 80)
+	_, err = s.clientTests.elb.DeleteLoadBalancer("fwdlb")
+	c.Assert(err, IsNil)
+	c.Assert(srv.ListenerAddr("fwdlb", 80), Equals, "")
+	_, err = net.Dial("tcp", addr)
+	c.Assert(err, NotNil)
+}
+
+// newVirtualClockServer starts a server of its own with a virtual clock, so
+// that the shared server keeps running on the wall clock.
+func newVirtualClockServer(c *C) (*elbtest.Server, *elb.ELB) {
+	srv, err := elbtest.NewServer()
+	c.Assert(err, IsNil)
+	srv.SetVirtualClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	return srv, elb.New(aws.Auth{"abc", "123"}, aws.Region{ELBEndpoint: srv.URL()})
+}
+
+func instanceState(c *C, e *elb.ELB, lbName, instId string) elb.InstanceState {
+	resp, err := e.DescribeInstanceHealth(lbName, instId)
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 1)
+	return resp.InstanceStates[0]
+}
+
+func (s *LocalServerSuite) TestHealthCheckMovesInstancesBetweenStates(c *C) {
+	srv, client := newVirtualClockServer(c)
+	defer srv.Quit()
+	healthy := make(chan bool, 1)
+	healthy <- true
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ok := <-healthy
+		healthy <- ok
+		if !ok || req.URL.Path != "/ping" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+	setHealthy := func(ok bool) {
+		<-healthy
+		healthy <- ok
+	}
+	instId := srv.NewInstance()
+	srv.SetInstanceBackend(instId, backend.Listener.Addr().String())
+	unmapped := srv.NewInstance()
+	srv.NewLoadBalancer("hclb")
+	_, err := client.ConfigureHealthCheck("hclb", &elb.HealthCheck{
+		HealthyThreshold:   2,
+		Interval:           10,
+		Target:             "HTTP:80/ping",
+		Timeout:            5,
+		UnhealthyThreshold: 3,
+	})
+	c.Assert(err, IsNil)
+	_, err = client.RegisterInstancesWithLoadBalancer([]string{instId, unmapped}, "hclb")
+	c.Assert(err, IsNil)
+	pending := instanceState(c, client, "hclb", instId)
+	c.Assert(pending.State, Equals, "OutOfService")
+
+	srv.Advance(19 * time.Second)
+	c.Assert(instanceState(c, client, "hclb", instId), DeepEquals, pending)
+	srv.Advance(time.Second)
+	c.Assert(instanceState(c, client, "hclb", instId), DeepEquals, elb.InstanceState{
+		InstanceId:  instId,
+		State:       "InService",
+		ReasonCode:  "N/A",
+		Description: "N/A",
+	})
+
+	setHealthy(false)
+	srv.Advance(20 * time.Second)
+	c.Assert(instanceState(c, client, "hclb", instId).State, Equals, "InService")
+	srv.Advance(10 * time.Second)
+	c.Assert(instanceState(c, client, "hclb", instId), DeepEquals, elb.InstanceState{
+		InstanceId:  instId,
+		State:       "OutOfService",
+		ReasonCode:  "Instance",
+		Description: "Instance has failed at least the UnhealthyThreshold number of health checks consecutively.",
+	})
+
+	// A single success is not enough to get back in service.
+	setHealthy(true)
+	srv.Advance(10 * time.Second)
+	c.Assert(instanceState(c, client, "hclb", instId).State, Equals, "OutOfService")
+	srv.Advance(10 * time.Second)
+	c.Assert(instanceState(c, client, "hclb", instId).State, Equals, "InService")
+
+	// Instances without a backend address are never checked.
+	c.Assert(instanceState(c, client, "hclb", unmapped).State, Equals, "OutOfService")
+	c.Assert(instanceState(c, client, "hclb", unmapped).Description, Equals, "Instance is in pending state.")
+}
+
+func (s *LocalServerSuite) TestHealthCheckWithTCPTargetGatesForwarding(c *C) {
+	srv, client := newVirtualClockServer(c)
+	defer srv.Quit()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer backend.Close()
+	instId := srv.NewInstance()
+	srv.SetInstanceBackend(instId, backend.Listener.Addr().String())
+	_, err := client.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "hclb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 8080, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"}},
+	})
+	c.Assert(err, IsNil)
+	_, err = client.ConfigureHealthCheck("hclb", &elb.HealthCheck{
+		HealthyThreshold:   2,
+		Interval:           5,
+		Target:             "TCP:8080",
+		Timeout:            2,
+		UnhealthyThreshold: 2,
+	})
+	c.Assert(err, IsNil)
+	_, err = client.RegisterInstancesWithLoadBalancer([]string{instId}, "hclb")
+	c.Assert(err, IsNil)
+	url := "http://" + srv.ListenerAddr("hclb", 80) + "/"
+	code, _ := get(c, http.DefaultClient, url)
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+	srv.Advance(10 * time.Second)
+	code, body := get(c, http.DefaultClient, url)
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "hello")
+
+	backend.Close()
+	srv.Advance(10 * time.Second)
+	c.Assert(instanceState(c, client, "hclb", instId).State, Equals, "OutOfService")
+	code, _ = get(c, http.DefaultClient, url)
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+}
+
+func (s *LocalServerSuite) TestVirtualClockDrivesConnectionDraining(c *C) {
+	srv, client := newVirtualClockServer(c)
+	defer srv.Quit()
+	instId := srv.NewInstance()
+	srv.NewLoadBalancer("drainlb")
+	srv.RegisterInstance(instId, "drainlb")
+	_, err := client.ModifyLoadBalancerAttributes("drainlb", &elb.LoadBalancerAttributes{
+		ConnectionDraining: elb.ConnectionDraining{Enabled: true, Timeout: 300},
+	})
+	c.Assert(err, IsNil)
+	_, err = client.DeregisterInstancesFromLoadBalancer([]string{instId}, "drainlb")
+	c.Assert(err, IsNil)
+	srv.Advance(299 * time.Second)
+	c.Assert(instanceState(c, client, "drainlb", instId).ReasonCode, Equals, "ELB")
+	srv.Advance(time.Second)
+	resp, err := client.DescribeInstanceHealth("drainlb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 0)
+}
+
+// newEC2BackedServer starts a server of its own that takes its instances
+// from an ec2test server.
+func newEC2BackedServer(c *C) (*elbtest.Server, *elb.ELB, *ec2test.Server, *ec2.EC2) {
+	ec2srv, err := ec2test.NewServer()
+	c.Assert(err, IsNil)
+	srv, err := elbtest.NewServer()
+	c.Assert(err, IsNil)
+	srv.SetInstanceSource(ec2srv)
+	auth := aws.Auth{"abc", "123"}
+	return srv, elb.New(auth, aws.Region{ELBEndpoint: srv.URL()}), ec2srv, ec2.New(auth, aws.Region{EC2Endpoint: ec2srv.URL()})
+}
+
+func runInstance(c *C, e *ec2.EC2, zone string) string {
+	resp, err := e.RunInstances(&ec2.RunInstances{ImageId: "ami-a1b2c3d4", InstanceType: "m1.small", AvailZone: zone})
+	c.Assert(err, IsNil)
+	return resp.Instances[0].InstanceId
+}
+
+func (s *LocalServerSuite) TestRegisterEC2Instances(c *C) {
+	srv, client, ec2srv, ec2client := newEC2BackedServer(c)
+	defer srv.Quit()
+	defer ec2srv.Quit()
+	_, err := client.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "ec2lb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 80, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"}},
+	})
+	c.Assert(err, IsNil)
+	_, err = client.RegisterInstancesWithLoadBalancer([]string{"i-unknown"}, "ec2lb")
+	c.Assert(err, ErrorMatches, `InvalidInstance found in \[i-unknown\]. Invalid id: "i-unknown" \(InvalidInstance\)`)
+	pending := runInstance(c, ec2client, "us-east-1a")
+	_, err = client.RegisterInstancesWithLoadBalancer([]string{pending}, "ec2lb")
+	c.Assert(err, ErrorMatches, fmt.Sprintf(`EC2 instance %s is not in running state. \(InvalidInstance\)`, pending))
+
+	ec2srv.SetInitialInstanceState(ec2test.Running)
+	instId := runInstance(c, ec2client, "us-east-1a")
+	_, err = client.RegisterInstancesWithLoadBalancer([]string{instId}, "ec2lb")
+	c.Assert(err, IsNil)
+	srv.ChangeInstanceState("ec2lb", elb.InstanceState{InstanceId: instId, State: "InService", ReasonCode: "N/A", Description: "N/A"})
+	c.Assert(instanceState(c, client, "ec2lb", instId).State, Equals, "InService")
+
+	_, err = ec2client.TerminateInstances([]string{instId})
+	c.Assert(err, IsNil)
+	c.Assert(instanceState(c, client, "ec2lb", instId), DeepEquals, elb.InstanceState{
+		InstanceId:  instId,
+		State:       "OutOfService",
+		ReasonCode:  "Instance",
+		Description: "Instance is in shutting-down state.",
+	})
+}
+
+func (s *LocalServerSuite) TestEC2InstancesOutsideLoadBalancerZones(c *C) {
+	srv, client, ec2srv, ec2client := newEC2BackedServer(c)
+	defer srv.Quit()
+	defer ec2srv.Quit()
+	ec2srv.SetInitialInstanceState(ec2test.Running)
+	srv.SetVirtualClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer backend.Close()
+	_, err := client.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "ec2lb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 80, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"}},
+	})
+	c.Assert(err, IsNil)
+	inZone := runInstance(c, ec2client, "us-east-1a")
+	outOfZone := runInstance(c, ec2client, "us-east-1b")
+	srv.SetInstanceBackend(inZone, backend.Listener.Addr().String())
+	srv.SetInstanceBackend(outOfZone, backend.Listener.Addr().String())
+	_, err = client.RegisterInstancesWithLoadBalancer([]string{inZone, outOfZone}, "ec2lb")
+	c.Assert(err, IsNil)
+	srv.Advance(10 * time.Minute)
+	c.Assert(instanceState(c, client, "ec2lb", inZone).State, Equals, "InService")
+	c.Assert(instanceState(c, client, "ec2lb", outOfZone), DeepEquals, elb.InstanceState{
+		InstanceId:  outOfZone,
+		State:       "OutOfService",
+		ReasonCode:  "ELB",
+		Description: "Instance is in an Availability Zone for which LoadBalancer is not configured to route traffic to.",
+	})
+}
+
+func (s *LocalServerSuite) TestNewInstanceStartsEC2Instance(c *C) {
+	srv, _, ec2srv, _ := newEC2BackedServer(c)
+	defer srv.Quit()
+	defer ec2srv.Quit()
+	instId := srv.NewInstance()
+	inst, ok := ec2srv.DescribeInstance(instId)
+	c.Assert(ok, Equals, true)
+	c.Assert(inst.State.Name, Equals, "running")
+}
+
+func (s *LocalServerSuite) TestSnapshotAndRestore(c *C) {
+	srv, client := newVirtualClockServer(c)
+	defer srv.Quit()
+	inst1, inst2 := srv.NewInstance(), srv.NewInstance()
+	_, err := client.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "snaplb",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 8080, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"}},
+	})
+	c.Assert(err, IsNil)
+	_, err = client.ConfigureHealthCheck("snaplb", &elb.HealthCheck{
+		HealthyThreshold:   2,
+		Interval:           10,
+		Target:             "HTTP:8080/ping",
+		Timeout:            5,
+		UnhealthyThreshold: 3,
+	})
+	c.Assert(err, IsNil)
+	_, err = client.CreateLBCookieStickinessPolicy("snaplb", "sticky", 60)
+	c.Assert(err, IsNil)
+	_, err = client.SetLoadBalancerPoliciesOfListener("snaplb", 80, "sticky")
+	c.Assert(err, IsNil)
+	_, err = client.ModifyLoadBalancerAttributes("snaplb", &elb.LoadBalancerAttributes{
+		ConnectionDraining: elb.ConnectionDraining{Enabled: true, Timeout: 300},
+	})
+	c.Assert(err, IsNil)
+	_, err = client.RegisterInstancesWithLoadBalancer([]string{inst1, inst2}, "snaplb")
+	c.Assert(err, IsNil)
+	srv.ChangeInstanceState("snaplb", elb.InstanceState{InstanceId: inst1, State: "InService"})
+	_, err = client.DeregisterInstancesFromLoadBalancer([]string{inst2}, "snaplb")
+	c.Assert(err, IsNil)
+
+	data, err := srv.Snapshot().JSON()
+	c.Assert(err, IsNil)
+	snap, err := elbtest.ParseSnapshot(data)
+	c.Assert(err, IsNil)
+
+	restored, rclient := newVirtualClockServer(c)
+	defer restored.Quit()
+	restored.NewLoadBalancer("stale")
+	c.Assert(restored.Restore(snap), IsNil)
+	c.Assert(restored.Snapshot(), DeepEquals, snap)
+
+	want, err := client.DescribeLoadBalancers("snaplb")
+	c.Assert(err, IsNil)
+	got, err := rclient.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+	c.Assert(got.LoadBalancerDescriptions, HasLen, 1)
+	c.Assert(got.LoadBalancerDescriptions[0].HealthCheck, DeepEquals, want.LoadBalancerDescriptions[0].HealthCheck)
+	c.Assert(got.LoadBalancerDescriptions[0].ListenerDescriptions, DeepEquals, want.LoadBalancerDescriptions[0].ListenerDescriptions)
+	c.Assert(got.LoadBalancerDescriptions[0].Policies, DeepEquals, want.LoadBalancerDescriptions[0].Policies)
+	c.Assert(instanceState(c, rclient, "snaplb", inst1).State, Equals, "InService")
+	c.Assert(instanceState(c, rclient, "snaplb", inst2).ReasonCode, Equals, "ELB")
+	c.Assert(restored.ListenerAddr("snaplb", 80), Not(Equals), "")
+	c.Assert(restored.NewInstance(), Equals, "i-3")
+
+	// The draining deadline is restored too.
+	restored.SetVirtualClock(time.Date(2014, 1, 1, 0, 5, 0, 0, time.UTC))
+	resp, err := rclient.DescribeInstanceHealth("snaplb")
+	c.Assert(err, IsNil)
+	c.Assert(resp.InstanceStates, HasLen, 1)
+}
+
+func (s *LocalServerSuite) TestRestoreRejectsDuplicateLoadBalancers(c *C) {
+	srv, err := elbtest.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	lb := elbtest.LoadBalancerSnapshot{Description: elb.LoadBalancerDescription{LoadBalancerName: "dup"}}
+	err = srv.Restore(&elbtest.Snapshot{LoadBalancers: []elbtest.LoadBalancerSnapshot{lb, lb}})
+	c.Assert(err, ErrorMatches, `duplicate load balancer name "dup"`)
+}
+
+func (s *LocalServerSuite) TestRestoreRejectsInvalidHealthCheck(c *C) {
+	srv, client := newVirtualClockServer(c)
+	defer srv.Quit()
+	_, err := client.CreateLoadBalancer(&elb.CreateLoadBalancer{
+		Name:       "keep",
+		AvailZones: []string{"us-east-1a"},
+		Listeners:  []elb.Listener{{InstancePort: 80, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"}},
+	})
+	c.Assert(err, IsNil)
+	addr := srv.ListenerAddr("keep", 80)
+	backend, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+	defer backend.Close()
+	instId := srv.NewInstance()
+	srv.SetInstanceBackend(instId, backend.Addr().String())
+	err = srv.Restore(&elbtest.Snapshot{
+		LoadBalancers: []elbtest.LoadBalancerSnapshot{{
+			Description: elb.LoadBalancerDescription{
+				LoadBalancerName: "hclb",
+				HealthCheck:      elb.HealthCheck{Target: "tcp:80/", Interval: 5},
+				Instances:        []elb.Instance{{InstanceId: instId}},
+			},
+		}},
+		Instances: []string{instId},
+	})
+	c.Assert(err, ErrorMatches, `invalid health check of load balancer "hclb": HealthCheck TCP Target must specify a port only.*`)
+	c.Assert(srv.ListenerAddr("keep", 80), Equals, addr)
+	resp, err := client.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+	c.Assert(resp.LoadBalancerDescriptions, HasLen, 1)
+	c.Assert(resp.LoadBalancerDescriptions[0].LoadBalancerName, Equals, "keep")
+
+	// Time still moves on the untouched server.
+	done := make(chan bool)
+	go func() {
+		srv.Advance(30 * time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("Advance did not return")
+	}
+}
+
+// signedGet sends a request signed with the given credentials and
+// timestamp to the server.
+func signedGet(c *C, auth aws.Auth, srvURL string, timestamp time.Time) *http.Response {
+	u, err := url.Parse(srvURL)
+	c.Assert(err, IsNil)
+	params := map[string]string{
+		"Action":    "DescribeLoadBalancers",
+		"Version":   "2012-06-01",
+		"Timestamp": timestamp.In(time.UTC).Format(time.RFC3339),
+	}
+	elb.Sign(auth, "GET", "/", params, u.Host)
+	q := make(url.Values)
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	resp, err := http.Get(srvURL + "/?" + q.Encode())
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	return resp
+}
+
+func (s *LocalServerSuite) TestSignatureVerification(c *C) {
+	srv, err := elbtest.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Quit()
+	auth := aws.Auth{"key", "secret"}
+	srv.SetCredentials(auth)
+	region := aws.Region{ELBEndpoint: srv.URL()}
+
+	client := elb.New(auth, region)
+	_, err = client.DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+	// Values that need encoding are signed the same way on both ends.
+	_, err = client.DescribeLoadBalancers("a b+c/d~e=f&g*h")
+	c.Assert(err, ErrorMatches, ".*LoadBalancerNotFound.*")
+
+	_, err = elb.New(aws.Auth{"key", "wrong"}, region).DescribeLoadBalancers()
+	c.Assert(err, NotNil)
+	c.Assert(err.(*elb.Error).StatusCode, Equals, 403)
+	c.Assert(err.(*elb.Error).Code, Equals, "SignatureDoesNotMatch")
+
+	_, err = elb.New(aws.Auth{"unknown", "secret"}, region).DescribeLoadBalancers()
+	c.Assert(err, NotNil)
+	c.Assert(err.(*elb.Error).StatusCode, Equals, 403)
+	c.Assert(err.(*elb.Error).Code, Equals, "InvalidClientTokenId")
+
+	resp := signedGet(c, auth, srv.URL(), time.Now())
+	c.Assert(resp.StatusCode, Equals, 200)
+	resp = signedGet(c, auth, srv.URL(), time.Now().Add(-time.Hour))
+	c.Assert(resp.StatusCode, Equals, 400)
+	actions := srv.Actions()
+	c.Assert(actions[len(actions)-1].Err.Code, Equals, "RequestExpired")
+
+	srv.SetCredentials()
+	_, err = elb.New(aws.Auth{"unknown", "secret"}, region).DescribeLoadBalancers()
+	c.Assert(err, IsNil)
+}
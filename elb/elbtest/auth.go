@@ -0,0 +1,59 @@
+package elbtest
+
+import (
+	"fmt"
+	"github.com/flaviamissi/go-elb/aws"
+	"github.com/flaviamissi/go-elb/elb"
+	"net/http"
+)
+
+// SetCredentials makes the server authenticate every request against the
+// given credentials, verifying its Signature Version 2. Requests signed
+// with an unknown access key, with a wrong signature or with an expired
+// timestamp are rejected with the errors ELB returns. Timestamps are
+// checked against the wall clock, even if the server runs on a virtual
+// clock.
+//
+// Calling SetCredentials without arguments turns authentication off, which
+// is the default.
+func (srv *Server) SetCredentials(auths ...aws.Auth) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if len(auths) == 0 {
+		srv.secrets = nil
+		return
+	}
+	srv.secrets = make(map[string]string)
+	for _, auth := range auths {
+		srv.secrets[auth.AccessKey] = auth.SecretKey
+	}
+}
+
+// authenticate verifies the signature of the request, if the server was
+// given credentials.
+func (srv *Server) authenticate(req *http.Request) *elb.Error {
+	if srv.secrets == nil {
+		return nil
+	}
+	form := req.Form
+	key := form.Get("AWSAccessKeyId")
+	if key == "" || form.Get("Signature") == "" {
+		return authError(403, "MissingAuthenticationToken", "Request is missing Authentication Token")
+	}
+	secret, ok := srv.secrets[key]
+	if !ok {
+		return authError(403, "InvalidClientTokenId", "The security token included in the request is invalid.")
+	}
+	if err := aws.VerifySignatureV2(req, secret); err != nil {
+		return authError(err.StatusCode, err.Code, "%s", err.Message)
+	}
+	return nil
+}
+
+func authError(statusCode int, code, format string, args ...interface{}) *elb.Error {
+	return &elb.Error{
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
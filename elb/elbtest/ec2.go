@@ -0,0 +1,98 @@
+package elbtest
+
+import (
+	"fmt"
+	"github.com/flaviamissi/go-elb/ec2/ec2test"
+	"github.com/flaviamissi/go-elb/elb"
+)
+
+// SetInstanceSource makes the server use the instances of an ec2test server
+// instead of the ones created with NewInstance. Registering an instance
+// then requires it to exist and to be running in EC2, and instances that
+// leave the running state, e.g. because they were terminated, are reported
+// OutOfService. Instances placed in an availability zone the load balancer
+// is not enabled in are reported OutOfService as well.
+//
+// NewInstance starts a running instance in ec2srv.
+func (srv *Server) SetInstanceSource(ec2srv *ec2test.Server) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.ec2 = ec2srv
+}
+
+// ec2InstanceExists checks that the instance exists in EC2.
+func (srv *Server) ec2InstanceExists(id string) error {
+	if _, ok := srv.ec2.DescribeInstance(id); !ok {
+		return &elb.Error{
+			StatusCode: 400,
+			Code:       "InvalidInstance",
+			Message:    fmt.Sprintf("InvalidInstance found in [%s]. Invalid id: \"%s\"", id, id),
+		}
+	}
+	return nil
+}
+
+// instanceRunning checks that the instance is running in EC2. Without an
+// instance source every instance is considered running.
+func (srv *Server) instanceRunning(id string) error {
+	if srv.ec2 == nil {
+		return nil
+	}
+	if inst, _ := srv.ec2.DescribeInstance(id); inst.State.Name != "running" {
+		return &elb.Error{
+			StatusCode: 400,
+			Code:       "InvalidInstance",
+			Message:    fmt.Sprintf("EC2 instance %s is not in running state.", id),
+		}
+	}
+	return nil
+}
+
+// ec2InstanceState returns the state an instance registered with the load
+// balancer is in because of its EC2 state or placement, or nil if EC2 has
+// no say in it.
+func (srv *Server) ec2InstanceState(lb *elb.LoadBalancerDescription, id string) *elb.InstanceState {
+	if srv.ec2 == nil {
+		return nil
+	}
+	inst, ok := srv.ec2.DescribeInstance(id)
+	if !ok {
+		return &elb.InstanceState{
+			InstanceId:  id,
+			State:       "OutOfService",
+			ReasonCode:  "Instance",
+			Description: "Instance has been terminated.",
+		}
+	}
+	if inst.State.Name != "running" {
+		return &elb.InstanceState{
+			InstanceId:  id,
+			State:       "OutOfService",
+			ReasonCode:  "Instance",
+			Description: fmt.Sprintf("Instance is in %s state.", inst.State.Name),
+		}
+	}
+	if len(lb.AvailZones) > 0 && !contains(lb.AvailZones, inst.AvailZone) {
+		return &elb.InstanceState{
+			InstanceId:  id,
+			State:       "OutOfService",
+			ReasonCode:  "ELB",
+			Description: "Instance is in an Availability Zone for which LoadBalancer is not configured to route traffic to.",
+		}
+	}
+	return nil
+}
+
+// syncEC2States updates the state of the instances registered with the
+// load balancer according to EC2.
+func (srv *Server) syncEC2States(lbName string) {
+	lb := srv.lbs[lbName]
+	if lb == nil || srv.ec2 == nil {
+		return
+	}
+	for _, inst := range lb.Instances {
+		if state := srv.ec2InstanceState(lb, inst.InstanceId); state != nil {
+			srv.setInstanceState(lbName, inst.InstanceId, *state)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package elbtest
+
+import (
+	"github.com/flaviamissi/go-elb/elb"
+	"net/url"
+	"time"
+)
+
+// Fault describes an error induced on an ELB action. See Server.InjectFault.
+type Fault struct {
+	// Count is the number of calls that fail before the fault is
+	// removed. If zero, every matching call fails until ClearFaults is
+	// called.
+	Count int
+
+	// Probability, if non-zero, is the chance in the interval (0, 1]
+	// that a matching call fails. Calls that are spared do not count
+	// towards Count.
+	Probability float64
+
+	// Params, if not nil, restricts the fault to requests that hold all
+	// the given parameter values, e.g. {"LoadBalancerName": "mylb"}.
+	Params map[string]string
+
+	// Err is the error returned to the client. If Err is nil and Latency
+	// is zero, a 500 InternalFailure error is returned. A zero
+	// StatusCode is sent as 400.
+	Err *elb.Error
+
+	// Latency delays the response by the given duration. If Err is nil
+	// the call then proceeds as usual, which is useful for testing
+	// timeouts.
+	Latency time.Duration
+}
+
+var internalFailure = elb.Error{
+	StatusCode: 500,
+	Code:       "InternalFailure",
+	Message:    "The request processing has failed because of an unknown error, exception or failure.",
+}
+
+// InjectFault queues a fault for the given action, e.g.
+// "RegisterInstancesWithLoadBalancer". Faults of an action are tried in the
+// order they were injected, and the first one that matches a request is
+// used.
+func (srv *Server) InjectFault(action string, fault Fault) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	f := fault
+	srv.faults[action] = append(srv.faults[action], &f)
+}
+
+// ClearFaults removes all faults queued with InjectFault.
+func (srv *Server) ClearFaults() {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.faults = make(map[string][]*Fault)
+}
+
+// fault returns the fault that applies to a call of the given action, or
+// nil if the call should proceed as usual. Faults with a limited Count are
+// consumed by this call. A fault that spares the call by chance lets the
+// next matching one apply. It must be called with the server mutex held,
+// once the request has been authenticated and its action recognized.
+func (srv *Server) fault(action string, params url.Values) *Fault {
+	faults := srv.faults[action]
+	for i, f := range faults {
+		if !f.matches(params) {
+			continue
+		}
+		if f.Probability > 0 && srv.rand.Float64() >= f.Probability {
+			continue
+		}
+		if f.Count > 0 {
+			if f.Count--; f.Count == 0 {
+				srv.faults[action] = append(faults[:i:i], faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (f *Fault) matches(params url.Values) bool {
+	for name, value := range f.Params {
+		if params.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// error returns the error the fault induces, or nil if it only adds
+// latency.
+func (f *Fault) error() *elb.Error {
+	if f.Err == nil {
+		if f.Latency > 0 {
+			return nil
+		}
+		err := internalFailure
+		return &err
+	}
+	err := *f.Err
+	if err.StatusCode == 0 {
+		err.StatusCode = 400
+	}
+	return &err
+}
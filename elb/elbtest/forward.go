@@ -0,0 +1,304 @@
+package elbtest
+
+import (
+	"fmt"
+	"github.com/flaviamissi/go-elb/elb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// stickinessCookie is the name of the cookie the load balancer uses to bind
+// a client to an instance.
+const stickinessCookie = "AWSELB"
+
+// frontend forwards the traffic received by a load balancer listener to
+// the instances registered with the load balancer.
+type frontend struct {
+	srv      *Server
+	lbName   string
+	port     int
+	protocol string
+	listener net.Listener
+	http     *http.Server
+	next     int
+}
+
+// SetInstanceBackend maps an instance to the local address, in the form
+// host:port, that load balancer listeners forward traffic to. Every
+// listener of every load balancer the instance is registered with forwards
+// to the same address, regardless of the listener InstancePort.
+//
+// Instances without a backend address never receive traffic.
+func (srv *Server) SetInstanceBackend(instId, addr string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.backends[instId] = addr
+}
+
+// ListenerAddr returns the local address, in the form host:port, on which
+// the listener of the given load balancer port accepts traffic, or an empty
+// string if there is no such listener.
+//
+// HTTP and HTTPS listeners are both served as plain HTTP, and TCP and SSL
+// listeners as plain TCP.
+func (srv *Server) ListenerAddr(lbName string, port int) string {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	fe := srv.frontends[lbName][port]
+	if fe == nil {
+		return ""
+	}
+	return fe.listener.Addr().String()
+}
+
+// syncFrontends opens a frontend for every listener of the load balancer
+// that doesn't have one yet, and closes the frontends left without a
+// listener. It only fails if a frontend cannot be opened, so it never
+// fails for a load balancer that was removed.
+func (srv *Server) syncFrontends(lbName string) error {
+	lb := srv.lbs[lbName]
+	frontends := srv.frontends[lbName]
+	if frontends == nil {
+		frontends = make(map[int]*frontend)
+		srv.frontends[lbName] = frontends
+	}
+	for port, fe := range frontends {
+		if lb == nil || findListener(lb, port) == nil {
+			fe.close()
+			delete(frontends, port)
+		}
+	}
+	if lb == nil {
+		delete(srv.frontends, lbName)
+		return nil
+	}
+	for _, ld := range lb.ListenerDescriptions {
+		port := ld.Listener.LoadBalancerPort
+		if frontends[port] != nil {
+			continue
+		}
+		fe, err := srv.newFrontend(lbName, ld.Listener)
+		if err != nil {
+			return err
+		}
+		frontends[port] = fe
+	}
+	return nil
+}
+
+// openFrontends opens a frontend for every listener of the given load
+// balancers, without making them part of the server state. If one cannot
+// be opened, those already opened are closed.
+func (srv *Server) openFrontends(lbs map[string]*elb.LoadBalancerDescription) (map[string]map[int]*frontend, error) {
+	all := make(map[string]map[int]*frontend)
+	for lbName, lb := range lbs {
+		frontends := make(map[int]*frontend)
+		all[lbName] = frontends
+		for _, ld := range lb.ListenerDescriptions {
+			port := ld.Listener.LoadBalancerPort
+			if frontends[port] != nil {
+				continue
+			}
+			fe, err := srv.newFrontend(lbName, ld.Listener)
+			if err != nil {
+				closeFrontends(all)
+				return nil, err
+			}
+			frontends[port] = fe
+		}
+	}
+	return all, nil
+}
+
+func closeFrontends(all map[string]map[int]*frontend) {
+	for _, frontends := range all {
+		for _, fe := range frontends {
+			fe.close()
+		}
+	}
+}
+
+// frontendError returns the error sent to clients when the frontend of a
+// listener cannot be opened.
+func frontendError(err error) *elb.Error {
+	return &elb.Error{
+		StatusCode: 500,
+		Code:       "InternalFailure",
+		Message:    err.Error(),
+	}
+}
+
+func (srv *Server) newFrontend(lbName string, listener elb.Listener) (*frontend, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on localhost: %v", err)
+	}
+	fe := &frontend{
+		srv:      srv,
+		lbName:   lbName,
+		port:     listener.LoadBalancerPort,
+		protocol: strings.ToUpper(listener.Protocol),
+		listener: l,
+	}
+	switch fe.protocol {
+	case "HTTP", "HTTPS":
+		fe.http = &http.Server{Handler: fe}
+		go fe.http.Serve(l)
+	default:
+		go fe.serveTCP()
+	}
+	return fe, nil
+}
+
+func (fe *frontend) close() {
+	if fe.http != nil {
+		fe.http.Close()
+	} else {
+		fe.listener.Close()
+	}
+}
+
+// stickiness describes the stickiness policy set on a listener. If appCookie
+// is not empty, the load balancer follows the lifetime of the application
+// cookie with that name, otherwise it issues its own cookie that expires
+// after expiration seconds, or at the end of the browser session if
+// expiration is zero.
+type stickiness struct {
+	appCookie  string
+	expiration int
+}
+
+// backend picks the instance a request or connection is forwarded to,
+// among the InService instances with a backend address. If sticky is not
+// empty and names one of those instances, it is picked. It returns the
+// instance id and its address, or empty strings if no instance is
+// available.
+func (fe *frontend) backend(sticky string) (instId, addr string) {
+	fe.srv.mutex.Lock()
+	defer fe.srv.mutex.Unlock()
+	lb := fe.srv.lbs[fe.lbName]
+	if lb == nil {
+		return "", ""
+	}
+	fe.srv.syncEC2States(fe.lbName)
+	var ids []string
+	for _, inst := range lb.Instances {
+		if fe.srv.instanceState(fe.lbName, inst.InstanceId).State != "InService" {
+			continue
+		}
+		if fe.srv.backends[inst.InstanceId] == "" {
+			continue
+		}
+		if inst.InstanceId == sticky {
+			return sticky, fe.srv.backends[sticky]
+		}
+		ids = append(ids, inst.InstanceId)
+	}
+	if len(ids) == 0 {
+		return "", ""
+	}
+	instId = ids[fe.next%len(ids)]
+	fe.next++
+	return instId, fe.srv.backends[instId]
+}
+
+// stickiness returns the stickiness policy of the listener, or nil if
+// there is none.
+func (fe *frontend) stickiness() *stickiness {
+	fe.srv.mutex.Lock()
+	defer fe.srv.mutex.Unlock()
+	lb := fe.srv.lbs[fe.lbName]
+	if lb == nil {
+		return nil
+	}
+	l := findListener(lb, fe.port)
+	if l == nil {
+		return nil
+	}
+	for _, name := range l.PolicyNames {
+		for _, p := range lb.Policies.AppCookieStickinessPolicies {
+			if p.PolicyName == name {
+				return &stickiness{appCookie: p.CookieName}
+			}
+		}
+		for _, p := range lb.Policies.LBCookieStickinessPolicies {
+			if p.PolicyName == name {
+				return &stickiness{expiration: p.CookieExpirationPeriod}
+			}
+		}
+	}
+	return nil
+}
+
+func (fe *frontend) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	policy := fe.stickiness()
+	var sticky string
+	if policy != nil {
+		if c, err := req.Cookie(stickinessCookie); err == nil {
+			sticky = c.Value
+		}
+		if policy.appCookie != "" {
+			// The binding ends with the application session.
+			if _, err := req.Cookie(policy.appCookie); err != nil {
+				sticky = ""
+			}
+		}
+	}
+	instId, addr := fe.backend(sticky)
+	if addr == "" {
+		http.Error(w, "Service Unavailable: Back-end server is at capacity", http.StatusServiceUnavailable)
+		return
+	}
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: addr})
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if policy == nil {
+			return nil
+		}
+		cookie := &http.Cookie{Name: stickinessCookie, Value: instId, Path: "/"}
+		if policy.appCookie != "" {
+			for _, c := range resp.Cookies() {
+				if c.Name == policy.appCookie {
+					resp.Header.Add("Set-Cookie", cookie.String())
+					break
+				}
+			}
+		} else if instId != sticky {
+			cookie.MaxAge = policy.expiration
+			resp.Header.Add("Set-Cookie", cookie.String())
+		}
+		return nil
+	}
+	proxy.ServeHTTP(w, req)
+}
+
+func (fe *frontend) serveTCP() {
+	for {
+		conn, err := fe.listener.Accept()
+		if err != nil {
+			return
+		}
+		go fe.forward(conn)
+	}
+}
+
+func (fe *frontend) forward(conn net.Conn) {
+	defer conn.Close()
+	_, addr := fe.backend("")
+	if addr == "" {
+		return
+	}
+	backend, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer backend.Close()
+	go func() {
+		io.Copy(backend, conn)
+		backend.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(conn, backend)
+}
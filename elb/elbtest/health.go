@@ -0,0 +1,279 @@
+package elbtest
+
+import (
+	"github.com/flaviamissi/go-elb/elb"
+	"net"
+	"net/http"
+	"time"
+)
+
+// healthCheckTick is how often the server looks for health checks that are
+// due when it runs on the wall clock.
+const healthCheckTick = 100 * time.Millisecond
+
+// Instance states set by active health checking.
+var (
+	inService = elb.InstanceState{
+		State:       "InService",
+		ReasonCode:  "N/A",
+		Description: "N/A",
+	}
+	failedHealthCheck = elb.InstanceState{
+		State:       "OutOfService",
+		ReasonCode:  "Instance",
+		Description: "Instance has failed at least the UnhealthyThreshold number of health checks consecutively.",
+	}
+)
+
+// health tracks the health checks of an instance registered with a load
+// balancer.
+type health struct {
+	next      time.Time // when the next check is due
+	successes int       // consecutive successful checks
+	failures  int       // consecutive failed checks
+}
+
+// probe is a single health check of an instance.
+type probe struct {
+	lbName  string
+	instId  string
+	addr    string
+	target  *elb.HealthCheckTarget
+	timeout time.Duration
+	ok      bool
+}
+
+// SetVirtualClock stops the server from using the wall clock and sets its
+// time to now. From then on time only moves when Advance is called, which
+// makes health checks and connection draining deterministic.
+func (srv *Server) SetVirtualClock(now time.Time) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.virtual = true
+	srv.virtualNow = now
+}
+
+// Advance moves the virtual clock forward by d, running every health check
+// that falls due on the way in order. Health checks are performed against
+// the real backend addresses set with SetInstanceBackend, and Advance
+// waits for them to finish.
+//
+// It panics if SetVirtualClock was not called.
+func (srv *Server) Advance(d time.Duration) {
+	srv.mutex.Lock()
+	if !srv.virtual {
+		srv.mutex.Unlock()
+		panic("elbtest: Advance called without a virtual clock")
+	}
+	end := srv.virtualNow.Add(d)
+	for {
+		srv.scheduleHealthChecks()
+		next, ok := srv.nextHealthCheck()
+		if !ok || next.After(end) {
+			break
+		}
+		srv.virtualNow = next
+		probes := srv.dueProbes()
+		srv.mutex.Unlock()
+		runProbes(probes)
+		srv.mutex.Lock()
+		srv.applyProbes(probes)
+	}
+	srv.virtualNow = end
+	srv.mutex.Unlock()
+}
+
+// now returns the current time of the server, which is the wall clock time
+// unless SetVirtualClock was called.
+func (srv *Server) now() time.Time {
+	if srv.virtual {
+		return srv.virtualNow
+	}
+	return time.Now()
+}
+
+// checkHealth runs the health checks that are due on the wall clock, until
+// the server is closed.
+func (srv *Server) checkHealth() {
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-srv.closed:
+			return
+		case <-ticker.C:
+		}
+		srv.mutex.Lock()
+		if srv.virtual {
+			srv.mutex.Unlock()
+			continue
+		}
+		srv.scheduleHealthChecks()
+		probes := srv.dueProbes()
+		srv.mutex.Unlock()
+		runProbes(probes)
+		srv.mutex.Lock()
+		srv.applyProbes(probes)
+		srv.mutex.Unlock()
+	}
+}
+
+// scheduleHealthChecks starts tracking the health of the instances that
+// can be checked, scheduling their first check one interval from now, and
+// stops tracking instances that were deregistered. Only instances with a
+// backend address registered with load balancers with a valid health check
+// configured are checked, and only while EC2 deems them routable.
+func (srv *Server) scheduleHealthChecks() {
+	now := srv.now()
+	for lbName, lb := range srv.lbs {
+		interval := time.Duration(lb.HealthCheck.Interval) * time.Second
+		if lb.HealthCheck.Target == "" || interval <= 0 {
+			delete(srv.health, lbName)
+			continue
+		}
+		if _, err := elb.ParseHealthCheckTarget(lb.HealthCheck.Target); err != nil {
+			delete(srv.health, lbName)
+			continue
+		}
+		checked := make(map[string]*health)
+		for _, inst := range lb.Instances {
+			if srv.backends[inst.InstanceId] == "" {
+				continue
+			}
+			if srv.ec2InstanceState(lb, inst.InstanceId) != nil {
+				// Not running or not routable, so never healthy.
+				continue
+			}
+			h := srv.health[lbName][inst.InstanceId]
+			if h == nil {
+				h = &health{next: now.Add(interval)}
+			}
+			checked[inst.InstanceId] = h
+		}
+		srv.health[lbName] = checked
+	}
+	for lbName := range srv.health {
+		if srv.lbs[lbName] == nil {
+			delete(srv.health, lbName)
+		}
+	}
+}
+
+// nextHealthCheck returns the time the earliest health check is due.
+func (srv *Server) nextHealthCheck() (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, checked := range srv.health {
+		for _, h := range checked {
+			if !found || h.next.Before(next) {
+				next = h.next
+				found = true
+			}
+		}
+	}
+	return next, found
+}
+
+// dueProbes returns the health checks that are due and schedules the
+// following ones.
+func (srv *Server) dueProbes() []*probe {
+	now := srv.now()
+	var probes []*probe
+	for lbName, checked := range srv.health {
+		hc := srv.lbs[lbName].HealthCheck
+		target, err := elb.ParseHealthCheckTarget(hc.Target)
+		if err != nil {
+			continue
+		}
+		for instId, h := range checked {
+			if h.next.After(now) {
+				continue
+			}
+			h.next = now.Add(time.Duration(hc.Interval) * time.Second)
+			probes = append(probes, &probe{
+				lbName:  lbName,
+				instId:  instId,
+				addr:    srv.backends[instId],
+				target:  target,
+				timeout: time.Duration(hc.Timeout) * time.Second,
+			})
+		}
+	}
+	return probes
+}
+
+// applyProbes updates the state of the checked instances. An instance goes
+// InService after HealthyThreshold consecutive successful checks, and
+// OutOfService after UnhealthyThreshold consecutive failed ones.
+func (srv *Server) applyProbes(probes []*probe) {
+	for _, p := range probes {
+		h := srv.health[p.lbName][p.instId]
+		lb := srv.lbs[p.lbName]
+		if h == nil || lb == nil {
+			// Deregistered while being checked.
+			continue
+		}
+		hc := lb.HealthCheck
+		if p.ok {
+			h.successes++
+			h.failures = 0
+			if h.successes >= hc.HealthyThreshold {
+				srv.setInstanceState(p.lbName, p.instId, inService)
+			}
+		} else {
+			h.failures++
+			h.successes = 0
+			if h.failures >= hc.UnhealthyThreshold {
+				srv.setInstanceState(p.lbName, p.instId, failedHealthCheck)
+			}
+		}
+	}
+}
+
+func (srv *Server) setInstanceState(lbName, instId string, state elb.InstanceState) {
+	state.InstanceId = instId
+	for i, s := range srv.instanceStates[lbName] {
+		if s.InstanceId == instId {
+			srv.instanceStates[lbName][i] = &state
+			return
+		}
+	}
+}
+
+// runProbes performs the health checks concurrently and waits for them.
+func runProbes(probes []*probe) {
+	done := make(chan bool)
+	for _, p := range probes {
+		go func(p *probe) {
+			p.ok = p.run()
+			done <- true
+		}(p)
+	}
+	for i := 0; i < len(probes); i++ {
+		<-done
+	}
+}
+
+// run performs the health check. HTTP and HTTPS targets succeed if a GET of
+// the target path, made in plain HTTP, returns 200 OK. TCP and SSL targets
+// succeed if a connection can be established. The target port is ignored,
+// checks are always made against the instance backend address.
+func (p *probe) run() bool {
+	switch p.target.Protocol {
+	case "HTTP", "HTTPS":
+		client := http.Client{Timeout: p.timeout}
+		resp, err := client.Get("http://" + p.addr + p.target.Path)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	default:
+		conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+}
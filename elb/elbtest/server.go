@@ -6,27 +6,57 @@ package elbtest
 import (
 	"encoding/xml"
 	"fmt"
+	"github.com/flaviamissi/go-elb/ec2/ec2test"
 	"github.com/flaviamissi/go-elb/elb"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// Action represents a request received by the server.
+type Action struct {
+	RequestId string
+
+	// Request holds the requested action as a url.Values instance
+	Request url.Values
+
+	// If the action succeeded, Response holds the value that
+	// was marshalled to build the XML response for the request.
+	Response interface{}
+
+	// If the action failed, Err holds an error giving details of the failure.
+	Err *elb.Error
+}
+
 // Server implements an ELB simulator for use in testing.
 type Server struct {
 	url            string
 	listener       net.Listener
 	mutex          sync.Mutex
 	reqId          int
+	reqs           []*Action
 	lbs            map[string]*elb.LoadBalancerDescription
 	lbsReqs        map[string]url.Values
 	instances      []string
 	instanceStates map[string][]*elb.InstanceState
 	instCount      int
+	attributes     map[string]*elb.LoadBalancerAttributes
+	draining       map[string]map[string]time.Time // lb name -> instance id -> deadline
+	faults         map[string][]*Fault             // action -> faults
+	frontends      map[string]map[int]*frontend    // lb name -> load balancer port -> frontend
+	backends       map[string]string               // instance id -> address
+	health         map[string]map[string]*health   // lb name -> instance id -> health
+	virtual        bool
+	virtualNow     time.Time
+	closed         chan struct{}
+	rand           *rand.Rand
+	ec2            *ec2test.Server
+	secrets        map[string]string // access key -> secret key
 }
 
 // Starts and returns a new server
@@ -40,16 +70,29 @@ func NewServer() (*Server, error) {
 		url:            "http://" + l.Addr().String(),
 		lbs:            make(map[string]*elb.LoadBalancerDescription),
 		instanceStates: make(map[string][]*elb.InstanceState),
+		attributes:     make(map[string]*elb.LoadBalancerAttributes),
+		draining:       make(map[string]map[string]time.Time),
+		faults:         make(map[string][]*Fault),
+		frontends:      make(map[string]map[int]*frontend),
+		backends:       make(map[string]string),
+		health:         make(map[string]map[string]*health),
+		closed:         make(chan struct{}),
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		srv.serveHTTP(w, req)
 	}))
+	go srv.checkHealth()
 	return srv, nil
 }
 
-// Quit closes down the server.
+// Quit closes down the server and the listeners of its load balancers.
 func (srv *Server) Quit() {
 	srv.listener.Close()
+	close(srv.closed)
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	closeFrontends(srv.frontends)
 }
 
 // URL returns the URL of the server.
@@ -72,32 +115,101 @@ func (srv *Server) error(w http.ResponseWriter, err *elb.Error) {
 
 func (srv *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
+	action := req.Form.Get("Action")
 	srv.mutex.Lock()
 	defer srv.mutex.Unlock()
-	f := actions[req.Form.Get("Action")]
+	a := &Action{
+		RequestId: fmt.Sprintf("req%0X", srv.reqId),
+		Request:   req.Form,
+	}
+	srv.reqId++
+	srv.reqs = append(srv.reqs, a)
+	if err := srv.authenticate(req); err != nil {
+		a.Err = err
+		srv.error(w, err)
+		return
+	}
+	f := actions[action]
 	if f == nil {
-		srv.error(w, &elb.Error{
+		a.Err = &elb.Error{
 			StatusCode: 400,
 			Code:       "InvalidParameterValue",
 			Message:    "Unrecognized Action",
-		})
+		}
+		srv.error(w, a.Err)
+		return
 	}
-	reqId := fmt.Sprintf("req%0X", srv.reqId)
-	srv.reqId++
-	if resp, err := f(srv, w, req, reqId); err == nil {
+	fault := srv.fault(action, req.Form)
+	if fault != nil && fault.Latency > 0 {
+		srv.mutex.Unlock()
+		time.Sleep(fault.Latency)
+		srv.mutex.Lock()
+	}
+	if fault != nil {
+		if err := fault.error(); err != nil {
+			a.Err = err
+			srv.error(w, err)
+			return
+		}
+	}
+	if resp, err := f(srv, w, req, a.RequestId); err == nil {
+		a.Response = resp
 		if err := xml.NewEncoder(w).Encode(resp); err != nil {
 			panic(err)
 		}
 	} else {
 		switch err.(type) {
 		case *elb.Error:
-			srv.error(w, err.(*elb.Error))
+			a.Err = err.(*elb.Error)
+			srv.error(w, a.Err)
 		default:
 			panic(err)
 		}
 	}
 }
 
+// Actions returns the requests received by the server since it was started
+// or since the last call to ClearActions, in the order they were received.
+// If names are given, only actions with one of those names, e.g.
+// "CreateLoadBalancer", are returned.
+func (srv *Server) Actions(names ...string) []*Action {
+	return srv.ActionsSince(0, names...)
+}
+
+// ActionsSince is like Actions but only returns the actions received after
+// the first n, so that
+//
+//	n := len(srv.Actions())
+//	// ... code under test ...
+//	actions := srv.ActionsSince(n)
+//
+// holds the requests made by the code under test. A negative n is treated
+// as zero.
+func (srv *Server) ActionsSince(n int, names ...string) []*Action {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	var actions []*Action
+	if n < 0 {
+		n = 0
+	}
+	if n >= len(srv.reqs) {
+		return actions
+	}
+	for _, a := range srv.reqs[n:] {
+		if len(names) == 0 || contains(names, a.Request.Get("Action")) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// ClearActions discards the recorded actions.
+func (srv *Server) ClearActions() {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.reqs = nil
+}
+
 func (srv *Server) createLoadBalancer(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
 	composition := map[string]string{
 		"AvailabilityZones.member.1": "Subnets.member.1",
@@ -120,8 +232,18 @@ func (srv *Server) createLoadBalancer(w http.ResponseWriter, req *http.Request,
 		path = "/"
 	}
 	lbName := req.FormValue("LoadBalancerName")
+	old := srv.lbs[lbName]
 	srv.lbs[lbName] = srv.makeLoadBalancerDescription(req.Form)
 	srv.lbs[lbName].DNSName = fmt.Sprintf("%s-some-aws-stuff.us-east-1.elb.amazonaws.com", lbName)
+	if err := srv.syncFrontends(lbName); err != nil {
+		if old != nil {
+			srv.lbs[lbName] = old
+		} else {
+			delete(srv.lbs, lbName)
+		}
+		srv.syncFrontends(lbName)
+		return nil, frontendError(err)
+	}
 	return elb.CreateLoadBalancerResp{
 		DNSName: srv.lbs[lbName].DNSName,
 	}, nil
@@ -131,7 +253,7 @@ func (srv *Server) deleteLoadBalancer(w http.ResponseWriter, req *http.Request,
 	if err := srv.validate(req, []string{"LoadBalancerName"}); err != nil {
 		return nil, err
 	}
-	srv.RemoveLoadBalancer(req.FormValue("LoadBalancerName"))
+	srv.removeLoadBalancer(req.FormValue("LoadBalancerName"))
 	return elb.SimpleResp{RequestId: reqId}, nil
 }
 
@@ -145,20 +267,22 @@ func (srv *Server) registerInstancesWithLoadBalancer(w http.ResponseWriter, req
 		return nil, err
 	}
 	instIds := []string{}
-	instances := []elb.Instance{}
 	i := 1
 	instId := req.FormValue(fmt.Sprintf("Instances.member.%d.InstanceId", i))
 	for instId != "" {
 		if err := srv.instanceExists(instId); err != nil {
 			return nil, err
 		}
+		if err := srv.instanceRunning(instId); err != nil {
+			return nil, err
+		}
 		instIds = append(instIds, instId)
-		instances = append(instances, elb.Instance{InstanceId: instId})
 		i++
 		instId = req.FormValue(fmt.Sprintf("Instances.member.%d.InstanceId", i))
 	}
-	srv.instanceStates[lbName] = append(srv.instanceStates[lbName], srv.makeInstanceState(instId))
-	srv.lbs[lbName].Instances = append(srv.lbs[lbName].Instances, instances...)
+	for _, id := range instIds {
+		srv.registerInstance(id, lbName)
+	}
 	return elb.RegisterInstancesResp{InstanceIds: instIds}, nil
 }
 
@@ -180,10 +304,10 @@ func (srv *Server) deregisterInstancesFromLoadBalancer(w http.ResponseWriter, re
 		}
 		i++
 		removeInstanceFromLB(lb, instId)
+		srv.deregisterInstanceState(lbName, instId)
 		instId = req.FormValue(fmt.Sprintf("Instances.member.%d.InstanceId", i))
 	}
 	srv.lbs[lbName] = lb
-	srv.removeInstanceStatesFromLoadBalancer(lbName, instId)
 	return elb.SimpleResp{RequestId: reqId}, nil
 }
 
@@ -242,6 +366,17 @@ func (srv *Server) makeInstanceState(id string) *elb.InstanceState {
 	}
 }
 
+// instanceState returns the health state of an instance in the given load
+// balancer. Instances that are not registered are reported as pending.
+func (srv *Server) instanceState(lbName, id string) *elb.InstanceState {
+	for _, state := range srv.instanceStates[lbName] {
+		if state.InstanceId == id {
+			return state
+		}
+	}
+	return srv.makeInstanceState(id)
+}
+
 func removeInstanceFromLB(lb *elb.LoadBalancerDescription, id string) {
 	index := -1
 	for i, instance := range lb.Instances {
@@ -257,6 +392,7 @@ func removeInstanceFromLB(lb *elb.LoadBalancerDescription, id string) {
 }
 
 func (srv *Server) removeInstanceStatesFromLoadBalancer(lb, id string) {
+	delete(srv.draining[lb], id)
 	for i, state := range srv.instanceStates[lb] {
 		if state.InstanceId == id {
 			a := srv.instanceStates[lb]
@@ -267,7 +403,9 @@ func (srv *Server) removeInstanceStatesFromLoadBalancer(lb, id string) {
 	}
 }
 
-func (srv *Server) makeLoadBalancerDescription(value url.Values) *elb.LoadBalancerDescription {
+// makeListenerDescriptions returns the listeners described by the
+// "Listeners.member.N." parameters of a request.
+func (srv *Server) makeListenerDescriptions(value url.Values) []elb.ListenerDescription {
 	lds := []elb.ListenerDescription{}
 	i := 1
 	protocol := value.Get(fmt.Sprintf("Listeners.member.%d.Protocol", i))
@@ -281,19 +419,24 @@ func (srv *Server) makeLoadBalancerDescription(value url.Values) *elb.LoadBalanc
 				InstanceProtocol: strings.ToUpper(value.Get(key + "InstanceProtocol")),
 				LoadBalancerPort: lLBPort,
 				InstancePort:     lInstPort,
+				SSLCertificateId: value.Get(key + "SSLCertificateId"),
 			},
 		}
 		i++
 		protocol = value.Get(fmt.Sprintf("Listeners.member.%d.Protocol", i))
 		lds = append(lds, lDescription)
 	}
+	return lds
+}
+
+func (srv *Server) makeLoadBalancerDescription(value url.Values) *elb.LoadBalancerDescription {
 	sourceSecGroup := srv.makeSourceSecGroup(value)
 	lbDesc := elb.LoadBalancerDescription{
 		AvailZones:           srv.getParameters("AvailabilityZones.member.", value),
 		Subnets:              srv.getParameters("Subnets.member.", value),
 		SecurityGroups:       srv.getParameters("SecurityGroups.member.", value),
 		HealthCheck:          srv.makeHealthCheck(value),
-		ListenerDescriptions: lds,
+		ListenerDescriptions: srv.makeListenerDescriptions(value),
 		Scheme:               value.Get("Scheme"),
 		SourceSecurityGroup:  sourceSecGroup,
 		LoadBalancerName:     value.Get("LoadBalancerName"),
@@ -353,25 +496,25 @@ func (srv *Server) describeInstanceHealth(w http.ResponseWriter, req *http.Reque
 	if err := srv.lbExists(req.FormValue("LoadBalancerName")); err != nil {
 		return nil, err
 	}
+	lbName := req.FormValue("LoadBalancerName")
+	srv.expireDraining(lbName)
+	srv.syncEC2States(lbName)
 	resp := elb.DescribeInstanceHealthResp{
 		InstanceStates: []elb.InstanceState{},
 	}
-	for _, state := range srv.instanceStates[req.FormValue("LoadBalancerName")] {
-		resp.InstanceStates = append(resp.InstanceStates, *state)
-	}
 	i := 1
 	instanceId := req.FormValue("Instances.member.1.InstanceId")
+	if instanceId == "" {
+		for _, state := range srv.instanceStates[lbName] {
+			resp.InstanceStates = append(resp.InstanceStates, *state)
+		}
+		return resp, nil
+	}
 	for instanceId != "" {
 		if err := srv.instanceExists(instanceId); err != nil {
 			return nil, err
 		}
-		is := elb.InstanceState{
-			Description: "Instance is in pending state.",
-			InstanceId:  instanceId,
-			State:       "OutOfService",
-			ReasonCode:  "Instance",
-		}
-		resp.InstanceStates = append(resp.InstanceStates, is)
+		resp.InstanceStates = append(resp.InstanceStates, *srv.instanceState(lbName, instanceId))
 		i++
 		instanceId = req.FormValue(fmt.Sprintf("Instances.member.%d.InstanceId", i))
 	}
@@ -390,34 +533,419 @@ func (srv *Server) configureHealthCheck(w http.ResponseWriter, req *http.Request
 	if err := srv.validate(req, required); err != nil {
 		return nil, err
 	}
-	target := req.FormValue("HealthCheck.Target")
-	r, err := regexp.Compile(`[\w]+:[\d]+\/+`)
+	target, err := elb.ParseHealthCheckTarget(req.FormValue("HealthCheck.Target"))
 	if err != nil {
-		panic(err)
-	}
-	if m := r.FindStringSubmatch(target); m == nil {
 		return nil, &elb.Error{
 			StatusCode: 400,
 			Code:       "ValidationError",
-			Message:    "HealthCheck HTTP Target must specify a port followed by a path that begins with a slash. e.g. HTTP:80/ping/this/path",
+			Message:    err.(*elb.Error).Message,
 		}
 	}
 	ht, _ := strconv.Atoi(req.FormValue("HealthCheck.HealthyThreshold"))
 	interval, _ := strconv.Atoi(req.FormValue("HealthCheck.Interval"))
 	timeout, _ := strconv.Atoi(req.FormValue("HealthCheck.Timeout"))
 	ut, _ := strconv.Atoi(req.FormValue("HealthCheck.UnhealthyThreshold"))
-	return elb.HealthCheckResp{
-		HealthCheck: &elb.HealthCheck{
-			HealthyThreshold:   ht,
-			Interval:           interval,
-			Target:             target,
-			Timeout:            timeout,
-			UnhealthyThreshold: ut,
-		},
+	hc := elb.HealthCheck{
+		HealthyThreshold:   ht,
+		Interval:           interval,
+		Target:             target.String(),
+		Timeout:            timeout,
+		UnhealthyThreshold: ut,
+	}
+	if lb, ok := srv.lbs[req.FormValue("LoadBalancerName")]; ok {
+		lb.HealthCheck = hc
+	}
+	return elb.HealthCheckResp{HealthCheck: &hc}, nil
+}
+
+func (srv *Server) createLoadBalancerListeners(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	required := []string{
+		"LoadBalancerName",
+		"Listeners.member.1.InstancePort",
+		"Listeners.member.1.InstanceProtocol",
+		"Listeners.member.1.Protocol",
+		"Listeners.member.1.LoadBalancerPort",
+	}
+	if err := srv.validate(req, required); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	lb := srv.lbs[lbName]
+	var added []elb.ListenerDescription
+	for _, ld := range srv.makeListenerDescriptions(req.Form) {
+		if l := findListener(lb, ld.Listener.LoadBalancerPort); l != nil {
+			if l.Listener != ld.Listener {
+				return nil, &elb.Error{
+					StatusCode: 400,
+					Code:       "DuplicateListener",
+					Message:    fmt.Sprintf("A listener already exists for %s with LoadBalancerPort %d, but with a different InstancePort, Protocol, or SSLCertificateId", lbName, ld.Listener.LoadBalancerPort),
+				}
+			}
+			continue
+		}
+		added = append(added, ld)
+	}
+	lb.ListenerDescriptions = append(lb.ListenerDescriptions, added...)
+	if err := srv.syncFrontends(lbName); err != nil {
+		lb.ListenerDescriptions = lb.ListenerDescriptions[:len(lb.ListenerDescriptions)-len(added)]
+		srv.syncFrontends(lbName)
+		return nil, frontendError(err)
+	}
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) deleteLoadBalancerListeners(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName", "LoadBalancerPorts.member.1"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	lb := srv.lbs[lbName]
+	for _, p := range srv.getParameters("LoadBalancerPorts.member.", req.Form) {
+		port, _ := strconv.Atoi(p)
+		for i, ld := range lb.ListenerDescriptions {
+			if ld.Listener.LoadBalancerPort == port {
+				lb.ListenerDescriptions = append(lb.ListenerDescriptions[:i], lb.ListenerDescriptions[i+1:]...)
+				break
+			}
+		}
+	}
+	if err := srv.syncFrontends(lbName); err != nil {
+		return nil, frontendError(err)
+	}
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) enableAvailabilityZonesForLoadBalancer(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	return srv.changeMembers(req, reqId, "AvailabilityZones", true)
+}
+
+func (srv *Server) disableAvailabilityZonesForLoadBalancer(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	return srv.changeMembers(req, reqId, "AvailabilityZones", false)
+}
+
+func (srv *Server) attachLoadBalancerToSubnets(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	return srv.changeMembers(req, reqId, "Subnets", true)
+}
+
+func (srv *Server) detachLoadBalancerFromSubnets(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	return srv.changeMembers(req, reqId, "Subnets", false)
+}
+
+// changeMembers adds or removes the availability zones or subnets listed in
+// the request to or from a load balancer.
+func (srv *Server) changeMembers(req *http.Request, reqId, label string, add bool) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName", label + ".member.1"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	lb := srv.lbs[lbName]
+	members := &lb.AvailZones
+	if label == "Subnets" {
+		members = &lb.Subnets
+	}
+	result := []string{}
+	values := srv.getParameters(label+".member.", req.Form)
+	if add {
+		result = append(result, *members...)
+		for _, v := range values {
+			if !contains(result, v) {
+				result = append(result, v)
+			}
+		}
+	} else {
+		for _, m := range *members {
+			if !contains(values, m) {
+				result = append(result, m)
+			}
+		}
+		if len(result) == 0 {
+			return nil, &elb.Error{
+				StatusCode: 400,
+				Code:       "ValidationError",
+				Message:    fmt.Sprintf("Cannot remove all %s from load balancer %s", label, lbName),
+			}
+		}
+	}
+	*members = result
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) applySecurityGroupsToLoadBalancer(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName", "SecurityGroups.member.1"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	srv.lbs[lbName].SecurityGroups = srv.getParameters("SecurityGroups.member.", req.Form)
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) createAppCookieStickinessPolicy(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName", "PolicyName", "CookieName"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	policyName := req.FormValue("PolicyName")
+	if err := srv.policyIsNew(lbName, policyName); err != nil {
+		return nil, err
+	}
+	policies := &srv.lbs[lbName].Policies
+	policies.AppCookieStickinessPolicies = append(policies.AppCookieStickinessPolicies, elb.AppCookieStickinessPolicies{
+		CookieName: req.FormValue("CookieName"),
+		PolicyName: policyName,
+	})
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) createLBCookieStickinessPolicy(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName", "PolicyName"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	policyName := req.FormValue("PolicyName")
+	if err := srv.policyIsNew(lbName, policyName); err != nil {
+		return nil, err
+	}
+	expiration, _ := strconv.Atoi(req.FormValue("CookieExpirationPeriod"))
+	policies := &srv.lbs[lbName].Policies
+	policies.LBCookieStickinessPolicies = append(policies.LBCookieStickinessPolicies, elb.LBCookieStickinessPolicies{
+		CookieExpirationPeriod: expiration,
+		PolicyName:             policyName,
+	})
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) setLoadBalancerPoliciesOfListener(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName", "LoadBalancerPort"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	lb := srv.lbs[lbName]
+	port, _ := strconv.Atoi(req.FormValue("LoadBalancerPort"))
+	l := findListener(lb, port)
+	if l == nil {
+		return nil, &elb.Error{
+			StatusCode: 400,
+			Code:       "ListenerNotFound",
+			Message:    fmt.Sprintf("There is no listener for LoadBalancerPort %d on %s", port, lbName),
+		}
+	}
+	names := srv.getParameters("PolicyNames.member.", req.Form)
+	for _, name := range names {
+		if !hasPolicy(lb, name) {
+			return nil, &elb.Error{
+				StatusCode: 400,
+				Code:       "PolicyNotFound",
+				Message:    fmt.Sprintf("There is no policy named '%s' in %s", name, lbName),
+			}
+		}
+	}
+	l.PolicyNames = names
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) deleteLoadBalancerPolicy(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName", "PolicyName"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	lb := srv.lbs[lbName]
+	name := req.FormValue("PolicyName")
+	for _, ld := range lb.ListenerDescriptions {
+		if contains(ld.PolicyNames, name) {
+			return nil, &elb.Error{
+				StatusCode: 400,
+				Code:       "InvalidConfigurationRequest",
+				Message:    fmt.Sprintf("Cannot delete policy %s, it is in use by the listener on port %d", name, ld.Listener.LoadBalancerPort),
+			}
+		}
+	}
+	var app []elb.AppCookieStickinessPolicies
+	for _, p := range lb.Policies.AppCookieStickinessPolicies {
+		if p.PolicyName != name {
+			app = append(app, p)
+		}
+	}
+	var lbc []elb.LBCookieStickinessPolicies
+	for _, p := range lb.Policies.LBCookieStickinessPolicies {
+		if p.PolicyName != name {
+			lbc = append(lbc, p)
+		}
+	}
+	lb.Policies.AppCookieStickinessPolicies = app
+	lb.Policies.LBCookieStickinessPolicies = lbc
+	return elb.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) policyIsNew(lbName, policyName string) error {
+	if hasPolicy(srv.lbs[lbName], policyName) {
+		return &elb.Error{
+			StatusCode: 400,
+			Code:       "DuplicatePolicyName",
+			Message:    fmt.Sprintf("Policy %s already exists for %s", policyName, lbName),
+		}
+	}
+	return nil
+}
+
+func hasPolicy(lb *elb.LoadBalancerDescription, name string) bool {
+	for _, p := range lb.Policies.AppCookieStickinessPolicies {
+		if p.PolicyName == name {
+			return true
+		}
+	}
+	for _, p := range lb.Policies.LBCookieStickinessPolicies {
+		if p.PolicyName == name {
+			return true
+		}
+	}
+	return contains(lb.Policies.OtherPolicies, name)
+}
+
+func findListener(lb *elb.LoadBalancerDescription, port int) *elb.ListenerDescription {
+	for i := range lb.ListenerDescriptions {
+		if lb.ListenerDescriptions[i].Listener.LoadBalancerPort == port {
+			return &lb.ListenerDescriptions[i]
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *Server) describeLoadBalancerAttributes(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	return elb.DescribeLoadBalancerAttributesResp{
+		LoadBalancerAttributes: *srv.lbAttributes(lbName),
 	}, nil
 }
 
+func (srv *Server) modifyLoadBalancerAttributes(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerName"}); err != nil {
+		return nil, err
+	}
+	lbName := req.FormValue("LoadBalancerName")
+	if err := srv.lbExists(lbName); err != nil {
+		return nil, err
+	}
+	attrs := *srv.lbAttributes(lbName)
+	if v := req.FormValue("LoadBalancerAttributes.ConnectionDraining.Enabled"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &elb.Error{
+				StatusCode: 400,
+				Code:       "ValidationError",
+				Message:    fmt.Sprintf("Invalid value for ConnectionDraining.Enabled: %s", v),
+			}
+		}
+		attrs.ConnectionDraining.Enabled = enabled
+	}
+	if v := req.FormValue("LoadBalancerAttributes.ConnectionDraining.Timeout"); v != "" {
+		timeout, err := strconv.Atoi(v)
+		if err != nil || timeout < 1 || timeout > 3600 {
+			return nil, &elb.Error{
+				StatusCode: 400,
+				Code:       "ValidationError",
+				Message:    "ConnectionDraining timeout must be between 1 and 3600 seconds.",
+			}
+		}
+		attrs.ConnectionDraining.Timeout = timeout
+	}
+	srv.attributes[lbName] = &attrs
+	return elb.ModifyLoadBalancerAttributesResp{
+		LoadBalancerName:       lbName,
+		LoadBalancerAttributes: attrs,
+	}, nil
+}
+
+// lbAttributes returns the attributes of a load balancer, which default to
+// connection draining being disabled.
+func (srv *Server) lbAttributes(lbName string) *elb.LoadBalancerAttributes {
+	if attrs, ok := srv.attributes[lbName]; ok {
+		return attrs
+	}
+	return &elb.LoadBalancerAttributes{
+		ConnectionDraining: elb.ConnectionDraining{Enabled: false, Timeout: 300},
+	}
+}
+
+// deregisterInstanceState removes the health state of a deregistered
+// instance. If connection draining is enabled for the load balancer, the
+// instance is kept in the health report until the draining timeout passes or
+// FinishDraining is called.
+func (srv *Server) deregisterInstanceState(lbName, id string) {
+	draining := srv.lbAttributes(lbName).ConnectionDraining
+	if !draining.Enabled {
+		srv.removeInstanceStatesFromLoadBalancer(lbName, id)
+		return
+	}
+	for i, state := range srv.instanceStates[lbName] {
+		if state.InstanceId == id {
+			srv.instanceStates[lbName][i] = &elb.InstanceState{
+				Description: "Instance deregistration currently in progress.",
+				InstanceId:  id,
+				State:       "OutOfService",
+				ReasonCode:  "ELB",
+			}
+			if srv.draining[lbName] == nil {
+				srv.draining[lbName] = make(map[string]time.Time)
+			}
+			srv.draining[lbName][id] = srv.now().Add(time.Duration(draining.Timeout) * time.Second)
+			return
+		}
+	}
+}
+
+// expireDraining drops the instances whose draining timeout has passed.
+func (srv *Server) expireDraining(lbName string) {
+	now := srv.now()
+	for id, deadline := range srv.draining[lbName] {
+		if !now.Before(deadline) {
+			srv.removeInstanceStatesFromLoadBalancer(lbName, id)
+		}
+	}
+}
+
 func (srv *Server) instanceExists(id string) error {
+	if srv.ec2 != nil {
+		return srv.ec2InstanceExists(id)
+	}
 	for _, instId := range srv.instances {
 		if instId == id {
 			return nil
@@ -485,6 +1013,11 @@ func (srv *Server) validateComposition(req *http.Request, composition map[string
 
 // Creates a fake instance in the server
 func (srv *Server) NewInstance() string {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.ec2 != nil {
+		return srv.ec2.NewInstances(1, "m1.small", "ami-a1b2c3d4", ec2test.Running, nil)[0]
+	}
 	srv.instCount++
 	instId := fmt.Sprintf("i-%d", srv.instCount)
 	srv.instances = append(srv.instances, instId)
@@ -495,15 +1028,20 @@ func (srv *Server) NewInstance() string {
 //
 // If no instance is found it does nothing
 func (srv *Server) RemoveInstance(instId string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
 	for i, id := range srv.instances {
 		if id == instId {
 			srv.instances[i], srv.instances = srv.instances[len(srv.instances)-1], srv.instances[:len(srv.instances)-1]
 		}
 	}
+	delete(srv.backends, instId)
 }
 
 // Creates a fake load balancer in the fake server
 func (srv *Server) NewLoadBalancer(name string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
 	srv.lbs[name] = &elb.LoadBalancerDescription{
 		LoadBalancerName: name,
 		DNSName:          fmt.Sprintf("%s-some-aws-stuff.sa-east-1.amazonaws.com", name),
@@ -512,28 +1050,60 @@ func (srv *Server) NewLoadBalancer(name string) {
 
 // Removes a fake load balancer from the fake server
 func (srv *Server) RemoveLoadBalancer(name string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.removeLoadBalancer(name)
+}
+
+func (srv *Server) removeLoadBalancer(name string) {
 	delete(srv.lbs, name)
+	delete(srv.instanceStates, name)
+	delete(srv.attributes, name)
+	delete(srv.draining, name)
+	srv.syncFrontends(name)
 }
 
 // Register a fake instance with a fake Load Balancer
 //
-// If the Load Balancer does not exists it does nothing
+// If the Load Balancer does not exists or the instance is already registered
+// it does nothing
 func (srv *Server) RegisterInstance(instId, lbName string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.registerInstance(instId, lbName)
+}
+
+func (srv *Server) registerInstance(instId, lbName string) {
 	lb, ok := srv.lbs[lbName]
 	if !ok {
 		fmt.Println("lb not found :/")
 		return
 	}
+	for _, instance := range lb.Instances {
+		if instance.InstanceId == instId {
+			return
+		}
+	}
+	// registering an instance again cancels its connection draining
+	srv.removeInstanceStatesFromLoadBalancer(lbName, instId)
 	lb.Instances = append(lb.Instances, elb.Instance{InstanceId: instId})
 	srv.instanceStates[lbName] = append(srv.instanceStates[lbName], srv.makeInstanceState(instId))
 }
 
 func (srv *Server) DeregisterInstance(instId, lbName string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
 	removeInstanceFromLB(srv.lbs[lbName], instId)
 	srv.removeInstanceStatesFromLoadBalancer(lbName, instId)
 }
 
+// Changes the health state of an instance registered with a fake Load Balancer
+//
+// It is safe to call it while requests are being served, so tests can change
+// the state of an instance that a client is waiting on.
 func (srv *Server) ChangeInstanceState(lb string, state elb.InstanceState) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
 	states := srv.instanceStates[lb]
 	for i, s := range states {
 		if s.InstanceId == state.InstanceId {
@@ -543,12 +1113,37 @@ func (srv *Server) ChangeInstanceState(lb string, state elb.InstanceState) {
 	}
 }
 
+// Finishes connection draining for an instance deregistered from a fake Load
+// Balancer, as if all its in-flight requests had completed
+//
+// If the instance is not draining it does nothing
+func (srv *Server) FinishDraining(lbName, instId string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if _, ok := srv.draining[lbName][instId]; ok {
+		srv.removeInstanceStatesFromLoadBalancer(lbName, instId)
+	}
+}
+
 var actions = map[string]func(*Server, http.ResponseWriter, *http.Request, string) (interface{}, error){
-	"CreateLoadBalancer":                  (*Server).createLoadBalancer,
-	"DeleteLoadBalancer":                  (*Server).deleteLoadBalancer,
-	"RegisterInstancesWithLoadBalancer":   (*Server).registerInstancesWithLoadBalancer,
-	"DeregisterInstancesFromLoadBalancer": (*Server).deregisterInstancesFromLoadBalancer,
-	"DescribeLoadBalancers":               (*Server).describeLoadBalancers,
-	"DescribeInstanceHealth":              (*Server).describeInstanceHealth,
-	"ConfigureHealthCheck":                (*Server).configureHealthCheck,
+	"CreateLoadBalancer":                      (*Server).createLoadBalancer,
+	"DeleteLoadBalancer":                      (*Server).deleteLoadBalancer,
+	"RegisterInstancesWithLoadBalancer":       (*Server).registerInstancesWithLoadBalancer,
+	"DeregisterInstancesFromLoadBalancer":     (*Server).deregisterInstancesFromLoadBalancer,
+	"DescribeLoadBalancers":                   (*Server).describeLoadBalancers,
+	"DescribeInstanceHealth":                  (*Server).describeInstanceHealth,
+	"ConfigureHealthCheck":                    (*Server).configureHealthCheck,
+	"DescribeLoadBalancerAttributes":          (*Server).describeLoadBalancerAttributes,
+	"ModifyLoadBalancerAttributes":            (*Server).modifyLoadBalancerAttributes,
+	"CreateLoadBalancerListeners":             (*Server).createLoadBalancerListeners,
+	"DeleteLoadBalancerListeners":             (*Server).deleteLoadBalancerListeners,
+	"EnableAvailabilityZonesForLoadBalancer":  (*Server).enableAvailabilityZonesForLoadBalancer,
+	"DisableAvailabilityZonesForLoadBalancer": (*Server).disableAvailabilityZonesForLoadBalancer,
+	"AttachLoadBalancerToSubnets":             (*Server).attachLoadBalancerToSubnets,
+	"DetachLoadBalancerFromSubnets":           (*Server).detachLoadBalancerFromSubnets,
+	"ApplySecurityGroupsToLoadBalancer":       (*Server).applySecurityGroupsToLoadBalancer,
+	"CreateAppCookieStickinessPolicy":         (*Server).createAppCookieStickinessPolicy,
+	"CreateLBCookieStickinessPolicy":          (*Server).createLBCookieStickinessPolicy,
+	"SetLoadBalancerPoliciesOfListener":       (*Server).setLoadBalancerPoliciesOfListener,
+	"DeleteLoadBalancerPolicy":                (*Server).deleteLoadBalancerPolicy,
 }
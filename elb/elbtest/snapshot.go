@@ -0,0 +1,149 @@
+package elbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/flaviamissi/go-elb/elb"
+	"sort"
+	"time"
+)
+
+// Snapshot holds the state of a Server: its load balancers, with their
+// listeners, policies and health checks, the health state of their
+// instances and the fake instances created with NewInstance. Snapshots can
+// be serialized as JSON, so that fixtures can be checked in and restored
+// with Server.Restore.
+//
+// Backend addresses set with SetInstanceBackend are local to a test run
+// and are not part of a snapshot.
+type Snapshot struct {
+	LoadBalancers []LoadBalancerSnapshot `json:"loadBalancers"`
+	Instances     []string               `json:"instances,omitempty"`
+
+	// InstanceCount is the number of fake instances created so far, used
+	// to number the next one.
+	InstanceCount int `json:"instanceCount,omitempty"`
+}
+
+// LoadBalancerSnapshot holds the state of a load balancer.
+type LoadBalancerSnapshot struct {
+	Description    elb.LoadBalancerDescription `json:"description"`
+	InstanceStates []elb.InstanceState         `json:"instanceStates,omitempty"`
+	Attributes     *elb.LoadBalancerAttributes `json:"attributes,omitempty"`
+
+	// Draining maps the instances being drained to the time their
+	// draining ends.
+	Draining map[string]time.Time `json:"draining,omitempty"`
+}
+
+// ParseSnapshot parses a snapshot serialized as JSON.
+func ParseSnapshot(data []byte) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("cannot parse elbtest snapshot: %v", err)
+	}
+	return &snap, nil
+}
+
+// JSON returns the snapshot serialized as indented JSON.
+func (snap *Snapshot) JSON() ([]byte, error) {
+	return json.MarshalIndent(snap, "", "\t")
+}
+
+// Snapshot returns the current state of the server. The recorded actions,
+// injected faults and clock are not part of it. Load balancers are sorted
+// by name.
+func (srv *Server) Snapshot() *Snapshot {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	snap := &Snapshot{
+		Instances:     append([]string(nil), srv.instances...),
+		InstanceCount: srv.instCount,
+	}
+	sort.Strings(snap.Instances)
+	for name, lb := range srv.lbs {
+		srv.expireDraining(name)
+		srv.syncEC2States(name)
+		ls := LoadBalancerSnapshot{
+			Description: *lb,
+			Attributes:  srv.attributes[name],
+			Draining:    srv.draining[name],
+		}
+		for _, state := range srv.instanceStates[name] {
+			ls.InstanceStates = append(ls.InstanceStates, *state)
+		}
+		snap.LoadBalancers = append(snap.LoadBalancers, ls)
+	}
+	sort.Slice(snap.LoadBalancers, func(i, j int) bool {
+		return snap.LoadBalancers[i].Description.LoadBalancerName < snap.LoadBalancers[j].Description.LoadBalancerName
+	})
+	// The descriptions share slices and maps with the server state.
+	return snap.copy()
+}
+
+// Restore replaces the state of the server with the given snapshot. The
+// listeners of the previous load balancers are closed and new ones are
+// opened for the restored load balancers, so their addresses change. It
+// returns an error, leaving the server untouched, if two load balancers in
+// the snapshot have the same name, if a load balancer has a health check
+// that HealthCheck.Validate rejects, or if a listener cannot be opened.
+func (srv *Server) Restore(snap *Snapshot) error {
+	snap = snap.copy()
+	lbs := make(map[string]*elb.LoadBalancerDescription)
+	instanceStates := make(map[string][]*elb.InstanceState)
+	attributes := make(map[string]*elb.LoadBalancerAttributes)
+	draining := make(map[string]map[string]time.Time)
+	for i := range snap.LoadBalancers {
+		ls := &snap.LoadBalancers[i]
+		name := ls.Description.LoadBalancerName
+		if _, ok := lbs[name]; ok {
+			return fmt.Errorf("duplicate load balancer name %q", name)
+		}
+		if hc := ls.Description.HealthCheck; hc.Target != "" {
+			if err := hc.Validate(); err != nil {
+				return fmt.Errorf("invalid health check of load balancer %q: %v", name, err)
+			}
+		}
+		lbs[name] = &ls.Description
+		for j := range ls.InstanceStates {
+			instanceStates[name] = append(instanceStates[name], &ls.InstanceStates[j])
+		}
+		if ls.Attributes != nil {
+			attributes[name] = ls.Attributes
+		}
+		if ls.Draining != nil {
+			draining[name] = ls.Draining
+		}
+	}
+
+	frontends, err := srv.openFrontends(lbs)
+	if err != nil {
+		return err
+	}
+
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	closeFrontends(srv.frontends)
+	srv.frontends = frontends
+	srv.lbs = lbs
+	srv.instanceStates = instanceStates
+	srv.attributes = attributes
+	srv.draining = draining
+	srv.health = make(map[string]map[string]*health)
+	srv.instances = snap.Instances
+	srv.instCount = snap.InstanceCount
+	return nil
+}
+
+// copy returns a deep copy of the snapshot.
+func (snap *Snapshot) copy() *Snapshot {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		panic(err)
+	}
+	var c Snapshot
+	if err := json.Unmarshal(data, &c); err != nil {
+		panic(err)
+	}
+	return &c
+}
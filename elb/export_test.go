@@ -2,8 +2,21 @@ package elb
 
 import (
 	"github.com/flaviamissi/go-elb/aws"
+	"time"
 )
 
 func Sign(auth aws.Auth, method, path string, params map[string]string, host string) {
 	sign(auth, method, path, params, host)
 }
+
+// FastWait makes the instance health waiters poll every few milliseconds,
+// so tests against the fake server don't have to wait for real backoffs.
+func FastWait(fast bool) {
+	if fast {
+		waitMinDelay = time.Millisecond
+		waitMaxDelay = 10 * time.Millisecond
+	} else {
+		waitMinDelay = 1 * time.Second
+		waitMaxDelay = 20 * time.Second
+	}
+}
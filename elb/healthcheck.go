@@ -0,0 +1,72 @@
+package elb
+
+import (
+	"strconv"
+	"strings"
+)
+
+// HealthCheckTarget is the parsed form of HealthCheck.Target, the
+// instance endpoint probed by a health check.
+//
+// TCP and SSL targets have only a protocol and a port, e.g. TCP:80, while
+// HTTP and HTTPS targets also have a path starting with a slash, e.g.
+// HTTP:80/ping.
+//
+// See http://docs.aws.amazon.com/ElasticLoadBalancing/latest/APIReference/API_HealthCheck.html
+// for more information.
+type HealthCheckTarget struct {
+	Protocol string
+	Port     int
+	Path     string
+}
+
+// ParseHealthCheckTarget parses and validates a health check target such as
+// TCP:80 or HTTP:80/ping. The protocol is converted to upper case. Invalid
+// targets yield the same ValidationError AWS would return.
+func ParseHealthCheckTarget(target string) (*HealthCheckTarget, error) {
+	i := strings.Index(target, ":")
+	if i < 0 {
+		return nil, validationError("Invalid HealthCheck Target %q, it must be of the form PROTOCOL:PORT, e.g. TCP:80", target)
+	}
+	t := HealthCheckTarget{Protocol: strings.ToUpper(target[:i])}
+	port := target[i+1:]
+	if j := strings.Index(port, "/"); j >= 0 {
+		port, t.Path = port[:j], port[j:]
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, validationError("Invalid HealthCheck Target port %q, it must be between 1 and 65535", port)
+	}
+	t.Port = n
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Validate checks that the target protocol is one of TCP, SSL, HTTP or
+// HTTPS, that the port is in range and that a path is given exactly when
+// the protocol is HTTP or HTTPS.
+func (t *HealthCheckTarget) Validate() error {
+	switch strings.ToUpper(t.Protocol) {
+	case "HTTP", "HTTPS":
+		if !strings.HasPrefix(t.Path, "/") {
+			return validationError("HealthCheck HTTP Target must specify a port followed by a path that begins with a slash. e.g. HTTP:80/ping/this/path")
+		}
+	case "TCP", "SSL":
+		if t.Path != "" {
+			return validationError("HealthCheck %s Target must specify a port only, e.g. %s:%d", strings.ToUpper(t.Protocol), strings.ToUpper(t.Protocol), t.Port)
+		}
+	default:
+		return validationError("Invalid HealthCheck Target protocol %q, it must be one of HTTP, HTTPS, TCP or SSL", t.Protocol)
+	}
+	if t.Port < 1 || t.Port > 65535 {
+		return validationError("Invalid HealthCheck Target port %q, it must be between 1 and 65535", strconv.Itoa(t.Port))
+	}
+	return nil
+}
+
+// String returns the target in the form expected by HealthCheck.Target.
+func (t *HealthCheckTarget) String() string {
+	return strings.ToUpper(t.Protocol) + ":" + strconv.Itoa(t.Port) + t.Path
+}
@@ -0,0 +1,54 @@
+package elb_test
+
+import (
+	"github.com/flaviamissi/go-elb/elb"
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestParseHealthCheckTarget(c *C) {
+	tests := []struct {
+		target   string
+		expected elb.HealthCheckTarget
+		str      string
+	}{
+		{"TCP:80", elb.HealthCheckTarget{Protocol: "TCP", Port: 80}, "TCP:80"},
+		{"ssl:443", elb.HealthCheckTarget{Protocol: "SSL", Port: 443}, "SSL:443"},
+		{"HTTP:8080/", elb.HealthCheckTarget{Protocol: "HTTP", Port: 8080, Path: "/"}, "HTTP:8080/"},
+		{"https:443/ping/this/path", elb.HealthCheckTarget{Protocol: "HTTPS", Port: 443, Path: "/ping/this/path"}, "HTTPS:443/ping/this/path"},
+	}
+	for _, t := range tests {
+		target, err := elb.ParseHealthCheckTarget(t.target)
+		c.Assert(err, IsNil)
+		c.Check(*target, DeepEquals, t.expected)
+		c.Check(target.String(), Equals, t.str)
+	}
+}
+
+func (s *S) TestParseHealthCheckTargetErrors(c *C) {
+	tests := []struct {
+		target string
+		err    string
+	}{
+		{"", `Invalid HealthCheck Target "", it must be of the form PROTOCOL:PORT, e.g. TCP:80 \(ValidationError\)`},
+		{"TCP", `Invalid HealthCheck Target "TCP", it must be of the form PROTOCOL:PORT, e.g. TCP:80 \(ValidationError\)`},
+		{"TCP:80/", `HealthCheck TCP Target must specify a port only, e.g. TCP:80 \(ValidationError\)`},
+		{"SSL:443/ping", `HealthCheck SSL Target must specify a port only, e.g. SSL:443 \(ValidationError\)`},
+		{"HTTP:80", `HealthCheck HTTP Target must specify a port followed by a path that begins with a slash. e.g. HTTP:80/ping/this/path \(ValidationError\)`},
+		{"UDP:53", `Invalid HealthCheck Target protocol "UDP", it must be one of HTTP, HTTPS, TCP or SSL \(ValidationError\)`},
+		{"HTTP:/", `Invalid HealthCheck Target port "", it must be between 1 and 65535 \(ValidationError\)`},
+		{"TCP:65536", `Invalid HealthCheck Target port "65536", it must be between 1 and 65535 \(ValidationError\)`},
+	}
+	for _, t := range tests {
+		target, err := elb.ParseHealthCheckTarget(t.target)
+		c.Check(target, IsNil)
+		c.Check(err, ErrorMatches, t.err)
+	}
+}
+
+func (s *S) TestHealthCheckTargetString(c *C) {
+	target := elb.HealthCheckTarget{Protocol: "http", Port: 80, Path: "/ping"}
+	c.Assert(target.Validate(), IsNil)
+	c.Assert(target.String(), Equals, "HTTP:80/ping")
+	target = elb.HealthCheckTarget{Protocol: "TCP", Port: 80, Path: "/ping"}
+	c.Assert(target.Validate(), ErrorMatches, "HealthCheck TCP Target must specify a port only.*")
+}
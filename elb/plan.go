@@ -0,0 +1,463 @@
+package elb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadBalancerSpec describes the desired state of a Load Balancer, to be
+// converged by Plan and Apply.
+//
+// A nil HealthCheck, AvailZones, Subnets, SecurityGroups or Instances leaves
+// the respective settings of an existing Load Balancer untouched. A non-nil
+// but empty slice is enforced, so e.g. Instances: []string{} deregisters all
+// instances, except that ELB never lets a Load Balancer lose its last zone
+// or subnet: Plan returns an error for a spec that would remove them all.
+// Listeners and stickiness policies are always enforced.
+type LoadBalancerSpec struct {
+	Name           string
+	Scheme         string
+	Listeners      []ListenerDescription
+	HealthCheck    *HealthCheck
+	AvailZones     []string
+	Subnets        []string
+	SecurityGroups []string
+
+	AppCookieStickinessPolicies []AppCookieStickinessPolicies
+	LBCookieStickinessPolicies  []LBCookieStickinessPolicies
+
+	Instances []string
+}
+
+// Change is a single ELB call that is part of a Plan.
+type Change struct {
+	// Action is the name of the ELB action, e.g. "CreateLoadBalancerListeners".
+	Action string
+	// Args holds a human readable description of the action arguments.
+	Args []string
+
+	apply func(elb *ELB) error
+}
+
+func (c *Change) String() string {
+	if len(c.Args) == 0 {
+		return c.Action
+	}
+	return c.Action + " " + strings.Join(c.Args, " ")
+}
+
+// Plan holds the changes needed to converge a Load Balancer to a
+// LoadBalancerSpec, in the order they must be applied.
+type Plan struct {
+	LoadBalancerName string
+	Changes          []*Change
+}
+
+// String returns a textual description of the plan, suitable for dry runs.
+func (p *Plan) String() string {
+	if len(p.Changes) == 0 {
+		return fmt.Sprintf("load balancer %q is up to date\n", p.LoadBalancerName)
+	}
+	s := fmt.Sprintf("load balancer %q:\n", p.LoadBalancerName)
+	for _, c := range p.Changes {
+		s += "  " + c.String() + "\n"
+	}
+	return s
+}
+
+// ApplyError is returned by Apply when one of the changes of a plan fails.
+// Changes applied before it are not reverted.
+type ApplyError struct {
+	Change *Change
+	Err    error
+}
+
+func (err *ApplyError) Error() string {
+	return fmt.Sprintf("cannot apply %s: %v", err.Change, err.Err)
+}
+
+// Plan compares spec with the current state of the Load Balancer it names,
+// as returned by DescribeLoadBalancers, and returns the changes needed to
+// converge them. Nothing is changed in AWS.
+//
+// Changes are ordered so that the Load Balancer keeps serving while they are
+// applied: zones, subnets and instances are added before the old ones are
+// removed, and policies are created before listeners refer to them and
+// deleted only after no listener does.
+func (elb *ELB) Plan(spec *LoadBalancerSpec) (*Plan, error) {
+	if spec.Name == "" {
+		return nil, errors.New("load balancer spec has no name")
+	}
+	current, err := elb.currentLoadBalancer(spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	p := &planner{spec: spec, plan: &Plan{LoadBalancerName: spec.Name}}
+	if current == nil {
+		current = p.create()
+	} else if spec.Scheme != "" && !strings.EqualFold(spec.Scheme, current.Scheme) {
+		return nil, fmt.Errorf("cannot change scheme of load balancer %q from %s to %s", spec.Name, current.Scheme, spec.Scheme)
+	}
+	if err := p.diff(current); err != nil {
+		return nil, err
+	}
+	return p.plan, nil
+}
+
+// Apply carries out the changes of a plan in order, stopping at the first
+// failure, which is returned as an *ApplyError.
+func (elb *ELB) Apply(plan *Plan) error {
+	for _, c := range plan.Changes {
+		if err := c.apply(elb); err != nil {
+			return &ApplyError{Change: c, Err: err}
+		}
+	}
+	return nil
+}
+
+// currentLoadBalancer returns the description of the named Load Balancer,
+// or nil if it does not exist.
+func (elb *ELB) currentLoadBalancer(name string) (*LoadBalancerDescription, error) {
+	resp, err := elb.DescribeLoadBalancers(name)
+	if err != nil {
+		if e, ok := err.(*Error); ok && e.Code == "LoadBalancerNotFound" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for i := range resp.LoadBalancerDescriptions {
+		if resp.LoadBalancerDescriptions[i].LoadBalancerName == name {
+			return &resp.LoadBalancerDescriptions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+type planner struct {
+	spec *LoadBalancerSpec
+	plan *Plan
+}
+
+func (p *planner) add(action string, args []string, apply func(elb *ELB) error) {
+	p.plan.Changes = append(p.plan.Changes, &Change{Action: action, Args: args, apply: apply})
+}
+
+// create adds the creation of the Load Balancer to the plan, and returns the
+// description of the Load Balancer as it will be right after it.
+func (p *planner) create() *LoadBalancerDescription {
+	spec := p.spec
+	options := &CreateLoadBalancer{
+		Name:           spec.Name,
+		AvailZones:     spec.AvailZones,
+		Scheme:         spec.Scheme,
+		SecurityGroups: spec.SecurityGroups,
+		Subnets:        spec.Subnets,
+	}
+	created := &LoadBalancerDescription{
+		LoadBalancerName: spec.Name,
+		AvailZones:       spec.AvailZones,
+		Subnets:          spec.Subnets,
+		SecurityGroups:   spec.SecurityGroups,
+	}
+	for _, ld := range spec.Listeners {
+		options.Listeners = append(options.Listeners, ld.Listener)
+		created.ListenerDescriptions = append(created.ListenerDescriptions, ListenerDescription{Listener: ld.Listener})
+	}
+	args := []string{"listeners=" + listenersString(options.Listeners)}
+	if len(spec.AvailZones) > 0 {
+		args = append(args, "zones="+strings.Join(spec.AvailZones, ","))
+	}
+	if len(spec.Subnets) > 0 {
+		args = append(args, "subnets="+strings.Join(spec.Subnets, ","))
+	}
+	if len(spec.SecurityGroups) > 0 {
+		args = append(args, "security-groups="+strings.Join(spec.SecurityGroups, ","))
+	}
+	if spec.Scheme != "" {
+		args = append(args, "scheme="+spec.Scheme)
+	}
+	p.add("CreateLoadBalancer", args, func(elb *ELB) error {
+		_, err := elb.CreateLoadBalancer(options)
+		return err
+	})
+	return created
+}
+
+func (p *planner) diff(current *LoadBalancerDescription) error {
+	spec := p.spec
+	name := spec.Name
+
+	if spec.AvailZones != nil && len(spec.AvailZones) == 0 && len(current.AvailZones) > 0 {
+		return fmt.Errorf("cannot remove all availability zones of load balancer %q", name)
+	}
+	if spec.Subnets != nil && len(spec.Subnets) == 0 && len(current.Subnets) > 0 {
+		return fmt.Errorf("cannot remove all subnets of load balancer %q", name)
+	}
+	var addZones, removeZones, addSubnets, removeSubnets []string
+	if spec.AvailZones != nil {
+		addZones = difference(spec.AvailZones, current.AvailZones)
+		removeZones = difference(current.AvailZones, spec.AvailZones)
+	}
+	if spec.Subnets != nil {
+		addSubnets = difference(spec.Subnets, current.Subnets)
+		removeSubnets = difference(current.Subnets, spec.Subnets)
+	}
+	if len(addZones) > 0 {
+		p.add("EnableAvailabilityZonesForLoadBalancer", addZones, func(elb *ELB) error {
+			_, err := elb.EnableAvailabilityZonesForLoadBalancer(name, addZones...)
+			return err
+		})
+	}
+	if len(addSubnets) > 0 {
+		p.add("AttachLoadBalancerToSubnets", addSubnets, func(elb *ELB) error {
+			_, err := elb.AttachLoadBalancerToSubnets(name, addSubnets...)
+			return err
+		})
+	}
+	if spec.SecurityGroups != nil && !sameSet(spec.SecurityGroups, current.SecurityGroups) {
+		groups := spec.SecurityGroups
+		p.add("ApplySecurityGroupsToLoadBalancer", groups, func(elb *ELB) error {
+			_, err := elb.ApplySecurityGroupsToLoadBalancer(name, groups...)
+			return err
+		})
+	}
+
+	removePolicies, err := p.diffPolicies(current)
+	if err != nil {
+		return err
+	}
+	p.diffListeners(current)
+
+	if hc := spec.HealthCheck; hc != nil && !sameHealthCheck(*hc, current.HealthCheck) {
+		args := []string{
+			"target=" + hc.Target,
+			"interval=" + strconv.Itoa(hc.Interval),
+			"timeout=" + strconv.Itoa(hc.Timeout),
+			"healthy=" + strconv.Itoa(hc.HealthyThreshold),
+			"unhealthy=" + strconv.Itoa(hc.UnhealthyThreshold),
+		}
+		p.add("ConfigureHealthCheck", args, func(elb *ELB) error {
+			_, err := elb.ConfigureHealthCheck(name, hc)
+			return err
+		})
+	}
+
+	if spec.Instances != nil {
+		var registered []string
+		for _, inst := range current.Instances {
+			registered = append(registered, inst.InstanceId)
+		}
+		register := difference(spec.Instances, registered)
+		deregister := difference(registered, spec.Instances)
+		if len(register) > 0 {
+			p.add("RegisterInstancesWithLoadBalancer", register, func(elb *ELB) error {
+				_, err := elb.RegisterInstancesWithLoadBalancer(register, name)
+				return err
+			})
+		}
+		if len(deregister) > 0 {
+			p.add("DeregisterInstancesFromLoadBalancer", deregister, func(elb *ELB) error {
+				_, err := elb.DeregisterInstancesFromLoadBalancer(deregister, name)
+				return err
+			})
+		}
+	}
+
+	for _, policy := range removePolicies {
+		policy := policy
+		p.add("DeleteLoadBalancerPolicy", []string{policy}, func(elb *ELB) error {
+			_, err := elb.DeleteLoadBalancerPolicy(name, policy)
+			return err
+		})
+	}
+	if len(removeSubnets) > 0 {
+		p.add("DetachLoadBalancerFromSubnets", removeSubnets, func(elb *ELB) error {
+			_, err := elb.DetachLoadBalancerFromSubnets(name, removeSubnets...)
+			return err
+		})
+	}
+	if len(removeZones) > 0 {
+		p.add("DisableAvailabilityZonesForLoadBalancer", removeZones, func(elb *ELB) error {
+			_, err := elb.DisableAvailabilityZonesForLoadBalancer(name, removeZones...)
+			return err
+		})
+	}
+	return nil
+}
+
+// diffPolicies adds the creation of missing stickiness policies to the plan,
+// and returns the names of the policies that must be deleted once no
+// listener uses them anymore. Policies cannot be modified in place, so a
+// policy whose settings differ from the spec is an error.
+func (p *planner) diffPolicies(current *LoadBalancerDescription) ([]string, error) {
+	name := p.spec.Name
+	wanted := make(map[string]bool)
+	for _, policy := range p.spec.AppCookieStickinessPolicies {
+		policy := policy
+		wanted[policy.PolicyName] = true
+		found := false
+		for _, cp := range current.Policies.AppCookieStickinessPolicies {
+			if cp.PolicyName == policy.PolicyName {
+				if cp != policy {
+					return nil, fmt.Errorf("policy %q of load balancer %q cannot be modified", policy.PolicyName, name)
+				}
+				found = true
+			}
+		}
+		if !found {
+			args := []string{policy.PolicyName, "cookie=" + policy.CookieName}
+			p.add("CreateAppCookieStickinessPolicy", args, func(elb *ELB) error {
+				_, err := elb.CreateAppCookieStickinessPolicy(name, policy.PolicyName, policy.CookieName)
+				return err
+			})
+		}
+	}
+	for _, policy := range p.spec.LBCookieStickinessPolicies {
+		policy := policy
+		wanted[policy.PolicyName] = true
+		found := false
+		for _, cp := range current.Policies.LBCookieStickinessPolicies {
+			if cp.PolicyName == policy.PolicyName {
+				if cp != policy {
+					return nil, fmt.Errorf("policy %q of load balancer %q cannot be modified", policy.PolicyName, name)
+				}
+				found = true
+			}
+		}
+		if !found {
+			args := []string{policy.PolicyName, "expiration=" + strconv.Itoa(policy.CookieExpirationPeriod)}
+			p.add("CreateLBCookieStickinessPolicy", args, func(elb *ELB) error {
+				_, err := elb.CreateLBCookieStickinessPolicy(name, policy.PolicyName, policy.CookieExpirationPeriod)
+				return err
+			})
+		}
+	}
+	var remove []string
+	for _, cp := range current.Policies.AppCookieStickinessPolicies {
+		if !wanted[cp.PolicyName] {
+			remove = append(remove, cp.PolicyName)
+		}
+	}
+	for _, cp := range current.Policies.LBCookieStickinessPolicies {
+		if !wanted[cp.PolicyName] {
+			remove = append(remove, cp.PolicyName)
+		}
+	}
+	return remove, nil
+}
+
+// diffListeners adds to the plan the changes needed to converge listeners
+// and the policies they use. A listener whose settings differ from the spec
+// is deleted and created again.
+func (p *planner) diffListeners(current *LoadBalancerDescription) {
+	name := p.spec.Name
+	var deletePorts []int
+	var create []Listener
+	var setPolicies []ListenerDescription
+	for _, ld := range current.ListenerDescriptions {
+		want := findListenerDescription(p.spec.Listeners, ld.Listener.LoadBalancerPort)
+		if want == nil {
+			deletePorts = append(deletePorts, ld.Listener.LoadBalancerPort)
+		} else if !sameListener(want.Listener, ld.Listener) {
+			deletePorts = append(deletePorts, ld.Listener.LoadBalancerPort)
+			create = append(create, want.Listener)
+			if len(want.PolicyNames) > 0 {
+				setPolicies = append(setPolicies, *want)
+			}
+		} else if !sameSet(want.PolicyNames, ld.PolicyNames) {
+			setPolicies = append(setPolicies, *want)
+		}
+	}
+	for _, want := range p.spec.Listeners {
+		if findListenerDescription(current.ListenerDescriptions, want.Listener.LoadBalancerPort) == nil {
+			create = append(create, want.Listener)
+			if len(want.PolicyNames) > 0 {
+				setPolicies = append(setPolicies, want)
+			}
+		}
+	}
+	if len(deletePorts) > 0 {
+		var args []string
+		for _, port := range deletePorts {
+			args = append(args, strconv.Itoa(port))
+		}
+		p.add("DeleteLoadBalancerListeners", args, func(elb *ELB) error {
+			_, err := elb.DeleteLoadBalancerListeners(name, deletePorts...)
+			return err
+		})
+	}
+	if len(create) > 0 {
+		p.add("CreateLoadBalancerListeners", []string{listenersString(create)}, func(elb *ELB) error {
+			_, err := elb.CreateLoadBalancerListeners(name, create...)
+			return err
+		})
+	}
+	for _, ld := range setPolicies {
+		ld := ld
+		args := []string{"port=" + strconv.Itoa(ld.Listener.LoadBalancerPort), "policies=" + strings.Join(ld.PolicyNames, ",")}
+		p.add("SetLoadBalancerPoliciesOfListener", args, func(elb *ELB) error {
+			_, err := elb.SetLoadBalancerPoliciesOfListener(name, ld.Listener.LoadBalancerPort, ld.PolicyNames...)
+			return err
+		})
+	}
+}
+
+func findListenerDescription(lds []ListenerDescription, port int) *ListenerDescription {
+	for i := range lds {
+		if lds[i].Listener.LoadBalancerPort == port {
+			return &lds[i]
+		}
+	}
+	return nil
+}
+
+func sameListener(a, b Listener) bool {
+	return a.LoadBalancerPort == b.LoadBalancerPort &&
+		a.InstancePort == b.InstancePort &&
+		strings.EqualFold(a.Protocol, b.Protocol) &&
+		strings.EqualFold(a.InstanceProtocol, b.InstanceProtocol) &&
+		a.SSLCertificateId == b.SSLCertificateId
+}
+
+// sameHealthCheck reports whether a and b are the same health check. ELB
+// upper-cases the protocol of the target, so targets are compared in their
+// canonical form.
+func sameHealthCheck(a, b HealthCheck) bool {
+	a.Target, b.Target = canonicalTarget(a.Target), canonicalTarget(b.Target)
+	return a == b
+}
+
+func canonicalTarget(target string) string {
+	t, err := ParseHealthCheckTarget(target)
+	if err != nil {
+		return target
+	}
+	return t.String()
+}
+
+func listenersString(listeners []Listener) string {
+	var s []string
+	for _, l := range listeners {
+		s = append(s, fmt.Sprintf("%s:%d->%s:%d", strings.ToUpper(l.Protocol), l.LoadBalancerPort, strings.ToUpper(l.InstanceProtocol), l.InstancePort))
+	}
+	return strings.Join(s, ",")
+}
+
+// difference returns the values in a that are not in b, sorted.
+func difference(a, b []string) []string {
+	var result []string
+	for _, v := range a {
+		if !contains(b, v) && !contains(result, v) {
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func sameSet(a, b []string) bool {
+	return len(difference(a, b)) == 0 && len(difference(b, a)) == 0
+}
@@ -0,0 +1,214 @@
+package elb
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReplaceOptions encapsulates options for ReplaceInstances.
+type ReplaceOptions struct {
+	// BatchSize is the number of instances registered or deregistered at
+	// a time. It defaults to 1.
+	BatchSize int
+
+	// MinHealthyPercent is the percentage of the old instances count that
+	// must remain InService while old instances are being deregistered.
+	MinHealthyPercent int
+
+	// HealthTimeout is how long to wait for each batch of new instances
+	// to become InService. It defaults to 5 minutes.
+	HealthTimeout time.Duration
+
+	// Rollback, when set, makes ReplaceInstances restore the original set
+	// of instances if the replacement fails midway.
+	Rollback bool
+
+	// Progress, if not nil, is called after every step of the replacement.
+	Progress func(ReplaceEvent)
+}
+
+// ReplaceEvent describes a step carried out by ReplaceInstances.
+type ReplaceEvent struct {
+	// Action is one of "register", "in-service", "deregister",
+	// "rollback-register" or "rollback-deregister".
+	Action      string
+	InstanceIds []string
+	// Batch is the 1-based index of the batch the step belongs to, out of
+	// Batches batches. Both are zero for rollback steps.
+	Batch   int
+	Batches int
+}
+
+// ReplaceError is returned by ReplaceInstances when the replacement fails.
+// Err holds the error that stopped the replacement. If a rollback was
+// attempted, RolledBack is true and RollbackErr holds the error that
+// interrupted it, if any.
+type ReplaceError struct {
+	Err         error
+	RolledBack  bool
+	RollbackErr error
+}
+
+func (err *ReplaceError) Error() string {
+	msg := "cannot replace instances: " + err.Err.Error()
+	if err.RollbackErr != nil {
+		msg += "; rollback failed: " + err.RollbackErr.Error()
+	} else if err.RolledBack {
+		msg += "; rolled back"
+	}
+	return msg
+}
+
+// ReplaceInstances replaces the instances oldIds by newIds in the given load
+// balancer, batch by batch. For every batch, new instances are registered and
+// waited on until they are InService, and then the same number of old
+// instances is deregistered, as long as enough instances remain healthy.
+// Old instances left once all new instances are in service are deregistered
+// in batches as well.
+//
+// If any step fails and options.Rollback is set, every new instance is
+// deregistered and every old instance that was already deregistered is
+// registered again.
+func (elb *ELB) ReplaceInstances(lbName string, oldIds, newIds []string, options *ReplaceOptions) error {
+	r := &replacer{elb: elb, lbName: lbName}
+	if options != nil {
+		r.options = *options
+	}
+	if r.options.BatchSize <= 0 {
+		r.options.BatchSize = 1
+	}
+	if r.options.HealthTimeout <= 0 {
+		r.options.HealthTimeout = 5 * time.Minute
+	}
+	r.minHealthy = (len(oldIds)*r.options.MinHealthyPercent + 99) / 100
+	err := r.replace(oldIds, newIds)
+	if err == nil {
+		return nil
+	}
+	rerr := &ReplaceError{Err: err}
+	if r.options.Rollback {
+		rerr.RolledBack = true
+		rerr.RollbackErr = r.rollback()
+	}
+	return rerr
+}
+
+type replacer struct {
+	elb          *ELB
+	lbName       string
+	options      ReplaceOptions
+	minHealthy   int
+	registered   []string
+	deregistered []string
+}
+
+func (r *replacer) replace(oldIds, newIds []string) error {
+	size := r.options.BatchSize
+	batches := batchCount(len(newIds), size)
+	if n := batchCount(len(oldIds), size); n > batches {
+		batches = n
+	}
+	for i := 0; i < batches; i++ {
+		if batch := nthBatch(newIds, i, size); len(batch) > 0 {
+			if _, err := r.elb.RegisterInstancesWithLoadBalancer(batch, r.lbName); err != nil {
+				return err
+			}
+			r.registered = append(r.registered, batch...)
+			r.notify("register", batch, i+1, batches)
+			if err := r.elb.WaitForInstancesInService(r.lbName, batch, r.options.HealthTimeout); err != nil {
+				return err
+			}
+			r.notify("in-service", batch, i+1, batches)
+		}
+		if batch := nthBatch(oldIds, i, size); len(batch) > 0 {
+			if err := r.checkHealthy(batch); err != nil {
+				return err
+			}
+			if _, err := r.elb.DeregisterInstancesFromLoadBalancer(batch, r.lbName); err != nil {
+				return err
+			}
+			r.deregistered = append(r.deregistered, batch...)
+			r.notify("deregister", batch, i+1, batches)
+		}
+	}
+	return nil
+}
+
+// checkHealthy returns an error if deregistering the given instances would
+// leave fewer than r.minHealthy instances in service.
+func (r *replacer) checkHealthy(leaving []string) error {
+	if r.minHealthy == 0 {
+		return nil
+	}
+	resp, err := r.elb.DescribeInstanceHealth(r.lbName)
+	if err != nil {
+		return err
+	}
+	healthy := 0
+	for _, s := range resp.InstanceStates {
+		if s.State == "InService" && !contains(leaving, s.InstanceId) {
+			healthy++
+		}
+	}
+	if healthy < r.minHealthy {
+		return fmt.Errorf("deregistering %v would leave %d healthy instances, at least %d are required", leaving, healthy, r.minHealthy)
+	}
+	return nil
+}
+
+func (r *replacer) rollback() error {
+	if len(r.deregistered) > 0 {
+		if _, err := r.elb.RegisterInstancesWithLoadBalancer(r.deregistered, r.lbName); err != nil {
+			return err
+		}
+		r.notify("rollback-register", r.deregistered, 0, 0)
+		if err := r.elb.WaitForInstancesInService(r.lbName, r.deregistered, r.options.HealthTimeout); err != nil {
+			return err
+		}
+	}
+	if len(r.registered) > 0 {
+		if _, err := r.elb.DeregisterInstancesFromLoadBalancer(r.registered, r.lbName); err != nil {
+			return err
+		}
+		r.notify("rollback-deregister", r.registered, 0, 0)
+	}
+	return nil
+}
+
+func (r *replacer) notify(action string, ids []string, batch, batches int) {
+	if r.options.Progress == nil {
+		return
+	}
+	r.options.Progress(ReplaceEvent{
+		Action:      action,
+		InstanceIds: ids,
+		Batch:       batch,
+		Batches:     batches,
+	})
+}
+
+func batchCount(n, size int) int {
+	return (n + size - 1) / size
+}
+
+// nthBatch returns the i-th batch of the given size from ids.
+func nthBatch(ids []string, i, size int) []string {
+	start := i * size
+	if start >= len(ids) {
+		return nil
+	}
+	end := start + size
+	if end > len(ids) {
+		end = len(ids)
+	}
+	return ids[start:end]
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
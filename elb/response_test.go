@@ -203,3 +203,113 @@ var ConfigureHealthCheckBadRequest = `
     <RequestId>2d9fe4a5-5697-11e2-9415-e325c02171d7</RequestId>
 </ErrorResponse>
 `
+
+var DescribeLoadBalancerAttributes = `
+<DescribeLoadBalancerAttributesResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <DescribeLoadBalancerAttributesResult>
+        <LoadBalancerAttributes>
+            <ConnectionDraining>
+                <Enabled>true</Enabled>
+                <Timeout>60</Timeout>
+            </ConnectionDraining>
+        </LoadBalancerAttributes>
+    </DescribeLoadBalancerAttributesResult>
+    <ResponseMetadata>
+        <RequestId>83c88b9d-12b7-11e3-8b82-87b12EXAMPLE</RequestId>
+    </ResponseMetadata>
+</DescribeLoadBalancerAttributesResponse>
+`
+
+var ModifyLoadBalancerAttributes = `
+<ModifyLoadBalancerAttributesResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <ModifyLoadBalancerAttributesResult>
+        <LoadBalancerName>testlb</LoadBalancerName>
+        <LoadBalancerAttributes>
+            <ConnectionDraining>
+                <Enabled>true</Enabled>
+                <Timeout>60</Timeout>
+            </ConnectionDraining>
+        </LoadBalancerAttributes>
+    </ModifyLoadBalancerAttributesResult>
+    <ResponseMetadata>
+        <RequestId>83c88b9d-12b7-11e3-8b82-87b12EXAMPLE</RequestId>
+    </ResponseMetadata>
+</ModifyLoadBalancerAttributesResponse>
+`
+
+var CreateLoadBalancerListeners = `
+<CreateLoadBalancerListenersResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <CreateLoadBalancerListenersResult/>
+    <ResponseMetadata>
+        <RequestId>1549581b-12b7-11e3-895e-1334aEXAMPLE</RequestId>
+    </ResponseMetadata>
+</CreateLoadBalancerListenersResponse>
+`
+
+var DeleteLoadBalancerListeners = `
+<DeleteLoadBalancerListenersResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <DeleteLoadBalancerListenersResult/>
+    <ResponseMetadata>
+        <RequestId>83c88b9d-12b7-11e3-8b82-87b12EXAMPLE</RequestId>
+    </ResponseMetadata>
+</DeleteLoadBalancerListenersResponse>
+`
+
+var EnableAvailabilityZonesForLoadBalancer = `
+<EnableAvailabilityZonesForLoadBalancerResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <EnableAvailabilityZonesForLoadBalancerResult>
+        <AvailabilityZones>
+            <member>us-east-1a</member>
+            <member>us-east-1b</member>
+        </AvailabilityZones>
+    </EnableAvailabilityZonesForLoadBalancerResult>
+    <ResponseMetadata>
+        <RequestId>83c88b9d-12b7-11e3-8b82-87b12EXAMPLE</RequestId>
+    </ResponseMetadata>
+</EnableAvailabilityZonesForLoadBalancerResponse>
+`
+
+var AttachLoadBalancerToSubnets = `
+<AttachLoadBalancerToSubnetsResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <AttachLoadBalancerToSubnetsResult>
+        <Subnets>
+            <member>subnet-3561b05d</member>
+            <member>subnet-119f0078</member>
+        </Subnets>
+    </AttachLoadBalancerToSubnetsResult>
+    <ResponseMetadata>
+        <RequestId>07b1ecbc-1100-11e3-acaf-dd7edEXAMPLE</RequestId>
+    </ResponseMetadata>
+</AttachLoadBalancerToSubnetsResponse>
+`
+
+var ApplySecurityGroupsToLoadBalancer = `
+<ApplySecurityGroupsToLoadBalancerResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <ApplySecurityGroupsToLoadBalancerResult>
+        <SecurityGroups>
+            <member>sg-123456789</member>
+        </SecurityGroups>
+    </ApplySecurityGroupsToLoadBalancerResult>
+    <ResponseMetadata>
+        <RequestId>06b5decc-102a-11e3-9ad6-bf3e4EXAMPLE</RequestId>
+    </ResponseMetadata>
+</ApplySecurityGroupsToLoadBalancerResponse>
+`
+
+var CreateLBCookieStickinessPolicy = `
+<CreateLBCookieStickinessPolicyResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <CreateLBCookieStickinessPolicyResult/>
+    <ResponseMetadata>
+        <RequestId>99a693e9-12b8-11e3-9ad6-bf3e4EXAMPLE</RequestId>
+    </ResponseMetadata>
+</CreateLBCookieStickinessPolicyResponse>
+`
+
+var SetLoadBalancerPoliciesOfListener = `
+<SetLoadBalancerPoliciesOfListenerResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+    <SetLoadBalancerPoliciesOfListenerResult/>
+    <ResponseMetadata>
+        <RequestId>07b1ecbc-1100-11e3-acaf-dd7edEXAMPLE</RequestId>
+    </ResponseMetadata>
+</SetLoadBalancerPoliciesOfListenerResponse>
+`
@@ -0,0 +1,115 @@
+package elb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var lbNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,30}[a-zA-Z0-9])?$`)
+
+// validationError returns an error in the same form as the ValidationError
+// returned by AWS. Its StatusCode is zero, as the request was never sent.
+func validationError(format string, args ...interface{}) error {
+	return &Error{Code: "ValidationError", Message: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks the options for obvious mistakes that AWS would reject
+// with a ValidationError. It is called by CreateLoadBalancer unless
+// ELB.SkipValidation is set.
+func (options *CreateLoadBalancer) Validate() error {
+	if !lbNameRegexp.MatchString(options.Name) {
+		return validationError("LoadBalancerName %q must have at most 32 characters, contain only alphanumeric characters or hyphens, and must not begin or end with a hyphen", options.Name)
+	}
+	if len(options.AvailZones) > 0 && len(options.Subnets) > 0 {
+		return validationError("Only one of SubnetIds or AvailabilityZones may be specified")
+	}
+	if len(options.AvailZones) == 0 && len(options.Subnets) == 0 {
+		return validationError("Either AvailabilityZones or SubnetIds must be specified")
+	}
+	switch options.Scheme {
+	case "", "internet-facing", "internal":
+	default:
+		return validationError("Invalid Scheme %q, it must be internet-facing or internal", options.Scheme)
+	}
+	if len(options.Listeners) == 0 {
+		return validationError("At least one Listener must be specified")
+	}
+	ports := make(map[int]bool)
+	for _, l := range options.Listeners {
+		if err := l.Validate(); err != nil {
+			return err
+		}
+		if ports[l.LoadBalancerPort] {
+			return validationError("Duplicate LoadBalancerPort %d", l.LoadBalancerPort)
+		}
+		ports[l.LoadBalancerPort] = true
+	}
+	return nil
+}
+
+// Validate checks the listener ports and protocols. The front-end and
+// instance protocols must be of the same layer: HTTP and HTTPS go together,
+// as do TCP and SSL. An empty InstanceProtocol is valid, AWS defaults it
+// based on Protocol.
+func (l *Listener) Validate() error {
+	if l.LoadBalancerPort < 1 || l.LoadBalancerPort > 65535 {
+		return validationError("LoadBalancerPort must be between 1 and 65535, got %d", l.LoadBalancerPort)
+	}
+	if l.InstancePort < 1 || l.InstancePort > 65535 {
+		return validationError("InstancePort must be between 1 and 65535, got %d", l.InstancePort)
+	}
+	layer := protocolLayer(l.Protocol)
+	if layer == "" {
+		return validationError("Invalid Protocol %q, it must be one of HTTP, HTTPS, TCP or SSL", l.Protocol)
+	}
+	if l.InstanceProtocol != "" {
+		instanceLayer := protocolLayer(l.InstanceProtocol)
+		if instanceLayer == "" {
+			return validationError("Invalid InstanceProtocol %q, it must be one of HTTP, HTTPS, TCP or SSL", l.InstanceProtocol)
+		}
+		if instanceLayer != layer {
+			return validationError("Listener protocol %s and instance protocol %s are not compatible", strings.ToUpper(l.Protocol), strings.ToUpper(l.InstanceProtocol))
+		}
+	}
+	if p := strings.ToUpper(l.Protocol); (p == "HTTPS" || p == "SSL") && l.SSLCertificateId == "" {
+		return validationError("Secure Listeners need to specify a SSLCertificateId")
+	}
+	return nil
+}
+
+// protocolLayer returns "http" for HTTP and HTTPS, "tcp" for TCP and SSL and
+// an empty string for anything else.
+func protocolLayer(protocol string) string {
+	switch strings.ToUpper(protocol) {
+	case "HTTP", "HTTPS":
+		return "http"
+	case "TCP", "SSL":
+		return "tcp"
+	}
+	return ""
+}
+
+// Validate checks the health check target syntax and its thresholds. It is
+// called by ConfigureHealthCheck unless ELB.SkipValidation is set.
+func (hc *HealthCheck) Validate() error {
+	if _, err := ParseHealthCheckTarget(hc.Target); err != nil {
+		return err
+	}
+	if hc.Interval < 5 || hc.Interval > 300 {
+		return validationError("HealthCheck Interval must be between 5 and 300 seconds, got %d", hc.Interval)
+	}
+	if hc.Timeout < 2 || hc.Timeout > 60 {
+		return validationError("HealthCheck Timeout must be between 2 and 60 seconds, got %d", hc.Timeout)
+	}
+	if hc.Interval <= hc.Timeout {
+		return validationError("HealthCheck Interval must be greater than the Timeout.")
+	}
+	if hc.HealthyThreshold < 2 || hc.HealthyThreshold > 10 {
+		return validationError("HealthCheck HealthyThreshold must be between 2 and 10, got %d", hc.HealthyThreshold)
+	}
+	if hc.UnhealthyThreshold < 2 || hc.UnhealthyThreshold > 10 {
+		return validationError("HealthCheck UnhealthyThreshold must be between 2 and 10, got %d", hc.UnhealthyThreshold)
+	}
+	return nil
+}
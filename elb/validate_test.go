@@ -0,0 +1,112 @@
+package elb_test
+
+import (
+	"github.com/flaviamissi/go-elb/elb"
+	. "launchpad.net/gocheck"
+)
+
+func validCreateLoadBalancer() *elb.CreateLoadBalancer {
+	return &elb.CreateLoadBalancer{
+		Name:       "my-lb-1",
+		AvailZones: []string{"us-east-1a"},
+		Listeners: []elb.Listener{
+			{InstancePort: 8080, InstanceProtocol: "http", LoadBalancerPort: 80, Protocol: "http"},
+		},
+	}
+}
+
+func (s *S) TestCreateLoadBalancerValidate(c *C) {
+	c.Assert(validCreateLoadBalancer().Validate(), IsNil)
+	tests := []struct {
+		change func(*elb.CreateLoadBalancer)
+		err    string
+	}{
+		{func(o *elb.CreateLoadBalancer) { o.Name = "" }, `LoadBalancerName "" must have .*`},
+		{func(o *elb.CreateLoadBalancer) { o.Name = "-lb" }, `LoadBalancerName "-lb" must have .*`},
+		{func(o *elb.CreateLoadBalancer) { o.Name = "lb-" }, `LoadBalancerName "lb-" must have .*`},
+		{func(o *elb.CreateLoadBalancer) { o.Name = "my_lb" }, `LoadBalancerName "my_lb" must have .*`},
+		{func(o *elb.CreateLoadBalancer) { o.Name = "a23456789012345678901234567890123" }, `LoadBalancerName .* must have .*`},
+		{func(o *elb.CreateLoadBalancer) { o.Subnets = []string{"subnet-1"} }, `Only one of SubnetIds or AvailabilityZones may be specified \(ValidationError\)`},
+		{func(o *elb.CreateLoadBalancer) { o.AvailZones = nil }, `Either AvailabilityZones or SubnetIds must be specified \(ValidationError\)`},
+		{func(o *elb.CreateLoadBalancer) { o.Scheme = "public" }, `Invalid Scheme "public".*`},
+		{func(o *elb.CreateLoadBalancer) { o.Listeners = nil }, `At least one Listener must be specified \(ValidationError\)`},
+		{func(o *elb.CreateLoadBalancer) { o.Listeners = append(o.Listeners, o.Listeners[0]) }, `Duplicate LoadBalancerPort 80 \(ValidationError\)`},
+		{func(o *elb.CreateLoadBalancer) { o.Listeners[0].InstancePort = 0 }, `InstancePort must be between 1 and 65535, got 0 \(ValidationError\)`},
+	}
+	for _, t := range tests {
+		options := validCreateLoadBalancer()
+		t.change(options)
+		c.Check(options.Validate(), ErrorMatches, t.err)
+	}
+	options := validCreateLoadBalancer()
+	options.Name = "a2345678901234567890123456789012"
+	options.AvailZones = nil
+	options.Subnets = []string{"subnet-1"}
+	options.Scheme = "internal"
+	c.Assert(options.Validate(), IsNil)
+}
+
+func (s *S) TestListenerValidate(c *C) {
+	valid := []elb.Listener{
+		{InstancePort: 80, InstanceProtocol: "HTTP", LoadBalancerPort: 80, Protocol: "HTTP"},
+		{InstancePort: 443, InstanceProtocol: "https", LoadBalancerPort: 80, Protocol: "http"},
+		{InstancePort: 80, InstanceProtocol: "http", LoadBalancerPort: 443, Protocol: "https", SSLCertificateId: "cert"},
+		{InstancePort: 22, InstanceProtocol: "tcp", LoadBalancerPort: 2222, Protocol: "tcp"},
+		{InstancePort: 22, InstanceProtocol: "ssl", LoadBalancerPort: 2222, Protocol: "ssl", SSLCertificateId: "cert"},
+		{InstancePort: 80, LoadBalancerPort: 80, Protocol: "tcp"},
+	}
+	for _, l := range valid {
+		c.Check(l.Validate(), IsNil)
+	}
+	invalid := []struct {
+		listener elb.Listener
+		err      string
+	}{
+		{elb.Listener{InstancePort: 80, LoadBalancerPort: 0, Protocol: "http"}, "LoadBalancerPort must be between 1 and 65535, got 0.*"},
+		{elb.Listener{InstancePort: 65536, LoadBalancerPort: 80, Protocol: "http"}, "InstancePort must be between 1 and 65535, got 65536.*"},
+		{elb.Listener{InstancePort: 80, LoadBalancerPort: 80, Protocol: "udp"}, `Invalid Protocol "udp".*`},
+		{elb.Listener{InstancePort: 80, LoadBalancerPort: 80, Protocol: "http", InstanceProtocol: "ftp"}, `Invalid InstanceProtocol "ftp".*`},
+		{elb.Listener{InstancePort: 80, LoadBalancerPort: 80, Protocol: "http", InstanceProtocol: "tcp"}, "Listener protocol HTTP and instance protocol TCP are not compatible.*"},
+		{elb.Listener{InstancePort: 80, LoadBalancerPort: 80, Protocol: "ssl", InstanceProtocol: "https"}, "Listener protocol SSL and instance protocol HTTPS are not compatible.*"},
+		{elb.Listener{InstancePort: 80, LoadBalancerPort: 443, Protocol: "https", InstanceProtocol: "http"}, "Secure Listeners need to specify a SSLCertificateId.*"},
+	}
+	for _, t := range invalid {
+		c.Check(t.listener.Validate(), ErrorMatches, t.err)
+	}
+}
+
+func (s *S) TestHealthCheckValidate(c *C) {
+	valid := func(target string) *elb.HealthCheck {
+		return &elb.HealthCheck{
+			HealthyThreshold:   10,
+			Interval:           30,
+			Target:             target,
+			Timeout:            5,
+			UnhealthyThreshold: 2,
+		}
+	}
+	for _, target := range []string{"HTTP:80/", "https:443/ping/this/path", "TCP:80", "SSL:443"} {
+		c.Check(valid(target).Validate(), IsNil)
+	}
+	tests := []struct {
+		change func(*elb.HealthCheck)
+		err    string
+	}{
+		{func(hc *elb.HealthCheck) { hc.Target = "HTTP:80" }, "HealthCheck HTTP Target must specify a port followed by a path that begins with a slash. e.g. HTTP:80/ping/this/path.*"},
+		{func(hc *elb.HealthCheck) { hc.Target = "TCP:80/ping" }, "HealthCheck TCP Target must specify a port only, e.g. TCP:80.*"},
+		{func(hc *elb.HealthCheck) { hc.Target = "UDP:53" }, `Invalid HealthCheck Target protocol "UDP".*`},
+		{func(hc *elb.HealthCheck) { hc.Target = "80" }, `Invalid HealthCheck Target "80".*`},
+		{func(hc *elb.HealthCheck) { hc.Target = "TCP:http" }, `Invalid HealthCheck Target port "http".*`},
+		{func(hc *elb.HealthCheck) { hc.Target = "HTTP:0/" }, `Invalid HealthCheck Target port "0".*`},
+		{func(hc *elb.HealthCheck) { hc.Interval = 301 }, "HealthCheck Interval must be between 5 and 300 seconds, got 301.*"},
+		{func(hc *elb.HealthCheck) { hc.Timeout = 1 }, "HealthCheck Timeout must be between 2 and 60 seconds, got 1.*"},
+		{func(hc *elb.HealthCheck) { hc.Interval, hc.Timeout = 10, 10 }, `HealthCheck Interval must be greater than the Timeout. \(ValidationError\)`},
+		{func(hc *elb.HealthCheck) { hc.HealthyThreshold = 11 }, "HealthCheck HealthyThreshold must be between 2 and 10, got 11.*"},
+		{func(hc *elb.HealthCheck) { hc.UnhealthyThreshold = 1 }, "HealthCheck UnhealthyThreshold must be between 2 and 10, got 1.*"},
+	}
+	for _, t := range tests {
+		hc := valid("HTTP:80/")
+		t.change(hc)
+		c.Check(hc.Validate(), ErrorMatches, t.err)
+	}
+}
@@ -0,0 +1,104 @@
+package elb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Delays used between consecutive DescribeInstanceHealth calls while
+// waiting for instances to reach a given state. The delay starts at
+// waitMinDelay and doubles after every attempt, up to waitMaxDelay.
+var (
+	waitMinDelay = 1 * time.Second
+	waitMaxDelay = 20 * time.Second
+)
+
+// WaitTimeoutError is returned when instances do not reach the expected
+// state within the given timeout. InstanceStates holds the last known
+// state of every instance that was still lagging behind.
+type WaitTimeoutError struct {
+	LoadBalancerName string
+	State            string
+	Timeout          time.Duration
+	InstanceStates   []InstanceState
+}
+
+func (err *WaitTimeoutError) Error() string {
+	msg := fmt.Sprintf("timed out after %s waiting for instances of load balancer %q to be %s",
+		err.Timeout, err.LoadBalancerName, err.State)
+	for _, s := range err.InstanceStates {
+		msg += fmt.Sprintf("; %s is %s (%s: %s)", s.InstanceId, s.State, s.ReasonCode, s.Description)
+	}
+	return msg
+}
+
+// WaitForInstancesInService polls the health of the given instances until all
+// of them are InService, or until timeout elapses. If no instance ids are
+// given, all instances registered with the load balancer are considered.
+//
+// On timeout the returned error is a *WaitTimeoutError describing the
+// instances that were not yet in service.
+func (elb *ELB) WaitForInstancesInService(lbName string, instanceIds []string, timeout time.Duration) error {
+	return elb.waitForInstancesState(lbName, instanceIds, "InService", timeout)
+}
+
+// WaitForInstancesOutOfService polls the health of the given instances until
+// all of them are OutOfService, or until timeout elapses. If no instance ids
+// are given, all instances registered with the load balancer are considered.
+//
+// On timeout the returned error is a *WaitTimeoutError describing the
+// instances that were still in service.
+func (elb *ELB) WaitForInstancesOutOfService(lbName string, instanceIds []string, timeout time.Duration) error {
+	return elb.waitForInstancesState(lbName, instanceIds, "OutOfService", timeout)
+}
+
+func (elb *ELB) waitForInstancesState(lbName string, instanceIds []string, state string, timeout time.Duration) error {
+	var lagging []InstanceState
+	ok, err := poll(timeout, func() (bool, error) {
+		resp, err := elb.DescribeInstanceHealth(lbName, instanceIds...)
+		if err != nil {
+			return false, err
+		}
+		lagging = nil
+		for _, s := range resp.InstanceStates {
+			if s.State != state {
+				lagging = append(lagging, s)
+			}
+		}
+		return len(lagging) == 0, nil
+	})
+	if err != nil || ok {
+		return err
+	}
+	return &WaitTimeoutError{
+		LoadBalancerName: lbName,
+		State:            state,
+		Timeout:          timeout,
+		InstanceStates:   lagging,
+	}
+}
+
+// poll calls done until it reports true or fails, or until timeout
+// elapses, backing off between calls from waitMinDelay to waitMaxDelay.
+// It reports whether done was satisfied in time.
+func poll(timeout time.Duration, done func() (bool, error)) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	delay := waitMinDelay
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return ok, err
+		}
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return false, nil
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > waitMaxDelay {
+			delay = waitMaxDelay
+		}
+	}
+}
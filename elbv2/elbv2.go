@@ -0,0 +1,752 @@
+// This package provides types and functions to interact with the Elastic Load
+// Balancing version 2 API, which manages Application Load Balancers.
+package elbv2
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/flaviamissi/go-elb/aws"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The ELBV2 type encapsulates operations with the Elastic Load Balancing
+// version 2 API in a specific region. It shares the endpoint of the classic
+// ELB API.
+type ELBV2 struct {
+	aws.Auth
+	aws.Region
+}
+
+// New creates a new ELBV2.
+func New(auth aws.Auth, region aws.Region) *ELBV2 {
+	return &ELBV2{Auth: auth, Region: region}
+}
+
+type SimpleResp struct {
+	RequestId string `xml:"ResponseMetadata>RequestId"`
+}
+
+// ----------------------------------------------------------------------------
+// Load balancers.
+
+// The CreateLoadBalancer type encapsulates options for the respective
+// request in AWS.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateLoadBalancer.html
+// for more information.
+type CreateLoadBalancer struct {
+	Name           string
+	Subnets        []string
+	SecurityGroups []string
+	Scheme         string
+	IpAddressType  string
+}
+
+// LoadBalancer describes an Application Load Balancer.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_LoadBalancer.html
+// for more information.
+type LoadBalancer struct {
+	LoadBalancerArn       string             `xml:"LoadBalancerArn"`
+	LoadBalancerName      string             `xml:"LoadBalancerName"`
+	DNSName               string             `xml:"DNSName"`
+	CanonicalHostedZoneId string             `xml:"CanonicalHostedZoneId"`
+	CreatedTime           time.Time          `xml:"CreatedTime"`
+	Scheme                string             `xml:"Scheme"`
+	Type                  string             `xml:"Type"`
+	VpcId                 string             `xml:"VpcId"`
+	State                 LoadBalancerState  `xml:"State"`
+	AvailabilityZones     []AvailabilityZone `xml:"AvailabilityZones>member"`
+	SecurityGroups        []string           `xml:"SecurityGroups>member"`
+	IpAddressType         string             `xml:"IpAddressType"`
+}
+
+// LoadBalancerState holds the provisioning state of a Load Balancer. Code is
+// one of "active", "provisioning" or "failed".
+type LoadBalancerState struct {
+	Code   string `xml:"Code"`
+	Reason string `xml:"Reason"`
+}
+
+type AvailabilityZone struct {
+	ZoneName string `xml:"ZoneName"`
+	SubnetId string `xml:"SubnetId"`
+}
+
+type CreateLoadBalancerResp struct {
+	RequestId     string         `xml:"ResponseMetadata>RequestId"`
+	LoadBalancers []LoadBalancer `xml:"CreateLoadBalancerResult>LoadBalancers>member"`
+}
+
+type DescribeLoadBalancersResp struct {
+	RequestId     string         `xml:"ResponseMetadata>RequestId"`
+	LoadBalancers []LoadBalancer `xml:"DescribeLoadBalancersResult>LoadBalancers>member"`
+	NextMarker    string         `xml:"DescribeLoadBalancersResult>NextMarker"`
+}
+
+// Creates an Application Load Balancer.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateLoadBalancer.html
+// for more information.
+func (elb *ELBV2) CreateLoadBalancer(options *CreateLoadBalancer) (*CreateLoadBalancerResp, error) {
+	params := map[string]string{
+		"Action": "CreateLoadBalancer",
+		"Name":   options.Name,
+	}
+	addMembersParams(params, "Subnets", options.Subnets)
+	addMembersParams(params, "SecurityGroups", options.SecurityGroups)
+	if options.Scheme != "" {
+		params["Scheme"] = options.Scheme
+	}
+	if options.IpAddressType != "" {
+		params["IpAddressType"] = options.IpAddressType
+	}
+	resp := new(CreateLoadBalancerResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Deletes an Application Load Balancer along with its listeners.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DeleteLoadBalancer.html
+// for more information.
+func (elb *ELBV2) DeleteLoadBalancer(arn string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":          "DeleteLoadBalancer",
+		"LoadBalancerArn": arn,
+	}
+	return elb.simpleQuery(params)
+}
+
+// DescribeLoadBalancers encapsulates the filters of a DescribeLoadBalancers
+// request. At most one of Arns and Names may be set.
+type DescribeLoadBalancers struct {
+	Arns   []string
+	Names  []string
+	Marker string
+}
+
+// Describes Application Load Balancers. A nil options describes all of them.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DescribeLoadBalancers.html
+// for more information.
+func (elb *ELBV2) DescribeLoadBalancers(options *DescribeLoadBalancers) (*DescribeLoadBalancersResp, error) {
+	params := map[string]string{
+		"Action": "DescribeLoadBalancers",
+	}
+	if options != nil {
+		addMembersParams(params, "LoadBalancerArns", options.Arns)
+		addMembersParams(params, "Names", options.Names)
+		if options.Marker != "" {
+			params["Marker"] = options.Marker
+		}
+	}
+	resp := new(DescribeLoadBalancersResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ----------------------------------------------------------------------------
+// Target groups.
+
+// The CreateTargetGroup type encapsulates options for the respective request
+// in AWS. Zero health check settings are left for AWS to default.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateTargetGroup.html
+// for more information.
+type CreateTargetGroup struct {
+	Name       string
+	Protocol   string
+	Port       int
+	VpcId      string
+	TargetType string
+
+	HealthCheckProtocol        string
+	HealthCheckPort            string
+	HealthCheckPath            string
+	HealthCheckIntervalSeconds int
+	HealthCheckTimeoutSeconds  int
+	HealthyThresholdCount      int
+	UnhealthyThresholdCount    int
+	// Matcher holds the HTTP codes of healthy targets, e.g. "200" or "200-299".
+	Matcher string
+}
+
+// TargetGroup describes a group of targets Application Load Balancer
+// listeners forward requests to.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_TargetGroup.html
+// for more information.
+type TargetGroup struct {
+	TargetGroupArn             string   `xml:"TargetGroupArn"`
+	TargetGroupName            string   `xml:"TargetGroupName"`
+	Protocol                   string   `xml:"Protocol"`
+	Port                       int      `xml:"Port"`
+	VpcId                      string   `xml:"VpcId"`
+	TargetType                 string   `xml:"TargetType"`
+	HealthCheckProtocol        string   `xml:"HealthCheckProtocol"`
+	HealthCheckPort            string   `xml:"HealthCheckPort"`
+	HealthCheckPath            string   `xml:"HealthCheckPath"`
+	HealthCheckIntervalSeconds int      `xml:"HealthCheckIntervalSeconds"`
+	HealthCheckTimeoutSeconds  int      `xml:"HealthCheckTimeoutSeconds"`
+	HealthyThresholdCount      int      `xml:"HealthyThresholdCount"`
+	UnhealthyThresholdCount    int      `xml:"UnhealthyThresholdCount"`
+	Matcher                    string   `xml:"Matcher>HttpCode"`
+	LoadBalancerArns           []string `xml:"LoadBalancerArns>member"`
+}
+
+type CreateTargetGroupResp struct {
+	RequestId    string        `xml:"ResponseMetadata>RequestId"`
+	TargetGroups []TargetGroup `xml:"CreateTargetGroupResult>TargetGroups>member"`
+}
+
+type DescribeTargetGroupsResp struct {
+	RequestId    string        `xml:"ResponseMetadata>RequestId"`
+	TargetGroups []TargetGroup `xml:"DescribeTargetGroupsResult>TargetGroups>member"`
+	NextMarker   string        `xml:"DescribeTargetGroupsResult>NextMarker"`
+}
+
+// Creates a target group.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateTargetGroup.html
+// for more information.
+func (elb *ELBV2) CreateTargetGroup(options *CreateTargetGroup) (*CreateTargetGroupResp, error) {
+	params := map[string]string{
+		"Action":   "CreateTargetGroup",
+		"Name":     options.Name,
+		"Protocol": options.Protocol,
+		"Port":     strconv.Itoa(options.Port),
+		"VpcId":    options.VpcId,
+	}
+	optional := map[string]string{
+		"TargetType":          options.TargetType,
+		"HealthCheckProtocol": options.HealthCheckProtocol,
+		"HealthCheckPort":     options.HealthCheckPort,
+		"HealthCheckPath":     options.HealthCheckPath,
+		"Matcher.HttpCode":    options.Matcher,
+	}
+	for k, v := range optional {
+		if v != "" {
+			params[k] = v
+		}
+	}
+	optionalInts := map[string]int{
+		"HealthCheckIntervalSeconds": options.HealthCheckIntervalSeconds,
+		"HealthCheckTimeoutSeconds":  options.HealthCheckTimeoutSeconds,
+		"HealthyThresholdCount":      options.HealthyThresholdCount,
+		"UnhealthyThresholdCount":    options.UnhealthyThresholdCount,
+	}
+	for k, v := range optionalInts {
+		if v != 0 {
+			params[k] = strconv.Itoa(v)
+		}
+	}
+	resp := new(CreateTargetGroupResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Deletes a target group. It must not be used by any listener or rule.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DeleteTargetGroup.html
+// for more information.
+func (elb *ELBV2) DeleteTargetGroup(arn string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":         "DeleteTargetGroup",
+		"TargetGroupArn": arn,
+	}
+	return elb.simpleQuery(params)
+}
+
+// DescribeTargetGroups encapsulates the filters of a DescribeTargetGroups
+// request. Only one of LoadBalancerArn, Arns and Names may be set.
+type DescribeTargetGroups struct {
+	LoadBalancerArn string
+	Arns            []string
+	Names           []string
+	Marker          string
+}
+
+// Describes target groups. A nil options describes all of them.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DescribeTargetGroups.html
+// for more information.
+func (elb *ELBV2) DescribeTargetGroups(options *DescribeTargetGroups) (*DescribeTargetGroupsResp, error) {
+	params := map[string]string{
+		"Action": "DescribeTargetGroups",
+	}
+	if options != nil {
+		if options.LoadBalancerArn != "" {
+			params["LoadBalancerArn"] = options.LoadBalancerArn
+		}
+		addMembersParams(params, "TargetGroupArns", options.Arns)
+		addMembersParams(params, "Names", options.Names)
+		if options.Marker != "" {
+			params["Marker"] = options.Marker
+		}
+	}
+	resp := new(DescribeTargetGroupsResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ----------------------------------------------------------------------------
+// Listeners.
+
+// Action is what a listener or a rule does with a request. Type is "forward"
+// for sending requests to the target group TargetGroupArn.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_Action.html
+// for more information.
+type Action struct {
+	Type           string `xml:"Type"`
+	TargetGroupArn string `xml:"TargetGroupArn"`
+}
+
+// ForwardAction returns an action forwarding requests to a target group.
+func ForwardAction(targetGroupArn string) Action {
+	return Action{Type: "forward", TargetGroupArn: targetGroupArn}
+}
+
+// The CreateListener type encapsulates options for the respective request
+// in AWS. HTTPS listeners require CertificateArn.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateListener.html
+// for more information.
+type CreateListener struct {
+	LoadBalancerArn string
+	Protocol        string
+	Port            int
+	CertificateArn  string
+	SslPolicy       string
+	DefaultActions  []Action
+}
+
+// Listener describes a listener of an Application Load Balancer.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_Listener.html
+// for more information.
+type Listener struct {
+	ListenerArn     string        `xml:"ListenerArn"`
+	LoadBalancerArn string        `xml:"LoadBalancerArn"`
+	Protocol        string        `xml:"Protocol"`
+	Port            int           `xml:"Port"`
+	Certificates    []Certificate `xml:"Certificates>member"`
+	SslPolicy       string        `xml:"SslPolicy"`
+	DefaultActions  []Action      `xml:"DefaultActions>member"`
+}
+
+type Certificate struct {
+	CertificateArn string `xml:"CertificateArn"`
+}
+
+type CreateListenerResp struct {
+	RequestId string     `xml:"ResponseMetadata>RequestId"`
+	Listeners []Listener `xml:"CreateListenerResult>Listeners>member"`
+}
+
+type ModifyListenerResp struct {
+	RequestId string     `xml:"ResponseMetadata>RequestId"`
+	Listeners []Listener `xml:"ModifyListenerResult>Listeners>member"`
+}
+
+type DescribeListenersResp struct {
+	RequestId  string     `xml:"ResponseMetadata>RequestId"`
+	Listeners  []Listener `xml:"DescribeListenersResult>Listeners>member"`
+	NextMarker string     `xml:"DescribeListenersResult>NextMarker"`
+}
+
+// Creates a listener in an Application Load Balancer.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateListener.html
+// for more information.
+func (elb *ELBV2) CreateListener(options *CreateListener) (*CreateListenerResp, error) {
+	params := map[string]string{
+		"Action":          "CreateListener",
+		"LoadBalancerArn": options.LoadBalancerArn,
+		"Protocol":        options.Protocol,
+		"Port":            strconv.Itoa(options.Port),
+	}
+	if options.CertificateArn != "" {
+		params["Certificates.member.1.CertificateArn"] = options.CertificateArn
+	}
+	if options.SslPolicy != "" {
+		params["SslPolicy"] = options.SslPolicy
+	}
+	addActionsParams(params, "DefaultActions", options.DefaultActions)
+	resp := new(CreateListenerResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Changes the default actions of a listener.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_ModifyListener.html
+// for more information.
+func (elb *ELBV2) ModifyListener(arn string, defaultActions ...Action) (*ModifyListenerResp, error) {
+	params := map[string]string{
+		"Action":      "ModifyListener",
+		"ListenerArn": arn,
+	}
+	addActionsParams(params, "DefaultActions", defaultActions)
+	resp := new(ModifyListenerResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Deletes a listener along with its rules.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DeleteListener.html
+// for more information.
+func (elb *ELBV2) DeleteListener(arn string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":      "DeleteListener",
+		"ListenerArn": arn,
+	}
+	return elb.simpleQuery(params)
+}
+
+// Describes the listeners of a Load Balancer, or the listeners with the
+// given ARNs when lbArn is empty.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DescribeListeners.html
+// for more information.
+func (elb *ELBV2) DescribeListeners(lbArn string, arns ...string) (*DescribeListenersResp, error) {
+	params := map[string]string{
+		"Action": "DescribeListeners",
+	}
+	if lbArn != "" {
+		params["LoadBalancerArn"] = lbArn
+	}
+	addMembersParams(params, "ListenerArns", arns)
+	resp := new(DescribeListenersResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ----------------------------------------------------------------------------
+// Rules.
+
+// RuleCondition matches requests by Field, which is either "path-pattern"
+// or "host-header". Values hold patterns that may use the * and ? wildcards.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_RuleCondition.html
+// for more information.
+type RuleCondition struct {
+	Field  string   `xml:"Field"`
+	Values []string `xml:"Values>member"`
+}
+
+// PathCondition returns a condition matching request paths against the
+// given patterns.
+func PathCondition(patterns ...string) RuleCondition {
+	return RuleCondition{Field: "path-pattern", Values: patterns}
+}
+
+// HostCondition returns a condition matching the Host header against the
+// given patterns.
+func HostCondition(patterns ...string) RuleCondition {
+	return RuleCondition{Field: "host-header", Values: patterns}
+}
+
+// The CreateRule type encapsulates options for the respective request in
+// AWS. Priority must be unique among the rules of a listener, lower values
+// are evaluated first.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateRule.html
+// for more information.
+type CreateRule struct {
+	ListenerArn string
+	Priority    int
+	Conditions  []RuleCondition
+	Actions     []Action
+}
+
+// Rule describes a listener rule. Priority is "default" for the rule
+// holding the default actions of the listener.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_Rule.html
+// for more information.
+type Rule struct {
+	RuleArn    string          `xml:"RuleArn"`
+	Priority   string          `xml:"Priority"`
+	IsDefault  bool            `xml:"IsDefault"`
+	Conditions []RuleCondition `xml:"Conditions>member"`
+	Actions    []Action        `xml:"Actions>member"`
+}
+
+type CreateRuleResp struct {
+	RequestId string `xml:"ResponseMetadata>RequestId"`
+	Rules     []Rule `xml:"CreateRuleResult>Rules>member"`
+}
+
+type DescribeRulesResp struct {
+	RequestId  string `xml:"ResponseMetadata>RequestId"`
+	Rules      []Rule `xml:"DescribeRulesResult>Rules>member"`
+	NextMarker string `xml:"DescribeRulesResult>NextMarker"`
+}
+
+// Creates a rule in a listener.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_CreateRule.html
+// for more information.
+func (elb *ELBV2) CreateRule(options *CreateRule) (*CreateRuleResp, error) {
+	params := map[string]string{
+		"Action":      "CreateRule",
+		"ListenerArn": options.ListenerArn,
+		"Priority":    strconv.Itoa(options.Priority),
+	}
+	addConditionsParams(params, options.Conditions)
+	addActionsParams(params, "Actions", options.Actions)
+	resp := new(CreateRuleResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Deletes a rule. The default rule of a listener cannot be deleted.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DeleteRule.html
+// for more information.
+func (elb *ELBV2) DeleteRule(arn string) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":  "DeleteRule",
+		"RuleArn": arn,
+	}
+	return elb.simpleQuery(params)
+}
+
+// Describes the rules of a listener, or the rules with the given ARNs when
+// listenerArn is empty.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DescribeRules.html
+// for more information.
+func (elb *ELBV2) DescribeRules(listenerArn string, arns ...string) (*DescribeRulesResp, error) {
+	params := map[string]string{
+		"Action": "DescribeRules",
+	}
+	if listenerArn != "" {
+		params["ListenerArn"] = listenerArn
+	}
+	addMembersParams(params, "RuleArns", arns)
+	resp := new(DescribeRulesResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ----------------------------------------------------------------------------
+// Targets.
+
+// TargetDescription identifies a target. A zero Port means the port of the
+// target group.
+type TargetDescription struct {
+	Id               string `xml:"Id"`
+	Port             int    `xml:"Port"`
+	AvailabilityZone string `xml:"AvailabilityZone"`
+}
+
+// Registers targets with a target group.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_RegisterTargets.html
+// for more information.
+func (elb *ELBV2) RegisterTargets(targetGroupArn string, targets ...TargetDescription) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":         "RegisterTargets",
+		"TargetGroupArn": targetGroupArn,
+	}
+	addTargetsParams(params, targets)
+	return elb.simpleQuery(params)
+}
+
+// Deregisters targets from a target group.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DeregisterTargets.html
+// for more information.
+func (elb *ELBV2) DeregisterTargets(targetGroupArn string, targets ...TargetDescription) (*SimpleResp, error) {
+	params := map[string]string{
+		"Action":         "DeregisterTargets",
+		"TargetGroupArn": targetGroupArn,
+	}
+	addTargetsParams(params, targets)
+	return elb.simpleQuery(params)
+}
+
+// TargetHealth holds the health of a target. State is one of "initial",
+// "healthy", "unhealthy", "unused", "draining" or "unavailable".
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_TargetHealth.html
+// for more information.
+type TargetHealth struct {
+	State       string `xml:"State"`
+	Reason      string `xml:"Reason"`
+	Description string `xml:"Description"`
+}
+
+type TargetHealthDescription struct {
+	Target          TargetDescription `xml:"Target"`
+	HealthCheckPort string            `xml:"HealthCheckPort"`
+	TargetHealth    TargetHealth      `xml:"TargetHealth"`
+}
+
+type DescribeTargetHealthResp struct {
+	RequestId                string                    `xml:"ResponseMetadata>RequestId"`
+	TargetHealthDescriptions []TargetHealthDescription `xml:"DescribeTargetHealthResult>TargetHealthDescriptions>member"`
+}
+
+// Describes the health of the targets of a target group, or of the given
+// targets only.
+//
+// See http://docs.aws.amazon.com/elasticloadbalancing/latest/APIReference/API_DescribeTargetHealth.html
+// for more information.
+func (elb *ELBV2) DescribeTargetHealth(targetGroupArn string, targets ...TargetDescription) (*DescribeTargetHealthResp, error) {
+	params := map[string]string{
+		"Action":         "DescribeTargetHealth",
+		"TargetGroupArn": targetGroupArn,
+	}
+	addTargetsParams(params, targets)
+	resp := new(DescribeTargetHealthResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ----------------------------------------------------------------------------
+// Request parameters.
+
+// addMembersParams adds values to params as label.member.1 ... label.member.N.
+func addMembersParams(params map[string]string, label string, values []string) {
+	for i, v := range values {
+		params[fmt.Sprintf("%s.member.%d", label, i+1)] = v
+	}
+}
+
+func addActionsParams(params map[string]string, label string, actions []Action) {
+	for i, a := range actions {
+		prefix := fmt.Sprintf("%s.member.%d.", label, i+1)
+		params[prefix+"Type"] = a.Type
+		if a.TargetGroupArn != "" {
+			params[prefix+"TargetGroupArn"] = a.TargetGroupArn
+		}
+	}
+}
+
+func addConditionsParams(params map[string]string, conditions []RuleCondition) {
+	for i, cond := range conditions {
+		prefix := fmt.Sprintf("Conditions.member.%d.", i+1)
+		params[prefix+"Field"] = cond.Field
+		addMembersParams(params, prefix+"Values", cond.Values)
+	}
+}
+
+func addTargetsParams(params map[string]string, targets []TargetDescription) {
+	for i, t := range targets {
+		prefix := fmt.Sprintf("Targets.member.%d.", i+1)
+		params[prefix+"Id"] = t.Id
+		if t.Port != 0 {
+			params[prefix+"Port"] = strconv.Itoa(t.Port)
+		}
+		if t.AvailabilityZone != "" {
+			params[prefix+"AvailabilityZone"] = t.AvailabilityZone
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Request dispatching.
+
+func (elb *ELBV2) simpleQuery(params map[string]string) (*SimpleResp, error) {
+	resp := new(SimpleResp)
+	if err := elb.query(params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (elb *ELBV2) query(params map[string]string, resp interface{}) error {
+	params["Version"] = "2015-12-01"
+	params["Timestamp"] = time.Now().In(time.UTC).Format(time.RFC3339)
+	endpoint, err := url.Parse(elb.Region.ELBEndpoint)
+	if err != nil {
+		return err
+	}
+	if endpoint.Path == "" {
+		endpoint.Path = "/"
+	}
+	sign(elb.Auth, "GET", endpoint.Path, params, endpoint.Host)
+	endpoint.RawQuery = multimap(params).Encode()
+	r, err := http.Get(endpoint.String())
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return buildError(r)
+	}
+	return xml.NewDecoder(r.Body).Decode(resp)
+}
+
+// Error encapsulates an error returned by the ELB version 2 API.
+type Error struct {
+	// HTTP status code
+	StatusCode int
+	// AWS error code
+	Code string
+	// The human-oriented error message
+	Message string
+}
+
+func (err *Error) Error() string {
+	if err.Code == "" {
+		return err.Message
+	}
+	return fmt.Sprintf("%s (%s)", err.Message, err.Code)
+}
+
+type xmlErrors struct {
+	Errors []Error `xml:"Error"`
+}
+
+func buildError(r *http.Response) error {
+	var (
+		err    Error
+		errors xmlErrors
+	)
+	xml.NewDecoder(r.Body).Decode(&errors)
+	if len(errors.Errors) > 0 {
+		err = errors.Errors[0]
+	}
+	err.StatusCode = r.StatusCode
+	if err.Message == "" {
+		err.Message = r.Status
+	}
+	return &err
+}
+
+func multimap(p map[string]string) url.Values {
+	q := make(url.Values, len(p))
+	for k, v := range p {
+		q[k] = []string{v}
+	}
+	return q
+}
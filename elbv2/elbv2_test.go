@@ -0,0 +1,352 @@
+package elbv2_test
+
+import (
+	"github.com/flaviamissi/go-elb/aws"
+	"github.com/flaviamissi/go-elb/elbv2"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+type S struct {
+	HTTPSuite
+	elb *elbv2.ELBV2
+}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpSuite(c *C) {
+	s.HTTPSuite.SetUpSuite(c)
+	auth := aws.Auth{"abc", "123"}
+	s.elb = elbv2.New(auth, aws.Region{ELBEndpoint: testServer.URL})
+}
+
+const (
+	lbArn       = "arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/app/my-load-balancer/50dc6c495c0c9188"
+	tgArn       = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067"
+	listenerArn = "arn:aws:elasticloadbalancing:us-west-2:123456789012:listener/app/my-load-balancer/50dc6c495c0c9188/f2f7dc8efc522ab2"
+	ruleArn     = "arn:aws:elasticloadbalancing:us-west-2:123456789012:listener-rule/app/my-load-balancer/50dc6c495c0c9188/f2f7dc8efc522ab2/9683b2d02a6cabee"
+)
+
+func (s *S) TestCreateLoadBalancer(c *C) {
+	testServer.PrepareResponse(200, nil, CreateLoadBalancer)
+	options := elbv2.CreateLoadBalancer{
+		Name:           "my-load-balancer",
+		Subnets:        []string{"subnet-8360a9e7", "subnet-b7d581c0"},
+		SecurityGroups: []string{"sg-5943793c"},
+		Scheme:         "internet-facing",
+	}
+	resp, err := s.elb.CreateLoadBalancer(&options)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Version"), Equals, "2015-12-01")
+	c.Assert(values.Get("Signature"), Not(Equals), "")
+	c.Assert(values.Get("Timestamp"), Not(Equals), "")
+	c.Assert(values.Get("Action"), Equals, "CreateLoadBalancer")
+	c.Assert(values.Get("Name"), Equals, "my-load-balancer")
+	c.Assert(values.Get("Subnets.member.1"), Equals, "subnet-8360a9e7")
+	c.Assert(values.Get("Subnets.member.2"), Equals, "subnet-b7d581c0")
+	c.Assert(values.Get("SecurityGroups.member.1"), Equals, "sg-5943793c")
+	c.Assert(values.Get("Scheme"), Equals, "internet-facing")
+	c.Assert(values.Get("IpAddressType"), Equals, "")
+	c.Assert(resp.RequestId, Equals, "32d531b2-f2d0-11e5-9192-3fff33344cfa")
+	c.Assert(resp.LoadBalancers, HasLen, 1)
+	lb := resp.LoadBalancers[0]
+	c.Assert(lb.LoadBalancerArn, Equals, lbArn)
+	c.Assert(lb.LoadBalancerName, Equals, "my-load-balancer")
+	c.Assert(lb.DNSName, Equals, "my-load-balancer-424835706.us-west-2.elb.amazonaws.com")
+	c.Assert(lb.CanonicalHostedZoneId, Equals, "Z2P70J7EXAMPLE")
+	c.Assert(lb.CreatedTime, Equals, time.Date(2016, 3, 25, 21, 29, 48, 850000000, time.UTC))
+	c.Assert(lb.Scheme, Equals, "internet-facing")
+	c.Assert(lb.Type, Equals, "application")
+	c.Assert(lb.VpcId, Equals, "vpc-3ac0fb5f")
+	c.Assert(lb.State, Equals, elbv2.LoadBalancerState{Code: "provisioning"})
+	c.Assert(lb.AvailabilityZones, DeepEquals, []elbv2.AvailabilityZone{
+		{ZoneName: "us-west-2a", SubnetId: "subnet-8360a9e7"},
+		{ZoneName: "us-west-2b", SubnetId: "subnet-b7d581c0"},
+	})
+	c.Assert(lb.SecurityGroups, DeepEquals, []string{"sg-5943793c"})
+	c.Assert(lb.IpAddressType, Equals, "ipv4")
+}
+
+func (s *S) TestCreateLoadBalancerBadRequest(c *C) {
+	testServer.PrepareResponse(400, nil, CreateLoadBalancerBadRequest)
+	resp, err := s.elb.CreateLoadBalancer(&elbv2.CreateLoadBalancer{Name: "my-load-balancer"})
+	c.Assert(resp, IsNil)
+	e, ok := err.(*elbv2.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.StatusCode, Equals, 400)
+	c.Assert(e.Code, Equals, "DuplicateLoadBalancerName")
+	c.Assert(e.Message, Equals, "A load balancer with the same name 'my-load-balancer' exists, but with different settings")
+}
+
+func (s *S) TestDeleteLoadBalancer(c *C) {
+	testServer.PrepareResponse(200, nil, DeleteLoadBalancer)
+	resp, err := s.elb.DeleteLoadBalancer(lbArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DeleteLoadBalancer")
+	c.Assert(values.Get("LoadBalancerArn"), Equals, lbArn)
+	c.Assert(resp.RequestId, Equals, "1549581b-12b7-11e3-895e-1334aEXAMPLE")
+}
+
+func (s *S) TestDescribeLoadBalancers(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeLoadBalancers)
+	resp, err := s.elb.DescribeLoadBalancers(&elbv2.DescribeLoadBalancers{Names: []string{"my-load-balancer"}})
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DescribeLoadBalancers")
+	c.Assert(values.Get("Names.member.1"), Equals, "my-load-balancer")
+	c.Assert(values.Get("LoadBalancerArns.member.1"), Equals, "")
+	c.Assert(resp.LoadBalancers, HasLen, 1)
+	c.Assert(resp.LoadBalancers[0].State.Code, Equals, "active")
+	c.Assert(resp.NextMarker, Equals, "next-page")
+}
+
+func (s *S) TestDescribeAllLoadBalancers(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeLoadBalancers)
+	_, err := s.elb.DescribeLoadBalancers(nil)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DescribeLoadBalancers")
+	c.Assert(values.Get("Names.member.1"), Equals, "")
+}
+
+func (s *S) TestCreateTargetGroup(c *C) {
+	testServer.PrepareResponse(200, nil, CreateTargetGroup)
+	options := elbv2.CreateTargetGroup{
+		Name:            "my-targets",
+		Protocol:        "HTTP",
+		Port:            80,
+		VpcId:           "vpc-3ac0fb5f",
+		HealthCheckPath: "/",
+		Matcher:         "200",
+	}
+	resp, err := s.elb.CreateTargetGroup(&options)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "CreateTargetGroup")
+	c.Assert(values.Get("Name"), Equals, "my-targets")
+	c.Assert(values.Get("Protocol"), Equals, "HTTP")
+	c.Assert(values.Get("Port"), Equals, "80")
+	c.Assert(values.Get("VpcId"), Equals, "vpc-3ac0fb5f")
+	c.Assert(values.Get("HealthCheckPath"), Equals, "/")
+	c.Assert(values.Get("Matcher.HttpCode"), Equals, "200")
+	_, ok := values["HealthCheckIntervalSeconds"]
+	c.Assert(ok, Equals, false)
+	_, ok = values["TargetType"]
+	c.Assert(ok, Equals, false)
+	c.Assert(resp.TargetGroups, DeepEquals, []elbv2.TargetGroup{{
+		TargetGroupArn:             tgArn,
+		TargetGroupName:            "my-targets",
+		Protocol:                   "HTTP",
+		Port:                       80,
+		VpcId:                      "vpc-3ac0fb5f",
+		TargetType:                 "instance",
+		HealthCheckProtocol:        "HTTP",
+		HealthCheckPort:            "traffic-port",
+		HealthCheckPath:            "/",
+		HealthCheckIntervalSeconds: 30,
+		HealthCheckTimeoutSeconds:  5,
+		HealthyThresholdCount:      5,
+		UnhealthyThresholdCount:    2,
+		Matcher:                    "200",
+	}})
+}
+
+func (s *S) TestDescribeTargetGroups(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeTargetGroups)
+	resp, err := s.elb.DescribeTargetGroups(&elbv2.DescribeTargetGroups{LoadBalancerArn: lbArn})
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DescribeTargetGroups")
+	c.Assert(values.Get("LoadBalancerArn"), Equals, lbArn)
+	c.Assert(resp.TargetGroups, HasLen, 1)
+	c.Assert(resp.TargetGroups[0].LoadBalancerArns, DeepEquals, []string{lbArn})
+}
+
+func (s *S) TestDeleteTargetGroup(c *C) {
+	testServer.PrepareResponse(200, nil, DeleteLoadBalancer)
+	_, err := s.elb.DeleteTargetGroup(tgArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DeleteTargetGroup")
+	c.Assert(values.Get("TargetGroupArn"), Equals, tgArn)
+}
+
+func (s *S) TestCreateListener(c *C) {
+	testServer.PrepareResponse(200, nil, CreateListener)
+	options := elbv2.CreateListener{
+		LoadBalancerArn: lbArn,
+		Protocol:        "HTTPS",
+		Port:            443,
+		CertificateArn:  "arn:aws:iam::123456789012:server-certificate/my-server-cert",
+		SslPolicy:       "ELBSecurityPolicy-2016-08",
+		DefaultActions:  []elbv2.Action{elbv2.ForwardAction(tgArn)},
+	}
+	resp, err := s.elb.CreateListener(&options)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "CreateListener")
+	c.Assert(values.Get("LoadBalancerArn"), Equals, lbArn)
+	c.Assert(values.Get("Protocol"), Equals, "HTTPS")
+	c.Assert(values.Get("Port"), Equals, "443")
+	c.Assert(values.Get("Certificates.member.1.CertificateArn"), Equals, "arn:aws:iam::123456789012:server-certificate/my-server-cert")
+	c.Assert(values.Get("SslPolicy"), Equals, "ELBSecurityPolicy-2016-08")
+	c.Assert(values.Get("DefaultActions.member.1.Type"), Equals, "forward")
+	c.Assert(values.Get("DefaultActions.member.1.TargetGroupArn"), Equals, tgArn)
+	c.Assert(resp.Listeners, DeepEquals, []elbv2.Listener{{
+		ListenerArn:     listenerArn,
+		LoadBalancerArn: lbArn,
+		Protocol:        "HTTPS",
+		Port:            443,
+		Certificates:    []elbv2.Certificate{{CertificateArn: "arn:aws:iam::123456789012:server-certificate/my-server-cert"}},
+		SslPolicy:       "ELBSecurityPolicy-2016-08",
+		DefaultActions:  []elbv2.Action{{Type: "forward", TargetGroupArn: tgArn}},
+	}})
+}
+
+func (s *S) TestModifyListener(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeListeners)
+	_, err := s.elb.ModifyListener(listenerArn, elbv2.ForwardAction(tgArn))
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "ModifyListener")
+	c.Assert(values.Get("ListenerArn"), Equals, listenerArn)
+	c.Assert(values.Get("DefaultActions.member.1.Type"), Equals, "forward")
+	c.Assert(values.Get("DefaultActions.member.1.TargetGroupArn"), Equals, tgArn)
+}
+
+func (s *S) TestDescribeListeners(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeListeners)
+	resp, err := s.elb.DescribeListeners(lbArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DescribeListeners")
+	c.Assert(values.Get("LoadBalancerArn"), Equals, lbArn)
+	c.Assert(resp.Listeners, HasLen, 1)
+	c.Assert(resp.Listeners[0].Port, Equals, 80)
+	c.Assert(resp.Listeners[0].DefaultActions, DeepEquals, []elbv2.Action{elbv2.ForwardAction(tgArn)})
+}
+
+func (s *S) TestDeleteListener(c *C) {
+	testServer.PrepareResponse(200, nil, DeleteLoadBalancer)
+	_, err := s.elb.DeleteListener(listenerArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DeleteListener")
+	c.Assert(values.Get("ListenerArn"), Equals, listenerArn)
+}
+
+func (s *S) TestCreateRule(c *C) {
+	testServer.PrepareResponse(200, nil, CreateRule)
+	options := elbv2.CreateRule{
+		ListenerArn: listenerArn,
+		Priority:    10,
+		Conditions: []elbv2.RuleCondition{
+			elbv2.PathCondition("/img/*"),
+			elbv2.HostCondition("example.com", "*.example.com"),
+		},
+		Actions: []elbv2.Action{elbv2.ForwardAction(tgArn)},
+	}
+	resp, err := s.elb.CreateRule(&options)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "CreateRule")
+	c.Assert(values.Get("ListenerArn"), Equals, listenerArn)
+	c.Assert(values.Get("Priority"), Equals, "10")
+	c.Assert(values.Get("Conditions.member.1.Field"), Equals, "path-pattern")
+	c.Assert(values.Get("Conditions.member.1.Values.member.1"), Equals, "/img/*")
+	c.Assert(values.Get("Conditions.member.2.Field"), Equals, "host-header")
+	c.Assert(values.Get("Conditions.member.2.Values.member.1"), Equals, "example.com")
+	c.Assert(values.Get("Conditions.member.2.Values.member.2"), Equals, "*.example.com")
+	c.Assert(values.Get("Actions.member.1.Type"), Equals, "forward")
+	c.Assert(values.Get("Actions.member.1.TargetGroupArn"), Equals, tgArn)
+	c.Assert(resp.Rules, DeepEquals, []elbv2.Rule{{
+		RuleArn:    ruleArn,
+		Priority:   "10",
+		Conditions: []elbv2.RuleCondition{elbv2.PathCondition("/img/*")},
+		Actions:    []elbv2.Action{elbv2.ForwardAction(tgArn)},
+	}})
+}
+
+func (s *S) TestDescribeRules(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeRules)
+	resp, err := s.elb.DescribeRules(listenerArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DescribeRules")
+	c.Assert(values.Get("ListenerArn"), Equals, listenerArn)
+	c.Assert(resp.Rules, HasLen, 2)
+	c.Assert(resp.Rules[0].Conditions, DeepEquals, []elbv2.RuleCondition{elbv2.HostCondition("*.example.com")})
+	c.Assert(resp.Rules[1].IsDefault, Equals, true)
+	c.Assert(resp.Rules[1].Priority, Equals, "default")
+	c.Assert(resp.Rules[1].Conditions, HasLen, 0)
+}
+
+func (s *S) TestDescribeRulesByArn(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeRules)
+	_, err := s.elb.DescribeRules("", ruleArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	_, ok := values["ListenerArn"]
+	c.Assert(ok, Equals, false)
+	c.Assert(values.Get("RuleArns.member.1"), Equals, ruleArn)
+}
+
+func (s *S) TestDeleteRule(c *C) {
+	testServer.PrepareResponse(200, nil, DeleteLoadBalancer)
+	_, err := s.elb.DeleteRule(ruleArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DeleteRule")
+	c.Assert(values.Get("RuleArn"), Equals, ruleArn)
+}
+
+func (s *S) TestRegisterTargets(c *C) {
+	testServer.PrepareResponse(200, nil, RegisterTargets)
+	resp, err := s.elb.RegisterTargets(tgArn, elbv2.TargetDescription{Id: "i-0f76fade"}, elbv2.TargetDescription{Id: "i-0f76fadf", Port: 8080})
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "RegisterTargets")
+	c.Assert(values.Get("TargetGroupArn"), Equals, tgArn)
+	c.Assert(values.Get("Targets.member.1.Id"), Equals, "i-0f76fade")
+	_, ok := values["Targets.member.1.Port"]
+	c.Assert(ok, Equals, false)
+	c.Assert(values.Get("Targets.member.2.Id"), Equals, "i-0f76fadf")
+	c.Assert(values.Get("Targets.member.2.Port"), Equals, "8080")
+	c.Assert(resp.RequestId, Equals, "f9a2ff2d-f2d5-11e5-b95d-3b2c1831fc26")
+}
+
+func (s *S) TestDeregisterTargets(c *C) {
+	testServer.PrepareResponse(200, nil, RegisterTargets)
+	_, err := s.elb.DeregisterTargets(tgArn, elbv2.TargetDescription{Id: "i-0f76fade"})
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DeregisterTargets")
+	c.Assert(values.Get("Targets.member.1.Id"), Equals, "i-0f76fade")
+}
+
+func (s *S) TestDescribeTargetHealth(c *C) {
+	testServer.PrepareResponse(200, nil, DescribeTargetHealth)
+	resp, err := s.elb.DescribeTargetHealth(tgArn)
+	c.Assert(err, IsNil)
+	values := testServer.WaitRequest().URL.Query()
+	c.Assert(values.Get("Action"), Equals, "DescribeTargetHealth")
+	c.Assert(values.Get("TargetGroupArn"), Equals, tgArn)
+	c.Assert(resp.TargetHealthDescriptions, DeepEquals, []elbv2.TargetHealthDescription{
+		{
+			Target:          elbv2.TargetDescription{Id: "i-0f76fade", Port: 80},
+			HealthCheckPort: "80",
+			TargetHealth:    elbv2.TargetHealth{State: "healthy"},
+		},
+		{
+			Target:          elbv2.TargetDescription{Id: "i-0f76fadf", Port: 80},
+			HealthCheckPort: "80",
+			TargetHealth: elbv2.TargetHealth{
+				State:       "unhealthy",
+				Reason:      "Target.ResponseCodeMismatch",
+				Description: "Health checks failed with these codes: [404]",
+			},
+		},
+	})
+}
@@ -0,0 +1,340 @@
+package elbv2_test
+
+import (
+	"github.com/flaviamissi/go-elb/aws"
+	"github.com/flaviamissi/go-elb/elbv2"
+	"github.com/flaviamissi/go-elb/elbv2/elbv2test"
+	. "launchpad.net/gocheck"
+)
+
+// LocalServerSuite runs tests against the local elbv2test fake server.
+type LocalServerSuite struct {
+	srv *elbv2test.Server
+	elb *elbv2.ELBV2
+}
+
+var _ = Suite(&LocalServerSuite{})
+
+func (s *LocalServerSuite) SetUpSuite(c *C) {
+	srv, err := elbv2test.NewServer()
+	c.Assert(err, IsNil)
+	s.srv = srv
+	s.elb = elbv2.New(aws.Auth{"abc", "123"}, aws.Region{ELBEndpoint: srv.URL()})
+}
+
+func (s *LocalServerSuite) TearDownSuite(c *C) {
+	s.srv.Quit()
+}
+
+// createLoadBalancer creates a load balancer with a target group forwarded
+// to by an HTTP listener on port 80, and returns their ARNs.
+func (s *LocalServerSuite) createLoadBalancer(c *C, name string) (lbArn, tgArn, listenerArn string) {
+	lbResp, err := s.elb.CreateLoadBalancer(&elbv2.CreateLoadBalancer{
+		Name:    name,
+		Subnets: []string{"subnet-1", "subnet-2"},
+	})
+	c.Assert(err, IsNil)
+	lbArn = lbResp.LoadBalancers[0].LoadBalancerArn
+	tgArn = s.createTargetGroup(c, name+"-targets")
+	lResp, err := s.elb.CreateListener(&elbv2.CreateListener{
+		LoadBalancerArn: lbArn,
+		Protocol:        "HTTP",
+		Port:            80,
+		DefaultActions:  []elbv2.Action{elbv2.ForwardAction(tgArn)},
+	})
+	c.Assert(err, IsNil)
+	return lbArn, tgArn, lResp.Listeners[0].ListenerArn
+}
+
+func (s *LocalServerSuite) createTargetGroup(c *C, name string) string {
+	resp, err := s.elb.CreateTargetGroup(&elbv2.CreateTargetGroup{
+		Name:     name,
+		Protocol: "HTTP",
+		Port:     8080,
+		VpcId:    "vpc-1",
+	})
+	c.Assert(err, IsNil)
+	return resp.TargetGroups[0].TargetGroupArn
+}
+
+func (s *LocalServerSuite) deleteLoadBalancer(c *C, lbArn string, tgArns ...string) {
+	_, err := s.elb.DeleteLoadBalancer(lbArn)
+	c.Check(err, IsNil)
+	for _, arn := range tgArns {
+		_, err := s.elb.DeleteTargetGroup(arn)
+		c.Check(err, IsNil)
+	}
+}
+
+func (s *LocalServerSuite) TestCreateAndDescribeLoadBalancer(c *C) {
+	resp, err := s.elb.CreateLoadBalancer(&elbv2.CreateLoadBalancer{
+		Name:           "mylb",
+		Subnets:        []string{"subnet-1", "subnet-2"},
+		SecurityGroups: []string{"sg-1"},
+	})
+	c.Assert(err, IsNil)
+	lb := resp.LoadBalancers[0]
+	defer s.deleteLoadBalancer(c, lb.LoadBalancerArn)
+	c.Assert(lb.LoadBalancerName, Equals, "mylb")
+	c.Assert(lb.Scheme, Equals, "internet-facing")
+	c.Assert(lb.State.Code, Equals, "active")
+	c.Assert(lb.AvailabilityZones, DeepEquals, []elbv2.AvailabilityZone{
+		{ZoneName: "us-east-1a", SubnetId: "subnet-1"},
+		{ZoneName: "us-east-1b", SubnetId: "subnet-2"},
+	})
+	describe, err := s.elb.DescribeLoadBalancers(&elbv2.DescribeLoadBalancers{Names: []string{"mylb"}})
+	c.Assert(err, IsNil)
+	c.Assert(describe.LoadBalancers, HasLen, 1)
+	c.Assert(describe.LoadBalancers[0].LoadBalancerArn, Equals, lb.LoadBalancerArn)
+	c.Assert(describe.LoadBalancers[0].SecurityGroups, DeepEquals, []string{"sg-1"})
+	describe, err = s.elb.DescribeLoadBalancers(&elbv2.DescribeLoadBalancers{Arns: []string{lb.LoadBalancerArn}})
+	c.Assert(err, IsNil)
+	c.Assert(describe.LoadBalancers, HasLen, 1)
+}
+
+func (s *LocalServerSuite) TestCreateLoadBalancerErrors(c *C) {
+	_, err := s.elb.CreateLoadBalancer(&elbv2.CreateLoadBalancer{Name: "mylb", Subnets: []string{"subnet-1"}})
+	c.Assert(err, ErrorMatches, `At least two subnets in two different Availability Zones must be specified \(ValidationError\)`)
+	resp, err := s.elb.CreateLoadBalancer(&elbv2.CreateLoadBalancer{Name: "mylb", Subnets: []string{"subnet-1", "subnet-2"}})
+	c.Assert(err, IsNil)
+	defer s.deleteLoadBalancer(c, resp.LoadBalancers[0].LoadBalancerArn)
+	_, err = s.elb.CreateLoadBalancer(&elbv2.CreateLoadBalancer{Name: "mylb", Subnets: []string{"subnet-1", "subnet-2"}})
+	c.Assert(err, ErrorMatches, `.*\(DuplicateLoadBalancerName\)`)
+}
+
+func (s *LocalServerSuite) TestDescribeAbsentLoadBalancer(c *C) {
+	_, err := s.elb.DescribeLoadBalancers(&elbv2.DescribeLoadBalancers{Names: []string{"absent"}})
+	e, ok := err.(*elbv2.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.StatusCode, Equals, 400)
+	c.Assert(e.Code, Equals, "LoadBalancerNotFound")
+}
+
+func (s *LocalServerSuite) TestTargetGroupDefaults(c *C) {
+	arn := s.createTargetGroup(c, "defaults")
+	defer s.elb.DeleteTargetGroup(arn)
+	resp, err := s.elb.DescribeTargetGroups(&elbv2.DescribeTargetGroups{Names: []string{"defaults"}})
+	c.Assert(err, IsNil)
+	c.Assert(resp.TargetGroups, DeepEquals, []elbv2.TargetGroup{{
+		TargetGroupArn:             arn,
+		TargetGroupName:            "defaults",
+		Protocol:                   "HTTP",
+		Port:                       8080,
+		VpcId:                      "vpc-1",
+		TargetType:                 "instance",
+		HealthCheckProtocol:        "HTTP",
+		HealthCheckPort:            "traffic-port",
+		HealthCheckPath:            "/",
+		HealthCheckIntervalSeconds: 30,
+		HealthCheckTimeoutSeconds:  5,
+		HealthyThresholdCount:      5,
+		UnhealthyThresholdCount:    2,
+		Matcher:                    "200",
+	}})
+	_, err = s.elb.CreateTargetGroup(&elbv2.CreateTargetGroup{Name: "defaults", Protocol: "HTTP", Port: 80, VpcId: "vpc-1"})
+	c.Assert(err, ErrorMatches, `.*\(DuplicateTargetGroupName\)`)
+
+	// Creating it again with the same settings returns the same group.
+	again, err := s.elb.CreateTargetGroup(&elbv2.CreateTargetGroup{Name: "defaults", Protocol: "HTTP", Port: 8080, VpcId: "vpc-1"})
+	c.Assert(err, IsNil)
+	c.Assert(again.TargetGroups, DeepEquals, resp.TargetGroups)
+	resp, err = s.elb.DescribeTargetGroups(&elbv2.DescribeTargetGroups{Names: []string{"defaults"}})
+	c.Assert(err, IsNil)
+	c.Assert(resp.TargetGroups, HasLen, 1)
+}
+
+func (s *LocalServerSuite) TestListenersAndDefaultActions(c *C) {
+	lbArn, tgArn, listenerArn := s.createLoadBalancer(c, "mylb")
+	defer s.deleteLoadBalancer(c, lbArn, tgArn)
+	resp, err := s.elb.DescribeListeners(lbArn)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Listeners, DeepEquals, []elbv2.Listener{{
+		ListenerArn:     listenerArn,
+		LoadBalancerArn: lbArn,
+		Protocol:        "HTTP",
+		Port:            80,
+		DefaultActions:  []elbv2.Action{elbv2.ForwardAction(tgArn)},
+	}})
+	tgs, err := s.elb.DescribeTargetGroups(&elbv2.DescribeTargetGroups{LoadBalancerArn: lbArn})
+	c.Assert(err, IsNil)
+	c.Assert(tgs.TargetGroups, HasLen, 1)
+	c.Assert(tgs.TargetGroups[0].LoadBalancerArns, DeepEquals, []string{lbArn})
+
+	other := s.createTargetGroup(c, "other")
+	modified, err := s.elb.ModifyListener(listenerArn, elbv2.ForwardAction(other))
+	c.Assert(err, IsNil)
+	c.Assert(modified.Listeners[0].DefaultActions, DeepEquals, []elbv2.Action{elbv2.ForwardAction(other)})
+	_, err = s.elb.DeleteTargetGroup(other)
+	c.Assert(err, ErrorMatches, `Target group '.*' is currently in use by a listener or a rule \(ResourceInUse\)`)
+	_, err = s.elb.DeleteListener(listenerArn)
+	c.Assert(err, IsNil)
+	_, err = s.elb.DeleteTargetGroup(other)
+	c.Assert(err, IsNil)
+	resp, err = s.elb.DescribeListeners(lbArn)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Listeners, HasLen, 0)
+}
+
+func (s *LocalServerSuite) TestCreateListenerErrors(c *C) {
+	lbArn, tgArn, _ := s.createLoadBalancer(c, "mylb")
+	defer s.deleteLoadBalancer(c, lbArn, tgArn)
+	options := elbv2.CreateListener{
+		LoadBalancerArn: lbArn,
+		Protocol:        "HTTP",
+		Port:            80,
+		DefaultActions:  []elbv2.Action{elbv2.ForwardAction(tgArn)},
+	}
+	_, err := s.elb.CreateListener(&options)
+	c.Assert(err, ErrorMatches, `A listener already exists on this port for this load balancer \(DuplicateListener\)`)
+	options.Port = 443
+	options.Protocol = "HTTPS"
+	_, err = s.elb.CreateListener(&options)
+	c.Assert(err, ErrorMatches, `.*\(CertificateNotFound\)`)
+	options.Protocol = "HTTP"
+	options.DefaultActions = []elbv2.Action{elbv2.ForwardAction("arn:absent")}
+	_, err = s.elb.CreateListener(&options)
+	c.Assert(err, ErrorMatches, `.*\(TargetGroupNotFound\)`)
+	options.LoadBalancerArn = "arn:absent"
+	_, err = s.elb.CreateListener(&options)
+	c.Assert(err, ErrorMatches, `.*\(LoadBalancerNotFound\)`)
+}
+
+func (s *LocalServerSuite) TestTargetGroupCannotBeSharedByLoadBalancers(c *C) {
+	lbArn, tgArn, _ := s.createLoadBalancer(c, "mylb")
+	defer s.deleteLoadBalancer(c, lbArn, tgArn)
+	resp, err := s.elb.CreateLoadBalancer(&elbv2.CreateLoadBalancer{Name: "otherlb", Subnets: []string{"subnet-1", "subnet-2"}})
+	c.Assert(err, IsNil)
+	defer s.deleteLoadBalancer(c, resp.LoadBalancers[0].LoadBalancerArn)
+	_, err = s.elb.CreateListener(&elbv2.CreateListener{
+		LoadBalancerArn: resp.LoadBalancers[0].LoadBalancerArn,
+		Protocol:        "HTTP",
+		Port:            80,
+		DefaultActions:  []elbv2.Action{elbv2.ForwardAction(tgArn)},
+	})
+	c.Assert(err, ErrorMatches, `.*\(TargetGroupAssociationLimit\)`)
+}
+
+func (s *LocalServerSuite) TestRules(c *C) {
+	lbArn, tgArn, listenerArn := s.createLoadBalancer(c, "mylb")
+	images := s.createTargetGroup(c, "images")
+	defer s.deleteLoadBalancer(c, lbArn, tgArn, images)
+	create := func(priority int, conditions ...elbv2.RuleCondition) (*elbv2.CreateRuleResp, error) {
+		return s.elb.CreateRule(&elbv2.CreateRule{
+			ListenerArn: listenerArn,
+			Priority:    priority,
+			Conditions:  conditions,
+			Actions:     []elbv2.Action{elbv2.ForwardAction(images)},
+		})
+	}
+	r20, err := create(20, elbv2.HostCondition("img.example.com"))
+	c.Assert(err, IsNil)
+	r10, err := create(10, elbv2.PathCondition("/img/*"))
+	c.Assert(err, IsNil)
+	_, err = create(10, elbv2.PathCondition("/static/*"))
+	c.Assert(err, ErrorMatches, `Priority '10' is currently in use \(PriorityInUse\)`)
+	_, err = create(0, elbv2.PathCondition("/static/*"))
+	c.Assert(err, ErrorMatches, `.*\(ValidationError\)`)
+	_, err = create(30, elbv2.RuleCondition{Field: "query-string", Values: []string{"a=b"}})
+	c.Assert(err, ErrorMatches, `.*\(ValidationError\)`)
+
+	resp, err := s.elb.DescribeRules(listenerArn)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Rules, HasLen, 3)
+	c.Assert(resp.Rules[0], DeepEquals, r10.Rules[0])
+	c.Assert(resp.Rules[1], DeepEquals, r20.Rules[0])
+	c.Assert(resp.Rules[2].IsDefault, Equals, true)
+	c.Assert(resp.Rules[2].Priority, Equals, "default")
+	c.Assert(resp.Rules[2].Actions, DeepEquals, []elbv2.Action{elbv2.ForwardAction(tgArn)})
+
+	tgs, err := s.elb.DescribeTargetGroups(&elbv2.DescribeTargetGroups{Arns: []string{images}})
+	c.Assert(err, IsNil)
+	c.Assert(tgs.TargetGroups[0].LoadBalancerArns, DeepEquals, []string{lbArn})
+
+	_, err = s.elb.DeleteRule(resp.Rules[2].RuleArn)
+	c.Assert(err, ErrorMatches, `Default rule '.*' cannot be deleted \(OperationNotPermitted\)`)
+	_, err = s.elb.DeleteRule(r10.Rules[0].RuleArn)
+	c.Assert(err, IsNil)
+	_, err = s.elb.DeleteRule(r10.Rules[0].RuleArn)
+	c.Assert(err, ErrorMatches, `.*\(RuleNotFound\)`)
+	byArn, err := s.elb.DescribeRules("", r20.Rules[0].RuleArn)
+	c.Assert(err, IsNil)
+	c.Assert(byArn.Rules, DeepEquals, r20.Rules)
+}
+
+func (s *LocalServerSuite) TestRegisterTargetsAndDescribeTargetHealth(c *C) {
+	instId1 := s.srv.NewInstance()
+	defer s.srv.RemoveInstance(instId1)
+	instId2 := s.srv.NewInstance()
+	defer s.srv.RemoveInstance(instId2)
+	unused := s.createTargetGroup(c, "unused")
+	defer s.elb.DeleteTargetGroup(unused)
+	_, err := s.elb.RegisterTargets(unused, elbv2.TargetDescription{Id: instId1})
+	c.Assert(err, IsNil)
+	health, err := s.elb.DescribeTargetHealth(unused)
+	c.Assert(err, IsNil)
+	c.Assert(health.TargetHealthDescriptions, HasLen, 1)
+	c.Assert(health.TargetHealthDescriptions[0].TargetHealth.State, Equals, "unused")
+	c.Assert(health.TargetHealthDescriptions[0].TargetHealth.Reason, Equals, "Target.NotInUse")
+
+	lbArn, tgArn, _ := s.createLoadBalancer(c, "mylb")
+	defer s.deleteLoadBalancer(c, lbArn, tgArn)
+	_, err = s.elb.RegisterTargets(tgArn, elbv2.TargetDescription{Id: instId1}, elbv2.TargetDescription{Id: instId2, Port: 9090})
+	c.Assert(err, IsNil)
+	health, err = s.elb.DescribeTargetHealth(tgArn)
+	c.Assert(err, IsNil)
+	c.Assert(health.TargetHealthDescriptions, DeepEquals, []elbv2.TargetHealthDescription{
+		{
+			Target:          elbv2.TargetDescription{Id: instId1, Port: 8080},
+			HealthCheckPort: "8080",
+			TargetHealth: elbv2.TargetHealth{
+				State:       "initial",
+				Reason:      "Elb.RegistrationInProgress",
+				Description: "Target registration is in progress",
+			},
+		},
+		{
+			Target:          elbv2.TargetDescription{Id: instId2, Port: 9090},
+			HealthCheckPort: "9090",
+			TargetHealth: elbv2.TargetHealth{
+				State:       "initial",
+				Reason:      "Elb.RegistrationInProgress",
+				Description: "Target registration is in progress",
+			},
+		},
+	})
+
+	s.srv.ChangeTargetHealth(tgArn, elbv2.TargetDescription{Id: instId1}, elbv2.TargetHealth{State: "healthy"})
+	health, err = s.elb.DescribeTargetHealth(tgArn, elbv2.TargetDescription{Id: instId1})
+	c.Assert(err, IsNil)
+	c.Assert(health.TargetHealthDescriptions, HasLen, 1)
+	c.Assert(health.TargetHealthDescriptions[0].TargetHealth, Equals, elbv2.TargetHealth{State: "healthy"})
+
+	_, err = s.elb.DeregisterTargets(tgArn, elbv2.TargetDescription{Id: instId1})
+	c.Assert(err, IsNil)
+	health, err = s.elb.DescribeTargetHealth(tgArn, elbv2.TargetDescription{Id: instId1})
+	c.Assert(err, IsNil)
+	c.Assert(health.TargetHealthDescriptions[0].TargetHealth.Reason, Equals, "Target.NotRegistered")
+	_, err = s.elb.DeregisterTargets(tgArn, elbv2.TargetDescription{Id: instId1})
+	c.Assert(err, ErrorMatches, `.*\(InvalidTarget\)`)
+}
+
+func (s *LocalServerSuite) TestRegisterUnknownTarget(c *C) {
+	tgArn := s.createTargetGroup(c, "mytargets")
+	defer s.elb.DeleteTargetGroup(tgArn)
+	_, err := s.elb.RegisterTargets(tgArn, elbv2.TargetDescription{Id: "i-unknown"})
+	c.Assert(err, ErrorMatches, `.*'i-unknown' \(InvalidTarget\)`)
+	_, err = s.elb.RegisterTargets("arn:absent", elbv2.TargetDescription{Id: "i-unknown"})
+	c.Assert(err, ErrorMatches, `.*\(TargetGroupNotFound\)`)
+}
+
+func (s *LocalServerSuite) TestDeleteLoadBalancerReleasesTargetGroups(c *C) {
+	lbArn, tgArn, _ := s.createLoadBalancer(c, "mylb")
+	defer s.elb.DeleteTargetGroup(tgArn)
+	_, err := s.elb.DeleteLoadBalancer(lbArn)
+	c.Assert(err, IsNil)
+	resp, err := s.elb.DescribeTargetGroups(&elbv2.DescribeTargetGroups{Arns: []string{tgArn}})
+	c.Assert(err, IsNil)
+	c.Assert(resp.TargetGroups[0].LoadBalancerArns, HasLen, 0)
+	_, err = s.elb.DescribeListeners(lbArn)
+	c.Assert(err, ErrorMatches, `.*\(LoadBalancerNotFound\)`)
+}
@@ -0,0 +1,1054 @@
+// Package elbv2test implements a fake Elastic Load Balancing version 2
+// provider, holding Application Load Balancers, target groups, listeners and
+// rules in memory, for use in testing.
+package elbv2test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/flaviamissi/go-elb/elbv2"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const arnPrefix = "arn:aws:elasticloadbalancing:us-east-1:123456789012:"
+
+// Server implements an ELB version 2 simulator for use in testing.
+type Server struct {
+	url      string
+	listener net.Listener
+	mutex    sync.Mutex
+	reqId    int
+	idCount  int
+
+	lbs          map[string]*elbv2.LoadBalancer // arn -> load balancer
+	targetGroups map[string]*elbv2.TargetGroup  // arn -> target group
+	listeners    map[string]*elbv2.Listener     // arn -> listener
+	rules        map[string][]*elbv2.Rule       // listener arn -> non-default rules
+	targets      map[string][]*elbv2.TargetHealthDescription
+	instances    []string
+	instCount    int
+}
+
+// Starts and returns a new server
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on localhost: %v", err)
+	}
+	srv := &Server{
+		listener:     l,
+		url:          "http://" + l.Addr().String(),
+		lbs:          make(map[string]*elbv2.LoadBalancer),
+		targetGroups: make(map[string]*elbv2.TargetGroup),
+		listeners:    make(map[string]*elbv2.Listener),
+		rules:        make(map[string][]*elbv2.Rule),
+		targets:      make(map[string][]*elbv2.TargetHealthDescription),
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		srv.serveHTTP(w, req)
+	}))
+	return srv, nil
+}
+
+// Quit closes down the server.
+func (srv *Server) Quit() {
+	srv.listener.Close()
+}
+
+// URL returns the URL of the server.
+func (srv *Server) URL() string {
+	return srv.url
+}
+
+type xmlErrors struct {
+	XMLName string `xml:"ErrorResponse"`
+	Error   elbv2.Error
+}
+
+func (srv *Server) error(w http.ResponseWriter, err *elbv2.Error) {
+	w.WriteHeader(err.StatusCode)
+	xmlErr := xmlErrors{Error: *err}
+	if e := xml.NewEncoder(w).Encode(xmlErr); e != nil {
+		panic(e)
+	}
+}
+
+func (srv *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	f := actions[req.Form.Get("Action")]
+	if f == nil {
+		srv.error(w, &elbv2.Error{
+			StatusCode: 400,
+			Code:       "InvalidAction",
+			Message:    "Unrecognized Action",
+		})
+		return
+	}
+	reqId := fmt.Sprintf("req%0X", srv.reqId)
+	srv.reqId++
+	if resp, err := f(srv, w, req, reqId); err == nil {
+		if err := xml.NewEncoder(w).Encode(resp); err != nil {
+			panic(err)
+		}
+	} else {
+		switch err.(type) {
+		case *elbv2.Error:
+			srv.error(w, err.(*elbv2.Error))
+		default:
+			panic(err)
+		}
+	}
+}
+
+func (srv *Server) newId() string {
+	srv.idCount++
+	return fmt.Sprintf("%016x", srv.idCount)
+}
+
+func (srv *Server) createLoadBalancer(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"Name"}); err != nil {
+		return nil, err
+	}
+	name := req.FormValue("Name")
+	for _, lb := range srv.lbs {
+		if lb.LoadBalancerName == name {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "DuplicateLoadBalancerName",
+				Message:    fmt.Sprintf("A load balancer with the same name '%s' exists, but with different settings", name),
+			}
+		}
+	}
+	subnets := getParameters("Subnets.member.", req.Form)
+	if len(subnets) < 2 {
+		return nil, validationError("At least two subnets in two different Availability Zones must be specified")
+	}
+	scheme := req.FormValue("Scheme")
+	if scheme == "" {
+		scheme = "internet-facing"
+	}
+	ipAddressType := req.FormValue("IpAddressType")
+	if ipAddressType == "" {
+		ipAddressType = "ipv4"
+	}
+	id := srv.newId()
+	lb := &elbv2.LoadBalancer{
+		LoadBalancerArn:       arnPrefix + "loadbalancer/app/" + name + "/" + id,
+		LoadBalancerName:      name,
+		DNSName:               fmt.Sprintf("%s-%s.us-east-1.elb.amazonaws.com", name, id[8:]),
+		CanonicalHostedZoneId: "Z35SXDOTRQ7X7K",
+		CreatedTime:           time.Now().UTC(),
+		Scheme:                scheme,
+		Type:                  "application",
+		VpcId:                 "vpc-1",
+		State:                 elbv2.LoadBalancerState{Code: "active"},
+		SecurityGroups:        getParameters("SecurityGroups.member.", req.Form),
+		IpAddressType:         ipAddressType,
+	}
+	for i, subnet := range subnets {
+		lb.AvailabilityZones = append(lb.AvailabilityZones, elbv2.AvailabilityZone{
+			ZoneName: fmt.Sprintf("us-east-1%c", 'a'+i%26),
+			SubnetId: subnet,
+		})
+	}
+	srv.lbs[lb.LoadBalancerArn] = lb
+	return elbv2.CreateLoadBalancerResp{
+		RequestId:     reqId,
+		LoadBalancers: []elbv2.LoadBalancer{*lb},
+	}, nil
+}
+
+func (srv *Server) deleteLoadBalancer(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"LoadBalancerArn"}); err != nil {
+		return nil, err
+	}
+	arn := req.FormValue("LoadBalancerArn")
+	// deleting an absent load balancer succeeds, as it does in AWS
+	for listenerArn, l := range srv.listeners {
+		if l.LoadBalancerArn == arn {
+			delete(srv.listeners, listenerArn)
+			delete(srv.rules, listenerArn)
+		}
+	}
+	delete(srv.lbs, arn)
+	srv.updateTargetGroupsUsage()
+	return elbv2.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) describeLoadBalancers(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	arns := getParameters("LoadBalancerArns.member.", req.Form)
+	names := getParameters("Names.member.", req.Form)
+	if len(arns) > 0 && len(names) > 0 {
+		return nil, validationError("Load balancer names and load balancer ARNs cannot be specified at the same time")
+	}
+	var lbs []elbv2.LoadBalancer
+	for _, arn := range arns {
+		lb, err := srv.loadBalancer(arn)
+		if err != nil {
+			return nil, err
+		}
+		lbs = append(lbs, *lb)
+	}
+	for _, name := range names {
+		found := false
+		for _, lb := range srv.lbs {
+			if lb.LoadBalancerName == name {
+				lbs = append(lbs, *lb)
+				found = true
+			}
+		}
+		if !found {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "LoadBalancerNotFound",
+				Message:    fmt.Sprintf("Load balancers '[%s]' not found", name),
+			}
+		}
+	}
+	if len(arns) == 0 && len(names) == 0 {
+		for _, lb := range srv.lbs {
+			lbs = append(lbs, *lb)
+		}
+		sort.Sort(loadBalancersByName(lbs))
+	}
+	return elbv2.DescribeLoadBalancersResp{RequestId: reqId, LoadBalancers: lbs}, nil
+}
+
+func (srv *Server) createTargetGroup(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"Name", "Protocol", "Port", "VpcId"}); err != nil {
+		return nil, err
+	}
+	name := req.FormValue("Name")
+	protocol := strings.ToUpper(req.FormValue("Protocol"))
+	if protocol != "HTTP" && protocol != "HTTPS" {
+		return nil, validationError("Protocol '%s' must be one of 'HTTP, HTTPS'", req.FormValue("Protocol"))
+	}
+	port, err := strconv.Atoi(req.FormValue("Port"))
+	if err != nil || port < 1 || port > 65535 {
+		return nil, validationError("Port '%s' must be between 1 and 65535", req.FormValue("Port"))
+	}
+	tg := &elbv2.TargetGroup{
+		TargetGroupName:            name,
+		Protocol:                   protocol,
+		Port:                       port,
+		VpcId:                      req.FormValue("VpcId"),
+		TargetType:                 "instance",
+		HealthCheckProtocol:        protocol,
+		HealthCheckPort:            "traffic-port",
+		HealthCheckPath:            "/",
+		HealthCheckIntervalSeconds: 30,
+		HealthCheckTimeoutSeconds:  5,
+		HealthyThresholdCount:      5,
+		UnhealthyThresholdCount:    2,
+		Matcher:                    "200",
+	}
+	strs := map[string]*string{
+		"TargetType":          &tg.TargetType,
+		"HealthCheckProtocol": &tg.HealthCheckProtocol,
+		"HealthCheckPort":     &tg.HealthCheckPort,
+		"HealthCheckPath":     &tg.HealthCheckPath,
+		"Matcher.HttpCode":    &tg.Matcher,
+	}
+	for k, p := range strs {
+		if v := req.FormValue(k); v != "" {
+			*p = v
+		}
+	}
+	ints := map[string]*int{
+		"HealthCheckIntervalSeconds": &tg.HealthCheckIntervalSeconds,
+		"HealthCheckTimeoutSeconds":  &tg.HealthCheckTimeoutSeconds,
+		"HealthyThresholdCount":      &tg.HealthyThresholdCount,
+		"UnhealthyThresholdCount":    &tg.UnhealthyThresholdCount,
+	}
+	for k, p := range ints {
+		if v := req.FormValue(k); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, validationError("%s '%s' is not a valid integer", k, v)
+			}
+			*p = n
+		}
+	}
+	if tg.HealthCheckIntervalSeconds <= tg.HealthCheckTimeoutSeconds {
+		return nil, validationError("Health check interval must be greater than the timeout.")
+	}
+	// As in ELB, creating a target group again with the same settings
+	// returns the existing one.
+	for _, existing := range srv.targetGroups {
+		if existing.TargetGroupName != name {
+			continue
+		}
+		if !sameTargetGroupSettings(existing, tg) {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "DuplicateTargetGroupName",
+				Message:    fmt.Sprintf("A target group with the same name '%s' exists, but with different settings", name),
+			}
+		}
+		return elbv2.CreateTargetGroupResp{
+			RequestId:    reqId,
+			TargetGroups: []elbv2.TargetGroup{*existing},
+		}, nil
+	}
+	tg.TargetGroupArn = arnPrefix + "targetgroup/" + name + "/" + srv.newId()
+	srv.targetGroups[tg.TargetGroupArn] = tg
+	return elbv2.CreateTargetGroupResp{
+		RequestId:    reqId,
+		TargetGroups: []elbv2.TargetGroup{*tg},
+	}, nil
+}
+
+func (srv *Server) deleteTargetGroup(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"TargetGroupArn"}); err != nil {
+		return nil, err
+	}
+	arn := req.FormValue("TargetGroupArn")
+	tg, ok := srv.targetGroups[arn]
+	if !ok {
+		return elbv2.SimpleResp{RequestId: reqId}, nil
+	}
+	if len(tg.LoadBalancerArns) > 0 {
+		return nil, &elbv2.Error{
+			StatusCode: 400,
+			Code:       "ResourceInUse",
+			Message:    fmt.Sprintf("Target group '%s' is currently in use by a listener or a rule", arn),
+		}
+	}
+	delete(srv.targetGroups, arn)
+	delete(srv.targets, arn)
+	return elbv2.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) describeTargetGroups(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	lbArn := req.FormValue("LoadBalancerArn")
+	arns := getParameters("TargetGroupArns.member.", req.Form)
+	names := getParameters("Names.member.", req.Form)
+	var tgs []elbv2.TargetGroup
+	switch {
+	case lbArn != "":
+		if _, err := srv.loadBalancer(lbArn); err != nil {
+			return nil, err
+		}
+		for _, tg := range srv.targetGroups {
+			if contains(tg.LoadBalancerArns, lbArn) {
+				tgs = append(tgs, *tg)
+			}
+		}
+		sort.Sort(targetGroupsByName(tgs))
+	case len(arns) > 0:
+		for _, arn := range arns {
+			tg, err := srv.targetGroup(arn)
+			if err != nil {
+				return nil, err
+			}
+			tgs = append(tgs, *tg)
+		}
+	case len(names) > 0:
+		for _, name := range names {
+			found := false
+			for _, tg := range srv.targetGroups {
+				if tg.TargetGroupName == name {
+					tgs = append(tgs, *tg)
+					found = true
+				}
+			}
+			if !found {
+				return nil, &elbv2.Error{
+					StatusCode: 400,
+					Code:       "TargetGroupNotFound",
+					Message:    "One or more target groups not found",
+				}
+			}
+		}
+	default:
+		for _, tg := range srv.targetGroups {
+			tgs = append(tgs, *tg)
+		}
+		sort.Sort(targetGroupsByName(tgs))
+	}
+	return elbv2.DescribeTargetGroupsResp{RequestId: reqId, TargetGroups: tgs}, nil
+}
+
+func (srv *Server) createListener(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	required := []string{
+		"LoadBalancerArn",
+		"Protocol",
+		"Port",
+		"DefaultActions.member.1.Type",
+	}
+	if err := srv.validate(req, required); err != nil {
+		return nil, err
+	}
+	lb, err := srv.loadBalancer(req.FormValue("LoadBalancerArn"))
+	if err != nil {
+		return nil, err
+	}
+	protocol := strings.ToUpper(req.FormValue("Protocol"))
+	if protocol != "HTTP" && protocol != "HTTPS" {
+		return nil, validationError("Protocol '%s' must be one of 'HTTP, HTTPS'", req.FormValue("Protocol"))
+	}
+	port, err := strconv.Atoi(req.FormValue("Port"))
+	if err != nil || port < 1 || port > 65535 {
+		return nil, validationError("Port '%s' must be between 1 and 65535", req.FormValue("Port"))
+	}
+	for _, l := range srv.listeners {
+		if l.LoadBalancerArn == lb.LoadBalancerArn && l.Port == port {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "DuplicateListener",
+				Message:    "A listener already exists on this port for this load balancer",
+			}
+		}
+	}
+	listener := &elbv2.Listener{
+		LoadBalancerArn: lb.LoadBalancerArn,
+		Protocol:        protocol,
+		Port:            port,
+	}
+	if cert := req.FormValue("Certificates.member.1.CertificateArn"); cert != "" {
+		listener.Certificates = []elbv2.Certificate{{CertificateArn: cert}}
+	}
+	if protocol == "HTTPS" {
+		if listener.Certificates == nil {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "CertificateNotFound",
+				Message:    "A certificate must be specified for HTTPS listeners",
+			}
+		}
+		listener.SslPolicy = req.FormValue("SslPolicy")
+		if listener.SslPolicy == "" {
+			listener.SslPolicy = "ELBSecurityPolicy-2016-08"
+		}
+	}
+	listener.DefaultActions, err = srv.parseActions("DefaultActions", req.Form, lb.LoadBalancerArn)
+	if err != nil {
+		return nil, err
+	}
+	listener.ListenerArn = fmt.Sprintf("%slistener/app/%s/%s/%s", arnPrefix, lb.LoadBalancerName, lbId(lb.LoadBalancerArn), srv.newId())
+	srv.listeners[listener.ListenerArn] = listener
+	srv.updateTargetGroupsUsage()
+	return elbv2.CreateListenerResp{
+		RequestId: reqId,
+		Listeners: []elbv2.Listener{*listener},
+	}, nil
+}
+
+func (srv *Server) modifyListener(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"ListenerArn", "DefaultActions.member.1.Type"}); err != nil {
+		return nil, err
+	}
+	listener, err := srv.findListener(req.FormValue("ListenerArn"))
+	if err != nil {
+		return nil, err
+	}
+	actions, err := srv.parseActions("DefaultActions", req.Form, listener.LoadBalancerArn)
+	if err != nil {
+		return nil, err
+	}
+	listener.DefaultActions = actions
+	srv.updateTargetGroupsUsage()
+	return elbv2.ModifyListenerResp{
+		RequestId: reqId,
+		Listeners: []elbv2.Listener{*listener},
+	}, nil
+}
+
+func (srv *Server) deleteListener(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"ListenerArn"}); err != nil {
+		return nil, err
+	}
+	arn := req.FormValue("ListenerArn")
+	if _, err := srv.findListener(arn); err != nil {
+		return nil, err
+	}
+	delete(srv.listeners, arn)
+	delete(srv.rules, arn)
+	srv.updateTargetGroupsUsage()
+	return elbv2.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) describeListeners(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	lbArn := req.FormValue("LoadBalancerArn")
+	arns := getParameters("ListenerArns.member.", req.Form)
+	if lbArn == "" && len(arns) == 0 {
+		return nil, validationError("You must specify either listener ARNs or a load balancer ARN")
+	}
+	var listeners []elbv2.Listener
+	if lbArn != "" {
+		if _, err := srv.loadBalancer(lbArn); err != nil {
+			return nil, err
+		}
+		for _, l := range srv.listeners {
+			if l.LoadBalancerArn == lbArn {
+				listeners = append(listeners, *l)
+			}
+		}
+		sort.Sort(listenersByPort(listeners))
+	}
+	for _, arn := range arns {
+		l, err := srv.findListener(arn)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, *l)
+	}
+	return elbv2.DescribeListenersResp{RequestId: reqId, Listeners: listeners}, nil
+}
+
+func (srv *Server) createRule(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	required := []string{
+		"ListenerArn",
+		"Priority",
+		"Conditions.member.1.Field",
+		"Actions.member.1.Type",
+	}
+	if err := srv.validate(req, required); err != nil {
+		return nil, err
+	}
+	listener, err := srv.findListener(req.FormValue("ListenerArn"))
+	if err != nil {
+		return nil, err
+	}
+	priority, err := strconv.Atoi(req.FormValue("Priority"))
+	if err != nil || priority < 1 || priority > 50000 {
+		return nil, validationError("Priority '%s' must be between 1 and 50000", req.FormValue("Priority"))
+	}
+	for _, r := range srv.rules[listener.ListenerArn] {
+		if r.Priority == strconv.Itoa(priority) {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "PriorityInUse",
+				Message:    fmt.Sprintf("Priority '%d' is currently in use", priority),
+			}
+		}
+	}
+	rule := &elbv2.Rule{Priority: strconv.Itoa(priority)}
+	for i := 1; req.FormValue(fmt.Sprintf("Conditions.member.%d.Field", i)) != ""; i++ {
+		prefix := fmt.Sprintf("Conditions.member.%d.", i)
+		cond := elbv2.RuleCondition{
+			Field:  req.FormValue(prefix + "Field"),
+			Values: getParameters(prefix+"Values.member.", req.Form),
+		}
+		if cond.Field != "path-pattern" && cond.Field != "host-header" {
+			return nil, validationError("Condition field '%s' must be one of '[path-pattern, host-header]'", cond.Field)
+		}
+		if len(cond.Values) == 0 {
+			return nil, validationError("A condition value must be specified for field '%s'", cond.Field)
+		}
+		rule.Conditions = append(rule.Conditions, cond)
+	}
+	rule.Actions, err = srv.parseActions("Actions", req.Form, listener.LoadBalancerArn)
+	if err != nil {
+		return nil, err
+	}
+	rule.RuleArn = strings.Replace(listener.ListenerArn, ":listener/", ":listener-rule/", 1) + "/" + srv.newId()
+	srv.rules[listener.ListenerArn] = append(srv.rules[listener.ListenerArn], rule)
+	srv.updateTargetGroupsUsage()
+	return elbv2.CreateRuleResp{
+		RequestId: reqId,
+		Rules:     []elbv2.Rule{*rule},
+	}, nil
+}
+
+func (srv *Server) deleteRule(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"RuleArn"}); err != nil {
+		return nil, err
+	}
+	arn := req.FormValue("RuleArn")
+	for listenerArn, rules := range srv.rules {
+		for i, r := range rules {
+			if r.RuleArn == arn {
+				srv.rules[listenerArn] = append(rules[:i:i], rules[i+1:]...)
+				srv.updateTargetGroupsUsage()
+				return elbv2.SimpleResp{RequestId: reqId}, nil
+			}
+		}
+	}
+	for listenerArn := range srv.listeners {
+		if arn == defaultRuleArn(listenerArn) {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "OperationNotPermitted",
+				Message:    fmt.Sprintf("Default rule '%s' cannot be deleted", arn),
+			}
+		}
+	}
+	return nil, ruleNotFound()
+}
+
+func (srv *Server) describeRules(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	listenerArn := req.FormValue("ListenerArn")
+	arns := getParameters("RuleArns.member.", req.Form)
+	if listenerArn == "" && len(arns) == 0 {
+		return nil, validationError("You must specify either listener rule ARNs or a listener ARN")
+	}
+	var rules []elbv2.Rule
+	if listenerArn != "" {
+		var err error
+		if rules, err = srv.listenerRules(listenerArn); err != nil {
+			return nil, err
+		}
+	}
+	for _, arn := range arns {
+		found := false
+		for l := range srv.listeners {
+			all, _ := srv.listenerRules(l)
+			for _, r := range all {
+				if r.RuleArn == arn {
+					rules = append(rules, r)
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, ruleNotFound()
+		}
+	}
+	return elbv2.DescribeRulesResp{RequestId: reqId, Rules: rules}, nil
+}
+
+// listenerRules returns the rules of a listener sorted by priority, followed
+// by its default rule.
+func (srv *Server) listenerRules(listenerArn string) ([]elbv2.Rule, error) {
+	listener, err := srv.findListener(listenerArn)
+	if err != nil {
+		return nil, err
+	}
+	var rules []elbv2.Rule
+	for _, r := range srv.rules[listenerArn] {
+		rules = append(rules, *r)
+	}
+	sort.Sort(rulesByPriority(rules))
+	rules = append(rules, elbv2.Rule{
+		RuleArn:   defaultRuleArn(listenerArn),
+		Priority:  "default",
+		IsDefault: true,
+		Actions:   listener.DefaultActions,
+	})
+	return rules, nil
+}
+
+func (srv *Server) registerTargets(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"TargetGroupArn", "Targets.member.1.Id"}); err != nil {
+		return nil, err
+	}
+	tg, err := srv.targetGroup(req.FormValue("TargetGroupArn"))
+	if err != nil {
+		return nil, err
+	}
+	targets, err := srv.parseTargets(req.Form, tg)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if err := srv.instanceExists(t.Id); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range targets {
+		if srv.findTarget(tg.TargetGroupArn, t) != nil {
+			continue
+		}
+		desc := &elbv2.TargetHealthDescription{
+			Target:          t,
+			HealthCheckPort: tg.HealthCheckPort,
+			TargetHealth:    initialHealth(tg),
+		}
+		if desc.HealthCheckPort == "traffic-port" {
+			desc.HealthCheckPort = strconv.Itoa(t.Port)
+		}
+		srv.targets[tg.TargetGroupArn] = append(srv.targets[tg.TargetGroupArn], desc)
+	}
+	return elbv2.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) deregisterTargets(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"TargetGroupArn", "Targets.member.1.Id"}); err != nil {
+		return nil, err
+	}
+	tg, err := srv.targetGroup(req.FormValue("TargetGroupArn"))
+	if err != nil {
+		return nil, err
+	}
+	targets, err := srv.parseTargets(req.Form, tg)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if srv.findTarget(tg.TargetGroupArn, t) == nil {
+			return nil, &elbv2.Error{
+				StatusCode: 400,
+				Code:       "InvalidTarget",
+				Message:    fmt.Sprintf("The following targets are not registered in target group '%s': '%s'", tg.TargetGroupArn, t.Id),
+			}
+		}
+	}
+	var remaining []*elbv2.TargetHealthDescription
+	for _, desc := range srv.targets[tg.TargetGroupArn] {
+		if !containsTarget(targets, desc.Target) {
+			remaining = append(remaining, desc)
+		}
+	}
+	srv.targets[tg.TargetGroupArn] = remaining
+	return elbv2.SimpleResp{RequestId: reqId}, nil
+}
+
+func (srv *Server) describeTargetHealth(w http.ResponseWriter, req *http.Request, reqId string) (interface{}, error) {
+	if err := srv.validate(req, []string{"TargetGroupArn"}); err != nil {
+		return nil, err
+	}
+	tg, err := srv.targetGroup(req.FormValue("TargetGroupArn"))
+	if err != nil {
+		return nil, err
+	}
+	targets, err := srv.parseTargets(req.Form, tg)
+	if err != nil {
+		return nil, err
+	}
+	var descs []elbv2.TargetHealthDescription
+	if len(targets) == 0 {
+		for _, desc := range srv.targets[tg.TargetGroupArn] {
+			descs = append(descs, *desc)
+		}
+	}
+	for _, t := range targets {
+		if desc := srv.findTarget(tg.TargetGroupArn, t); desc != nil {
+			descs = append(descs, *desc)
+			continue
+		}
+		descs = append(descs, elbv2.TargetHealthDescription{
+			Target: t,
+			TargetHealth: elbv2.TargetHealth{
+				State:       "unused",
+				Reason:      "Target.NotRegistered",
+				Description: "Target is not registered to the target group",
+			},
+		})
+	}
+	return elbv2.DescribeTargetHealthResp{RequestId: reqId, TargetHealthDescriptions: descs}, nil
+}
+
+// initialHealth returns the health of targets just registered with tg.
+func initialHealth(tg *elbv2.TargetGroup) elbv2.TargetHealth {
+	if len(tg.LoadBalancerArns) == 0 {
+		return elbv2.TargetHealth{
+			State:       "unused",
+			Reason:      "Target.NotInUse",
+			Description: "Target group is not configured to receive traffic from the load balancer",
+		}
+	}
+	return elbv2.TargetHealth{
+		State:       "initial",
+		Reason:      "Elb.RegistrationInProgress",
+		Description: "Target registration is in progress",
+	}
+}
+
+// parseActions reads the actions under label, checking that the target
+// groups they forward to exist and are not used by another load balancer.
+func (srv *Server) parseActions(label string, values url.Values, lbArn string) ([]elbv2.Action, error) {
+	var actions []elbv2.Action
+	for i := 1; values.Get(fmt.Sprintf("%s.member.%d.Type", label, i)) != ""; i++ {
+		prefix := fmt.Sprintf("%s.member.%d.", label, i)
+		action := elbv2.Action{
+			Type:           values.Get(prefix + "Type"),
+			TargetGroupArn: values.Get(prefix + "TargetGroupArn"),
+		}
+		if action.Type != "forward" {
+			return nil, validationError("Action type '%s' must be one of '[forward]'", action.Type)
+		}
+		tg, err := srv.targetGroup(action.TargetGroupArn)
+		if err != nil {
+			return nil, err
+		}
+		for _, arn := range tg.LoadBalancerArns {
+			if arn != lbArn {
+				return nil, &elbv2.Error{
+					StatusCode: 400,
+					Code:       "TargetGroupAssociationLimit",
+					Message:    fmt.Sprintf("The following target groups cannot be associated with more than one load balancer: %s", tg.TargetGroupArn),
+				}
+			}
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (srv *Server) parseTargets(values url.Values, tg *elbv2.TargetGroup) ([]elbv2.TargetDescription, error) {
+	var targets []elbv2.TargetDescription
+	for i := 1; values.Get(fmt.Sprintf("Targets.member.%d.Id", i)) != ""; i++ {
+		prefix := fmt.Sprintf("Targets.member.%d.", i)
+		t := elbv2.TargetDescription{
+			Id:               values.Get(prefix + "Id"),
+			Port:             tg.Port,
+			AvailabilityZone: values.Get(prefix + "AvailabilityZone"),
+		}
+		if v := values.Get(prefix + "Port"); v != "" {
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, validationError("Port '%s' must be between 1 and 65535", v)
+			}
+			t.Port = port
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// updateTargetGroupsUsage recomputes the load balancers every target group
+// receives traffic from, and moves targets of target groups that stopped or
+// started being used into the respective state.
+func (srv *Server) updateTargetGroupsUsage() {
+	used := make(map[string][]string)
+	add := func(actions []elbv2.Action, lbArn string) {
+		for _, a := range actions {
+			if a.TargetGroupArn != "" && !contains(used[a.TargetGroupArn], lbArn) {
+				used[a.TargetGroupArn] = append(used[a.TargetGroupArn], lbArn)
+			}
+		}
+	}
+	for arn, l := range srv.listeners {
+		add(l.DefaultActions, l.LoadBalancerArn)
+		for _, r := range srv.rules[arn] {
+			add(r.Actions, l.LoadBalancerArn)
+		}
+	}
+	for arn, tg := range srv.targetGroups {
+		wasUsed := len(tg.LoadBalancerArns) > 0
+		tg.LoadBalancerArns = used[arn]
+		if isUsed := len(tg.LoadBalancerArns) > 0; isUsed != wasUsed {
+			for _, desc := range srv.targets[arn] {
+				desc.TargetHealth = initialHealth(tg)
+			}
+		}
+	}
+}
+
+func (srv *Server) findTarget(tgArn string, t elbv2.TargetDescription) *elbv2.TargetHealthDescription {
+	for _, desc := range srv.targets[tgArn] {
+		if desc.Target.Id == t.Id && (t.Port == 0 || desc.Target.Port == t.Port) {
+			return desc
+		}
+	}
+	return nil
+}
+
+func containsTarget(targets []elbv2.TargetDescription, t elbv2.TargetDescription) bool {
+	for _, target := range targets {
+		if target.Id == t.Id && target.Port == t.Port {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *Server) loadBalancer(arn string) (*elbv2.LoadBalancer, error) {
+	lb, ok := srv.lbs[arn]
+	if !ok {
+		return nil, &elbv2.Error{
+			StatusCode: 400,
+			Code:       "LoadBalancerNotFound",
+			Message:    fmt.Sprintf("Load balancer '%s' not found", arn),
+		}
+	}
+	return lb, nil
+}
+
+func (srv *Server) targetGroup(arn string) (*elbv2.TargetGroup, error) {
+	tg, ok := srv.targetGroups[arn]
+	if !ok {
+		return nil, &elbv2.Error{
+			StatusCode: 400,
+			Code:       "TargetGroupNotFound",
+			Message:    fmt.Sprintf("Target groups '%s' not found", arn),
+		}
+	}
+	return tg, nil
+}
+
+func (srv *Server) findListener(arn string) (*elbv2.Listener, error) {
+	l, ok := srv.listeners[arn]
+	if !ok {
+		return nil, &elbv2.Error{
+			StatusCode: 400,
+			Code:       "ListenerNotFound",
+			Message:    fmt.Sprintf("Listener '%s' not found", arn),
+		}
+	}
+	return l, nil
+}
+
+func (srv *Server) instanceExists(id string) error {
+	if !contains(srv.instances, id) {
+		return &elbv2.Error{
+			StatusCode: 400,
+			Code:       "InvalidTarget",
+			Message:    fmt.Sprintf("The following targets are not in a running state and cannot be registered: '%s'", id),
+		}
+	}
+	return nil
+}
+
+func ruleNotFound() error {
+	return &elbv2.Error{
+		StatusCode: 400,
+		Code:       "RuleNotFound",
+		Message:    "One or more rules not found",
+	}
+}
+
+func defaultRuleArn(listenerArn string) string {
+	return strings.Replace(listenerArn, ":listener/", ":listener-rule/", 1) + "/default"
+}
+
+// lbId returns the trailing id of a load balancer ARN.
+func lbId(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+func (srv *Server) validate(req *http.Request, required []string) error {
+	for _, field := range required {
+		if req.FormValue(field) == "" {
+			return validationError("%s is required.", field)
+		}
+	}
+	return nil
+}
+
+func validationError(format string, args ...interface{}) error {
+	return &elbv2.Error{
+		StatusCode: 400,
+		Code:       "ValidationError",
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+// getParameters returns the values of the parameters prefix1, prefix2 ...
+// prefixN. The prefix must include the trailing dot.
+func getParameters(prefix string, values url.Values) []string {
+	var result []string
+	for i := 1; values.Get(prefix+strconv.Itoa(i)) != ""; i++ {
+		result = append(result, values.Get(prefix+strconv.Itoa(i)))
+	}
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type loadBalancersByName []elbv2.LoadBalancer
+
+func (lbs loadBalancersByName) Len() int { return len(lbs) }
+func (lbs loadBalancersByName) Less(i, j int) bool {
+	return lbs[i].LoadBalancerName < lbs[j].LoadBalancerName
+}
+func (lbs loadBalancersByName) Swap(i, j int) { lbs[i], lbs[j] = lbs[j], lbs[i] }
+
+// sameTargetGroupSettings reports whether a and b were created with the
+// same settings, regardless of their ARN and load balancers.
+func sameTargetGroupSettings(a, b *elbv2.TargetGroup) bool {
+	x, y := *a, *b
+	x.TargetGroupArn, y.TargetGroupArn = "", ""
+	x.LoadBalancerArns, y.LoadBalancerArns = nil, nil
+	return reflect.DeepEqual(x, y)
+}
+
+type targetGroupsByName []elbv2.TargetGroup
+
+func (tgs targetGroupsByName) Len() int { return len(tgs) }
+func (tgs targetGroupsByName) Less(i, j int) bool {
+	return tgs[i].TargetGroupName < tgs[j].TargetGroupName
+}
+func (tgs targetGroupsByName) Swap(i, j int) { tgs[i], tgs[j] = tgs[j], tgs[i] }
+
+type listenersByPort []elbv2.Listener
+
+func (ls listenersByPort) Len() int           { return len(ls) }
+func (ls listenersByPort) Less(i, j int) bool { return ls[i].Port < ls[j].Port }
+func (ls listenersByPort) Swap(i, j int)      { ls[i], ls[j] = ls[j], ls[i] }
+
+type rulesByPriority []elbv2.Rule
+
+func (rs rulesByPriority) Len() int      { return len(rs) }
+func (rs rulesByPriority) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs rulesByPriority) Less(i, j int) bool {
+	pi, _ := strconv.Atoi(rs[i].Priority)
+	pj, _ := strconv.Atoi(rs[j].Priority)
+	return pi < pj
+}
+
+// Creates a fake instance in the server, that can be registered as a target
+func (srv *Server) NewInstance() string {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.instCount++
+	instId := fmt.Sprintf("i-%d", srv.instCount)
+	srv.instances = append(srv.instances, instId)
+	return instId
+}
+
+// Removes a fake instance from the server
+//
+// If no instance is found it does nothing
+func (srv *Server) RemoveInstance(instId string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	for i, id := range srv.instances {
+		if id == instId {
+			srv.instances = append(srv.instances[:i:i], srv.instances[i+1:]...)
+			return
+		}
+	}
+}
+
+// Changes the health of a target registered with a fake target group
+//
+// A zero target.Port matches the target on any port. If the target is not
+// registered it does nothing. It is safe to call it while requests are being
+// served, so tests can flip the health of a target a client is waiting on.
+func (srv *Server) ChangeTargetHealth(tgArn string, target elbv2.TargetDescription, health elbv2.TargetHealth) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	for _, desc := range srv.targets[tgArn] {
+		if desc.Target.Id == target.Id && (target.Port == 0 || desc.Target.Port == target.Port) {
+			desc.TargetHealth = health
+		}
+	}
+}
+
+var actions = map[string]func(*Server, http.ResponseWriter, *http.Request, string) (interface{}, error){
+	"CreateLoadBalancer":    (*Server).createLoadBalancer,
+	"DeleteLoadBalancer":    (*Server).deleteLoadBalancer,
+	"DescribeLoadBalancers": (*Server).describeLoadBalancers,
+	"CreateTargetGroup":     (*Server).createTargetGroup,
+	"DeleteTargetGroup":     (*Server).deleteTargetGroup,
+	"DescribeTargetGroups":  (*Server).describeTargetGroups,
+	"CreateListener":        (*Server).createListener,
+	"ModifyListener":        (*Server).modifyListener,
+	"DeleteListener":        (*Server).deleteListener,
+	"DescribeListeners":     (*Server).describeListeners,
+	"CreateRule":            (*Server).createRule,
+	"DeleteRule":            (*Server).deleteRule,
+	"DescribeRules":         (*Server).describeRules,
+	"RegisterTargets":       (*Server).registerTargets,
+	"DeregisterTargets":     (*Server).deregisterTargets,
+	"DescribeTargetHealth":  (*Server).describeTargetHealth,
+}
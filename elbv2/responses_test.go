@@ -0,0 +1,318 @@
+package elbv2_test
+
+var CreateLoadBalancer = `
+<CreateLoadBalancerResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <CreateLoadBalancerResult>
+    <LoadBalancers>
+      <member>
+        <LoadBalancerArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/app/my-load-balancer/50dc6c495c0c9188</LoadBalancerArn>
+        <Scheme>internet-facing</Scheme>
+        <LoadBalancerName>my-load-balancer</LoadBalancerName>
+        <VpcId>vpc-3ac0fb5f</VpcId>
+        <CanonicalHostedZoneId>Z2P70J7EXAMPLE</CanonicalHostedZoneId>
+        <CreatedTime>2016-03-25T21:29:48.850Z</CreatedTime>
+        <AvailabilityZones>
+          <member>
+            <SubnetId>subnet-8360a9e7</SubnetId>
+            <ZoneName>us-west-2a</ZoneName>
+          </member>
+          <member>
+            <SubnetId>subnet-b7d581c0</SubnetId>
+            <ZoneName>us-west-2b</ZoneName>
+          </member>
+        </AvailabilityZones>
+        <SecurityGroups>
+          <member>sg-5943793c</member>
+        </SecurityGroups>
+        <DNSName>my-load-balancer-424835706.us-west-2.elb.amazonaws.com</DNSName>
+        <State>
+          <Code>provisioning</Code>
+        </State>
+        <Type>application</Type>
+        <IpAddressType>ipv4</IpAddressType>
+      </member>
+    </LoadBalancers>
+  </CreateLoadBalancerResult>
+  <ResponseMetadata>
+    <RequestId>32d531b2-f2d0-11e5-9192-3fff33344cfa</RequestId>
+  </ResponseMetadata>
+</CreateLoadBalancerResponse>
+`
+
+var CreateLoadBalancerBadRequest = `
+<ErrorResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>DuplicateLoadBalancerName</Code>
+    <Message>A load balancer with the same name 'my-load-balancer' exists, but with different settings</Message>
+  </Error>
+  <RequestId>32d531b2-f2d0-11e5-9192-3fff33344cfa</RequestId>
+</ErrorResponse>
+`
+
+var DeleteLoadBalancer = `
+<DeleteLoadBalancerResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <DeleteLoadBalancerResult/>
+  <ResponseMetadata>
+    <RequestId>1549581b-12b7-11e3-895e-1334aEXAMPLE</RequestId>
+  </ResponseMetadata>
+</DeleteLoadBalancerResponse>
+`
+
+var DescribeLoadBalancers = `
+<DescribeLoadBalancersResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <DescribeLoadBalancersResult>
+    <LoadBalancers>
+      <member>
+        <LoadBalancerArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/app/my-load-balancer/50dc6c495c0c9188</LoadBalancerArn>
+        <Scheme>internet-facing</Scheme>
+        <LoadBalancerName>my-load-balancer</LoadBalancerName>
+        <VpcId>vpc-3ac0fb5f</VpcId>
+        <CanonicalHostedZoneId>Z2P70J7EXAMPLE</CanonicalHostedZoneId>
+        <CreatedTime>2016-03-25T21:26:12.920Z</CreatedTime>
+        <AvailabilityZones>
+          <member>
+            <SubnetId>subnet-8360a9e7</SubnetId>
+            <ZoneName>us-west-2a</ZoneName>
+          </member>
+        </AvailabilityZones>
+        <SecurityGroups>
+          <member>sg-5943793c</member>
+        </SecurityGroups>
+        <DNSName>my-load-balancer-424835706.us-west-2.elb.amazonaws.com</DNSName>
+        <State>
+          <Code>active</Code>
+        </State>
+        <Type>application</Type>
+      </member>
+    </LoadBalancers>
+    <NextMarker>next-page</NextMarker>
+  </DescribeLoadBalancersResult>
+  <ResponseMetadata>
+    <RequestId>6581c0ac-f39f-11e5-bb98-57195a6eb84a</RequestId>
+  </ResponseMetadata>
+</DescribeLoadBalancersResponse>
+`
+
+var CreateTargetGroup = `
+<CreateTargetGroupResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <CreateTargetGroupResult>
+    <TargetGroups>
+      <member>
+        <TargetGroupArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067</TargetGroupArn>
+        <HealthCheckTimeoutSeconds>5</HealthCheckTimeoutSeconds>
+        <HealthCheckPort>traffic-port</HealthCheckPort>
+        <Matcher>
+          <HttpCode>200</HttpCode>
+        </Matcher>
+        <TargetGroupName>my-targets</TargetGroupName>
+        <HealthCheckProtocol>HTTP</HealthCheckProtocol>
+        <HealthCheckPath>/</HealthCheckPath>
+        <Protocol>HTTP</Protocol>
+        <Port>80</Port>
+        <VpcId>vpc-3ac0fb5f</VpcId>
+        <HealthyThresholdCount>5</HealthyThresholdCount>
+        <HealthCheckIntervalSeconds>30</HealthCheckIntervalSeconds>
+        <UnhealthyThresholdCount>2</UnhealthyThresholdCount>
+        <TargetType>instance</TargetType>
+      </member>
+    </TargetGroups>
+  </CreateTargetGroupResult>
+  <ResponseMetadata>
+    <RequestId>b83fe90e-f2d5-11e5-b95d-3b2c1831fc26</RequestId>
+  </ResponseMetadata>
+</CreateTargetGroupResponse>
+`
+
+var DescribeTargetGroups = `
+<DescribeTargetGroupsResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <DescribeTargetGroupsResult>
+    <TargetGroups>
+      <member>
+        <TargetGroupArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067</TargetGroupArn>
+        <TargetGroupName>my-targets</TargetGroupName>
+        <Protocol>HTTP</Protocol>
+        <Port>80</Port>
+        <VpcId>vpc-3ac0fb5f</VpcId>
+        <LoadBalancerArns>
+          <member>arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/app/my-load-balancer/50dc6c495c0c9188</member>
+        </LoadBalancerArns>
+      </member>
+    </TargetGroups>
+  </DescribeTargetGroupsResult>
+  <ResponseMetadata>
+    <RequestId>70092c0e-f3a9-11e5-ae48-cff02092876b</RequestId>
+  </ResponseMetadata>
+</DescribeTargetGroupsResponse>
+`
+
+var CreateListener = `
+<CreateListenerResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <CreateListenerResult>
+    <Listeners>
+      <member>
+        <LoadBalancerArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/app/my-load-balancer/50dc6c495c0c9188</LoadBalancerArn>
+        <Protocol>HTTPS</Protocol>
+        <Certificates>
+          <member>
+            <CertificateArn>arn:aws:iam::123456789012:server-certificate/my-server-cert</CertificateArn>
+          </member>
+        </Certificates>
+        <Port>443</Port>
+        <SslPolicy>ELBSecurityPolicy-2016-08</SslPolicy>
+        <ListenerArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:listener/app/my-load-balancer/50dc6c495c0c9188/f2f7dc8efc522ab2</ListenerArn>
+        <DefaultActions>
+          <member>
+            <Type>forward</Type>
+            <TargetGroupArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067</TargetGroupArn>
+          </member>
+        </DefaultActions>
+      </member>
+    </Listeners>
+  </CreateListenerResult>
+  <ResponseMetadata>
+    <RequestId>883f9ef5-f2d7-11e5-b95d-3b2c1831fc26</RequestId>
+  </ResponseMetadata>
+</CreateListenerResponse>
+`
+
+var DescribeListeners = `
+<DescribeListenersResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <DescribeListenersResult>
+    <Listeners>
+      <member>
+        <LoadBalancerArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/app/my-load-balancer/50dc6c495c0c9188</LoadBalancerArn>
+        <Protocol>HTTP</Protocol>
+        <Port>80</Port>
+        <ListenerArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:listener/app/my-load-balancer/50dc6c495c0c9188/f2f7dc8efc522ab2</ListenerArn>
+        <DefaultActions>
+          <member>
+            <Type>forward</Type>
+            <TargetGroupArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067</TargetGroupArn>
+          </member>
+        </DefaultActions>
+      </member>
+    </Listeners>
+  </DescribeListenersResult>
+  <ResponseMetadata>
+    <RequestId>18e470d3-f39c-11e5-a53c-67205c0d10fd</RequestId>
+  </ResponseMetadata>
+</DescribeListenersResponse>
+`
+
+var CreateRule = `
+<CreateRuleResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <CreateRuleResult>
+    <Rules>
+      <member>
+        <IsDefault>false</IsDefault>
+        <Conditions>
+          <member>
+            <Field>path-pattern</Field>
+            <Values>
+              <member>/img/*</member>
+            </Values>
+          </member>
+        </Conditions>
+        <Priority>10</Priority>
+        <Actions>
+          <member>
+            <Type>forward</Type>
+            <TargetGroupArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067</TargetGroupArn>
+          </member>
+        </Actions>
+        <RuleArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:listener-rule/app/my-load-balancer/50dc6c495c0c9188/f2f7dc8efc522ab2/9683b2d02a6cabee</RuleArn>
+      </member>
+    </Rules>
+  </CreateRuleResult>
+  <ResponseMetadata>
+    <RequestId>c5478c83-f397-11e5-bb98-57195a6eb84a</RequestId>
+  </ResponseMetadata>
+</CreateRuleResponse>
+`
+
+var DescribeRules = `
+<DescribeRulesResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <DescribeRulesResult>
+    <Rules>
+      <member>
+        <IsDefault>false</IsDefault>
+        <Conditions>
+          <member>
+            <Field>host-header</Field>
+            <Values>
+              <member>*.example.com</member>
+            </Values>
+          </member>
+        </Conditions>
+        <Priority>10</Priority>
+        <Actions>
+          <member>
+            <Type>forward</Type>
+            <TargetGroupArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067</TargetGroupArn>
+          </member>
+        </Actions>
+        <RuleArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:listener-rule/app/my-load-balancer/50dc6c495c0c9188/f2f7dc8efc522ab2/9683b2d02a6cabee</RuleArn>
+      </member>
+      <member>
+        <IsDefault>true</IsDefault>
+        <Conditions/>
+        <Priority>default</Priority>
+        <Actions>
+          <member>
+            <Type>forward</Type>
+            <TargetGroupArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067</TargetGroupArn>
+          </member>
+        </Actions>
+        <RuleArn>arn:aws:elasticloadbalancing:us-west-2:123456789012:listener-rule/app/my-load-balancer/50dc6c495c0c9188/f2f7dc8efc522ab2/default</RuleArn>
+      </member>
+    </Rules>
+  </DescribeRulesResult>
+  <ResponseMetadata>
+    <RequestId>74926cf3-f3a3-11e5-b543-9f2066fd7dc5</RequestId>
+  </ResponseMetadata>
+</DescribeRulesResponse>
+`
+
+var RegisterTargets = `
+<RegisterTargetsResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <RegisterTargetsResult/>
+  <ResponseMetadata>
+    <RequestId>f9a2ff2d-f2d5-11e5-b95d-3b2c1831fc26</RequestId>
+  </ResponseMetadata>
+</RegisterTargetsResponse>
+`
+
+var DescribeTargetHealth = `
+<DescribeTargetHealthResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2015-12-01/">
+  <DescribeTargetHealthResult>
+    <TargetHealthDescriptions>
+      <member>
+        <HealthCheckPort>80</HealthCheckPort>
+        <TargetHealth>
+          <State>healthy</State>
+        </TargetHealth>
+        <Target>
+          <Port>80</Port>
+          <Id>i-0f76fade</Id>
+        </Target>
+      </member>
+      <member>
+        <HealthCheckPort>80</HealthCheckPort>
+        <TargetHealth>
+          <Reason>Target.ResponseCodeMismatch</Reason>
+          <Description>Health checks failed with these codes: [404]</Description>
+          <State>unhealthy</State>
+        </TargetHealth>
+        <Target>
+          <Port>80</Port>
+          <Id>i-0f76fadf</Id>
+        </Target>
+      </member>
+    </TargetHealthDescriptions>
+  </DescribeTargetHealthResult>
+  <ResponseMetadata>
+    <RequestId>c534f810-f389-11e5-9192-3fff33344cfa</RequestId>
+  </ResponseMetadata>
+</DescribeTargetHealthResponse>
+`